@@ -9,6 +9,7 @@
 package gpio
 
 import (
+	"context"
 	"errors"
 	"strconv"
 	"strings"
@@ -235,6 +236,53 @@ type PinDefaultPull interface {
 	DefaultPull() Pull
 }
 
+// EdgeEvent is an edge detected on an input pin.
+type EdgeEvent struct {
+	// Edge is the kind of edge detected, either RisingEdge or FallingEdge.
+	Edge Edge
+	// Level is the level of the pin right after the edge.
+	Level Level
+	// Time is the monotonic time at which the edge was detected, relative to
+	// an arbitrary point in the past, generally the host boot time.
+	//
+	// When the OS driver timestamps edges, like the linux GPIO character
+	// device, this is the kernel timestamp. Otherwise it is the time at which
+	// the edge was reported to the process.
+	Time time.Duration
+}
+
+func (e EdgeEvent) String() string {
+	return e.Edge.String() + "/" + e.Level.String() + "@" + e.Time.String()
+}
+
+// PinEdgeEvents is optionally implemented by a PinIn that can report each
+// detected edge along with its kind and its timestamp.
+type PinEdgeEvents interface {
+	// ReadEdge waits for the next edge or immediately return if an edge
+	// occurred since the last call, like WaitForEdge(), and returns the edge
+	// event.
+	//
+	// Returns false if the timeout occurred or In() was called while waiting,
+	// causing the function to exit.
+	//
+	// Specify -1 to effectively disable timeout.
+	ReadEdge(timeout time.Duration) (EdgeEvent, bool)
+}
+
+// PinInContext is optionally implemented by a PinIn whose edge detection can
+// be canceled via a context.
+//
+// This permits a service to stop cleanly, for example upon SIGTERM, without
+// having to poll WaitForEdge() with a short timeout.
+type PinInContext interface {
+	// WaitForEdgeContext is like WaitForEdge() but waits until ctx is done
+	// instead of a timeout.
+	//
+	// Returns false if ctx is done or In() was called while waiting, causing
+	// the function to exit.
+	WaitForEdgeContext(ctx context.Context) bool
+}
+
 // INVALID implements PinIO and fails on all access.
 var INVALID PinIO
 
@@ -23,6 +23,13 @@ func TestStrings(t *testing.T) {
 	}
 }
 
+func TestEdgeEvent_String(t *testing.T) {
+	e := EdgeEvent{Edge: RisingEdge, Level: High, Time: time.Millisecond}
+	if s := e.String(); s != "RisingEdge/High@1ms" {
+		t.Fatal(s)
+	}
+}
+
 func TestDuty_String(t *testing.T) {
 	data := []struct {
 		d        Duty
@@ -6,6 +6,7 @@
 package gpiotest
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"sync"
@@ -25,7 +26,8 @@ type Pin struct {
 	sync.Mutex            // Grab the Mutex before modifying the members to keep it concurrent safe
 	L          gpio.Level // Used for both input and output
 	P          gpio.Pull
-	EdgesChan  chan gpio.Level // Use it to fake edges
+	EdgesChan  chan gpio.Level     // Use it to fake edges
+	EventsChan chan gpio.EdgeEvent // Use it to fake timestamped edges
 }
 
 func (p *Pin) String() string {
@@ -64,13 +66,14 @@ func (p *Pin) In(pull gpio.Pull, edge gpio.Edge) error {
 	} else if pull == gpio.PullUp {
 		p.L = gpio.High
 	}
-	if edge != gpio.NoEdge && p.EdgesChan == nil {
-		return errors.New("gpiotest: please set p.EdgesChan first")
+	if edge != gpio.NoEdge && p.EdgesChan == nil && p.EventsChan == nil {
+		return errors.New("gpiotest: please set p.EdgesChan or p.EventsChan first")
 	}
 	// Flush any buffered edges.
 	for {
 		select {
 		case <-p.EdgesChan:
+		case <-p.EventsChan:
 		default:
 			return nil
 		}
@@ -86,16 +89,44 @@ func (p *Pin) Read() gpio.Level {
 
 // WaitForEdge implements gpio.PinIn.
 func (p *Pin) WaitForEdge(timeout time.Duration) bool {
-	if timeout == -1 {
-		_ = p.Out(<-p.EdgesChan)
-		return true
+	_, ok := p.ReadEdge(timeout)
+	return ok
+}
+
+// ReadEdge implements gpio.PinEdgeEvents.
+//
+// The edges sent over EdgesChan are timestamped with the time they are
+// received at. The edges sent over EventsChan are returned as is.
+func (p *Pin) ReadEdge(timeout time.Duration) (gpio.EdgeEvent, bool) {
+	var after <-chan time.Time
+	if timeout != -1 {
+		after = time.After(timeout)
 	}
+	return p.readEdge(nil, after)
+}
+
+// WaitForEdgeContext implements gpio.PinInContext.
+func (p *Pin) WaitForEdgeContext(ctx context.Context) bool {
+	_, ok := p.readEdge(ctx.Done(), nil)
+	return ok
+}
+
+func (p *Pin) readEdge(done <-chan struct{}, after <-chan time.Time) (gpio.EdgeEvent, bool) {
 	select {
-	case <-time.After(timeout):
-		return false
+	case <-done:
+		return gpio.EdgeEvent{}, false
+	case <-after:
+		return gpio.EdgeEvent{}, false
 	case l := <-p.EdgesChan:
 		_ = p.Out(l)
-		return true
+		e := gpio.EdgeEvent{Edge: gpio.FallingEdge, Level: l, Time: time.Since(start)}
+		if l == gpio.High {
+			e.Edge = gpio.RisingEdge
+		}
+		return e, true
+	case e := <-p.EventsChan:
+		_ = p.Out(e.Level)
+		return e, true
 	}
 }
 
@@ -128,5 +159,10 @@ func (p *PinPWM) PWM(duty gpio.Duty, period time.Duration) error {
 	return nil
 }
 
+// start is the reference time for the edges timestamps.
+var start = time.Now()
+
 var _ gpio.PinIO = &Pin{}
+var _ gpio.PinEdgeEvents = &Pin{}
+var _ gpio.PinInContext = &Pin{}
 var _ gpio.PinPWM = &PinPWM{}
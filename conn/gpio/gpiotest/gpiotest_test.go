@@ -5,6 +5,7 @@
 package gpiotest
 
 import (
+	"context"
 	"testing"
 	"time"
 
@@ -61,6 +62,48 @@ func TestPin_edge(t *testing.T) {
 	}
 }
 
+func TestPin_ReadEdge(t *testing.T) {
+	p := &Pin{N: "GPIO1", Num: 1, Fn: "I2C1_SDA", EdgesChan: make(chan gpio.Level, 1), EventsChan: make(chan gpio.EdgeEvent, 1)}
+	if err := p.In(gpio.PullNoChange, gpio.BothEdges); err != nil {
+		t.Fatal(err)
+	}
+	p.EdgesChan <- gpio.High
+	if e, ok := p.ReadEdge(-1); !ok || e.Edge != gpio.RisingEdge || e.Level != gpio.High || e.Time <= 0 {
+		t.Fatal(e, ok)
+	}
+	p.EdgesChan <- gpio.Low
+	if e, ok := p.ReadEdge(time.Minute); !ok || e.Edge != gpio.FallingEdge || e.Level != gpio.Low {
+		t.Fatal(e, ok)
+	}
+	expected := gpio.EdgeEvent{Edge: gpio.RisingEdge, Level: gpio.High, Time: 42 * time.Millisecond}
+	p.EventsChan <- expected
+	if e, ok := p.ReadEdge(-1); !ok || e != expected {
+		t.Fatal(e, ok)
+	}
+	if p.Read() != gpio.High {
+		t.Fatal("level is not updated")
+	}
+	if e, ok := p.ReadEdge(time.Millisecond); ok {
+		t.Fatal(e)
+	}
+}
+
+func TestPin_WaitForEdgeContext(t *testing.T) {
+	p := &Pin{N: "GPIO1", Num: 1, Fn: "I2C1_SDA", EdgesChan: make(chan gpio.Level, 1)}
+	if err := p.In(gpio.PullNoChange, gpio.BothEdges); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	p.EdgesChan <- gpio.High
+	if !p.WaitForEdgeContext(ctx) {
+		t.Fatal("expected edge")
+	}
+	cancel()
+	if p.WaitForEdgeContext(ctx) {
+		t.Fatal("context is canceled")
+	}
+}
+
 func TestPin_fail(t *testing.T) {
 	p := &Pin{N: "GPIO1", Num: 1, Fn: "I2C1_SDA"}
 	if err := p.In(gpio.Float, gpio.BothEdges); err == nil {
@@ -0,0 +1,116 @@
+// Copyright 2018 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package gpio
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Group is a set of pins that are written to or read from together.
+//
+// This is useful for bit-parallel buses, like a HD44780 display, a parallel
+// TFT or a DAC resistor ladder.
+//
+// Bit i of the values corresponds to the pin at index i in Pins().
+type Group interface {
+	// Pins returns the pins in the group.
+	Pins() []PinIO
+	// Out sets all the pins in the group as output if they weren't already,
+	// then sets their level according to the corresponding bit in bits.
+	//
+	// When supported by the driver, the pins that share an hardware register
+	// are set in a single write operation.
+	Out(bits uint64) error
+	// Read returns the current level of all the pins in the group.
+	//
+	// When supported by the driver, the pins that share an hardware register
+	// are read in a single read operation.
+	Read() uint64
+}
+
+// PinGrouper is optionally implemented by a pin whose driver can access
+// multiple pins at once, for example via memory mapped registers.
+type PinGrouper interface {
+	// Group returns a Group for pins.
+	//
+	// It returns an error if one of the pins is not supported by this driver,
+	// in which case NewGroup uses a generic implementation instead.
+	Group(pins []PinIO) (Group, error)
+}
+
+// NewGroup returns a Group for the pins specified.
+//
+// Up to 64 pins are supported. Aliases are resolved to their real pin.
+//
+// If the first pin implements PinGrouper and accepts all the pins, the driver
+// specific implementation is used. Otherwise, each pin is accessed one after
+// the other.
+func NewGroup(pins ...PinIO) (Group, error) {
+	if len(pins) == 0 {
+		return nil, errors.New("gpio: group must have at least one pin")
+	}
+	if len(pins) > 64 {
+		return nil, errors.New("gpio: group can't have more than 64 pins, got " + strconv.Itoa(len(pins)))
+	}
+	resolved := make([]PinIO, len(pins))
+	for i, p := range pins {
+		if p == nil {
+			return nil, errors.New("gpio: group pin #" + strconv.Itoa(i) + " is nil")
+		}
+		if r, ok := p.(RealPin); ok {
+			p = r.Real()
+		}
+		resolved[i] = p
+	}
+	if g, ok := resolved[0].(PinGrouper); ok {
+		if out, err := g.Group(resolved); err == nil {
+			return out, nil
+		}
+	}
+	return &pinGroup{pins: resolved}, nil
+}
+
+//
+
+// pinGroup is the generic implementation of Group, accessing each pin one
+// after the other.
+type pinGroup struct {
+	pins []PinIO
+}
+
+func (g *pinGroup) String() string {
+	names := make([]string, len(g.pins))
+	for i, p := range g.pins {
+		names[i] = p.Name()
+	}
+	return "Group(" + strings.Join(names, ",") + ")"
+}
+
+func (g *pinGroup) Pins() []PinIO {
+	return g.pins
+}
+
+func (g *pinGroup) Out(bits uint64) error {
+	for i, p := range g.pins {
+		if err := p.Out(Level(bits&(1<<uint(i)) != 0)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *pinGroup) Read() uint64 {
+	var out uint64
+	for i, p := range g.pins {
+		if p.Read() {
+			out |= 1 << uint(i)
+		}
+	}
+	return out
+}
+
+var _ Group = &pinGroup{}
@@ -0,0 +1,121 @@
+// Copyright 2018 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package gpio
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNewGroup(t *testing.T) {
+	pins := []PinIO{&groupPin{name: "A"}, &groupPin{name: "B"}, &groupPin{name: "C"}}
+	g, err := NewGroup(pins...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := g.(*pinGroup).String(); s != "Group(A,B,C)" {
+		t.Fatal(s)
+	}
+	if p := g.Pins(); len(p) != 3 || p[1] != pins[1] {
+		t.Fatal(p)
+	}
+	if err := g.Out(5); err != nil {
+		t.Fatal(err)
+	}
+	if !pins[0].(*groupPin).l || pins[1].(*groupPin).l || !pins[2].(*groupPin).l {
+		t.Fatal("unexpected levels")
+	}
+	if v := g.Read(); v != 5 {
+		t.Fatal(v)
+	}
+	pins[1].(*groupPin).err = errors.New("injected")
+	if g.Out(7) == nil {
+		t.Fatal("Out failed")
+	}
+}
+
+func TestNewGroup_alias(t *testing.T) {
+	a := &groupPin{name: "A"}
+	g, err := NewGroup(&aliasPin{a})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := g.Pins(); p[0] != a {
+		t.Fatal("alias must be resolved")
+	}
+}
+
+func TestNewGroup_grouper(t *testing.T) {
+	p := &grouperPin{}
+	g, err := NewGroup(p, &groupPin{name: "A"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g != &p.g {
+		t.Fatal("expected driver specific group")
+	}
+	p.err = errors.New("unsupported")
+	if g, err = NewGroup(p, &groupPin{name: "A"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := g.(*pinGroup); !ok {
+		t.Fatal("expected generic group")
+	}
+}
+
+func TestNewGroup_fail(t *testing.T) {
+	if _, err := NewGroup(); err == nil {
+		t.Fatal("no pin")
+	}
+	if _, err := NewGroup(INVALID, nil); err == nil {
+		t.Fatal("nil pin")
+	}
+	if _, err := NewGroup(make([]PinIO, 65)...); err == nil {
+		t.Fatal("too many pins")
+	}
+}
+
+//
+
+type groupPin struct {
+	invalidPin
+	name string
+	l    Level
+	err  error
+}
+
+func (g *groupPin) Name() string {
+	return g.name
+}
+
+func (g *groupPin) Read() Level {
+	return g.l
+}
+
+func (g *groupPin) Out(l Level) error {
+	g.l = l
+	return g.err
+}
+
+type aliasPin struct {
+	PinIO
+}
+
+func (a *aliasPin) Real() PinIO {
+	return a.PinIO
+}
+
+type grouperPin struct {
+	invalidPin
+	g   pinGroup
+	err error
+}
+
+func (g *grouperPin) Group(pins []PinIO) (Group, error) {
+	if g.err != nil {
+		return nil, g.err
+	}
+	return &g.g, nil
+}
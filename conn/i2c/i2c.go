@@ -16,11 +16,16 @@
 package i2c
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"strconv"
+	"strings"
+	"time"
 
 	"periph.io/x/periph/conn"
 	"periph.io/x/periph/conn/gpio"
+	"periph.io/x/periph/conn/pin"
 )
 
 // Bus defines the interface a concrete I²C driver must implement.
@@ -49,6 +54,135 @@ type BusCloser interface {
 	Bus
 }
 
+// MsgFlags modifies how a Msg is transferred.
+type MsgFlags uint16
+
+// Flags supported by Msg. The driver may not support all of them.
+const (
+	// Read reads into Msg.Buf instead of writing it.
+	Read MsgFlags = 1 << iota
+	// TenBit uses a 10 bits address.
+	TenBit
+	// NoStart doesn't send a repeated START and the address before this
+	// message, so its data continues the previous message.
+	NoStart
+	// IgnoreNAK continues the transaction even if the device doesn't
+	// acknowledge a byte.
+	IgnoreNAK
+	// RecvLen reads the number of bytes to read as the first byte, like a SMBus
+	// block read. Msg.Buf must be at least 33 bytes long and is resliced to the
+	// bytes actually read, including the first byte.
+	RecvLen
+)
+
+func (m MsgFlags) String() string {
+	if m == 0 {
+		return "0"
+	}
+	var out []string
+	for i, n := range []string{"Read", "TenBit", "NoStart", "IgnoreNAK", "RecvLen"} {
+		if m&(1<<uint(i)) != 0 {
+			out = append(out, n)
+			m &^= 1 << uint(i)
+		}
+	}
+	if m != 0 {
+		out = append(out, "0x"+strconv.FormatUint(uint64(m), 16))
+	}
+	return strings.Join(out, "|")
+}
+
+// Msg is one segment of a combined transaction done with MsgBus.TxMsgs().
+type Msg struct {
+	Addr  uint16
+	Flags MsgFlags
+	// Buf is the data to write, or the buffer to read into when Flags has Read.
+	Buf []byte
+}
+
+// MsgBus is optionally implemented by a Bus that can do combined transactions
+// made of multiple messages, like the I2C_RDWR ioctl on linux.
+//
+// This is needed for devices requiring more than one write followed by one
+// read, 10 bits addressing or consecutive reads without a STOP.
+type MsgBus interface {
+	// TxMsgs does all the messages as a single transaction.
+	//
+	// A START is sent before the first message, a repeated START between each
+	// message unless NoStart is specified and a STOP after the last one.
+	TxMsgs(msgs []Msg) error
+}
+
+// NACKError is an interface that should be implemented by errors that
+// indicate that the device didn't acknowledge its address or a byte written
+// to it.
+//
+// It generally means that no device is present at this address, that the
+// device is busy or that it rejected the data.
+type NACKError interface {
+	// NACK returns true if the transaction failed because of a NACK.
+	NACK() bool
+}
+
+// ArbitrationLostError is an interface that should be implemented by errors
+// that indicate that another master won the bus arbitration.
+//
+// The transaction can generally be retried.
+type ArbitrationLostError interface {
+	// ArbitrationLost returns true if the bus arbitration was lost.
+	ArbitrationLost() bool
+}
+
+// TimeoutError is an interface that should be implemented by errors that
+// indicate that the transaction didn't complete in time, for example because
+// a device stretched the clock for too long.
+type TimeoutError interface {
+	// Timeout returns true if the transaction timed out.
+	Timeout() bool
+}
+
+// BusBusyError is an interface that should be implemented by errors that
+// indicate that the bus was busy for longer than allowed, so the transaction
+// couldn't start.
+//
+// This can be caused by a device holding SDA low.
+type BusBusyError interface {
+	// BusBusy returns true if the bus was busy.
+	BusBusy() bool
+}
+
+// BusTimeout is optionally implemented by a Bus whose transactions can be
+// aborted after a timeout, so a device stretching the clock indefinitely
+// doesn't block the bus forever.
+type BusTimeout interface {
+	// SetTimeout sets the maximum duration of a transaction. A transaction
+	// taking longer fails with an error implementing TimeoutError.
+	SetTimeout(d time.Duration) error
+}
+
+// Target handles the transactions addressed by a master to a target
+// registered with TargetBus.RegisterTarget().
+//
+// The methods are called sequentially by the driver.
+type Target interface {
+	// OnWrite is called with the bytes written by the master.
+	OnWrite(w []byte)
+	// OnRead is called when the master reads and returns the bytes to send.
+	//
+	// If the master reads more bytes than returned, 0xFF is sent.
+	OnRead() []byte
+}
+
+// TargetBus is optionally implemented by a Bus that can act as a target
+// (slave) for another master on the bus.
+type TargetBus interface {
+	// RegisterTarget starts responding at addr and calls t for each
+	// transaction addressed to it.
+	RegisterTarget(addr uint16, t Target) error
+	// UnregisterTarget stops responding at addr.
+	UnregisterTarget(addr uint16) error
+}
+
 // Pins defines the pins that an I²C bus interconnect is using on the host.
 //
 // It is expected that a implementer of Bus also implement Pins but this is not
@@ -60,6 +194,34 @@ type Pins interface {
 	SDA() gpio.PinIO
 }
 
+// Recover frees a bus on which a device holds SDA low, for example because
+// the host was reset in the middle of a read.
+//
+// The pins are temporarily used as GPIOs to clock SCL up to nine times until
+// the device releases SDA, then a STOP is sent. The original function of the
+// pins is restored if they implement pin.PinFunc, otherwise they are left as
+// inputs with pull up.
+//
+// The bus must not be used concurrently. If SDA is still held low, the error
+// returned implements BusBusyError.
+func Recover(p Pins) error {
+	scl := realPin(p.SCL())
+	sda := realPin(p.SDA())
+	if scl == nil || sda == nil || scl == gpio.INVALID || sda == gpio.INVALID {
+		return errors.New("i2c: can't recover a bus without its pins")
+	}
+	sclFunc := scl.Function()
+	sdaFunc := sda.Function()
+	err := recoverBus(scl, sda)
+	if err2 := restoreFunc(sda, sdaFunc); err == nil {
+		err = err2
+	}
+	if err2 := restoreFunc(scl, sclFunc); err == nil {
+		err = err2
+	}
+	return err
+}
+
 // Dev is a device on a I²C bus.
 //
 // It implements conn.Conn.
@@ -98,4 +260,89 @@ func (d *Dev) Duplex() conn.Duplex {
 
 //
 
+// recoverHalfCycle is half the period of the clock generated by Recover, for
+// 100kHz.
+const recoverHalfCycle = 5 * time.Microsecond
+
+// recoverBus clocks SCL until sda is released then sends a STOP.
+//
+// The pins are used as open drain outputs; a line is released by setting it
+// as an input with pull up.
+func recoverBus(scl, sda gpio.PinIO) error {
+	release := func(p gpio.PinIO) error {
+		if err := p.In(gpio.PullUp, gpio.NoEdge); err != nil {
+			return fmt.Errorf("i2c: %s: %v", p, err)
+		}
+		time.Sleep(recoverHalfCycle)
+		return nil
+	}
+	drive := func(p gpio.PinIO) error {
+		if err := p.Out(gpio.Low); err != nil {
+			return fmt.Errorf("i2c: %s: %v", p, err)
+		}
+		time.Sleep(recoverHalfCycle)
+		return nil
+	}
+	if err := release(sda); err != nil {
+		return err
+	}
+	if err := release(scl); err != nil {
+		return err
+	}
+	// The device releases SDA once it clocked out the rest of the byte and
+	// sees the missing ACK.
+	for i := 0; i < 9 && sda.Read() == gpio.Low; i++ {
+		if err := drive(scl); err != nil {
+			return err
+		}
+		if err := release(scl); err != nil {
+			return err
+		}
+	}
+	if sda.Read() == gpio.Low {
+		return busBusyError("i2c: SDA is still held low after 9 clocks")
+	}
+	// STOP is SDA going high while SCL is high.
+	if err := drive(scl); err != nil {
+		return err
+	}
+	if err := drive(sda); err != nil {
+		return err
+	}
+	if err := release(scl); err != nil {
+		return err
+	}
+	return release(sda)
+}
+
+// restoreFunc sets the function of p back to f, if supported.
+func restoreFunc(p gpio.PinIO, f string) error {
+	if pf, ok := p.(pin.PinFunc); ok {
+		if err := pf.SetFunction(f); err != nil {
+			return fmt.Errorf("i2c: failed to restore %s as %s: %v", p, f, err)
+		}
+	}
+	return nil
+}
+
+// realPin returns the real pin behind an alias.
+func realPin(p gpio.PinIO) gpio.PinIO {
+	if r, ok := p.(gpio.RealPin); ok {
+		return r.Real()
+	}
+	return p
+}
+
+// busBusyError implements BusBusyError.
+type busBusyError string
+
+func (e busBusyError) Error() string {
+	return string(e)
+}
+
+func (e busBusyError) BusBusy() bool {
+	return true
+}
+
 var _ conn.Conn = &Dev{}
+var _ BusBusyError = busBusyError("")
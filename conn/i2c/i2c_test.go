@@ -10,8 +10,58 @@ import (
 	"testing"
 
 	"periph.io/x/periph/conn"
+	"periph.io/x/periph/conn/gpio"
+	"periph.io/x/periph/conn/gpio/gpiotest"
 )
 
+func TestMsgFlags_String(t *testing.T) {
+	if s := MsgFlags(0).String(); s != "0" {
+		t.Fatal(s)
+	}
+	if s := (Read | RecvLen).String(); s != "Read|RecvLen" {
+		t.Fatal(s)
+	}
+	if s := (TenBit | 0x100).String(); s != "TenBit|0x100" {
+		t.Fatal(s)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	scl := &fakeSCL{Pin: gpiotest.Pin{N: "SCL", Fn: "I2C1_SCL"}}
+	sda := &fakeSDA{Pin: gpiotest.Pin{N: "SDA", Fn: "I2C1_SDA"}, scl: scl, release: 3}
+	if err := Recover(&fakePins{scl, sda}); err != nil {
+		t.Fatal(err)
+	}
+	// 3 clocks until SDA is released, then 1 for the STOP.
+	if scl.pulses != 4 {
+		t.Fatal(scl.pulses)
+	}
+	if scl.Read() != gpio.High || sda.Read() != gpio.High {
+		t.Fatal("lines must be released")
+	}
+	if scl.Fn != "I2C1_SCL" || sda.Fn != "I2C1_SDA" || scl.set != 1 {
+		t.Fatal(scl.Fn, sda.Fn, scl.set)
+	}
+}
+
+func TestRecover_stuck(t *testing.T) {
+	scl := &fakeSCL{Pin: gpiotest.Pin{N: "SCL", Fn: "I2C1_SCL"}}
+	sda := &fakeSDA{Pin: gpiotest.Pin{N: "SDA", Fn: "I2C1_SDA"}, scl: scl, release: 10}
+	err := Recover(&fakePins{scl, sda})
+	if e, ok := err.(BusBusyError); !ok || !e.BusBusy() {
+		t.Fatal(err)
+	}
+	if scl.pulses != 9 || scl.Fn != "I2C1_SCL" {
+		t.Fatal(scl.pulses, scl.Fn)
+	}
+	if Recover(&fakePins{gpio.INVALID, sda}) == nil {
+		t.Fatal("invalid pin")
+	}
+	if Recover(&fakePins{scl, nil}) == nil {
+		t.Fatal("nil pin")
+	}
+}
+
 func TestDevString(t *testing.T) {
 	d := Dev{&fakeBus{}, 12}
 	if s := d.String(); s != "fake(12)" {
@@ -103,3 +153,50 @@ func (f *fakeBus) SetSpeed(hz int64) error {
 	f.speed = hz
 	return f.err
 }
+
+type fakePins struct {
+	scl gpio.PinIO
+	sda gpio.PinIO
+}
+
+func (f *fakePins) SCL() gpio.PinIO {
+	return f.scl
+}
+
+func (f *fakePins) SDA() gpio.PinIO {
+	return f.sda
+}
+
+// fakeSCL counts the clocks and implements pin.PinFunc.
+type fakeSCL struct {
+	gpiotest.Pin
+	pulses int
+	set    int
+}
+
+func (f *fakeSCL) Out(l gpio.Level) error {
+	if l == gpio.Low {
+		f.pulses++
+	}
+	return f.Pin.Out(l)
+}
+
+func (f *fakeSCL) SetFunction(fn string) error {
+	f.set++
+	f.Fn = fn
+	return nil
+}
+
+// fakeSDA is held low by a device until release clocks are sent.
+type fakeSDA struct {
+	gpiotest.Pin
+	scl     *fakeSCL
+	release int
+}
+
+func (f *fakeSDA) Read() gpio.Level {
+	if f.scl.pulses < f.release {
+		return gpio.Low
+	}
+	return f.Pin.Read()
+}
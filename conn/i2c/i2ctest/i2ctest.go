@@ -20,6 +20,63 @@ type IO struct {
 	Addr uint16
 	W    []byte
 	R    []byte
+	// Msgs is set instead of W and R for a transaction done with
+	// i2c.MsgBus.TxMsgs(). Addr is the address of the first message. For the
+	// read messages, Buf is the data read.
+	Msgs []i2c.Msg
+	// Err is the error returned by the transaction, if any. Use NACKError,
+	// ArbitrationLostError, TimeoutError or BusBusyError to simulate a typed
+	// bus failure. The read data is ignored when Err is set.
+	Err error
+}
+
+// NACKError implements i2c.NACKError. It can be used as IO.Err.
+type NACKError string
+
+func (e NACKError) Error() string {
+	return string(e)
+}
+
+// NACK implements i2c.NACKError.
+func (e NACKError) NACK() bool {
+	return true
+}
+
+// ArbitrationLostError implements i2c.ArbitrationLostError. It can be used as
+// IO.Err.
+type ArbitrationLostError string
+
+func (e ArbitrationLostError) Error() string {
+	return string(e)
+}
+
+// ArbitrationLost implements i2c.ArbitrationLostError.
+func (e ArbitrationLostError) ArbitrationLost() bool {
+	return true
+}
+
+// TimeoutError implements i2c.TimeoutError. It can be used as IO.Err.
+type TimeoutError string
+
+func (e TimeoutError) Error() string {
+	return string(e)
+}
+
+// Timeout implements i2c.TimeoutError.
+func (e TimeoutError) Timeout() bool {
+	return true
+}
+
+// BusBusyError implements i2c.BusBusyError. It can be used as IO.Err.
+type BusBusyError string
+
+func (e BusBusyError) Error() string {
+	return string(e)
+}
+
+// BusBusy implements i2c.BusBusyError.
+func (e BusBusyError) BusBusy() bool {
+	return true
 }
 
 // Record implements i2c.Bus that records everything written to it.
@@ -52,6 +109,9 @@ func (r *Record) Tx(addr uint16, w, read []byte) error {
 		}
 	} else {
 		if err := r.Bus.Tx(addr, w, read); err != nil {
+			io.R = make([]byte, len(read))
+			io.Err = err
+			r.Ops = append(r.Ops, io)
 			return err
 		}
 	}
@@ -63,6 +123,31 @@ func (r *Record) Tx(addr uint16, w, read []byte) error {
 	return nil
 }
 
+// TxMsgs implements i2c.MsgBus.
+//
+// Bus must implement i2c.MsgBus if any message reads.
+func (r *Record) TxMsgs(msgs []i2c.Msg) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	r.Lock()
+	defer r.Unlock()
+	if b, ok := r.Bus.(i2c.MsgBus); ok {
+		if err := b.TxMsgs(msgs); err != nil {
+			r.Ops = append(r.Ops, IO{Addr: msgs[0].Addr, Msgs: copyMsgs(msgs), Err: err})
+			return err
+		}
+	} else {
+		for _, m := range msgs {
+			if m.Flags&i2c.Read != 0 {
+				return conntest.Errorf("i2ctest: read unsupported when no i2c.MsgBus is connected")
+			}
+		}
+	}
+	r.Ops = append(r.Ops, IO{Addr: msgs[0].Addr, Msgs: copyMsgs(msgs)})
+	return nil
+}
+
 // SetSpeed implements i2c.Bus.
 func (r *Record) SetSpeed(hz int64) error {
 	if r.Bus != nil {
@@ -129,17 +214,76 @@ func (p *Playback) Tx(addr uint16, w, r []byte) error {
 	if addr != p.Ops[p.Count].Addr {
 		return errorf(p.DontPanic, "i2ctest: unexpected addr (count #%d) %d != %d", p.Count, addr, p.Ops[p.Count].Addr)
 	}
+	if len(p.Ops[p.Count].Msgs) != 0 {
+		return errorf(p.DontPanic, "i2ctest: unexpected Tx() (count #%d) while expecting TxMsgs()", p.Count)
+	}
 	if !bytes.Equal(p.Ops[p.Count].W, w) {
 		return errorf(p.DontPanic, "i2ctest: unexpected write (count #%d) %#v != %#v", p.Count, w, p.Ops[p.Count].W)
 	}
 	if len(p.Ops[p.Count].R) != len(r) {
 		return errorf(p.DontPanic, "i2ctest: unexpected read buffer length (count #%d) %d != %d", p.Count, len(r), len(p.Ops[p.Count].R))
 	}
+	if err := p.Ops[p.Count].Err; err != nil {
+		p.Count++
+		return err
+	}
 	copy(r, p.Ops[p.Count].R)
 	p.Count++
 	return nil
 }
 
+// TxMsgs implements i2c.MsgBus.
+//
+// The messages must match the expected ones, except for the content of the
+// buffers of the read messages, which are filled with the expected data. The
+// read messages with RecvLen are resliced to the expected length.
+func (p *Playback) TxMsgs(msgs []i2c.Msg) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	p.Lock()
+	defer p.Unlock()
+	if len(p.Ops) <= p.Count {
+		return errorf(p.DontPanic, "i2ctest: unexpected TxMsgs() (count #%d) expecting %#v", p.Count, msgs)
+	}
+	exp := p.Ops[p.Count].Msgs
+	if len(exp) != len(msgs) {
+		return errorf(p.DontPanic, "i2ctest: unexpected number of messages (count #%d) %d != %d", p.Count, len(msgs), len(exp))
+	}
+	for i := range msgs {
+		m := &msgs[i]
+		e := &exp[i]
+		if m.Addr != e.Addr || m.Flags != e.Flags {
+			return errorf(p.DontPanic, "i2ctest: unexpected message #%d (count #%d) addr %d, flags %s != addr %d, flags %s", i, p.Count, m.Addr, m.Flags, e.Addr, e.Flags)
+		}
+		if m.Flags&i2c.Read == 0 {
+			if !bytes.Equal(m.Buf, e.Buf) {
+				return errorf(p.DontPanic, "i2ctest: unexpected write in message #%d (count #%d) %#v != %#v", i, p.Count, m.Buf, e.Buf)
+			}
+			continue
+		}
+		if m.Flags&i2c.RecvLen != 0 {
+			if len(m.Buf) < len(e.Buf) {
+				return errorf(p.DontPanic, "i2ctest: read buffer too short in message #%d (count #%d) %d < %d", i, p.Count, len(m.Buf), len(e.Buf))
+			}
+			m.Buf = m.Buf[:len(e.Buf)]
+		} else if len(m.Buf) != len(e.Buf) {
+			return errorf(p.DontPanic, "i2ctest: unexpected read buffer length in message #%d (count #%d) %d != %d", i, p.Count, len(m.Buf), len(e.Buf))
+		}
+	}
+	if err := p.Ops[p.Count].Err; err != nil {
+		p.Count++
+		return err
+	}
+	for i := range msgs {
+		if msgs[i].Flags&i2c.Read != 0 {
+			copy(msgs[i].Buf, exp[i].Buf)
+		}
+	}
+	p.Count++
+	return nil
+}
+
 // SetSpeed implements i2c.Bus.
 func (p *Playback) SetSpeed(hz int64) error {
 	return nil
@@ -155,7 +299,112 @@ func (p *Playback) SDA() gpio.PinIO {
 	return p.SDAPin
 }
 
+// Loopback implements i2c.Bus and i2c.TargetBus and routes the transactions
+// done on it as a master to the targets registered on it.
+//
+// This permits testing a master driver and a target implementation together.
+// A write message calls Target.OnWrite() and a read message calls
+// Target.OnRead(). The transactions to an address without target fail with a
+// NACKError.
+type Loopback struct {
+	sync.Mutex
+	targets map[uint16]i2c.Target
+}
+
+func (l *Loopback) String() string {
+	return "loopback"
+}
+
+// Close implements i2c.BusCloser.
+func (l *Loopback) Close() error {
+	return nil
+}
+
+// Tx implements i2c.Bus.
+func (l *Loopback) Tx(addr uint16, w, r []byte) error {
+	var msgs []i2c.Msg
+	if len(w) != 0 || len(r) == 0 {
+		msgs = append(msgs, i2c.Msg{Addr: addr, Buf: w})
+	}
+	if len(r) != 0 {
+		msgs = append(msgs, i2c.Msg{Addr: addr, Flags: i2c.Read, Buf: r})
+	}
+	return l.TxMsgs(msgs)
+}
+
+// TxMsgs implements i2c.MsgBus.
 //
+// NoStart and RecvLen are not supported.
+func (l *Loopback) TxMsgs(msgs []i2c.Msg) error {
+	l.Lock()
+	defer l.Unlock()
+	for _, m := range msgs {
+		if m.Flags&(i2c.NoStart|i2c.RecvLen) != 0 {
+			return conntest.Errorf("i2ctest: unsupported flags %s", m.Flags)
+		}
+		t := l.targets[m.Addr]
+		if t == nil {
+			return NACKError(fmt.Sprintf("i2ctest: no target at address %d", m.Addr))
+		}
+		if m.Flags&i2c.Read == 0 {
+			t.OnWrite(append([]byte{}, m.Buf...))
+			continue
+		}
+		n := copy(m.Buf, t.OnRead())
+		for i := n; i < len(m.Buf); i++ {
+			m.Buf[i] = 0xFF
+		}
+	}
+	return nil
+}
+
+// SetSpeed implements i2c.Bus.
+func (l *Loopback) SetSpeed(hz int64) error {
+	return nil
+}
+
+// RegisterTarget implements i2c.TargetBus.
+func (l *Loopback) RegisterTarget(addr uint16, t i2c.Target) error {
+	if t == nil {
+		return conntest.Errorf("i2ctest: target must not be nil")
+	}
+	l.Lock()
+	defer l.Unlock()
+	if _, ok := l.targets[addr]; ok {
+		return conntest.Errorf("i2ctest: target %d is already registered", addr)
+	}
+	if l.targets == nil {
+		l.targets = map[uint16]i2c.Target{}
+	}
+	l.targets[addr] = t
+	return nil
+}
+
+// UnregisterTarget implements i2c.TargetBus.
+func (l *Loopback) UnregisterTarget(addr uint16) error {
+	l.Lock()
+	defer l.Unlock()
+	if _, ok := l.targets[addr]; !ok {
+		return conntest.Errorf("i2ctest: target %d is not registered", addr)
+	}
+	delete(l.targets, addr)
+	return nil
+}
+
+//
+
+// copyMsgs returns a deep copy of msgs.
+func copyMsgs(msgs []i2c.Msg) []i2c.Msg {
+	out := make([]i2c.Msg, len(msgs))
+	for i, m := range msgs {
+		out[i] = i2c.Msg{Addr: m.Addr, Flags: m.Flags}
+		if len(m.Buf) != 0 {
+			out[i].Buf = make([]byte, len(m.Buf))
+			copy(out[i].Buf, m.Buf)
+		}
+	}
+	return out
+}
 
 // errorf is the internal implementation that optionally panic.
 //
@@ -171,6 +420,16 @@ func errorf(dontPanic bool, format string, a ...interface{}) error {
 var _ i2c.Bus = &Record{}
 var _ i2c.Pins = &Record{}
 var _ i2c.Bus = &Playback{}
+var _ i2c.MsgBus = &Record{}
+var _ i2c.MsgBus = &Playback{}
 var _ i2c.Pins = &Playback{}
 var _ fmt.Stringer = &Record{}
 var _ fmt.Stringer = &Playback{}
+var _ i2c.BusCloser = &Loopback{}
+var _ i2c.MsgBus = &Loopback{}
+var _ i2c.TargetBus = &Loopback{}
+var _ fmt.Stringer = &Loopback{}
+var _ i2c.NACKError = NACKError("")
+var _ i2c.ArbitrationLostError = ArbitrationLostError("")
+var _ i2c.TimeoutError = TimeoutError("")
+var _ i2c.BusBusyError = BusBusyError("")
@@ -5,11 +5,13 @@
 package i2ctest
 
 import (
+	"bytes"
 	"testing"
 
 	"periph.io/x/periph/conn/conntest"
 	"periph.io/x/periph/conn/gpio"
 	"periph.io/x/periph/conn/gpio/gpiotest"
+	"periph.io/x/periph/conn/i2c"
 )
 
 func TestRecord_empty(t *testing.T) {
@@ -165,3 +167,220 @@ func TestRecord_Playback(t *testing.T) {
 		t.Fatal("Playback.Ops is empty")
 	}
 }
+
+func TestPlayback_TxMsgs(t *testing.T) {
+	p := Playback{
+		Ops: []IO{
+			{
+				Addr: 23,
+				Msgs: []i2c.Msg{
+					{Addr: 23, Buf: []byte{10}},
+					{Addr: 23, Flags: i2c.Read, Buf: []byte{12}},
+					{Addr: 23, Flags: i2c.Read | i2c.RecvLen, Buf: []byte{2, 3, 4}},
+				},
+			},
+		},
+		DontPanic: true,
+	}
+	if err := p.TxMsgs(nil); err != nil {
+		t.Fatal(err)
+	}
+	if p.Tx(23, []byte{10}, nil) == nil {
+		t.Fatal("expecting TxMsgs")
+	}
+	if p.TxMsgs([]i2c.Msg{{Addr: 23}}) == nil {
+		t.Fatal("invalid number of messages")
+	}
+	block := make([]byte, 33)
+	msgs := []i2c.Msg{
+		{Addr: 23, Buf: []byte{10}},
+		{Addr: 23, Flags: i2c.Read, Buf: make([]byte, 1)},
+		{Addr: 23, Flags: i2c.Read | i2c.RecvLen, Buf: block},
+	}
+	msgs[0].Buf[0] = 11
+	if p.TxMsgs(msgs) == nil {
+		t.Fatal("invalid write")
+	}
+	msgs[0].Buf[0] = 10
+	msgs[1].Flags = 0
+	if p.TxMsgs(msgs) == nil {
+		t.Fatal("invalid flags")
+	}
+	msgs[1].Flags = i2c.Read
+	msgs[1].Buf = make([]byte, 2)
+	if p.TxMsgs(msgs) == nil {
+		t.Fatal("invalid read size")
+	}
+	msgs[1].Buf = msgs[1].Buf[:1]
+	msgs[2].Buf = block[:2]
+	if p.TxMsgs(msgs) == nil {
+		t.Fatal("block buffer too short")
+	}
+	msgs[2].Buf = block
+	if err := p.TxMsgs(msgs); err != nil {
+		t.Fatal(err)
+	}
+	if msgs[1].Buf[0] != 12 || !bytes.Equal(msgs[2].Buf, []byte{2, 3, 4}) {
+		t.Fatal(msgs)
+	}
+	if p.TxMsgs(msgs) == nil {
+		t.Fatal("Playback.Ops is empty")
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecord_TxMsgs(t *testing.T) {
+	r := Record{}
+	if err := r.TxMsgs(nil); err != nil {
+		t.Fatal(err)
+	}
+	if r.TxMsgs([]i2c.Msg{{Addr: 23, Flags: i2c.Read, Buf: make([]byte, 1)}}) == nil {
+		t.Fatal("Bus is nil")
+	}
+	w := []i2c.Msg{{Addr: 23, Buf: []byte{1}}, {Addr: 23, Flags: i2c.NoStart, Buf: []byte{2}}}
+	if err := r.TxMsgs(w); err != nil {
+		t.Fatal(err)
+	}
+	r.Bus = &Playback{
+		Ops: []IO{
+			{
+				Addr: 23,
+				Msgs: []i2c.Msg{{Addr: 23, Buf: []byte{1}}, {Addr: 23, Flags: i2c.Read, Buf: []byte{5}}},
+			},
+		},
+	}
+	msgs := []i2c.Msg{{Addr: 23, Buf: []byte{1}}, {Addr: 23, Flags: i2c.Read, Buf: make([]byte, 1)}}
+	if err := r.TxMsgs(msgs); err != nil {
+		t.Fatal(err)
+	}
+	w[0].Buf[0] = 42
+	if len(r.Ops) != 2 || r.Ops[0].Msgs[0].Buf[0] != 1 || r.Ops[1].Msgs[1].Buf[0] != 5 {
+		t.Fatal(r.Ops)
+	}
+}
+
+func TestPlayback_Err(t *testing.T) {
+	p := Playback{
+		Ops: []IO{
+			{Addr: 23, W: []byte{10}, R: []byte{0}, Err: NACKError("nack")},
+			{Addr: 23, Err: ArbitrationLostError("arbitration")},
+			{Addr: 23, Err: TimeoutError("timeout")},
+			{Addr: 23, Msgs: []i2c.Msg{{Addr: 23, Buf: []byte{1}}}, Err: BusBusyError("busy")},
+		},
+	}
+	v := [1]byte{42}
+	err := p.Tx(23, []byte{10}, v[:])
+	if e, ok := err.(i2c.NACKError); !ok || !e.NACK() || err.Error() != "nack" {
+		t.Fatal(err)
+	}
+	if v[0] != 42 {
+		t.Fatal("unexpected read")
+	}
+	err = p.Tx(23, nil, nil)
+	if e, ok := err.(i2c.ArbitrationLostError); !ok || !e.ArbitrationLost() || err.Error() != "arbitration" {
+		t.Fatal(err)
+	}
+	err = p.Tx(23, nil, nil)
+	if e, ok := err.(i2c.TimeoutError); !ok || !e.Timeout() || err.Error() != "timeout" {
+		t.Fatal(err)
+	}
+	err = p.TxMsgs([]i2c.Msg{{Addr: 23, Buf: []byte{1}}})
+	if e, ok := err.(i2c.BusBusyError); !ok || !e.BusBusy() || err.Error() != "busy" {
+		t.Fatal(err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecord_Err(t *testing.T) {
+	r := Record{
+		Bus: &Playback{
+			Ops: []IO{
+				{Addr: 23, W: []byte{10}, R: []byte{0}, Err: NACKError("nack")},
+				{Addr: 23, Msgs: []i2c.Msg{{Addr: 23, Buf: []byte{1}}}, Err: TimeoutError("timeout")},
+			},
+		},
+	}
+	v := [1]byte{}
+	if r.Tx(23, []byte{10}, v[:]) == nil {
+		t.Fatal("expected NACK")
+	}
+	if r.TxMsgs([]i2c.Msg{{Addr: 23, Buf: []byte{1}}}) == nil {
+		t.Fatal("expected timeout")
+	}
+	if len(r.Ops) != 2 || len(r.Ops[0].R) != 1 || r.Ops[0].Err.Error() != "nack" || r.Ops[1].Err.Error() != "timeout" {
+		t.Fatal(r.Ops)
+	}
+}
+
+func TestLoopback(t *testing.T) {
+	l := Loopback{}
+	if s := l.String(); s != "loopback" {
+		t.Fatal(s)
+	}
+	if l.RegisterTarget(0x10, nil) == nil {
+		t.Fatal("nil target")
+	}
+	tg := &registers{}
+	if err := l.RegisterTarget(0x10, tg); err != nil {
+		t.Fatal(err)
+	}
+	if l.RegisterTarget(0x10, tg) == nil {
+		t.Fatal("already registered")
+	}
+	if err := l.SetSpeed(100000); err != nil {
+		t.Fatal(err)
+	}
+
+	// A register based device driven by i2c.Dev.
+	d := i2c.Dev{Bus: &l, Addr: 0x10}
+	if _, err := d.Write([]byte{2, 0xAA, 0xBB}); err != nil {
+		t.Fatal(err)
+	}
+	r := make([]byte, 3)
+	if err := d.Tx([]byte{2}, r); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(r, []byte{0xAA, 0xBB, 0xFF}) {
+		t.Fatal(r)
+	}
+	if err := l.TxMsgs([]i2c.Msg{{Addr: 0x10, Flags: i2c.NoStart}}); err == nil {
+		t.Fatal("NoStart is not supported")
+	}
+
+	err := l.Tx(0x11, []byte{1}, nil)
+	if e, ok := err.(i2c.NACKError); !ok || !e.NACK() {
+		t.Fatal(err)
+	}
+	if err := l.UnregisterTarget(0x10); err != nil {
+		t.Fatal(err)
+	}
+	if l.UnregisterTarget(0x10) == nil {
+		t.Fatal("not registered")
+	}
+	if l.Tx(0x10, nil, nil) == nil {
+		t.Fatal("unregistered")
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// registers is a target exposing 4 registers of 1 byte, selected by the
+// first byte written.
+type registers struct {
+	reg  byte
+	regs [4]byte
+}
+
+func (r *registers) OnWrite(w []byte) {
+	r.reg = w[0]
+	copy(r.regs[r.reg:], w[1:])
+}
+
+func (r *registers) OnRead() []byte {
+	return r.regs[r.reg:]
+}
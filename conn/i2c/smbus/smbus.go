@@ -0,0 +1,351 @@
+// Copyright 2018 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+// Package smbus implements the SMBus protocol on top of an I²C bus.
+//
+// SMBus is a subset of I²C with well defined transactions: quick command,
+// send and receive byte, read and write byte or word, process call and block
+// transfers. It optionally appends a CRC-8 named Packet Error Checking (PEC) to
+// each transaction.
+//
+// When the i2c.Bus implements Bus, the transactions are executed natively by
+// the driver. This is required for adapters that can't do generic I²C
+// transfers. Otherwise, the transactions are done via i2c.Bus.Tx().
+//
+// Specification
+//
+// http://smbus.org/specs/SMBus_3_1_20180319.pdf
+package smbus
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"periph.io/x/periph/conn/i2c"
+)
+
+// MaxBlockSize is the maximum number of bytes in a block transfer.
+const MaxBlockSize = 32
+
+// AlertResponseAddress is the address that devices asserting the SMBALERT#
+// line respond to.
+const AlertResponseAddress = 0x0C
+
+// Protocol is a kind of SMBus transaction.
+type Protocol uint8
+
+// Supported SMBus transactions.
+const (
+	// Quick sends only the address; the R/W bit is the data.
+	Quick Protocol = iota
+	// Byte is "Send Byte" or "Receive Byte", without command byte.
+	Byte
+	// ByteData is "Write Byte" or "Read Byte".
+	ByteData
+	// WordData is "Write Word" or "Read Word".
+	WordData
+	// ProcCall writes a word and reads back a word.
+	ProcCall
+	// BlockData is "Block Write" or "Block Read", prefixed with a byte count.
+	BlockData
+	// BlockProcCall writes a block and reads back a block.
+	BlockProcCall
+)
+
+const protocolName = "QuickByteByteDataWordDataProcCallBlockDataBlockProcCall"
+
+var protocolIndex = [...]uint8{0, 5, 9, 17, 25, 33, 42, 55}
+
+func (i Protocol) String() string {
+	if i >= Protocol(len(protocolIndex)-1) {
+		return "Protocol(" + strconv.Itoa(int(i)) + ")"
+	}
+	return protocolName[protocolIndex[i]:protocolIndex[i+1]]
+}
+
+// Transfer is a single SMBus transaction.
+type Transfer struct {
+	Protocol Protocol
+	// Read is the direction of the transaction. It is ignored for ProcCall and
+	// BlockProcCall, which always write then read.
+	Read bool
+	// Cmd is the command byte. It is the data byte for a Byte write and is
+	// ignored for Quick and Byte reads.
+	Cmd byte
+	// W is the data written after Cmd; 2 bytes in little endian for words.
+	W []byte
+	// R is the buffer for the data read; it must be MaxBlockSize bytes long
+	// for blocks.
+	R []byte
+	// PEC enables Packet Error Checking.
+	PEC bool
+}
+
+// Bus is optionally implemented by an i2c.Bus whose driver can execute SMBus
+// transactions natively.
+type Bus interface {
+	// SMBus executes t with the device at addr and returns the number of bytes
+	// read into t.R.
+	SMBus(addr uint16, t *Transfer) (int, error)
+}
+
+// Dev is a device on a SMBus.
+//
+// It saves from repeatedly specifying the device address.
+type Dev struct {
+	Bus  i2c.Bus
+	Addr uint16
+	// PEC enables Packet Error Checking on all the transactions.
+	PEC bool
+}
+
+func (d *Dev) String() string {
+	return fmt.Sprintf("%s(%d)", d.Bus, d.Addr)
+}
+
+// QuickCommand sends the address and the R/W bit only, without any data.
+//
+// It is generally used to turn a device on or off, or to probe for its
+// presence.
+func (d *Dev) QuickCommand(read bool) error {
+	_, err := d.do(&Transfer{Protocol: Quick, Read: read})
+	return err
+}
+
+// SendByte sends a single byte without command byte.
+func (d *Dev) SendByte(b byte) error {
+	_, err := d.do(&Transfer{Protocol: Byte, Cmd: b})
+	return err
+}
+
+// ReceiveByte reads a single byte without command byte.
+func (d *Dev) ReceiveByte() (byte, error) {
+	var r [1]byte
+	_, err := d.do(&Transfer{Protocol: Byte, Read: true, R: r[:]})
+	return r[0], err
+}
+
+// ReadByteData reads the byte at register cmd.
+func (d *Dev) ReadByteData(cmd byte) (byte, error) {
+	var r [1]byte
+	_, err := d.do(&Transfer{Protocol: ByteData, Read: true, Cmd: cmd, R: r[:]})
+	return r[0], err
+}
+
+// WriteByteData writes the byte v at register cmd.
+func (d *Dev) WriteByteData(cmd, v byte) error {
+	_, err := d.do(&Transfer{Protocol: ByteData, Cmd: cmd, W: []byte{v}})
+	return err
+}
+
+// ReadWordData reads the word at register cmd.
+//
+// SMBus words are transmitted in little endian.
+func (d *Dev) ReadWordData(cmd byte) (uint16, error) {
+	var r [2]byte
+	_, err := d.do(&Transfer{Protocol: WordData, Read: true, Cmd: cmd, R: r[:]})
+	return uint16(r[0]) | uint16(r[1])<<8, err
+}
+
+// WriteWordData writes the word v at register cmd.
+func (d *Dev) WriteWordData(cmd byte, v uint16) error {
+	_, err := d.do(&Transfer{Protocol: WordData, Cmd: cmd, W: []byte{byte(v), byte(v >> 8)}})
+	return err
+}
+
+// ProcessCall writes the word v at register cmd and reads back a word.
+func (d *Dev) ProcessCall(cmd byte, v uint16) (uint16, error) {
+	var r [2]byte
+	_, err := d.do(&Transfer{Protocol: ProcCall, Cmd: cmd, W: []byte{byte(v), byte(v >> 8)}, R: r[:]})
+	return uint16(r[0]) | uint16(r[1])<<8, err
+}
+
+// ReadBlock reads a block of up to MaxBlockSize bytes at register cmd.
+//
+// When the bus doesn't implement Bus, MaxBlockSize bytes are always read since
+// i2c.Bus can't stop the read according to the byte count sent by the device.
+func (d *Dev) ReadBlock(cmd byte) ([]byte, error) {
+	r := make([]byte, MaxBlockSize)
+	n, err := d.do(&Transfer{Protocol: BlockData, Read: true, Cmd: cmd, R: r})
+	if err != nil {
+		return nil, err
+	}
+	return r[:n], nil
+}
+
+// WriteBlock writes a block of up to MaxBlockSize bytes at register cmd.
+func (d *Dev) WriteBlock(cmd byte, b []byte) error {
+	_, err := d.do(&Transfer{Protocol: BlockData, Cmd: cmd, W: b})
+	return err
+}
+
+// BlockProcessCall writes a block at register cmd and reads back a block.
+//
+// The sum of both blocks is limited to MaxBlockSize bytes.
+func (d *Dev) BlockProcessCall(cmd byte, w []byte) ([]byte, error) {
+	r := make([]byte, MaxBlockSize)
+	n, err := d.do(&Transfer{Protocol: BlockProcCall, Cmd: cmd, W: w, R: r})
+	if err != nil {
+		return nil, err
+	}
+	return r[:n], nil
+}
+
+// ReadAlert returns the address of the device asserting the SMBALERT# line by
+// reading the Alert Response Address.
+//
+// When multiple devices assert the line, the one with the lowest address wins
+// the arbitration and releases the line; call ReadAlert again while the line
+// is asserted.
+func ReadAlert(b i2c.Bus) (uint16, error) {
+	d := Dev{Bus: b, Addr: AlertResponseAddress}
+	v, err := d.ReceiveByte()
+	if err != nil {
+		return 0, err
+	}
+	return uint16(v >> 1), nil
+}
+
+// CRC8 returns the Packet Error Code of b, which is a CRC-8 with the
+// polynomial x^8+x^2+x+1.
+func CRC8(b []byte) byte {
+	var c byte
+	for _, v := range b {
+		c ^= v
+		for i := 0; i < 8; i++ {
+			if c&0x80 != 0 {
+				c = c<<1 ^ 0x07
+			} else {
+				c <<= 1
+			}
+		}
+	}
+	return c
+}
+
+//
+
+func (d *Dev) do(t *Transfer) (int, error) {
+	if err := check(t); err != nil {
+		return 0, err
+	}
+	t.PEC = d.PEC
+	if b, ok := d.Bus.(Bus); ok {
+		return b.SMBus(d.Addr, t)
+	}
+	return transfer(d.Bus, d.Addr, t)
+}
+
+// check verifies the data sizes.
+func check(t *Transfer) error {
+	switch t.Protocol {
+	case BlockData:
+		if !t.Read && len(t.W) > MaxBlockSize {
+			return fmt.Errorf("smbus: block of %d bytes is larger than %d bytes", len(t.W), MaxBlockSize)
+		}
+	case BlockProcCall:
+		if len(t.W) == 0 || len(t.W) > MaxBlockSize-1 {
+			return fmt.Errorf("smbus: block process call must write between 1 and %d bytes, got %d", MaxBlockSize-1, len(t.W))
+		}
+	}
+	return nil
+}
+
+// transfer executes t over a generic I²C bus.
+func transfer(b i2c.Bus, addr uint16, t *Transfer) (int, error) {
+	if addr >= 0x80 {
+		return 0, errors.New("smbus: invalid address " + strconv.Itoa(int(addr)))
+	}
+	aw := byte(addr << 1)
+	ar := aw | 1
+	var w, r []byte
+	// pec is the data covered by the PEC.
+	var pec []byte
+	switch t.Protocol {
+	case Quick:
+		if t.Read {
+			return 0, errors.New("smbus: quick read requires a bus implementing smbus.Bus")
+		}
+		return 0, b.Tx(addr, nil, nil)
+	case Byte:
+		if t.Read {
+			r = make([]byte, 1)
+			pec = []byte{ar}
+		} else {
+			w = []byte{t.Cmd}
+			pec = []byte{aw}
+		}
+	case ByteData, WordData:
+		l := 1
+		if t.Protocol == WordData {
+			l = 2
+		}
+		if t.Read {
+			w = []byte{t.Cmd}
+			r = make([]byte, l)
+			pec = []byte{aw, t.Cmd, ar}
+		} else {
+			w = append([]byte{t.Cmd}, t.W[:l]...)
+			pec = []byte{aw}
+		}
+	case ProcCall:
+		w = append([]byte{t.Cmd}, t.W[:2]...)
+		r = make([]byte, 2)
+		pec = []byte{aw, t.Cmd, t.W[0], t.W[1], ar}
+	case BlockData:
+		if t.Read {
+			w = []byte{t.Cmd}
+			r = make([]byte, 1+MaxBlockSize)
+			pec = []byte{aw, t.Cmd, ar}
+		} else {
+			w = append([]byte{t.Cmd, byte(len(t.W))}, t.W...)
+			pec = []byte{aw}
+		}
+	case BlockProcCall:
+		w = append([]byte{t.Cmd, byte(len(t.W))}, t.W...)
+		r = make([]byte, 1+MaxBlockSize-len(t.W))
+		pec = append([]byte{aw}, w...)
+		pec = append(pec, ar)
+	default:
+		return 0, errors.New("smbus: invalid protocol " + t.Protocol.String())
+	}
+	if t.PEC {
+		if len(r) != 0 {
+			r = append(r, 0)
+		} else {
+			pec = append(pec, w...)
+			w = append(w, CRC8(pec))
+		}
+	}
+	if err := b.Tx(addr, w, r); err != nil {
+		return 0, err
+	}
+	if len(r) == 0 {
+		return 0, nil
+	}
+	data := r
+	if t.PEC {
+		data = r[:len(r)-1]
+	}
+	if t.Protocol == BlockData || t.Protocol == BlockProcCall {
+		n := int(r[0])
+		if n > len(data)-1 {
+			return 0, fmt.Errorf("smbus: device returned a block of %d bytes", n)
+		}
+		data = r[:1+n]
+		if t.PEC {
+			r = r[:2+n]
+		}
+	}
+	if t.PEC {
+		if c := CRC8(append(pec, data...)); c != r[len(r)-1] {
+			return 0, fmt.Errorf("smbus: PEC mismatch; expected 0x%02X, got 0x%02X", c, r[len(r)-1])
+		}
+	}
+	if t.Protocol == BlockData || t.Protocol == BlockProcCall {
+		data = data[1:]
+	}
+	return copy(t.R, data), nil
+}
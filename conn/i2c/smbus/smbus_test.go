@@ -0,0 +1,190 @@
+// Copyright 2018 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package smbus
+
+import (
+	"bytes"
+	"testing"
+
+	"periph.io/x/periph/conn/i2c/i2ctest"
+)
+
+func TestCRC8(t *testing.T) {
+	if c := CRC8([]byte("123456789")); c != 0xF4 {
+		t.Fatalf("0x%02X", c)
+	}
+	if c := CRC8(nil); c != 0 {
+		t.Fatal(c)
+	}
+}
+
+func TestProtocol_String(t *testing.T) {
+	if s := BlockProcCall.String(); s != "BlockProcCall" {
+		t.Fatal(s)
+	}
+	if s := Protocol(10).String(); s != "Protocol(10)" {
+		t.Fatal(s)
+	}
+}
+
+func TestDev(t *testing.T) {
+	bus := i2ctest.Playback{
+		Ops: []i2ctest.IO{
+			{Addr: 0x10},
+			{Addr: 0x10, W: []byte{0x42}},
+			{Addr: 0x10, R: []byte{0x43}},
+			{Addr: 0x10, W: []byte{0x01}, R: []byte{0x44}},
+			{Addr: 0x10, W: []byte{0x01, 0x45}},
+			{Addr: 0x10, W: []byte{0x02}, R: []byte{0x34, 0x12}},
+			{Addr: 0x10, W: []byte{0x02, 0x78, 0x56}},
+			{Addr: 0x10, W: []byte{0x03, 0x01, 0x02}, R: []byte{0x03, 0x04}},
+			{Addr: 0x10, W: []byte{0x04}, R: append([]byte{2, 0xA, 0xB}, make([]byte, 30)...)},
+			{Addr: 0x10, W: []byte{0x04, 3, 1, 2, 3}},
+			{Addr: 0x10, W: []byte{0x05, 1, 9}, R: append([]byte{1, 8}, make([]byte, 30)...)},
+		},
+	}
+	d := Dev{Bus: &bus, Addr: 0x10}
+	if s := d.String(); s != "playback(16)" {
+		t.Fatal(s)
+	}
+	if err := d.QuickCommand(false); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SendByte(0x42); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := d.ReceiveByte(); err != nil || v != 0x43 {
+		t.Fatal(v, err)
+	}
+	if v, err := d.ReadByteData(1); err != nil || v != 0x44 {
+		t.Fatal(v, err)
+	}
+	if err := d.WriteByteData(1, 0x45); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := d.ReadWordData(2); err != nil || v != 0x1234 {
+		t.Fatal(v, err)
+	}
+	if err := d.WriteWordData(2, 0x5678); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := d.ProcessCall(3, 0x0201); err != nil || v != 0x0403 {
+		t.Fatal(v, err)
+	}
+	if b, err := d.ReadBlock(4); err != nil || !bytes.Equal(b, []byte{0xA, 0xB}) {
+		t.Fatal(b, err)
+	}
+	if err := d.WriteBlock(4, []byte{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := d.BlockProcessCall(5, []byte{9}); err != nil || !bytes.Equal(b, []byte{8}) {
+		t.Fatal(b, err)
+	}
+	if err := bus.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDev_PEC(t *testing.T) {
+	const aw, ar = 0x10 << 1, 0x10<<1 | 1
+	block := append([]byte{2, 0xA, 0xB, CRC8([]byte{aw, 0x04, ar, 2, 0xA, 0xB})}, make([]byte, 30)...)
+	bus := i2ctest.Playback{
+		Ops: []i2ctest.IO{
+			{Addr: 0x10, W: []byte{0x01, 0x45, CRC8([]byte{aw, 0x01, 0x45})}},
+			{Addr: 0x10, W: []byte{0x02}, R: []byte{0x34, 0x12, CRC8([]byte{aw, 0x02, ar, 0x34, 0x12})}},
+			{Addr: 0x10, W: []byte{0x04}, R: block},
+			{Addr: 0x10, W: []byte{0x02}, R: []byte{0x34, 0x12, 0}},
+		},
+		DontPanic: true,
+	}
+	d := Dev{Bus: &bus, Addr: 0x10, PEC: true}
+	if err := d.WriteByteData(1, 0x45); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := d.ReadWordData(2); err != nil || v != 0x1234 {
+		t.Fatal(v, err)
+	}
+	if b, err := d.ReadBlock(4); err != nil || !bytes.Equal(b, []byte{0xA, 0xB}) {
+		t.Fatal(b, err)
+	}
+	if _, err := d.ReadWordData(2); err == nil {
+		t.Fatal("PEC mismatch")
+	}
+	if err := bus.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDev_fail(t *testing.T) {
+	bus := i2ctest.Playback{
+		Ops: []i2ctest.IO{
+			{Addr: 0x10, W: []byte{0x04}, R: append([]byte{33}, make([]byte, 32)...)},
+		},
+		DontPanic: true,
+	}
+	d := Dev{Bus: &bus, Addr: 0x10}
+	if d.QuickCommand(true) == nil {
+		t.Fatal("quick read is not supported on i2c.Bus")
+	}
+	if d.WriteBlock(4, make([]byte, 33)) == nil {
+		t.Fatal("block too large")
+	}
+	if _, err := d.BlockProcessCall(4, nil); err == nil {
+		t.Fatal("empty block")
+	}
+	if _, err := d.ReadBlock(4); err == nil {
+		t.Fatal("invalid block size")
+	}
+	if _, err := d.ReceiveByte(); err == nil {
+		t.Fatal("playback is empty")
+	}
+	d.Addr = 0x80
+	if d.SendByte(0) == nil {
+		t.Fatal("invalid address")
+	}
+}
+
+func TestDev_native(t *testing.T) {
+	b := &fakeBus{r: []byte{0x55}}
+	d := Dev{Bus: b, Addr: 0x10, PEC: true}
+	if err := d.QuickCommand(true); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := d.ReadByteData(3); err != nil || v != 0x55 {
+		t.Fatal(v, err)
+	}
+	if b.addr != 0x10 || b.t.Protocol != ByteData || !b.t.Read || b.t.Cmd != 3 || !b.t.PEC {
+		t.Fatal(b.t)
+	}
+}
+
+func TestReadAlert(t *testing.T) {
+	bus := i2ctest.Playback{
+		Ops: []i2ctest.IO{
+			{Addr: AlertResponseAddress, R: []byte{0x48<<1 | 1}},
+		},
+	}
+	if a, err := ReadAlert(&bus); err != nil || a != 0x48 {
+		t.Fatal(a, err)
+	}
+	if err := bus.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+//
+
+type fakeBus struct {
+	i2ctest.Playback
+	addr uint16
+	t    Transfer
+	r    []byte
+}
+
+func (f *fakeBus) SMBus(addr uint16, t *Transfer) (int, error) {
+	f.addr = addr
+	f.t = *t
+	return copy(t.R, f.r), nil
+}
@@ -5,6 +5,8 @@
 // Package ir defines InfraRed codes for use with a IR remote control.
 package ir
 
+import "context"
+
 // Key represents one of the supported key press.
 type Key string
 
@@ -566,3 +568,11 @@ type Conn interface {
 	// Emit emits a key press.
 	Emit(remote string, key Key) error
 }
+
+// ConnContext is optionally implemented by a Conn that can stop listening to
+// new messages via a context.
+type ConnContext interface {
+	// ChannelContext is like Channel() but the returned channel is also closed
+	// once ctx is done.
+	ChannelContext(ctx context.Context) <-chan Message
+}
@@ -24,6 +24,38 @@ func CalcCRC(buf []byte) byte {
 	return crc
 }
 
+// CheckCRC16 verifies that the last 2 bytes of the buffer contain the
+// inverted CRC-16 of the previous bytes, least significant byte first.
+//
+// This is how devices like the DS2408 and DS2431 send their CRC-16.
+func CheckCRC16(buf []byte) bool {
+	if len(buf) < 2 {
+		return false
+	}
+	crc := ^CalcCRC16(buf[:len(buf)-2])
+	return buf[len(buf)-2] == byte(crc) && buf[len(buf)-1] == byte(crc>>8)
+}
+
+// CalcCRC16 calculates the 16-bit CRC across the buffer of bytes and returns
+// it.
+//
+// The polynomial is X¹⁶+X¹⁵+X²+1, as described in App Note 27. Devices send
+// the inverted CRC.
+func CalcCRC16(buf []byte) uint16 {
+	var crc uint16
+	for _, b := range buf {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xa001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
+
 // crcTable comes from https://www.maximintegrated.com/en/app-notes/index.mvp/id/27
 var crcTable = []byte{
 	0, 94, 188, 226, 97, 63, 221, 131, 194, 156, 126, 32, 163, 253, 31, 65,
@@ -25,3 +25,23 @@ func TestCheckCRC(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+func TestCheckCRC16(t *testing.T) {
+	if c := CalcCRC16([]byte("123456789")); c != 0xbb3d {
+		t.Fatalf("%#x", c)
+	}
+	// Read memory of a DS2431: command, address, data and the inverted CRC-16.
+	b := []byte{0xf0, 0, 0, 1, 2, 3, 4}
+	c := ^CalcCRC16(b)
+	b = append(b, byte(c), byte(c>>8))
+	if !CheckCRC16(b) {
+		t.FailNow()
+	}
+	b[len(b)-1]++
+	if CheckCRC16(b) {
+		t.FailNow()
+	}
+	if CheckCRC16([]byte{0}) {
+		t.FailNow()
+	}
+}
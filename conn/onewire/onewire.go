@@ -22,6 +22,7 @@
 package onewire
 
 import (
+	"errors"
 	"fmt"
 
 	"periph.io/x/periph/conn"
@@ -81,6 +82,22 @@ func (p Pullup) String() string {
 	return "Weak"
 }
 
+// BusOverdrive is a 1-wire bus that supports the overdrive speed.
+//
+// It is expected that an implementer of Bus also implement BusOverdrive when
+// the hardware supports it, but this is not required.
+type BusOverdrive interface {
+	Bus
+	// TxOverdrive performs a bus transaction like Tx, except that only the
+	// reset and the ROM command rom are sent at standard speed; w and r are
+	// transferred at overdrive speed.
+	//
+	// rom is either OverdriveSkipROM or OverdriveMatchROM, which switch the
+	// selected devices to overdrive speed. The devices stay in overdrive until
+	// the next reset at standard speed, e.g. at the start of the next Tx.
+	TxOverdrive(rom byte, w, r []byte, power Pullup) error
+}
+
 // BusCloser is a 1-wire bus that can be closed.
 //
 // It is expected that an implementer of Bus also implement BusCloser, but
@@ -169,12 +186,26 @@ func (d *Dev) Tx(w, r []byte) error {
 	// Issue ROM match command to select the device followed by the
 	// bytes being written.
 	ww := make([]byte, 9, len(w)+9)
-	ww[0] = 0x55 // Match ROM
+	ww[0] = MatchROM
 	putUint64(ww[1:], d.Addr)
 	ww = append(ww, w...)
 	return d.Bus.Tx(ww, r, WeakPullup)
 }
 
+// TxOverdrive performs an "overdrive match ROM" command on the bus to select
+// the device and switch it to overdrive speed, and then transmits and
+// receives the specified bytes at overdrive speed. It ends by leaving a weak
+// pull-up on the bus.
+//
+// Dev.Bus must implement BusOverdrive.
+func (d *Dev) TxOverdrive(w, r []byte) error {
+	b, ok := d.Bus.(BusOverdrive)
+	if !ok {
+		return errors.New("onewire: bus doesn't support overdrive")
+	}
+	return OverdriveMatch(b, d.Addr, w, r, WeakPullup)
+}
+
 // Duplex always return conn.Half for 1-wire.
 func (d *Dev) Duplex() conn.Duplex {
 	return conn.Half
@@ -190,7 +221,7 @@ func (d *Dev) TxPower(w, r []byte) error {
 	// Issue ROM match command to select the device followed by the
 	// bytes being written.
 	ww := make([]byte, 9, len(w)+9)
-	ww[0] = 0x55 // Match ROM
+	ww[0] = MatchROM
 	putUint64(ww[1:], d.Addr)
 	ww = append(ww, w...)
 	return d.Bus.Tx(ww, r, StrongPullup)
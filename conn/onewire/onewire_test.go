@@ -86,6 +86,55 @@ func TestDevTxPower(t *testing.T) {
 	}
 }
 
+func TestDevTxOverdrive(t *testing.T) {
+	b := nopBus("hi")
+	d := Dev{Bus: &b, Addr: 12}
+	if err := d.TxOverdrive([]byte{1}, nil); err == nil || err.Error() != "onewire: bus doesn't support overdrive" {
+		t.Fatal(err)
+	}
+}
+
+func TestSkip(t *testing.T) {
+	b := &fakeBus{r: []byte{1}}
+	r := make([]byte, 1)
+	if err := Skip(b, []byte{0xbe}, r, StrongPullup); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b.w, []byte{0xcc, 0xbe}) || r[0] != 1 || b.power != StrongPullup {
+		t.Fatal(b.w, r, b.power)
+	}
+	b = &fakeBus{}
+	if err := Resume(b, []byte{0xaa}, nil, WeakPullup); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b.w, []byte{0xa5, 0xaa}) {
+		t.Fatal(b.w)
+	}
+}
+
+func TestOverdrive(t *testing.T) {
+	b := &fakeOverdriveBus{}
+	if err := OverdriveMatch(b, 12, []byte{3, 4}, nil, WeakPullup); err != nil {
+		t.Fatal(err)
+	}
+	if b.rom != OverdriveMatchROM || !bytes.Equal(b.w, []byte{12, 0, 0, 0, 0, 0, 0, 0, 3, 4}) {
+		t.Fatal(b.rom, b.w)
+	}
+	if err := OverdriveSkip(b, []byte{0x44}, nil, StrongPullup); err != nil {
+		t.Fatal(err)
+	}
+	if b.rom != OverdriveSkipROM || !bytes.Equal(b.w, []byte{0x44}) || b.power != StrongPullup {
+		t.Fatal(b.rom, b.w, b.power)
+	}
+	d := Dev{Bus: b, Addr: 12}
+	if err := d.TxOverdrive([]byte{5}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if b.rom != OverdriveMatchROM || !bytes.Equal(b.w, []byte{12, 0, 0, 0, 0, 0, 0, 0, 5}) || b.power != WeakPullup {
+		t.Fatal(b.rom, b.w, b.power)
+	}
+}
+
 //
 
 type fakeBus struct {
@@ -121,3 +170,18 @@ func (b *nopBus) String() string                           { return string(*b) }
 func (b *nopBus) Tx(w, r []byte, power Pullup) error       { return nil }
 func (b *nopBus) Search(alarmOnly bool) ([]Address, error) { return nil, nil }
 func (b *nopBus) Close() error                             { return nil }
+
+// fakeOverdriveBus implements BusOverdrive.
+type fakeOverdriveBus struct {
+	nopBus
+	rom   byte
+	w     []byte
+	power Pullup
+}
+
+func (f *fakeOverdriveBus) TxOverdrive(rom byte, w, r []byte, power Pullup) error {
+	f.rom = rom
+	f.w = w
+	f.power = power
+	return nil
+}
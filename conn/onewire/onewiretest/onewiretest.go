@@ -15,10 +15,14 @@ import (
 )
 
 // IO registers the I/O that happened on either a real or fake 1-wire bus.
+//
+// When Overdrive is true, the operation was a TxOverdrive and W starts with
+// the ROM command.
 type IO struct {
-	W    []byte
-	R    []byte
-	Pull onewire.Pullup
+	W         []byte
+	R         []byte
+	Pull      onewire.Pullup
+	Overdrive bool
 }
 
 // Record implements onewire.Bus that records everything written to it.
@@ -52,12 +56,31 @@ func (r *Record) Tx(w, read []byte, pull onewire.Pullup) error {
 			return err
 		}
 	}
-	if len(read) != 0 {
-		io.R = make([]byte, len(read))
-		copy(io.R, read)
+	return r.record(io, read)
+}
+
+// TxOverdrive implements onewire.BusOverdrive.
+//
+// Bus must implement onewire.BusOverdrive, unless only writes are being
+// recorded.
+func (r *Record) TxOverdrive(rom byte, w, read []byte, pull onewire.Pullup) error {
+	io := IO{W: append([]byte{rom}, w...), Pull: pull, Overdrive: true}
+	r.Lock()
+	defer r.Unlock()
+	if r.Bus == nil {
+		if len(read) != 0 {
+			return conntest.Errorf("onewiretest: read unsupported when no bus is connected")
+		}
+	} else {
+		b, ok := r.Bus.(onewire.BusOverdrive)
+		if !ok {
+			return conntest.Errorf("onewiretest: bus doesn't support overdrive")
+		}
+		if err := b.TxOverdrive(rom, w, read, pull); err != nil {
+			return err
+		}
 	}
-	r.Ops = append(r.Ops, io)
-	return nil
+	return r.record(io, read)
 }
 
 // Q implements onewire.Pins.
@@ -76,7 +99,7 @@ func (r *Record) Search(alarmOnly bool) ([]onewire.Address, error) {
 // Playback implements onewire.Bus and plays back a recorded I/O flow.
 //
 // The bus' search function is special-cased. When a Tx operation has
-// 0xf0 in w[0] the search state is reset and subsequent triplet operations
+// 0xf0 or 0xec in w[0] the search state is reset and subsequent triplet operations
 // respond according to the list of Devices.  In other words, Tx is
 // replayed but the responses to SearchTriplet operations are simulated.
 //
@@ -118,24 +141,25 @@ func (p *Playback) Tx(w, r []byte, pull onewire.Pullup) error {
 	if len(p.Ops) <= p.Count {
 		return errorf(p.DontPanic, "onewiretest: unexpected Tx() (count #%d) W:%#v  R:%#v", p.Count, w, r)
 	}
-	if !bytes.Equal(p.Ops[p.Count].W, w) {
-		return errorf(p.DontPanic, "onewiretest: unexpected write (count #%d) %#v != %#v", p.Count, w, p.Ops[p.Count].W)
+	if p.Ops[p.Count].Overdrive {
+		return errorf(p.DontPanic, "onewiretest: unexpected Tx() (count #%d); expected TxOverdrive()", p.Count)
 	}
-	if len(p.Ops[p.Count].R) != len(r) {
-		return errorf(p.DontPanic, "onewiretest: unexpected read buffer length (count #%d) %d != %d", p.Count, len(r), len(p.Ops[p.Count].R))
+	return p.playback(w, r, pull)
+}
+
+// TxOverdrive implements onewire.BusOverdrive.
+//
+// The recorded write must start with the ROM command.
+func (p *Playback) TxOverdrive(rom byte, w, r []byte, pull onewire.Pullup) error {
+	p.Lock()
+	defer p.Unlock()
+	if len(p.Ops) <= p.Count {
+		return errorf(p.DontPanic, "onewiretest: unexpected TxOverdrive() (count #%d) ROM:%#x W:%#v  R:%#v", p.Count, rom, w, r)
 	}
-	if pull != p.Ops[p.Count].Pull {
-		return errorf(p.DontPanic, "onewiretest: unexpected pullup (count #%d) %s != %s", p.Count, pull, p.Ops[p.Count].Pull)
+	if !p.Ops[p.Count].Overdrive {
+		return errorf(p.DontPanic, "onewiretest: unexpected TxOverdrive() (count #%d); expected Tx()", p.Count)
 	}
-	// Determine whether this starts a search and reset search state.
-	if len(w) > 0 && w[0] == 0xf0 {
-		p.searchBit = 0
-		p.inactive = make([]bool, len(p.Devices))
-	}
-	// Concoct response.
-	copy(r, p.Ops[p.Count].R)
-	p.Count++
-	return nil
+	return p.playback(append([]byte{rom}, w...), r, pull)
 }
 
 // Q implements onewire.Pins.
@@ -191,6 +215,40 @@ func (p *Playback) SearchTriplet(direction byte) (onewire.TripletResult, error)
 }
 
 //
+
+// record appends the operation, with a copy of the bytes read.
+func (r *Record) record(io IO, read []byte) error {
+	if len(read) != 0 {
+		io.R = make([]byte, len(read))
+		copy(io.R, read)
+	}
+	r.Ops = append(r.Ops, io)
+	return nil
+}
+
+// playback checks the operation against the next recorded one and returns
+// the bytes read.
+func (p *Playback) playback(w, r []byte, pull onewire.Pullup) error {
+	if !bytes.Equal(p.Ops[p.Count].W, w) {
+		return errorf(p.DontPanic, "onewiretest: unexpected write (count #%d) %#v != %#v", p.Count, w, p.Ops[p.Count].W)
+	}
+	if len(p.Ops[p.Count].R) != len(r) {
+		return errorf(p.DontPanic, "onewiretest: unexpected read buffer length (count #%d) %d != %d", p.Count, len(r), len(p.Ops[p.Count].R))
+	}
+	if pull != p.Ops[p.Count].Pull {
+		return errorf(p.DontPanic, "onewiretest: unexpected pullup (count #%d) %s != %s", p.Count, pull, p.Ops[p.Count].Pull)
+	}
+	// Determine whether this starts a search and reset search state.
+	if len(w) > 0 && (w[0] == onewire.SearchROM || w[0] == onewire.AlarmSearchROM) {
+		p.searchBit = 0
+		p.inactive = make([]bool, len(p.Devices))
+	}
+	// Concoct response.
+	copy(r, p.Ops[p.Count].R)
+	p.Count++
+	return nil
+}
+
 // errorf is the internal implementation that optionally panic.
 //
 // If dontPanic is false, it panics instead.
@@ -203,6 +261,8 @@ func errorf(dontPanic bool, format string, a ...interface{}) error {
 }
 
 var _ onewire.Bus = &Record{}
+var _ onewire.BusOverdrive = &Record{}
 var _ onewire.Pins = &Record{}
 var _ onewire.Bus = &Playback{}
+var _ onewire.BusOverdrive = &Playback{}
 var _ onewire.BusSearcher = &Playback{}
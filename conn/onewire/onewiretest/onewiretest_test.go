@@ -184,6 +184,66 @@ func TestRecord_Playback(t *testing.T) {
 	}
 }
 
+func TestRecord_Playback_overdrive(t *testing.T) {
+	r := Record{
+		Bus: &Playback{
+			Ops: []IO{
+				{
+					W:         []byte{0x69, 0x28, 0xac, 0x41, 0xe, 0x7, 0x0, 0x0, 0x74, 10, 11},
+					R:         []byte{12, 13},
+					Pull:      onewire.WeakPullup,
+					Overdrive: true,
+				},
+				{
+					W:    []byte{0xcc, 0x44},
+					Pull: onewire.StrongPullup,
+				},
+			},
+			DontPanic: true,
+		},
+	}
+	d := onewire.Dev{Bus: &r, Addr: 0x740000070e41ac28}
+	buf := []byte{0, 0}
+	if d.Tx([]byte{10, 11}, buf) == nil {
+		t.Fatal("expected TxOverdrive")
+	}
+	if err := d.TxOverdrive([]byte{10, 11}, buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf[0] != 12 || buf[1] != 13 {
+		t.Errorf("expected 12 & 13, got %d %d", buf[0], buf[1])
+	}
+	if onewire.OverdriveSkip(&r, []byte{0x44}, nil, onewire.StrongPullup) == nil {
+		t.Fatal("expected Tx")
+	}
+	if err := onewire.Skip(&r, []byte{0x44}, nil, onewire.StrongPullup); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Ops) != 2 || !r.Ops[0].Overdrive || r.Ops[0].W[0] != 0x69 || r.Ops[1].Overdrive {
+		t.Fatal(r.Ops)
+	}
+	if err := r.Bus.(*Playback).Close(); err != nil {
+		t.Fatal(err)
+	}
+	if r.TxOverdrive(0x3c, nil, nil, onewire.WeakPullup) == nil {
+		t.Fatal("Playback.Ops is empty")
+	}
+
+	// Without a bus, only writes are recorded.
+	r = Record{}
+	if err := r.TxOverdrive(0x3c, []byte{0x44}, nil, onewire.WeakPullup); err != nil {
+		t.Fatal(err)
+	}
+	if r.TxOverdrive(0x3c, nil, buf, onewire.WeakPullup) == nil {
+		t.Fatal("Bus is nil")
+	}
+	// The bus doesn't support overdrive.
+	r = Record{Bus: struct{ onewire.Bus }{&Record{}}}
+	if r.TxOverdrive(0x3c, nil, nil, onewire.WeakPullup) == nil {
+		t.Fatal("Bus doesn't support overdrive")
+	}
+}
+
 // TestSearch is the same as ../search_test.go.
 func TestSearch(t *testing.T) {
 	p := Playback{
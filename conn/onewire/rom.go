@@ -0,0 +1,55 @@
+// Copyright 2018 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package onewire
+
+// ROM commands, sent after the reset to select the devices that take part in
+// the transaction.
+const (
+	ReadROM           = 0x33 // Read the address of the single device on the bus
+	MatchROM          = 0x55 // Select the device whose address follows
+	SearchROM         = 0xf0 // Search cycle, see Search
+	AlarmSearchROM    = 0xec // Search cycle for the devices in alarm state
+	SkipROM           = 0xcc // Select all the devices
+	ResumeROM         = 0xa5 // Select the device selected last
+	OverdriveSkipROM  = 0x3c // Select all the devices and switch them to overdrive
+	OverdriveMatchROM = 0x69 // Select the device whose address follows and switch it to overdrive
+)
+
+// Skip performs a "skip ROM" command on the bus to select all the devices and
+// then transmits and receives the specified bytes.
+//
+// It is typically used to start a temperature conversion on all devices or to
+// talk to the single device on the bus.
+func Skip(b Bus, w, r []byte, power Pullup) error {
+	return b.Tx(append([]byte{SkipROM}, w...), r, power)
+}
+
+// Resume performs a "resume" command on the bus to select the device selected
+// by the last match ROM, search or resume command, and then transmits and
+// receives the specified bytes.
+//
+// It saves sending the 64 bits address again; it is supported by devices like
+// the DS2431 and DS28EC20.
+func Resume(b Bus, w, r []byte, power Pullup) error {
+	return b.Tx(append([]byte{ResumeROM}, w...), r, power)
+}
+
+// OverdriveSkip performs an "overdrive skip ROM" command on the bus to select
+// all the devices supporting overdrive and switch them to overdrive speed, and
+// then transmits and receives the specified bytes at overdrive speed.
+func OverdriveSkip(b BusOverdrive, w, r []byte, power Pullup) error {
+	return b.TxOverdrive(OverdriveSkipROM, w, r, power)
+}
+
+// OverdriveMatch performs an "overdrive match ROM" command on the bus to
+// select the device and switch it to overdrive speed, and then transmits and
+// receives the specified bytes at overdrive speed.
+//
+// The address is sent at overdrive speed.
+func OverdriveMatch(b BusOverdrive, addr Address, w, r []byte, power Pullup) error {
+	ww := make([]byte, 8, len(w)+8)
+	putUint64(ww, addr)
+	return b.TxOverdrive(OverdriveMatchROM, append(ww, w...), r, power)
+}
@@ -46,9 +46,9 @@ func Search(bus BusSearcher, alarmOnly bool) ([]Address, error) {
 	// Loop to do the search. Each iteration detects one device.
 	for {
 		// Issue a search command.
-		cmd := byte(0xf0) // plain search
+		cmd := byte(SearchROM)
 		if alarmOnly {
-			cmd = 0xec // alarm search
+			cmd = AlarmSearchROM
 		}
 		if err := bus.Tx([]byte{cmd}, nil, WeakPullup); err != nil {
 			// Expect an NoDevicesError if no device is present on the bus and
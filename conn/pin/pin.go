@@ -36,6 +36,17 @@ type Pin interface {
 	Function() string
 }
 
+// PinFunc is optionally implemented by a Pin whose function can be changed
+// back to one previously returned by Function().
+//
+// This permits a driver to temporarily use a bus pin as a GPIO, for example
+// to recover a stuck I²C bus, then restore its alternate function.
+type PinFunc interface {
+	// SetFunction changes the function of the pin to f, which must be a value
+	// previously returned by Function().
+	SetFunction(f string) error
+}
+
 //
 
 // BasicPin implements Pin as a non-functional pin.
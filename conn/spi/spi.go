@@ -18,8 +18,10 @@
 package spi
 
 import (
+	"context"
 	"io"
 	"strconv"
+	"time"
 
 	"periph.io/x/periph/conn"
 	"periph.io/x/periph/conn/gpio"
@@ -51,6 +53,20 @@ const (
 	// LSBFirst requests the words to be encoded in little endian instead of the
 	// default big endian.
 	LSBFirst = 0x10
+	// CSHigh requests the CS line to be active high instead of the default
+	// active low.
+	CSHigh Mode = 0x20
+	// Loopback requests MISO to be internally connected to MOSI, so the data
+	// written is read back. It is meant for testing.
+	Loopback Mode = 0x40
+	// TxDual and TxQuad specify that the device can receive data over 2 or 4
+	// wires. Use Packet.TxWidth to select the width of each packet.
+	TxDual Mode = 0x80
+	TxQuad Mode = 0x100
+	// RxDual and RxQuad specify that the device can send data over 2 or 4
+	// wires. Use Packet.RxWidth to select the width of each packet.
+	RxDual Mode = 0x200
+	RxQuad Mode = 0x400
 )
 
 func (m Mode) String() string {
@@ -66,18 +82,12 @@ func (m Mode) String() string {
 		s = "Mode3"
 	}
 	m &^= Mode3
-	if m&HalfDuplex != 0 {
-		s += "|HalfDuplex"
+	for _, f := range modeFlags {
+		if m&f.m != 0 {
+			s += "|" + f.s
+			m &^= f.m
+		}
 	}
-	m &^= HalfDuplex
-	if m&NoCS != 0 {
-		s += "|NoCS"
-	}
-	m &^= NoCS
-	if m&LSBFirst != 0 {
-		s += "|LSBFirst"
-	}
-	m &^= LSBFirst
 	if m != 0 {
 		s += "|0x"
 		s += strconv.FormatUint(uint64(m), 16)
@@ -92,6 +102,17 @@ type Packet struct {
 	W, R []byte
 	// BitsPerWord overrides the default bits per word value set in Connect.
 	BitsPerWord uint8
+	// TxWidth and RxWidth are the number of wires used to write W and read R:
+	// 1 for standard SPI, 2 for dual SPI and 4 for quad SPI. 0 means 1.
+	//
+	// A width of 2 requires TxDual or TxQuad (RxDual or RxQuad for RxWidth) to
+	// have been specified to Connect, and a width of 4 requires TxQuad (RxQuad).
+	TxWidth, RxWidth uint8
+	// MaxHz overrides the speed set in Connect for this packet when not 0.
+	MaxHz int64
+	// Delay is the time to wait after this packet before releasing CS or
+	// starting the next packet.
+	Delay time.Duration
 	// KeepCS tells the driver to keep CS asserted after this packet is
 	// completed. This can be leveraged to create long transaction as multiple
 	// packets like to use 9 bits commands then 8 bits data.
@@ -102,8 +123,11 @@ type Packet struct {
 	// clock cycle before the clock starts again for the next packet. This seems
 	// to be independent of the port clock speed but this wasn't fully verified.
 	//
-	// It cannot be expected that the driver will correctly keep CS asserted even
-	// if KeepCS:true on the last packet.
+	// KeepCS:true on the last packet keeps CS asserted after TxPackets()
+	// returns, so a device transaction can span multiple calls. CS is then
+	// released by the next transaction on the connection, after it completes
+	// unless it also ends with KeepCS:true, or before the port is used for
+	// another device.
 	//
 	// KeepCS is ignored when NoCS was specified to Connect.
 	KeepCS bool
@@ -120,11 +144,34 @@ type Conn interface {
 	// The maximum number of bytes can be limited depending on the driver. Query
 	// conn.Limits.MaxTxSize() can be used to determine the limit.
 	//
-	// If the last packet has KeepCS:true, the behavior is undefined. The CS line
-	// will likely not stay asserted. This is a driver limitation.
+	// If the last packet has KeepCS:true, CS stays asserted after the call
+	// returns; see Packet.KeepCS.
 	TxPackets(p []Packet) error
 }
 
+// ConnContext is optionally implemented by a Conn that can cancel a long
+// TxPackets() call via a context.
+type ConnContext interface {
+	// TxPacketsContext is like TxPackets() but stops once ctx is done and
+	// returns ctx.Err().
+	//
+	// Packets are only interrupted where the CS line is released, that is after
+	// a packet with KeepCS:false, so the device is never left in the middle of
+	// a transaction.
+	TxPacketsContext(ctx context.Context, p []Packet) error
+}
+
+// Configurer is optionally implemented by a Conn whose communication
+// parameters can be changed after Port.Connect().
+//
+// This permits sharing a port between multiple devices, as done by package
+// spishare.
+type Configurer interface {
+	// Configure changes the communication parameters, with the same meaning as
+	// the arguments of Port.Connect().
+	Configure(maxHz int64, mode Mode, bits int) error
+}
+
 // Port is the interface to be provided to device drivers.
 //
 // The device driver, that is the driver for the peripheral connected over
@@ -178,3 +225,20 @@ type Pins interface {
 	// CS returns the CSN (chip select) pin.
 	CS() gpio.PinOut
 }
+
+//
+
+var modeFlags = []struct {
+	m Mode
+	s string
+}{
+	{HalfDuplex, "HalfDuplex"},
+	{NoCS, "NoCS"},
+	{LSBFirst, "LSBFirst"},
+	{CSHigh, "CSHigh"},
+	{Loopback, "Loopback"},
+	{TxDual, "TxDual"},
+	{TxQuad, "TxQuad"},
+	{RxDual, "RxDual"},
+	{RxQuad, "RxQuad"},
+}
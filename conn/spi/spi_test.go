@@ -9,7 +9,7 @@ import (
 )
 
 func TestMode_String(t *testing.T) {
-	if s := Mode(^int(0)).String(); s != "Mode3|HalfDuplex|NoCS|LSBFirst|0xffffffffffffffe0" {
+	if s := Mode(^int(0)).String(); s != "Mode3|HalfDuplex|NoCS|LSBFirst|CSHigh|Loopback|TxDual|TxQuad|RxDual|RxQuad|0xfffffffffffff800" {
 		t.Fatal(s)
 	}
 	if s := Mode0.String(); s != "Mode0" {
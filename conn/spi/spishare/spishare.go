@@ -0,0 +1,306 @@
+// Copyright 2018 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+// Package spishare shares a SPI port between multiple devices.
+//
+// spi.Port.Connect() can only be called once, so a port can only be used by a
+// single device. Port hands out one spi.Conn per device instead, each with its
+// own mode, speed and bits per word. Before each transaction, the parameters
+// of the device are applied to the underlying connection if they differ from
+// the ones of the last transaction. The transactions are serialized.
+//
+// A device can use any gpio.PinOut as its chip select, so the number of
+// devices isn't limited by the number of hardware chip select lines.
+//
+// Changing the parameters requires the connection returned by the underlying
+// port to implement spi.Configurer.
+package spishare
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"periph.io/x/periph/conn"
+	"periph.io/x/periph/conn/gpio"
+	"periph.io/x/periph/conn/spi"
+)
+
+// New returns a Port sharing p between multiple devices.
+//
+// p.Connect() is called by the first call to Connect or ConnectCS.
+func New(p spi.Port) *Port {
+	return &Port{p: p}
+}
+
+// Port is a SPI port shared between multiple devices.
+//
+// It implements spi.Port; Connect() can be called multiple times.
+type Port struct {
+	p spi.Port
+
+	mu   sync.Mutex
+	c    spi.Conn // Underlying connection, set by the first Connect.
+	cfg  config   // Parameters currently set on c.
+	held *Conn    // Device whose GPIO chip select was left asserted.
+}
+
+func (p *Port) String() string {
+	return fmt.Sprintf("spishare(%s)", p.p)
+}
+
+// Connect implements spi.Port.
+//
+// The device uses the chip select of the underlying port.
+func (p *Port) Connect(maxHz int64, mode spi.Mode, bits int) (spi.Conn, error) {
+	c, err := p.connect(config{maxHz, mode, bits}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ConnectCS returns a connection for a device using cs as its chip select.
+//
+// cs is active low, or active high if CSHigh is specified in mode, and is
+// immediately set to its inactive level. NoCS is added to mode so the chip
+// select of the underlying port is not asserted for this device.
+func (p *Port) ConnectCS(maxHz int64, mode spi.Mode, bits int, cs gpio.PinOut) (*Conn, error) {
+	if cs == nil {
+		return nil, errors.New("spishare: cs must not be nil")
+	}
+	active := gpio.Level(mode&spi.CSHigh != 0)
+	if err := cs.Out(!active); err != nil {
+		return nil, fmt.Errorf("spishare: %v", err)
+	}
+	c, err := p.connect(config{maxHz, (mode | spi.NoCS) &^ spi.CSHigh, bits}, cs)
+	if err != nil {
+		return nil, err
+	}
+	c.csActive = active
+	return c, nil
+}
+
+//
+
+// config is the communication parameters of a device.
+type config struct {
+	maxHz int64
+	mode  spi.Mode
+	bits  int
+}
+
+func (p *Port) connect(cfg config, cs gpio.PinOut) (*Conn, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.c == nil {
+		c, err := p.p.Connect(cfg.maxHz, cfg.mode, cfg.bits)
+		if err != nil {
+			return nil, err
+		}
+		p.c = c
+		p.cfg = cfg
+	} else if _, ok := p.c.(spi.Configurer); !ok && cfg != p.cfg {
+		return nil, fmt.Errorf("spishare: %s can't change its parameters, all devices must use the same ones", p.c)
+	}
+	return &Conn{p: p, cfg: cfg, cs: cs}, nil
+}
+
+// releaseHeldLocked releases the chip select left asserted by a device other
+// than c.
+//
+// p.mu must be held.
+func (p *Port) releaseHeldLocked(c *Conn) error {
+	h := p.held
+	p.held = nil
+	if h == nil || h == c {
+		return nil
+	}
+	return h.releaseCS()
+}
+
+// applyLocked sets the parameters of the device on the underlying connection.
+//
+// p.mu must be held.
+func (p *Port) applyLocked(cfg config) error {
+	if cfg == p.cfg {
+		return nil
+	}
+	if err := p.c.(spi.Configurer).Configure(cfg.maxHz, cfg.mode, cfg.bits); err != nil {
+		// The state of the underlying connection is unknown.
+		p.cfg = config{}
+		return err
+	}
+	p.cfg = cfg
+	return nil
+}
+
+// Conn is the connection of a device on a shared Port.
+//
+// It implements spi.Conn.
+type Conn struct {
+	p        *Port
+	cfg      config
+	cs       gpio.PinOut
+	csActive gpio.Level // Level of cs asserting the chip select.
+}
+
+func (c *Conn) String() string {
+	if c.cs != nil {
+		return fmt.Sprintf("%s(%s)", c.p, c.cs.Name())
+	}
+	return c.p.String()
+}
+
+// Duplex implements spi.Conn.
+func (c *Conn) Duplex() conn.Duplex {
+	if c.cfg.mode&spi.HalfDuplex != 0 {
+		return conn.Half
+	}
+	return conn.Full
+}
+
+// Tx implements spi.Conn.
+func (c *Conn) Tx(w, r []byte) error {
+	c.p.mu.Lock()
+	defer c.p.mu.Unlock()
+	if err := c.p.releaseHeldLocked(c); err != nil {
+		return err
+	}
+	if err := c.p.applyLocked(c.cfg); err != nil {
+		return err
+	}
+	if err := c.assertCS(); err != nil {
+		return err
+	}
+	err := c.p.c.Tx(w, r)
+	if err2 := c.releaseCS(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// Write implements io.Writer.
+func (c *Conn) Write(b []byte) (int, error) {
+	if err := c.Tx(b, nil); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// TxPackets implements spi.Conn.
+//
+// When the device uses a GPIO as chip select, the packets are sent in multiple
+// batches, split after each packet with KeepCS:false, and the chip select is
+// released between the batches.
+//
+// KeepCS:true on the last packet keeps the chip select asserted after the call
+// returns, until the next transaction of this device. It is released before a
+// transaction of another device.
+func (c *Conn) TxPackets(p []spi.Packet) error {
+	c.p.mu.Lock()
+	defer c.p.mu.Unlock()
+	if err := c.p.releaseHeldLocked(c); err != nil {
+		return err
+	}
+	if err := c.p.applyLocked(c.cfg); err != nil {
+		return err
+	}
+	if c.cs == nil || len(p) == 0 {
+		return c.p.c.TxPackets(p)
+	}
+	for len(p) != 0 {
+		i := 0
+		for i < len(p)-1 && p[i].KeepCS {
+			i++
+		}
+		if err := c.assertCS(); err != nil {
+			return err
+		}
+		err := c.p.c.TxPackets(p[:i+1])
+		if err == nil && p[i].KeepCS {
+			// Only the last packet can have KeepCS:true here.
+			c.p.held = c
+			return nil
+		}
+		if err2 := c.releaseCS(); err == nil {
+			err = err2
+		}
+		if err != nil {
+			return err
+		}
+		p = p[i+1:]
+	}
+	return nil
+}
+
+// MaxTxSize implements conn.Limits.
+func (c *Conn) MaxTxSize() int {
+	if l, ok := c.p.c.(conn.Limits); ok {
+		return l.MaxTxSize()
+	}
+	return 0
+}
+
+// CLK implements spi.Pins.
+func (c *Conn) CLK() gpio.PinOut {
+	if p, ok := c.p.c.(spi.Pins); ok {
+		return p.CLK()
+	}
+	return gpio.INVALID
+}
+
+// MOSI implements spi.Pins.
+func (c *Conn) MOSI() gpio.PinOut {
+	if p, ok := c.p.c.(spi.Pins); ok {
+		return p.MOSI()
+	}
+	return gpio.INVALID
+}
+
+// MISO implements spi.Pins.
+func (c *Conn) MISO() gpio.PinIn {
+	if p, ok := c.p.c.(spi.Pins); ok {
+		return p.MISO()
+	}
+	return gpio.INVALID
+}
+
+// CS implements spi.Pins.
+//
+// It returns the GPIO passed to ConnectCS, if any.
+func (c *Conn) CS() gpio.PinOut {
+	if c.cs != nil {
+		return c.cs
+	}
+	if p, ok := c.p.c.(spi.Pins); ok {
+		return p.CS()
+	}
+	return gpio.INVALID
+}
+
+func (c *Conn) assertCS() error {
+	if c.cs != nil {
+		if err := c.cs.Out(c.csActive); err != nil {
+			return fmt.Errorf("spishare: %v", err)
+		}
+	}
+	return nil
+}
+
+func (c *Conn) releaseCS() error {
+	if c.cs != nil {
+		if err := c.cs.Out(!c.csActive); err != nil {
+			return fmt.Errorf("spishare: %v", err)
+		}
+	}
+	return nil
+}
+
+var _ spi.Port = &Port{}
+var _ fmt.Stringer = &Port{}
+var _ spi.Conn = &Conn{}
+var _ spi.Pins = &Conn{}
+var _ conn.Limits = &Conn{}
+var _ fmt.Stringer = &Conn{}
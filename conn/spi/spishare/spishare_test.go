@@ -0,0 +1,337 @@
+// Copyright 2018 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package spishare
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"periph.io/x/periph/conn"
+	"periph.io/x/periph/conn/gpio"
+	"periph.io/x/periph/conn/gpio/gpiotest"
+	"periph.io/x/periph/conn/spi"
+)
+
+func TestPort(t *testing.T) {
+	f := &fakePort{}
+	p := New(f)
+	if s := p.String(); s != "spishare(fake)" {
+		t.Fatal(s)
+	}
+	c1, err := p.Connect(1000, spi.Mode0, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := &gpiotest.Pin{N: "GPIO1", L: gpio.Low}
+	c2, err := p.ConnectCS(2000, spi.Mode3|spi.HalfDuplex, 16, cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cs.L != gpio.High {
+		t.Fatal("cs must be released")
+	}
+	f.c.cs = cs
+	if f.connects != 1 {
+		t.Fatal(f.connects)
+	}
+	if s := c2.String(); s != "spishare(fake)(GPIO1)" {
+		t.Fatal(s)
+	}
+	if d := c1.Duplex(); d != conn.Full {
+		t.Fatal(d)
+	}
+	if d := c2.Duplex(); d != conn.Half {
+		t.Fatal(d)
+	}
+
+	if err := c1.Tx([]byte{1}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := c1.Tx([]byte{2}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c2.Write([]byte{3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c1.TxPackets([]spi.Packet{{W: []byte{4}}}); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"tx [1] cs=High",
+		"tx [2] cs=High",
+		"configure 2000 Mode3|HalfDuplex|NoCS 16",
+		"tx [3] cs=Low",
+		"configure 1000 Mode0 8",
+		"packets 1 cs=High",
+	}
+	if !reflect.DeepEqual(f.c.log, expected) {
+		t.Fatalf("%q", f.c.log)
+	}
+	if cs.L != gpio.High {
+		t.Fatal("cs must be released")
+	}
+}
+
+func TestConn_TxPackets(t *testing.T) {
+	f := &fakePort{}
+	cs := &gpiotest.Pin{N: "GPIO1"}
+	c, err := New(f).ConnectCS(1000, spi.Mode0, 8, cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.c.cs = cs
+	p := []spi.Packet{
+		{W: []byte{1}, KeepCS: true},
+		{W: []byte{2}},
+		{W: []byte{3}},
+		{W: []byte{4}, KeepCS: true},
+	}
+	if err := c.TxPackets(p); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"packets 2 cs=Low",
+		"packets 1 cs=Low",
+		"packets 1 cs=Low",
+	}
+	if !reflect.DeepEqual(f.c.log, expected) {
+		t.Fatalf("%q", f.c.log)
+	}
+	// KeepCS:true on the last packet keeps cs asserted until the next
+	// transaction.
+	if cs.L != gpio.Low {
+		t.Fatal("cs must be kept asserted")
+	}
+	if err := c.Tx([]byte{5}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if cs.L != gpio.High {
+		t.Fatal("cs must be released")
+	}
+
+	// It is released before a transaction of another device.
+	if err := c.TxPackets([]spi.Packet{{W: []byte{6}, KeepCS: true}}); err != nil {
+		t.Fatal(err)
+	}
+	other, err := c.p.Connect(1000, spi.Mode0|spi.NoCS, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Tx([]byte{7}, nil); err != nil {
+		t.Fatal(err)
+	}
+	expected = append(expected, "tx [5] cs=Low", "packets 1 cs=Low", "tx [7] cs=High")
+	if !reflect.DeepEqual(f.c.log, expected) {
+		t.Fatalf("%q", f.c.log)
+	}
+}
+
+func TestConn_CSHigh(t *testing.T) {
+	f := &fakePort{}
+	cs := &gpiotest.Pin{N: "GPIO1", L: gpio.High}
+	p := New(f)
+	if _, err := p.Connect(1000, spi.Mode0, 8); err != nil {
+		t.Fatal(err)
+	}
+	c, err := p.ConnectCS(1000, spi.Mode0|spi.CSHigh, 8, cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cs.L != gpio.Low {
+		t.Fatal("cs must be released")
+	}
+	f.c.cs = cs
+	if err := c.Tx([]byte{1}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.TxPackets([]spi.Packet{{W: []byte{2}, KeepCS: true}, {W: []byte{3}}}); err != nil {
+		t.Fatal(err)
+	}
+	// CSHigh only applies to the GPIO, not to the underlying port.
+	expected := []string{
+		"configure 1000 Mode0|NoCS 8",
+		"tx [1] cs=High",
+		"packets 2 cs=High",
+	}
+	if !reflect.DeepEqual(f.c.log, expected) {
+		t.Fatalf("%q", f.c.log)
+	}
+	if cs.L != gpio.Low {
+		t.Fatal("cs must be released")
+	}
+}
+
+func TestConn_Pins(t *testing.T) {
+	f := &fakePort{}
+	p := New(f)
+	c1, err := p.Connect(1000, spi.Mode0, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := &gpiotest.Pin{N: "GPIO1"}
+	c2, err := p.ConnectCS(1000, spi.Mode0, 8, cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p1 := c1.(spi.Pins)
+	if p := p1.CLK(); p != gpio.INVALID {
+		t.Fatal(p)
+	}
+	if p := p1.MOSI(); p != gpio.INVALID {
+		t.Fatal(p)
+	}
+	if p := p1.MISO(); p != gpio.INVALID {
+		t.Fatal(p)
+	}
+	if p := p1.CS(); p != gpio.INVALID {
+		t.Fatal(p)
+	}
+	if p := c2.CS(); p != cs {
+		t.Fatal(p)
+	}
+	if l := c1.(conn.Limits).MaxTxSize(); l != 0 {
+		t.Fatal(l)
+	}
+}
+
+func TestPort_fail(t *testing.T) {
+	if _, err := New(&fakePort{}).ConnectCS(1000, spi.Mode0, 8, nil); err == nil {
+		t.Fatal("cs is nil")
+	}
+	if _, err := New(&fakePort{err: errors.New("oops")}).Connect(1000, spi.Mode0, 8); err == nil {
+		t.Fatal("Connect failed")
+	}
+
+	// The underlying connection doesn't implement spi.Configurer.
+	p := New(&fakePort{noConfig: true})
+	if _, err := p.Connect(1000, spi.Mode0, 8); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Connect(1000, spi.Mode0, 8); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Connect(2000, spi.Mode0, 8); err == nil {
+		t.Fatal("different parameters")
+	}
+
+	// Configure fails.
+	f := &fakePort{}
+	p = New(f)
+	c1, err := p.Connect(1000, spi.Mode0, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := p.Connect(2000, spi.Mode0, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.c.err = errors.New("oops")
+	if c2.Tx([]byte{1}, nil) == nil {
+		t.Fatal("Configure failed")
+	}
+	if c1.TxPackets(nil) == nil {
+		t.Fatal("the parameters must be set again")
+	}
+	f.c.err = nil
+	if err := c1.Tx([]byte{1}, nil); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"configure 1000 Mode0 8", "tx [1] cs=-"}
+	if !reflect.DeepEqual(f.c.log, expected) {
+		t.Fatalf("%q", f.c.log)
+	}
+}
+
+//
+
+// fakePort returns a fakeConn, or a connection not implementing
+// spi.Configurer if noConfig is set.
+type fakePort struct {
+	err      error
+	noConfig bool
+	connects int
+	c        *fakeConn
+}
+
+func (f *fakePort) String() string {
+	return "fake"
+}
+
+func (f *fakePort) Connect(maxHz int64, mode spi.Mode, bits int) (spi.Conn, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	f.connects++
+	f.c = &fakeConn{}
+	if f.noConfig {
+		return &fakeConnNoConfig{f.c}, nil
+	}
+	return f.c, nil
+}
+
+// fakeConn logs the calls and the level of the GPIO chip select, if any, at
+// the time of the transaction.
+type fakeConn struct {
+	err error
+	cs  *gpiotest.Pin
+	log []string
+}
+
+func (f *fakeConn) String() string {
+	return "fake"
+}
+
+func (f *fakeConn) Duplex() conn.Duplex {
+	return conn.Full
+}
+
+func (f *fakeConn) Configure(maxHz int64, mode spi.Mode, bits int) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.log = append(f.log, fmt.Sprintf("configure %d %s %d", maxHz, mode, bits))
+	return nil
+}
+
+func (f *fakeConn) Tx(w, r []byte) error {
+	f.log = append(f.log, fmt.Sprintf("tx %v cs=%s", w, f.csLevel()))
+	return nil
+}
+
+func (f *fakeConn) TxPackets(p []spi.Packet) error {
+	f.log = append(f.log, fmt.Sprintf("packets %d cs=%s", len(p), f.csLevel()))
+	return nil
+}
+
+func (f *fakeConn) csLevel() string {
+	if f.cs == nil {
+		return "-"
+	}
+	return f.cs.L.String()
+}
+
+type fakeConnNoConfig struct {
+	c *fakeConn
+}
+
+func (f *fakeConnNoConfig) String() string {
+	return "fake"
+}
+
+func (f *fakeConnNoConfig) Duplex() conn.Duplex {
+	return conn.Full
+}
+
+func (f *fakeConnNoConfig) Tx(w, r []byte) error {
+	return f.c.Tx(w, r)
+}
+
+func (f *fakeConnNoConfig) TxPackets(p []spi.Packet) error {
+	return f.c.TxPackets(p)
+}
+
+var _ spi.Configurer = &fakeConn{}
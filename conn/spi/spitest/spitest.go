@@ -77,11 +77,15 @@ func (r *recordRawConn) TxPackets(p []spi.Packet) error {
 // Record implements spi.PortCloser that records everything written to it.
 //
 // This can then be used to feed to Playback to do "replay" based unit tests.
+//
+// Each packet passed to TxPackets() is recorded as one conntest.IO.
 type Record struct {
 	sync.Mutex
 	Port        spi.PortCloser // Port can be nil if only writes are being recorded.
 	Ops         []conntest.IO
 	Initialized bool
+
+	mode spi.Mode
 }
 
 func (r *Record) String() string {
@@ -112,6 +116,7 @@ func (r *Record) Connect(maxHz int64, mode spi.Mode, bits int) (spi.Conn, error)
 		return nil, conntest.Errorf("spitest: Connect cannot be called twice")
 	}
 	r.Initialized = true
+	r.mode = mode
 	if r.Port != nil {
 		c, err := r.Port.Connect(maxHz, mode, bits)
 		if err != nil {
@@ -179,6 +184,41 @@ func (r *Record) txInternal(c spi.Conn, w, read []byte) error {
 	return nil
 }
 
+func (r *Record) txPackets(c spi.Conn, p []spi.Packet) error {
+	r.Lock()
+	defer r.Unlock()
+	if err := checkPackets(r.mode, p); err != nil {
+		return err
+	}
+	if r.Port == nil {
+		for i := range p {
+			if len(p[i].R) != 0 && r.mode&spi.Loopback == 0 {
+				return conntest.Errorf("spitest: read unsupported when no port is connected")
+			}
+		}
+		for i := range p {
+			copy(p[i].R, p[i].W)
+		}
+	} else {
+		if err := c.TxPackets(p); err != nil {
+			return err
+		}
+	}
+	for i := range p {
+		io := conntest.IO{}
+		if len(p[i].W) != 0 {
+			io.W = make([]byte, len(p[i].W))
+			copy(io.W, p[i].W)
+		}
+		if len(p[i].R) != 0 {
+			io.R = make([]byte, len(p[i].R))
+			copy(io.R, p[i].R)
+		}
+		r.Ops = append(r.Ops, io)
+	}
+	return nil
+}
+
 //
 
 type recordConn struct {
@@ -201,9 +241,12 @@ func (r *recordConn) Tx(w, read []byte) error {
 	return r.r.txInternal(r.c, w, read)
 }
 
-// TxPackets is not yet implemented.
+// TxPackets implements spi.Conn.
+//
+// When no port is connected, the data written is read back if Loopback was
+// specified to Connect.
 func (r *recordConn) TxPackets(p []spi.Packet) error {
-	return conntest.Errorf("spitest: TxPackets is not implemented")
+	return r.r.txPackets(r.c, p)
 }
 
 // CLK implements spi.Pins.
@@ -232,6 +275,10 @@ func (r *recordConn) CS() gpio.PinOut {
 //
 // While "replay" type of unit tests are of limited value, they still present
 // an easy way to do basic code coverage.
+//
+// Each packet passed to TxPackets() is played back as one conntest.IO. When
+// Loopback is specified to Connect, no IO is consumed and the data written is
+// read back instead.
 type Playback struct {
 	conntest.Playback
 	CLKPin      gpio.PinIO
@@ -239,6 +286,8 @@ type Playback struct {
 	MISOPin     gpio.PinIO
 	CSPin       gpio.PinIO
 	Initialized bool
+
+	mode spi.Mode
 }
 
 // Close implements spi.PortCloser.
@@ -259,6 +308,7 @@ func (p *Playback) Connect(maxHz int64, mode spi.Mode, bits int) (spi.Conn, erro
 		return nil, conntest.Errorf("spitest: Connect cannot be called twice")
 	}
 	p.Initialized = true
+	p.mode = mode
 	return &playbackConn{p}, nil
 }
 
@@ -295,11 +345,23 @@ func (p *playbackConn) Duplex() conn.Duplex {
 }
 
 func (p *playbackConn) Tx(w, r []byte) error {
+	if p.p.mode&spi.Loopback != 0 {
+		copy(r, w)
+		return nil
+	}
 	return p.p.Tx(w, r)
 }
 
 func (p *playbackConn) TxPackets(packets []spi.Packet) error {
-	return conntest.Errorf("spitest: TxPackets is not implemented")
+	if err := checkPackets(p.p.mode, packets); err != nil {
+		return err
+	}
+	for i := range packets {
+		if err := p.Tx(packets[i].W, packets[i].R); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (p *playbackConn) CLK() gpio.PinOut {
@@ -342,7 +404,7 @@ func (l *Log) LimitSpeed(maxHz int64) error {
 // Connect implements spi.PortCloser.
 func (l *Log) Connect(maxHz int64, mode spi.Mode, bits int) (spi.Conn, error) {
 	c, err := l.Port.Connect(maxHz, mode, bits)
-	log.Printf("%s.Connect(%d, %d, %d) = %v", l.Port, maxHz, mode, bits, err)
+	log.Printf("%s.Connect(%d, %s, %d) = %v", l.Port, maxHz, mode, bits, err)
 	return &LogConn{c}, err
 }
 
@@ -372,6 +434,45 @@ func (l *LogConn) Duplex() conn.Duplex {
 
 //
 
+// checkPackets verifies that the packets are valid for mode.
+func checkPackets(mode spi.Mode, p []spi.Packet) error {
+	if len(p) == 0 {
+		return conntest.Errorf("spitest: empty packets")
+	}
+	for i := range p {
+		lW := len(p[i].W)
+		lR := len(p[i].R)
+		if lW != 0 && lR != 0 {
+			if mode&spi.HalfDuplex != 0 {
+				return conntest.Errorf("spitest: packet #%d: can only specify one of w or r when in half duplex", i)
+			}
+			if lW != lR {
+				return conntest.Errorf("spitest: packet #%d: w and r must be the same size; got %d and %d bytes", i, lW, lR)
+			}
+		}
+		if !isWidthValid(p[i].TxWidth, mode, spi.TxDual, spi.TxQuad) {
+			return conntest.Errorf("spitest: packet #%d: TxWidth %d is not enabled in mode %s", i, p[i].TxWidth, mode)
+		}
+		if !isWidthValid(p[i].RxWidth, mode, spi.RxDual, spi.RxQuad) {
+			return conntest.Errorf("spitest: packet #%d: RxWidth %d is not enabled in mode %s", i, p[i].RxWidth, mode)
+		}
+	}
+	return nil
+}
+
+// isWidthValid returns true if width can be used with mode.
+func isWidthValid(width uint8, mode, dual, quad spi.Mode) bool {
+	switch width {
+	case 0, 1:
+		return true
+	case 2:
+		return mode&(dual|quad) != 0
+	case 4:
+		return mode&quad != 0
+	}
+	return false
+}
+
 var _ spi.PortCloser = &RecordRaw{}
 var _ spi.PortCloser = &Record{}
 var _ spi.PortCloser = &Playback{}
@@ -8,6 +8,7 @@ import (
 	"bytes"
 	"io/ioutil"
 	"log"
+	"reflect"
 	"testing"
 
 	"periph.io/x/periph/conn"
@@ -51,7 +52,7 @@ func TestRecord_empty(t *testing.T) {
 		t.Fatal("Port is nil")
 	}
 	if err := c.TxPackets(nil); err == nil {
-		t.Fatal("not yet implemented")
+		t.Fatal("empty packets")
 	}
 	if d := c.Duplex(); d != conn.DuplexUnknown {
 		t.Fatal(d)
@@ -113,7 +114,7 @@ func TestPlayback(t *testing.T) {
 		t.Fatal(err)
 	}
 	if err := c.TxPackets(nil); err == nil {
-		t.Fatal("not yet implemented")
+		t.Fatal("empty packets")
 	}
 	if err := p.Close(); err != nil {
 		t.Fatal(err)
@@ -175,6 +176,120 @@ func TestPlayback_Tx(t *testing.T) {
 	}
 }
 
+func TestPlayback_TxPackets(t *testing.T) {
+	p := Playback{
+		Playback: conntest.Playback{
+			Ops: []conntest.IO{
+				{W: []byte{0x6B, 0, 0, 0}},
+				{R: []byte{1, 2}},
+			},
+			DontPanic: true,
+		},
+	}
+	c, err := p.Connect(0, spi.Mode0|spi.TxQuad|spi.RxQuad, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := make([]byte, 2)
+	pkt := []spi.Packet{
+		{W: []byte{0x6B, 0, 0, 0}, KeepCS: true},
+		{R: r, RxWidth: 4},
+	}
+	if err := c.TxPackets(pkt); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(r, []byte{1, 2}) {
+		t.Fatal(r)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if c.TxPackets([]spi.Packet{{W: []byte{0}, TxWidth: 3}}) == nil {
+		t.Fatal("invalid width")
+	}
+	if c.TxPackets([]spi.Packet{{W: []byte{0}, R: []byte{0, 0}}}) == nil {
+		t.Fatal("different lengths")
+	}
+
+	p = Playback{Playback: conntest.Playback{DontPanic: true}}
+	if c, err = p.Connect(0, spi.Mode0|spi.HalfDuplex|spi.TxDual, 8); err != nil {
+		t.Fatal(err)
+	}
+	if c.TxPackets([]spi.Packet{{W: []byte{0}, TxWidth: 4}}) == nil {
+		t.Fatal("quad not enabled")
+	}
+	if c.TxPackets([]spi.Packet{{W: []byte{0}, R: []byte{0}}}) == nil {
+		t.Fatal("half duplex")
+	}
+}
+
+func TestPlayback_Loopback(t *testing.T) {
+	p := Playback{}
+	c, err := p.Connect(0, spi.Mode0|spi.Loopback, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := make([]byte, 2)
+	if err := c.Tx([]byte{1, 2}, r); err != nil || !bytes.Equal(r, []byte{1, 2}) {
+		t.Fatal(r, err)
+	}
+	r = make([]byte, 1)
+	if err := c.TxPackets([]spi.Packet{{W: []byte{3}, R: r}}); err != nil || r[0] != 3 {
+		t.Fatal(r, err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecord_TxPackets(t *testing.T) {
+	r := Record{}
+	c, err := r.Connect(0, spi.Mode0, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.TxPackets([]spi.Packet{{R: []byte{0}}}) == nil {
+		t.Fatal("Port is nil")
+	}
+	if err := c.TxPackets([]spi.Packet{{W: []byte{1}, KeepCS: true}, {W: []byte{2}}}); err != nil {
+		t.Fatal(err)
+	}
+	expected := []conntest.IO{{W: []byte{1}}, {W: []byte{2}}}
+	if !reflect.DeepEqual(r.Ops, expected) {
+		t.Fatal(r.Ops)
+	}
+
+	// Loopback without a port.
+	r = Record{}
+	if c, err = r.Connect(0, spi.Mode0|spi.Loopback, 8); err != nil {
+		t.Fatal(err)
+	}
+	b := []byte{0}
+	if err := c.TxPackets([]spi.Packet{{W: []byte{3}, R: b}}); err != nil || b[0] != 3 {
+		t.Fatal(b, err)
+	}
+
+	// With a port.
+	r = Record{
+		Port: &Playback{
+			Playback: conntest.Playback{Ops: []conntest.IO{{W: []byte{10}, R: []byte{12}}}},
+		},
+	}
+	if c, err = r.Connect(0, spi.Mode0, 8); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.TxPackets([]spi.Packet{{W: []byte{10}, R: b}}); err != nil || b[0] != 12 {
+		t.Fatal(b, err)
+	}
+	expected = []conntest.IO{{W: []byte{10}, R: []byte{12}}}
+	if !reflect.DeepEqual(r.Ops, expected) {
+		t.Fatal(r.Ops)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestRecord_Playback(t *testing.T) {
 	r := Record{
 		Port: &Playback{
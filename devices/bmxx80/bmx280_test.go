@@ -5,6 +5,7 @@
 package bmxx80
 
 import (
+	"context"
 	"errors"
 	"io/ioutil"
 	"log"
@@ -581,6 +582,65 @@ func TestI2CSenseContinuous280_success(t *testing.T) {
 	}
 }
 
+func TestI2CSenseContinuousContext280(t *testing.T) {
+	bus := i2ctest.Playback{
+		Ops: []i2ctest.IO{
+			// Chip ID detection.
+			{Addr: 0x76, W: []byte{0xd0}, R: []byte{0x60}},
+			// Calibration data.
+			{
+				Addr: 0x76,
+				W:    []byte{0x88},
+				R:    []byte{0x10, 0x6e, 0x6c, 0x66, 0x32, 0x0, 0x5d, 0x95, 0xb8, 0xd5, 0xd0, 0xb, 0x77, 0x1e, 0x9d, 0xff, 0xf9, 0xff, 0xac, 0x26, 0xa, 0xd8, 0xbd, 0x10, 0x0, 0x4b},
+			},
+			// Calibration data humidity.
+			{Addr: 0x76, W: []byte{0xe1}, R: []byte{0x6e, 0x1, 0x0, 0x13, 0x5, 0x0, 0x1e}},
+			// Configuration.
+			{Addr: 0x76, W: []byte{0xf4, 0x6c, 0xf2, 0x3, 0xf5, 0xa0, 0xf4, 0x6c}, R: nil},
+			// Normal mode.
+			{Addr: 0x76, W: []byte{0xF5, 0xa0, 0xf4, 0x6f}},
+			// Read.
+			{Addr: 0x76, W: []byte{0xf7}, R: []byte{0x4a, 0x52, 0xc0, 0x80, 0x96, 0xc0, 0x7a, 0x76}},
+			// Forced mode.
+			{Addr: 0x76, W: []byte{0xF5, 0xa0, 0xf4, 0x6c}},
+		},
+	}
+	dev, err := NewI2C(&bus, 0x76, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c, err := dev.SenseContinuousContext(ctx, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case env := <-c:
+		if env.Temperature != 23720 {
+			t.Fatalf("temp %d", env.Temperature)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("failed")
+	}
+	cancel()
+	select {
+	case _, ok := <-c:
+		if ok {
+			t.Fatal("c should be closed")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("c should be closed")
+	}
+	// The device was already halted.
+	if err := dev.Halt(); err != nil {
+		t.Fatal(err)
+	}
+	if err := bus.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestI2CSenseContinuous280_command_fail(t *testing.T) {
 	bus := i2ctest.Playback{
 		Ops: []i2ctest.IO{
@@ -27,6 +27,7 @@
 package bmxx80
 
 import (
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -282,6 +283,55 @@ func (d *Dev) Sense(env *devices.Environment) error {
 func (d *Dev) SenseContinuous(interval time.Duration) (<-chan devices.Environment, error) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
+	return d.senseContinuous(interval)
+}
+
+// SenseContinuousContext is like SenseContinuous but the sensing is also
+// halted once ctx is done, as if Halt() was called.
+//
+// It implements devices.EnvironmentalContext.
+func (d *Dev) SenseContinuousContext(ctx context.Context, interval time.Duration) (<-chan devices.Environment, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	sensing, err := d.senseContinuous(interval)
+	if err != nil {
+		return nil, err
+	}
+	stop := d.stop
+	go func() {
+		select {
+		case <-ctx.Done():
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			// Only halt if the sensing wasn't restarted or halted in the meantime.
+			if d.stop == stop {
+				if err := d.halt(); err != nil {
+					log.Printf("%s: failed to halt: %v", d, err)
+				}
+			}
+		case <-stop:
+		}
+	}()
+	return sensing, nil
+}
+
+// Halt stops the BMxx80 from acquiring measurements as initiated by
+// SenseContinuous().
+//
+// It is recommended to call this function before terminating the process to
+// reduce idle power usage and a goroutine leak.
+func (d *Dev) Halt() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.halt()
+}
+
+//
+
+// senseContinuous starts the continuous sensing.
+//
+// d.mu must be held.
+func (d *Dev) senseContinuous(interval time.Duration) (<-chan devices.Environment, error) {
 	if d.stop != nil {
 		// Don't send the stop command to the device.
 		close(d.stop)
@@ -303,24 +353,21 @@ func (d *Dev) SenseContinuous(interval time.Duration) (<-chan devices.Environmen
 	}
 
 	sensing := make(chan devices.Environment)
-	d.stop = make(chan struct{})
+	stop := make(chan struct{})
+	d.stop = stop
 	d.wg.Add(1)
 	go func() {
 		defer d.wg.Done()
 		defer close(sensing)
-		d.sensingContinuous(interval, sensing, d.stop)
+		d.sensingContinuous(interval, sensing, stop)
 	}()
 	return sensing, nil
 }
 
-// Halt stops the BMxx80 from acquiring measurements as initiated by
-// SenseContinuous().
+// halt stops the continuous sensing and puts the device to sleep.
 //
-// It is recommended to call this function before terminating the process to
-// reduce idle power usage and a goroutine leak.
-func (d *Dev) Halt() error {
-	d.mu.Lock()
-	defer d.mu.Unlock()
+// d.mu must be held.
+func (d *Dev) halt() error {
 	if d.stop == nil {
 		return nil
 	}
@@ -340,8 +387,6 @@ func (d *Dev) Halt() error {
 	return nil
 }
 
-//
-
 func (d *Dev) makeDev(opts *Opts) error {
 	if opts == nil {
 		opts = &defaults
@@ -515,4 +560,5 @@ var doSleep = time.Sleep
 
 var _ conn.Resource = &Dev{}
 var _ devices.Environmental = &Dev{}
+var _ devices.EnvironmentalContext = &Dev{}
 var _ fmt.Stringer = &Dev{}
@@ -5,6 +5,7 @@
 package devices
 
 import (
+	"context"
 	"image"
 	"image/color"
 	"io"
@@ -69,3 +70,12 @@ type Environmental interface {
 	// the device off and will close the channel.
 	SenseContinuous(interval time.Duration) (<-chan Environment, error)
 }
+
+// EnvironmentalContext is optionally implemented by an Environmental sensor
+// whose continuous sensing can be stopped via a context.
+type EnvironmentalContext interface {
+	// SenseContinuousContext is like SenseContinuous() but the sensing is also
+	// halted once ctx is done, as if Halt() was called, which closes the
+	// channel.
+	SenseContinuousContext(ctx context.Context, interval time.Duration) (<-chan Environment, error)
+}
@@ -2,28 +2,40 @@
 // Use of this source code is governed under the Apache License, Version 2.0
 // that can be found in the LICENSE file.
 
-// Package ds18b20 interfaces to Dallas Semi / Maxim DS18B20 and MAX31820
-// 1-wire temperature sensors.
+// Package ds18b20 interfaces to Dallas Semi / Maxim DS18B20, MAX31820,
+// DS1822 and DS18S20 1-wire temperature sensors.
 //
-// Note that both DS18B20 and MAX31820 use family code 0x28.
+// Note that both DS18B20 and MAX31820 use family code 0x28, the DS1822 uses
+// 0x22 and the DS18S20 uses 0x10.
 //
 // Both powered sensors and parasitically powered sensors are supported
 // as long as the bus driver can provide sufficient power using an active
-// pull-up.
+// pull-up. Parasitically powered sensors are detected when the device is
+// opened and the strong pull-up is only used for them.
 //
-// The DS18B20 alarm functionality and reading/writing the 2 alarm bytes in
-// the EEPROM are not supported. The DS18S20 is also not supported.
+// The alarm thresholds are read and written with Dev.Alarms and
+// Dev.SetAlarms and the sensors out of range are found with AlarmSearch.
+//
+// Dev implements devices.Environmental. To sense many devices on the same bus,
+// use a Poller, which performs a single conversion for all of them.
 //
 // Datasheets
 //
 // https://datasheets.maximintegrated.com/en/ds/DS18B20-PAR.pdf
 //
 // http://datasheets.maximintegrated.com/en/ds/MAX31820.pdf
+//
+// https://datasheets.maximintegrated.com/en/ds/DS1822.pdf
+//
+// https://datasheets.maximintegrated.com/en/ds/DS18S20.pdf
 package ds18b20
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"log"
+	"sync"
 	"time"
 
 	"periph.io/x/periph/conn"
@@ -36,7 +48,7 @@ import (
 // During the conversion it places the bus in strong pull-up mode to power
 // parasitic devices and returns when the conversions have completed. This time
 // period is determined by the maximum resolution of all devices on the bus and
-// must be provided.
+// must be provided. Use 12 when a DS18S20 is on the bus.
 //
 // ConvertAll uses time.Sleep to wait for the conversion to finish, which takes
 // from 94ms to 752ms.
@@ -44,13 +56,30 @@ func ConvertAll(o onewire.Bus, maxResolutionBits int) error {
 	if maxResolutionBits < 9 || maxResolutionBits > 12 {
 		return errors.New("ds18b20: invalid maxResolutionBits")
 	}
-	if err := o.Tx([]byte{0xcc, 0x44}, nil, onewire.StrongPullup); err != nil {
+	if err := onewire.Skip(o, []byte{cmdConvert}, nil, onewire.StrongPullup); err != nil {
 		return err
 	}
 	conversionSleep(maxResolutionBits)
 	return nil
 }
 
+// AlarmSearch returns the addresses of the temperature sensors on the bus
+// whose last conversion was out of the range set with Dev.SetAlarms.
+//
+// The alarm flag is updated by each conversion, so ConvertAll is usually
+// called first. The other devices in alarm state on the bus are ignored.
+func AlarmSearch(o onewire.Bus) ([]onewire.Address, error) {
+	addrs, err := o.Search(true)
+	var out []onewire.Address
+	for _, a := range addrs {
+		switch a & 0xff {
+		case familyDS18S20, familyDS1822, familyDS18B20:
+			out = append(out, a)
+		}
+	}
+	return out, err
+}
+
 // New returns an object that communicates over 1-wire to the DS18B20 sensor
 // with the specified 64-bit address.
 //
@@ -61,12 +90,24 @@ func ConvertAll(o onewire.Bus, maxResolutionBits int) error {
 // A resolution of 10 bits corresponds to 0.25C and tends to be a good
 // compromise between conversion time and the device's inherent accuracy of
 // +/-0.5C.
+//
+// The DS18S20 has no configurable resolution so resolutionBits is ignored; its
+// conversion always takes 750ms and the readings use the extended resolution
+// formula, datasheet p.6.
 func New(o onewire.Bus, addr onewire.Address, resolutionBits int) (*Dev, error) {
 	if resolutionBits < 9 || resolutionBits > 12 {
 		return nil, errors.New("ds18b20: invalid resolutionBits")
 	}
+	family := byte(addr)
+	switch family {
+	case familyDS18S20:
+		resolutionBits = 12
+	case familyDS1822, familyDS18B20:
+	default:
+		return nil, fmt.Errorf("ds18b20: unsupported family code %#02x", family)
+	}
 
-	d := &Dev{onewire: onewire.Dev{Bus: o, Addr: addr}, resolution: resolutionBits}
+	d := &Dev{onewire: onewire.Dev{Bus: o, Addr: addr}, family: family, resolution: resolutionBits}
 
 	// Start by reading the scratchpad memory, this will tell us whether we can
 	// talk to the device correctly and also how it's configured.
@@ -75,18 +116,24 @@ func New(o onewire.Bus, addr onewire.Address, resolutionBits int) (*Dev, error)
 		return nil, err
 	}
 
+	// Parasitically powered devices pull the bus low during the read slot.
+	var power [1]byte
+	if err := d.onewire.Tx([]byte{cmdReadPowerSupply}, power[:]); err != nil {
+		return nil, err
+	}
+	d.parasitic = power[0]&1 == 0
+
 	// Change the resolution, if necessary (datasheet p.6).
-	if int(spad[4]>>5) != resolutionBits-9 {
-		// Set the value in the configuration register.
-		if err := d.onewire.Tx([]byte{0x4e, 0, 0, byte((resolutionBits-9)<<5) | 0x1f}, nil); err != nil {
+	if family != familyDS18S20 && int(spad[4]>>5) != resolutionBits-9 {
+		// Set the value in the configuration register, keeping the alarms.
+		spad[4] = byte((resolutionBits-9)<<5) | 0x1f
+		if err := d.writeScratchpad(spad); err != nil {
 			return nil, err
 		}
 		// Copy the scratchpad to EEPROM to save the values.
-		if err := d.onewire.TxPower([]byte{0x48}, nil); err != nil {
+		if err := d.CopyScratchpad(); err != nil {
 			return nil, err
 		}
-		// Wait for the write to complete.
-		sleep(10 * time.Millisecond)
 	}
 
 	return d, nil
@@ -94,23 +141,109 @@ func New(o onewire.Bus, addr onewire.Address, resolutionBits int) (*Dev, error)
 
 // Dev is a handle to a Dallas Semi / Maxim DS18B20 temperature sensor on a
 // 1-wire bus.
+//
+// It also handles the DS1822 and DS18S20, which are identified by the family
+// code of their address.
 type Dev struct {
 	onewire    onewire.Dev // device on 1-wire bus
+	family     byte        // family code, lowest byte of the address
 	resolution int         // resolution in bits (9..12)
+	parasitic  bool        // device is parasitically powered
+
+	mu   sync.Mutex
+	stop chan struct{}
+	wg   sync.WaitGroup
 }
 
 func (d *Dev) String() string {
-	return fmt.Sprintf("DS18B20{%v}", d.onewire)
+	switch d.family {
+	case familyDS18S20:
+		return fmt.Sprintf("DS18S20{%v}", d.onewire)
+	case familyDS1822:
+		return fmt.Sprintf("DS1822{%v}", d.onewire)
+	default:
+		return fmt.Sprintf("DS18B20{%v}", d.onewire)
+	}
+}
+
+// Sense requests a one time measurement as °C.
+//
+// It implements devices.Environmental. Pressure and Humidity are not modified.
+func (d *Dev) Sense(env *devices.Environment) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.stop != nil {
+		return errors.New("ds18b20: already sensing continuously")
+	}
+	c, err := d.Temperature()
+	if err != nil {
+		return err
+	}
+	env.Temperature = c
+	return nil
 }
 
-// Halt implements conn.Resource.
+// SenseContinuous returns measurements as °C on a continuous basis.
+//
+// The application must call Halt() to stop the sensing when done to close the
+// channel.
+//
+// Each measurement performs a conversion on this device only, which takes up
+// to 752ms. Use a Poller to sense many devices on the same bus.
+//
+// It's the responsibility of the caller to retrieve the values from the
+// channel as fast as possible, otherwise the interval may not be respected.
+func (d *Dev) SenseContinuous(interval time.Duration) (<-chan devices.Environment, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.senseContinuous(interval), nil
+}
+
+// SenseContinuousContext is like SenseContinuous but the sensing is also
+// halted once ctx is done, as if Halt() was called.
+//
+// It implements devices.EnvironmentalContext.
+func (d *Dev) SenseContinuousContext(ctx context.Context, interval time.Duration) (<-chan devices.Environment, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	sensing := d.senseContinuous(interval)
+	stop := d.stop
+	go func() {
+		select {
+		case <-ctx.Done():
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			// Only halt if the sensing wasn't restarted or halted in the meantime.
+			if d.stop == stop {
+				d.halt()
+			}
+		case <-stop:
+		}
+	}()
+	return sensing, nil
+}
+
+// Halt stops the continuous sensing initiated by SenseContinuous().
+//
+// It implements conn.Resource.
 func (d *Dev) Halt() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.halt()
 	return nil
 }
 
+// Parasitic returns true if the device is parasitically powered, as reported
+// by the "read power supply" command when the device was opened.
+//
+// The strong pull-up is only used for parasitically powered devices.
+func (d *Dev) Parasitic() bool {
+	return d.parasitic
+}
+
 // Temperature performs a conversion and returns the temperature.
 func (d *Dev) Temperature() (devices.Celsius, error) {
-	if err := d.onewire.TxPower([]byte{0x44}, nil); err != nil {
+	if err := d.txPower([]byte{cmdConvert}); err != nil {
 		return 0, err
 	}
 	conversionSleep(d.resolution)
@@ -131,7 +264,17 @@ func (d *Dev) LastTemp() (devices.Celsius, error) {
 	// spad[1] is MSB, spad[0] is LSB and has 4 fractional bits. Need to do sign
 	// extension multiply by 1000 to get devices.Millis, divide by 16 due to 4
 	// fractional bits.  Datasheet p.4.
-	c := (devices.Celsius(int8(spad[1]))<<8 + devices.Celsius(spad[0])) * 1000 / 16
+	raw := devices.Celsius(int8(spad[1]))<<8 + devices.Celsius(spad[0])
+	c := raw * 1000 / 16
+	if d.family == familyDS18S20 {
+		// The LSB has a single fractional bit. The extended resolution is
+		// computed by truncating it and using COUNT_REMAIN and COUNT_PER_C,
+		// DS18S20 datasheet p.6.
+		c = raw * 500
+		if perC := devices.Celsius(spad[7]); perC != 0 {
+			c = (raw>>1)*1000 - 250 + (perC-devices.Celsius(spad[6]))*1000/perC
+		}
+	}
 
 	// The device powers up with a value of 85°C, so if we read that odds are
 	// very high that either no conversion was performed or that the conversion
@@ -144,7 +287,141 @@ func (d *Dev) LastTemp() (devices.Celsius, error) {
 	return c, nil
 }
 
+// Alarms returns the high and low alarm thresholds, TH and TL, as stored in
+// the scratchpad.
+//
+// The thresholds have a resolution of 1°C.
+func (d *Dev) Alarms() (high, low devices.Celsius, err error) {
+	spad, err := d.readScratchpad()
+	if err != nil {
+		return 0, 0, err
+	}
+	return devices.Celsius(int8(spad[2])) * 1000, devices.Celsius(int8(spad[3])) * 1000, nil
+}
+
+// SetAlarms writes the high and low alarm thresholds, TH and TL, in the
+// scratchpad.
+//
+// The thresholds are truncated to 1°C and must be in the range -55°C..125°C.
+// The device is in alarm state when the temperature of the last conversion
+// is higher or equal to high or lower or equal to low; see AlarmSearch.
+//
+// The thresholds are lost on power loss unless CopyScratchpad is called.
+func (d *Dev) SetAlarms(high, low devices.Celsius) error {
+	if high < -55000 || high > 125000 || low < -55000 || low > 125000 {
+		return errors.New("ds18b20: alarm threshold out of range")
+	}
+	if low > high {
+		return errors.New("ds18b20: low alarm threshold is higher than the high one")
+	}
+	spad, err := d.readScratchpad()
+	if err != nil {
+		return err
+	}
+	spad[2] = byte(int8(high / 1000))
+	spad[3] = byte(int8(low / 1000))
+	return d.writeScratchpad(spad)
+}
+
+// CopyScratchpad copies the alarm thresholds and the configuration from the
+// scratchpad to the EEPROM, so they are retained on power loss.
+//
+// It waits for the 10ms the EEPROM write takes.
+func (d *Dev) CopyScratchpad() error {
+	if err := d.txPower([]byte{cmdCopyScratchpad}); err != nil {
+		return err
+	}
+	sleep(10 * time.Millisecond)
+	return nil
+}
+
+// RecallEEPROM copies the alarm thresholds and the configuration from the
+// EEPROM to the scratchpad, undoing the changes not copied with
+// CopyScratchpad.
+//
+// The resolution used by the device is updated accordingly.
+func (d *Dev) RecallEEPROM() error {
+	if err := d.onewire.Tx([]byte{cmdRecallEEPROM}, nil); err != nil {
+		return err
+	}
+	// The recall takes a few µs, while the read below takes milliseconds.
+	spad, err := d.readScratchpad()
+	if err != nil {
+		return err
+	}
+	if d.family != familyDS18S20 {
+		d.resolution = int(spad[4]>>5&3) + 9
+	}
+	return nil
+}
+
+//
+
+const (
+	familyDS18S20 = 0x10
+	familyDS1822  = 0x22
+	familyDS18B20 = 0x28
+
+	cmdConvert         = 0x44
+	cmdWriteScratchpad = 0x4e
+	cmdReadScratchpad  = 0xbe
+	cmdCopyScratchpad  = 0x48
+	cmdRecallEEPROM    = 0xb8
+	cmdReadPowerSupply = 0xb4
+)
+
+// senseContinuous starts the continuous sensing.
+//
+// d.mu must be held.
+func (d *Dev) senseContinuous(interval time.Duration) <-chan devices.Environment {
+	d.halt()
+	sensing := make(chan devices.Environment)
+	stop := make(chan struct{})
+	d.stop = stop
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		defer close(sensing)
+		d.sensingContinuous(interval, sensing, stop)
+	}()
+	return sensing
+}
+
+// halt stops the continuous sensing.
 //
+// d.mu must be held.
+func (d *Dev) halt() {
+	if d.stop == nil {
+		return
+	}
+	close(d.stop)
+	d.stop = nil
+	d.wg.Wait()
+}
+
+func (d *Dev) sensingContinuous(interval time.Duration, sensing chan<- devices.Environment, stop <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		// Do one initial sensing right away.
+		c, err := d.Temperature()
+		if err != nil {
+			log.Printf("%s: failed to sense: %v", d, err)
+			return
+		}
+		select {
+		case sensing <- devices.Environment{Temperature: c}:
+		case <-stop:
+			return
+		}
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+	}
+}
 
 // busError implements error and onewire.BusError.
 type busError string
@@ -164,7 +441,7 @@ func conversionSleep(bits int) {
 func (d *Dev) readScratchpad() ([]byte, error) {
 	// Read the scratchpad memory.
 	var spad [9]byte
-	if err := d.onewire.Tx([]byte{0xbe}, spad[:]); err != nil {
+	if err := d.onewire.Tx([]byte{cmdReadScratchpad}, spad[:]); err != nil {
 		return nil, err
 	}
 
@@ -181,7 +458,28 @@ func (d *Dev) readScratchpad() ([]byte, error) {
 	return spad[:8], nil
 }
 
+// writeScratchpad writes TH, TL and, except on the DS18S20, the configuration
+// register from the scratchpad data spad.
+func (d *Dev) writeScratchpad(spad []byte) error {
+	w := []byte{cmdWriteScratchpad, spad[2], spad[3], spad[4]}
+	if d.family == familyDS18S20 {
+		w = w[:3]
+	}
+	return d.onewire.Tx(w, nil)
+}
+
+// txPower sends w and leaves the strong pull-up on if the device is
+// parasitically powered.
+func (d *Dev) txPower(w []byte) error {
+	if d.parasitic {
+		return d.onewire.TxPower(w, nil)
+	}
+	return d.onewire.Tx(w, nil)
+}
+
 var sleep = time.Sleep
 
 var _ conn.Resource = &Dev{}
+var _ devices.Environmental = &Dev{}
+var _ devices.EnvironmentalContext = &Dev{}
 var _ fmt.Stringer = &Dev{}
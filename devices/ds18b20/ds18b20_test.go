@@ -5,6 +5,7 @@
 package ds18b20
 
 import (
+	"context"
 	"reflect"
 	"testing"
 	"time"
@@ -40,6 +41,11 @@ func TestTemperature(t *testing.T) {
 			W: []uint8{0x55, 0x28, 0xac, 0x41, 0xe, 0x7, 0x0, 0x0, 0x74, 0xbe},
 			R: []uint8{0xe0, 0x1, 0x0, 0x0, 0x3f, 0xff, 0x10, 0x10, 0x3f},
 		},
+		// Match ROM + Read Power Supply (parasitic)
+		{
+			W: []uint8{0x55, 0x28, 0xac, 0x41, 0xe, 0x7, 0x0, 0x0, 0x74, 0xb4},
+			R: []uint8{0x0},
+		},
 		// Match ROM + Convert
 		{
 			W:    []uint8{0x55, 0x28, 0xac, 0x41, 0xe, 0x7, 0x0, 0x0, 0x74, 0x44},
@@ -85,6 +91,222 @@ func TestTemperature(t *testing.T) {
 	}
 }
 
+func TestSense(t *testing.T) {
+	match := []byte{0x55, 0x28, 0xac, 0x41, 0xe, 0x7, 0x0, 0x0, 0x74}
+	ops := []onewiretest.IO{
+		{W: append(match, 0xbe), R: spad([]byte{0x50, 0x05, 0x4b, 0x46, 0x1f, 0xff, 0x0c, 0x10})},
+		{W: append(match, 0xb4), R: []byte{0xff}},
+		{W: append(match, 0x44)},
+		{W: append(match, 0xbe), R: spad([]byte{0x91, 0x01, 0x4b, 0x46, 0x1f, 0xff, 0x0f, 0x10})},
+		{W: append(match, 0x44)},
+		{W: append(match, 0xbe), R: spad([]byte{0x90, 0x01, 0x4b, 0x46, 0x1f, 0xff, 0x0f, 0x10})},
+		{W: append(match, 0x44)},
+		{W: append(match, 0xbe), R: spad([]byte{0x80, 0x01, 0x4b, 0x46, 0x1f, 0xff, 0x0f, 0x10})},
+	}
+	bus := onewiretest.Playback{Ops: ops}
+	d, err := New(&bus, 0x740000070e41ac28, 9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := devices.Environment{Pressure: 1}
+	if err := d.Sense(&e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Temperature != 25062 || e.Pressure != 1 {
+		t.Fatal(e)
+	}
+
+	c, err := d.SenseContinuous(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := <-c; e.Temperature != 25000 {
+		t.Fatal(e)
+	}
+	if d.Sense(&e) == nil {
+		t.Fatal("sensing continuously")
+	}
+	if err := d.Halt(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := <-c; ok {
+		t.Fatal("channel must be closed")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if c, err = d.SenseContinuousContext(ctx, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if e := <-c; e.Temperature != 24000 {
+		t.Fatal(e)
+	}
+	cancel()
+	if _, ok := <-c; ok {
+		t.Fatal("channel must be closed")
+	}
+	if err := bus.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNew_fail_family(t *testing.T) {
+	bus := &onewiretest.Playback{}
+	if d, err := New(bus, 0x740000070e41ac01, 9); d != nil || err == nil || err.Error() != "ds18b20: unsupported family code 0x01" {
+		t.Fatal(err)
+	}
+}
+
+// TestNew_resolution tests that changing the resolution keeps the alarms and
+// that the strong pull-up is not used on a powered device.
+func TestNew_resolution(t *testing.T) {
+	match := []byte{0x55, 0x28, 0xac, 0x41, 0xe, 0x7, 0x0, 0x0, 0x74}
+	ops := []onewiretest.IO{
+		{W: append(match, 0xbe), R: spad([]byte{0x50, 0x05, 0x4b, 0x46, 0x7f, 0xff, 0x0c, 0x10})},
+		{W: append(match, 0xb4), R: []byte{0xff}},
+		{W: append(match, 0x4e, 0x4b, 0x46, 0x3f)},
+		{W: append(match, 0x48)},
+		{W: append(match, 0x44)},
+		{W: append(match, 0xbe), R: spad([]byte{0x91, 0x01, 0x4b, 0x46, 0x3f, 0xff, 0x0f, 0x10})},
+	}
+	bus := onewiretest.Playback{Ops: ops}
+	d, err := New(&bus, 0x740000070e41ac28, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Parasitic() {
+		t.Fatal("powered")
+	}
+	c, err := d.Temperature()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c != 25062 {
+		t.Fatal(c)
+	}
+	if err := bus.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDS18S20(t *testing.T) {
+	match := []byte{0x55, 0x10, 0xac, 0x41, 0xe, 0x7, 0x0, 0x0, 0x74}
+	ops := []onewiretest.IO{
+		{W: append(match, 0xbe), R: spad([]byte{0xaa, 0x00, 0x4b, 0x46, 0xff, 0xff, 0x0c, 0x10})},
+		{W: append(match, 0xb4), R: []byte{0xfe}},
+		{W: append(match, 0x44), Pull: onewire.StrongPullup},
+		// 25.0°C truncated, with a COUNT_REMAIN of 7: 25.3125°C.
+		{W: append(match, 0xbe), R: spad([]byte{0x32, 0x00, 0x4b, 0x46, 0xff, 0xff, 0x07, 0x10})},
+		// -10.5°C, COUNT_PER_C is invalid.
+		{W: append(match, 0xbe), R: spad([]byte{0xeb, 0xff, 0x4b, 0x46, 0xff, 0xff, 0x07, 0x00})},
+		// Set the alarms, the configuration register is not written.
+		{W: append(match, 0xbe), R: spad([]byte{0xeb, 0xff, 0x4b, 0x46, 0xff, 0xff, 0x07, 0x10})},
+		{W: append(match, 0x4e, 0x1e, 0xf6)},
+	}
+	bus := onewiretest.Playback{Ops: ops}
+	d, err := New(&bus, 0x740000070e41ac10, 9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := d.String(); s != "DS18S20{{playback 8358680938703596560}}" {
+		t.Fatal(s)
+	}
+	if !d.Parasitic() {
+		t.Fatal("parasitic")
+	}
+	var sleeps []time.Duration
+	sleep = func(d time.Duration) { sleeps = append(sleeps, d) }
+	defer func() { sleep = func(time.Duration) {} }()
+	c, err := d.Temperature()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c != 25312 {
+		t.Fatal(c)
+	}
+	if !reflect.DeepEqual(sleeps, []time.Duration{752 * time.Millisecond}) {
+		t.Fatal(sleeps)
+	}
+	if c, err = d.LastTemp(); err != nil || c != -10500 {
+		t.Fatal(c, err)
+	}
+	if err := d.SetAlarms(30500, -10500); err != nil {
+		t.Fatal(err)
+	}
+	if err := bus.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAlarms(t *testing.T) {
+	match := []byte{0x55, 0x22, 0xac, 0x41, 0xe, 0x7, 0x0, 0x0, 0x74}
+	ops := []onewiretest.IO{
+		{W: append(match, 0xbe), R: spad([]byte{0x91, 0x01, 0x4b, 0xf6, 0x1f, 0xff, 0x0f, 0x10})},
+		{W: append(match, 0xb4), R: []byte{0xfe}},
+		{W: append(match, 0xbe), R: spad([]byte{0x91, 0x01, 0x4b, 0xf6, 0x1f, 0xff, 0x0f, 0x10})},
+		{W: append(match, 0xbe), R: spad([]byte{0x91, 0x01, 0x4b, 0xf6, 0x1f, 0xff, 0x0f, 0x10})},
+		{W: append(match, 0x4e, 0x1e, 0x00, 0x1f)},
+		{W: append(match, 0x48), Pull: onewire.StrongPullup},
+		{W: append(match, 0xb8)},
+		{W: append(match, 0xbe), R: spad([]byte{0x91, 0x01, 0x4b, 0xf6, 0x7f, 0xff, 0x0f, 0x10})},
+	}
+	bus := onewiretest.Playback{Ops: ops}
+	d, err := New(&bus, 0x740000070e41ac22, 9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := d.String(); s != "DS1822{{playback 8358680938703596578}}" {
+		t.Fatal(s)
+	}
+	high, low, err := d.Alarms()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if high != 75000 || low != -10000 {
+		t.Fatal(high, low)
+	}
+	if err := d.SetAlarms(126000, 0); err == nil {
+		t.Fatal("out of range")
+	}
+	if err := d.SetAlarms(0, 10000); err == nil {
+		t.Fatal("low > high")
+	}
+	if err := d.SetAlarms(30999, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.CopyScratchpad(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.RecallEEPROM(); err != nil {
+		t.Fatal(err)
+	}
+	if d.resolution != 12 {
+		t.Fatal(d.resolution)
+	}
+	if err := bus.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAlarmSearch(t *testing.T) {
+	bus := onewiretest.Playback{
+		Devices: []onewire.Address{withCRC(0x0000000123456728), withCRC(0x0000000123456629), withCRC(0x0000000123456610)},
+	}
+	// One search operation per device.
+	for range bus.Devices {
+		bus.Ops = append(bus.Ops, onewiretest.IO{W: []byte{0xec}})
+	}
+	addrs, err := AlarmSearch(&bus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 2 || addrs[0]&0xff == 0x29 || addrs[1]&0xff == 0x29 {
+		t.Fatalf("%#x", addrs)
+	}
+	if err := bus.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
 // TestConvertAll tests a temperature conversion on all ds18b20 using
 // recorded bus transactions.
 func TestConvertAll(t *testing.T) {
@@ -124,6 +346,22 @@ func TestConvertAll_fail_io(t *testing.T) {
 	}
 }
 
+//
+
+// withCRC sets the CRC byte of the address.
+func withCRC(a onewire.Address) onewire.Address {
+	var b [7]byte
+	for i := range b {
+		b[i] = byte(a >> uint(8*i))
+	}
+	return a | onewire.Address(onewire.CalcCRC(b[:]))<<56
+}
+
+// spad appends the CRC to the scratchpad data.
+func spad(b []byte) []byte {
+	return append(b, onewire.CalcCRC(b))
+}
+
 func init() {
 	sleep = func(time.Duration) {}
 }
@@ -0,0 +1,148 @@
+// Copyright 2018 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package ds18b20
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"periph.io/x/periph/conn"
+	"periph.io/x/periph/conn/onewire"
+	"periph.io/x/periph/devices"
+)
+
+// NewPoller returns a Poller that senses all the devices on the bus o at once.
+//
+// All the devices must have been opened with New on the bus o.
+func NewPoller(o onewire.Bus, devs ...*Dev) (*Poller, error) {
+	if len(devs) == 0 {
+		return nil, errors.New("ds18b20: no device to poll")
+	}
+	p := &Poller{bus: o, devs: devs, resolution: 9}
+	for _, d := range devs {
+		if d.onewire.Bus != o {
+			return nil, fmt.Errorf("ds18b20: %s is not on bus %s", d, o)
+		}
+		if d.resolution > p.resolution {
+			p.resolution = d.resolution
+		}
+	}
+	return p, nil
+}
+
+// Poller senses many temperature sensors on the same 1-wire bus.
+//
+// Each interval, it starts the conversion on all the devices at once with
+// ConvertAll and then reads the temperature of each device with LastTemp.
+// This way a bus of 30 sensors takes one conversion time per interval instead
+// of 30.
+type Poller struct {
+	bus        onewire.Bus
+	devs       []*Dev
+	resolution int // highest resolution of the devices
+
+	mu   sync.Mutex
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func (p *Poller) String() string {
+	return fmt.Sprintf("ds18b20.Poller{%s}", p.bus)
+}
+
+// SenseContinuous returns one channel per device, in the order passed to
+// NewPoller, on which the measurements as °C are sent on a continuous basis.
+//
+// A device that fails to be read is skipped for this interval; the error is
+// logged.
+//
+// The application must call Halt() to stop the sensing when done to close the
+// channels.
+//
+// It's the responsibility of the caller to retrieve the values from all the
+// channels as fast as possible, otherwise the interval may not be respected.
+func (p *Poller) SenseContinuous(interval time.Duration) ([]<-chan devices.Environment, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.halt()
+	sensing := make([]chan devices.Environment, len(p.devs))
+	out := make([]<-chan devices.Environment, len(p.devs))
+	for i := range sensing {
+		sensing[i] = make(chan devices.Environment)
+		out[i] = sensing[i]
+	}
+	stop := make(chan struct{})
+	p.stop = stop
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer func() {
+			for _, c := range sensing {
+				close(c)
+			}
+		}()
+		p.sensingContinuous(interval, sensing, stop)
+	}()
+	return out, nil
+}
+
+// Halt stops the continuous sensing initiated by SenseContinuous().
+//
+// It implements conn.Resource.
+func (p *Poller) Halt() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.halt()
+	return nil
+}
+
+//
+
+// halt stops the continuous sensing.
+//
+// p.mu must be held.
+func (p *Poller) halt() {
+	if p.stop == nil {
+		return
+	}
+	close(p.stop)
+	p.stop = nil
+	p.wg.Wait()
+}
+
+func (p *Poller) sensingContinuous(interval time.Duration, sensing []chan devices.Environment, stop <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		// Do one initial sensing right away.
+		if err := ConvertAll(p.bus, p.resolution); err != nil {
+			log.Printf("%s: failed to convert: %v", p, err)
+		} else {
+			for i, d := range p.devs {
+				c, err := d.LastTemp()
+				if err != nil {
+					log.Printf("%s: failed to sense: %v", d, err)
+					continue
+				}
+				select {
+				case sensing[i] <- devices.Environment{Temperature: c}:
+				case <-stop:
+					return
+				}
+			}
+		}
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+var _ conn.Resource = &Poller{}
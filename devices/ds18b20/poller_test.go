@@ -0,0 +1,86 @@
+// Copyright 2018 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package ds18b20
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"periph.io/x/periph/conn/onewire/onewiretest"
+)
+
+func TestPoller(t *testing.T) {
+	match1 := []byte{0x55, 0x28, 0xac, 0x41, 0xe, 0x7, 0x0, 0x0, 0x74}
+	match2 := []byte{0x55, 0x28, 0x51, 0xdb, 0x3d, 0x0, 0x0, 0x0, 0xfc}
+	ops := []onewiretest.IO{
+		{W: append(match1, 0xbe), R: spad([]byte{0x50, 0x05, 0x4b, 0x46, 0x1f, 0xff, 0x0c, 0x10})},
+		{W: append(match1, 0xb4), R: []byte{0xff}},
+		{W: append(match2, 0xbe), R: spad([]byte{0x50, 0x05, 0x4b, 0x46, 0x3f, 0xff, 0x0c, 0x10})},
+		{W: append(match2, 0xb4), R: []byte{0x00}},
+		// A single conversion for both devices.
+		{W: []byte{0xcc, 0x44}, Pull: true},
+		{W: append(match1, 0xbe), R: spad([]byte{0x91, 0x01, 0x4b, 0x46, 0x1f, 0xff, 0x0f, 0x10})},
+		{W: append(match2, 0xbe), R: spad([]byte{0x80, 0x01, 0x4b, 0x46, 0x3f, 0xff, 0x0f, 0x10})},
+	}
+	bus := onewiretest.Playback{Ops: ops}
+	d1, err := New(&bus, 0x740000070e41ac28, 9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d2, err := New(&bus, 0xfc0000003ddb5128, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewPoller(&bus, d1, d2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := p.String(); s != "ds18b20.Poller{playback}" {
+		t.Fatal(s)
+	}
+	var sleeps []time.Duration
+	sleep = func(d time.Duration) { sleeps = append(sleeps, d) }
+	defer func() { sleep = func(time.Duration) {} }()
+	c, err := p.SenseContinuous(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c) != 2 {
+		t.Fatal(c)
+	}
+	if e := <-c[0]; e.Temperature != 25062 {
+		t.Fatal(e)
+	}
+	if e := <-c[1]; e.Temperature != 24000 {
+		t.Fatal(e)
+	}
+	if err := p.Halt(); err != nil {
+		t.Fatal(err)
+	}
+	for i := range c {
+		if _, ok := <-c[i]; ok {
+			t.Fatal("channel must be closed")
+		}
+	}
+	// The conversion time of the highest resolution.
+	if !reflect.DeepEqual(sleeps, []time.Duration{188 * time.Millisecond}) {
+		t.Fatal(sleeps)
+	}
+	if err := bus.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewPoller_fail(t *testing.T) {
+	bus := onewiretest.Playback{}
+	if _, err := NewPoller(&bus); err == nil {
+		t.Fatal("no device")
+	}
+	d := &Dev{}
+	if _, err := NewPoller(&bus, d); err == nil {
+		t.Fatal("device on another bus")
+	}
+}
@@ -0,0 +1,259 @@
+// Copyright 2018 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+// Package ds2408 controls a Maxim DS2408 8-channel addressable switch on a
+// 1-wire bus.
+//
+// The 8 open-drain channels P0 to P7 are exposed as gpio.PinIO. Driving a pin
+// high releases it, so it can be read as an input; an external pull-up
+// resistor is required.
+//
+// Datasheet
+//
+// https://datasheets.maximintegrated.com/en/ds/DS2408.pdf
+package ds2408
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"periph.io/x/periph/conn"
+	"periph.io/x/periph/conn/gpio"
+	"periph.io/x/periph/conn/gpio/gpioreg"
+	"periph.io/x/periph/conn/onewire"
+)
+
+// New returns an object that communicates over 1-wire to the DS2408 with the
+// specified 64-bit address.
+//
+// The state of the output latches is read from the device, so the pins keep
+// their current state.
+func New(o onewire.Bus, addr onewire.Address) (*Dev, error) {
+	if family := byte(addr); family != 0x29 {
+		return nil, fmt.Errorf("ds2408: unsupported family code %#02x", family)
+	}
+	d := &Dev{onewire: onewire.Dev{Bus: o, Addr: addr}}
+	for i := range d.pins {
+		d.pins[i] = Pin{d: d, n: i, name: fmt.Sprintf("DS2408-%016x-P%d", uint64(addr), i)}
+	}
+	regs, err := d.readRegisters()
+	if err != nil {
+		return nil, err
+	}
+	d.latch = regs[regLatch]
+	return d, nil
+}
+
+// Dev is a handle to a DS2408 on a 1-wire bus.
+type Dev struct {
+	onewire onewire.Dev
+	pins    [8]Pin
+
+	mu    sync.Mutex
+	latch byte // output latch state, bit n is Pn
+}
+
+func (d *Dev) String() string {
+	return fmt.Sprintf("DS2408{%v}", d.onewire)
+}
+
+// Halt implements conn.Resource.
+func (d *Dev) Halt() error {
+	return nil
+}
+
+// Pins returns the pins P0 to P7.
+func (d *Dev) Pins() []*Pin {
+	out := make([]*Pin, len(d.pins))
+	for i := range d.pins {
+		out[i] = &d.pins[i]
+	}
+	return out
+}
+
+// RegisterPins registers the pins in gpioreg as "DS2408-<addr>-P0" to
+// "DS2408-<addr>-P7", where addr is the 16 hex digits of the address.
+//
+// Use gpioreg.Unregister to unregister them.
+func (d *Dev) RegisterPins() error {
+	for i := range d.pins {
+		if err := gpioreg.Register(&d.pins[i], false); err != nil {
+			for j := 0; j < i; j++ {
+				gpioreg.Unregister(d.pins[j].name)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadPort returns the state of the 8 pins at once, bit n being Pn.
+func (d *Dev) ReadPort() (byte, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	regs, err := d.readRegisters()
+	if err != nil {
+		return 0, err
+	}
+	return regs[regState], nil
+}
+
+// WritePort sets the 8 output latches at once, bit n being Pn.
+//
+// A bit at 1 releases the pin.
+func (d *Dev) WritePort(latch byte) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.write(latch)
+}
+
+// Pin is one of the 8 channels of a DS2408.
+type Pin struct {
+	d    *Dev
+	n    int
+	name string
+}
+
+func (p *Pin) String() string {
+	return p.name
+}
+
+// Name implements pin.Pin.
+func (p *Pin) Name() string {
+	return p.name
+}
+
+// Number implements pin.Pin.
+//
+// It returns the channel number, between 0 and 7.
+func (p *Pin) Number() int {
+	return p.n
+}
+
+// Function implements pin.Pin.
+func (p *Pin) Function() string {
+	p.d.mu.Lock()
+	defer p.d.mu.Unlock()
+	if p.d.latch&(1<<uint(p.n)) == 0 {
+		return "Out/Low"
+	}
+	return "In/" + p.read().String()
+}
+
+// Halt implements conn.Resource.
+func (p *Pin) Halt() error {
+	return nil
+}
+
+// In implements gpio.PinIn.
+//
+// It releases the pin. Edge detection and the pull-down are not supported; the
+// pull-up is external.
+func (p *Pin) In(pull gpio.Pull, edge gpio.Edge) error {
+	if edge != gpio.NoEdge {
+		return errors.New("ds2408: edge detection is not supported")
+	}
+	if pull == gpio.PullDown {
+		return errors.New("ds2408: pull-down is not supported")
+	}
+	return p.Out(gpio.High)
+}
+
+// Read implements gpio.PinIn.
+//
+// It returns Low on a bus error.
+func (p *Pin) Read() gpio.Level {
+	p.d.mu.Lock()
+	defer p.d.mu.Unlock()
+	return p.read()
+}
+
+// WaitForEdge implements gpio.PinIn.
+//
+// It always returns false since edge detection is not supported.
+func (p *Pin) WaitForEdge(timeout time.Duration) bool {
+	return false
+}
+
+// Pull implements gpio.PinIn.
+func (p *Pin) Pull() gpio.Pull {
+	return gpio.PullNoChange
+}
+
+// Out implements gpio.PinOut.
+//
+// Low turns the open-drain output on. High turns it off, the pin is then pulled
+// high by the external resistor.
+func (p *Pin) Out(l gpio.Level) error {
+	p.d.mu.Lock()
+	defer p.d.mu.Unlock()
+	latch := p.d.latch &^ (1 << uint(p.n))
+	if l {
+		latch |= 1 << uint(p.n)
+	}
+	return p.d.write(latch)
+}
+
+//
+
+const (
+	cmdReadPIORegisters = 0xf0 // read PIO registers
+	cmdChannelWrite     = 0x5a // channel-access write
+
+	addrRegisters = 0x88 // address of the PIO logic state register
+	regState      = 0    // PIO logic state, relative to addrRegisters
+	regLatch      = 1    // PIO output latch state, relative to addrRegisters
+)
+
+// readRegisters reads the 8 registers from the PIO logic state register to the
+// end of the register page, and checks the CRC-16.
+func (d *Dev) readRegisters() ([]byte, error) {
+	w := []byte{cmdReadPIORegisters, addrRegisters, 0}
+	var r [10]byte
+	if err := d.onewire.Tx(w, r[:]); err != nil {
+		return nil, err
+	}
+	if !onewire.CheckCRC16(append(w, r[:]...)) {
+		return nil, busError("ds2408: incorrect CRC reading the registers")
+	}
+	return r[:8], nil
+}
+
+// write sets the output latches.
+//
+// d.mu must be held.
+func (d *Dev) write(latch byte) error {
+	var r [2]byte
+	if err := d.onewire.Tx([]byte{cmdChannelWrite, latch, ^latch}, r[:]); err != nil {
+		return err
+	}
+	if r[0] != 0xaa {
+		return busError("ds2408: channel-access write failed")
+	}
+	d.latch = latch
+	return nil
+}
+
+// read returns the state of the pin.
+//
+// p.d.mu must be held.
+func (p *Pin) read() gpio.Level {
+	regs, err := p.d.readRegisters()
+	if err != nil {
+		return gpio.Low
+	}
+	return regs[regState]&(1<<uint(p.n)) != 0
+}
+
+// busError implements error and onewire.BusError.
+type busError string
+
+func (e busError) Error() string  { return string(e) }
+func (e busError) BusError() bool { return true }
+
+var _ conn.Resource = &Dev{}
+var _ fmt.Stringer = &Dev{}
+var _ gpio.PinIO = &Pin{}
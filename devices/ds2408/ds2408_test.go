@@ -0,0 +1,138 @@
+// Copyright 2018 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package ds2408
+
+import (
+	"testing"
+
+	"periph.io/x/periph/conn/gpio"
+	"periph.io/x/periph/conn/gpio/gpioreg"
+	"periph.io/x/periph/conn/onewire"
+	"periph.io/x/periph/conn/onewire/onewiretest"
+)
+
+func TestNew_fail(t *testing.T) {
+	bus := &onewiretest.Playback{}
+	if d, err := New(bus, 0x740000070e41ac28); d != nil || err == nil {
+		t.Fatal("invalid family")
+	}
+	r := regs(0xff, 0xff)
+	r[9]++
+	bus = &onewiretest.Playback{
+		Ops: []onewiretest.IO{{W: append(match(), 0xf0, 0x88, 0x00), R: r}},
+	}
+	if d, err := New(bus, 0x740000070e41ac29); d != nil || err == nil {
+		t.Fatal("invalid CRC")
+	}
+}
+
+func TestPin(t *testing.T) {
+	bus := onewiretest.Playback{
+		Ops: []onewiretest.IO{
+			// All released, P5 is pulled low.
+			{W: append(match(), 0xf0, 0x88, 0x00), R: regs(0xdf, 0xff)},
+			// P3 low.
+			{W: append(match(), 0x5a, 0xf7, 0x08), R: []byte{0xaa, 0xd7}},
+			{W: append(match(), 0xf0, 0x88, 0x00), R: regs(0xd7, 0xf7)},
+			{W: append(match(), 0xf0, 0x88, 0x00), R: regs(0xd7, 0xf7)},
+			// P3 released.
+			{W: append(match(), 0x5a, 0xff, 0x00), R: []byte{0xaa, 0xdf}},
+			{W: append(match(), 0x5a, 0x0f, 0xf0), R: []byte{0xaa, 0x0f}},
+			{W: append(match(), 0xf0, 0x88, 0x00), R: regs(0x0f, 0x0f)},
+			// Failed write.
+			{W: append(match(), 0x5a, 0x0e, 0xf1), R: []byte{0xff, 0xff}},
+		},
+	}
+	d, err := New(&bus, 0x740000070e41ac29)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := d.String(); s != "DS2408{{playback 8358680938703596585}}" {
+		t.Fatal(s)
+	}
+	if err := d.Halt(); err != nil {
+		t.Fatal(err)
+	}
+	p := d.Pins()
+	if len(p) != 8 || p[3].Number() != 3 {
+		t.Fatal(p)
+	}
+	if s := p[3].String(); s != "DS2408-740000070e41ac29-P3" {
+		t.Fatal(s)
+	}
+	if err := p[3].Out(gpio.Low); err != nil {
+		t.Fatal(err)
+	}
+	if f := p[3].Function(); f != "Out/Low" {
+		t.Fatal(f)
+	}
+	if l := p[5].Read(); l != gpio.Low {
+		t.Fatal(l)
+	}
+	if f := p[4].Function(); f != "In/High" {
+		t.Fatal(f)
+	}
+	if err := p[3].In(gpio.PullUp, gpio.NoEdge); err != nil {
+		t.Fatal(err)
+	}
+	if p[3].In(gpio.PullNoChange, gpio.RisingEdge) == nil {
+		t.Fatal("edge detection is not supported")
+	}
+	if p[3].In(gpio.PullDown, gpio.NoEdge) == nil {
+		t.Fatal("pull-down is not supported")
+	}
+	if err := d.WritePort(0x0f); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := d.ReadPort(); err != nil || v != 0x0f {
+		t.Fatal(v, err)
+	}
+	if p[0].Out(gpio.Low) == nil {
+		t.Fatal("failed write")
+	}
+	if p[0].WaitForEdge(-1) || p[0].Pull() != gpio.PullNoChange || p[0].Halt() != nil {
+		t.Fatal("unexpected")
+	}
+	if err := bus.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRegisterPins(t *testing.T) {
+	bus := onewiretest.Playback{
+		Ops: []onewiretest.IO{{W: append(match(), 0xf0, 0x88, 0x00), R: regs(0xff, 0xff)}},
+	}
+	d, err := New(&bus, 0x740000070e41ac29)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.RegisterPins(); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range d.Pins() {
+		defer gpioreg.Unregister(p.Name())
+	}
+	if p := gpioreg.ByName("DS2408-740000070e41ac29-P7"); p != d.Pins()[7] {
+		t.Fatal(p)
+	}
+	if d.RegisterPins() == nil {
+		t.Fatal("already registered")
+	}
+}
+
+//
+
+// match returns the Match ROM command for the device 0x740000070e41ac29.
+func match() []byte {
+	return []byte{0x55, 0x29, 0xac, 0x41, 0x0e, 0x07, 0x00, 0x00, 0x74}
+}
+
+// regs returns the registers as read from the PIO logic state register,
+// followed by the inverted CRC-16.
+func regs(state, latch byte) []byte {
+	r := []byte{state, latch, 0, 0, 0, 0, 0x08, 0xff}
+	crc := ^onewire.CalcCRC16(append([]byte{0xf0, 0x88, 0x00}, r...))
+	return append(r, byte(crc), byte(crc>>8))
+}
@@ -0,0 +1,233 @@
+// Copyright 2018 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+// Package ds2413 controls a Maxim DS2413 dual channel addressable switch on a
+// 1-wire bus.
+//
+// The 2 open-drain channels PIOA and PIOB are exposed as gpio.PinIO. Driving a
+// pin high releases it, so it can be read as an input; an external pull-up
+// resistor is required.
+//
+// Datasheet
+//
+// https://datasheets.maximintegrated.com/en/ds/DS2413.pdf
+package ds2413
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"periph.io/x/periph/conn"
+	"periph.io/x/periph/conn/gpio"
+	"periph.io/x/periph/conn/gpio/gpioreg"
+	"periph.io/x/periph/conn/onewire"
+)
+
+// New returns an object that communicates over 1-wire to the DS2413 with the
+// specified 64-bit address.
+//
+// The state of the output latches is read from the device, so the pins keep
+// their current state.
+func New(o onewire.Bus, addr onewire.Address) (*Dev, error) {
+	if family := byte(addr); family != 0x3a {
+		return nil, fmt.Errorf("ds2413: unsupported family code %#02x", family)
+	}
+	d := &Dev{onewire: onewire.Dev{Bus: o, Addr: addr}}
+	for i := range d.pins {
+		d.pins[i] = Pin{d: d, n: i, name: fmt.Sprintf("DS2413-%016x-PIO%c", uint64(addr), 'A'+i)}
+	}
+	s, err := d.read()
+	if err != nil {
+		return nil, err
+	}
+	d.latch = s >> 1 & 1
+	d.latch |= s >> 2 & 2
+	return d, nil
+}
+
+// Dev is a handle to a DS2413 on a 1-wire bus.
+type Dev struct {
+	onewire onewire.Dev
+	pins    [2]Pin
+
+	mu    sync.Mutex
+	latch byte // output latch state, bit 0 is PIOA and bit 1 is PIOB
+}
+
+func (d *Dev) String() string {
+	return fmt.Sprintf("DS2413{%v}", d.onewire)
+}
+
+// Halt implements conn.Resource.
+func (d *Dev) Halt() error {
+	return nil
+}
+
+// Pins returns the pins PIOA and PIOB.
+func (d *Dev) Pins() []*Pin {
+	return []*Pin{&d.pins[0], &d.pins[1]}
+}
+
+// RegisterPins registers the pins in gpioreg as "DS2413-<addr>-PIOA" and
+// "DS2413-<addr>-PIOB", where addr is the 16 hex digits of the address.
+//
+// Use gpioreg.Unregister to unregister them.
+func (d *Dev) RegisterPins() error {
+	for i := range d.pins {
+		if err := gpioreg.Register(&d.pins[i], false); err != nil {
+			for j := 0; j < i; j++ {
+				gpioreg.Unregister(d.pins[j].name)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Pin is one of the 2 channels of a DS2413.
+type Pin struct {
+	d    *Dev
+	n    int
+	name string
+}
+
+func (p *Pin) String() string {
+	return p.name
+}
+
+// Name implements pin.Pin.
+func (p *Pin) Name() string {
+	return p.name
+}
+
+// Number implements pin.Pin.
+//
+// It returns 0 for PIOA and 1 for PIOB.
+func (p *Pin) Number() int {
+	return p.n
+}
+
+// Function implements pin.Pin.
+func (p *Pin) Function() string {
+	p.d.mu.Lock()
+	defer p.d.mu.Unlock()
+	if p.d.latch&(1<<uint(p.n)) == 0 {
+		return "Out/Low"
+	}
+	return "In/" + p.read().String()
+}
+
+// Halt implements conn.Resource.
+func (p *Pin) Halt() error {
+	return nil
+}
+
+// In implements gpio.PinIn.
+//
+// It releases the pin. Edge detection and the pull-down are not supported; the
+// pull-up is external.
+func (p *Pin) In(pull gpio.Pull, edge gpio.Edge) error {
+	if edge != gpio.NoEdge {
+		return errors.New("ds2413: edge detection is not supported")
+	}
+	if pull == gpio.PullDown {
+		return errors.New("ds2413: pull-down is not supported")
+	}
+	return p.Out(gpio.High)
+}
+
+// Read implements gpio.PinIn.
+//
+// It returns Low on a bus error.
+func (p *Pin) Read() gpio.Level {
+	p.d.mu.Lock()
+	defer p.d.mu.Unlock()
+	return p.read()
+}
+
+// WaitForEdge implements gpio.PinIn.
+//
+// It always returns false since edge detection is not supported.
+func (p *Pin) WaitForEdge(timeout time.Duration) bool {
+	return false
+}
+
+// Pull implements gpio.PinIn.
+func (p *Pin) Pull() gpio.Pull {
+	return gpio.PullNoChange
+}
+
+// Out implements gpio.PinOut.
+//
+// Low turns the open-drain output on. High turns it off, the pin is then pulled
+// high by the external resistor.
+func (p *Pin) Out(l gpio.Level) error {
+	p.d.mu.Lock()
+	defer p.d.mu.Unlock()
+	latch := p.d.latch &^ (1 << uint(p.n))
+	if l {
+		latch |= 1 << uint(p.n)
+	}
+	return p.d.write(latch)
+}
+
+//
+
+const (
+	cmdPIORead  = 0xf5 // PIO access read
+	cmdPIOWrite = 0x5a // PIO access write
+)
+
+// read returns the PIO status byte: bit 0 is the PIOA pin state, bit 1 the
+// PIOA output latch state, bit 2 and 3 the same for PIOB.
+func (d *Dev) read() (byte, error) {
+	var s [1]byte
+	if err := d.onewire.Tx([]byte{cmdPIORead}, s[:]); err != nil {
+		return 0, err
+	}
+	// The upper nibble is the complement of the lower nibble.
+	if s[0]>>4 != ^s[0]&0xf {
+		return 0, busError("ds2413: invalid PIO status")
+	}
+	return s[0] & 0xf, nil
+}
+
+// write sets the output latches.
+//
+// d.mu must be held.
+func (d *Dev) write(latch byte) error {
+	v := 0xfc | latch
+	var r [2]byte
+	if err := d.onewire.Tx([]byte{cmdPIOWrite, v, ^v}, r[:]); err != nil {
+		return err
+	}
+	if r[0] != 0xaa {
+		return busError("ds2413: PIO access write failed")
+	}
+	d.latch = latch
+	return nil
+}
+
+// read returns the state of the pin.
+//
+// p.d.mu must be held.
+func (p *Pin) read() gpio.Level {
+	s, err := p.d.read()
+	if err != nil {
+		return gpio.Low
+	}
+	return s&(1<<uint(2*p.n)) != 0
+}
+
+// busError implements error and onewire.BusError.
+type busError string
+
+func (e busError) Error() string  { return string(e) }
+func (e busError) BusError() bool { return true }
+
+var _ conn.Resource = &Dev{}
+var _ fmt.Stringer = &Dev{}
+var _ gpio.PinIO = &Pin{}
@@ -0,0 +1,126 @@
+// Copyright 2018 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package ds2413
+
+import (
+	"testing"
+
+	"periph.io/x/periph/conn/gpio"
+	"periph.io/x/periph/conn/gpio/gpioreg"
+	"periph.io/x/periph/conn/onewire/onewiretest"
+)
+
+func TestNew_fail(t *testing.T) {
+	bus := &onewiretest.Playback{}
+	if d, err := New(bus, 0x740000070e41ac28); d != nil || err == nil {
+		t.Fatal("invalid family")
+	}
+	bus = &onewiretest.Playback{
+		Ops: []onewiretest.IO{{W: append(match(), 0xf5), R: []byte{0xff}}},
+	}
+	if d, err := New(bus, 0x740000070e41ac3a); d != nil || err == nil {
+		t.Fatal("invalid status")
+	}
+}
+
+func TestPin(t *testing.T) {
+	bus := onewiretest.Playback{
+		Ops: []onewiretest.IO{
+			// PIOA is released and high, PIOB is released and pulled low.
+			{W: append(match(), 0xf5), R: []byte{0x4b}},
+			// PIOA low.
+			{W: append(match(), 0x5a, 0xfe, 0x01), R: []byte{0xaa, 0x1e}},
+			{W: append(match(), 0xf5), R: []byte{0x4b}},
+			{W: append(match(), 0xf5), R: []byte{0x4b}},
+			// PIOA released.
+			{W: append(match(), 0x5a, 0xff, 0x00), R: []byte{0xaa, 0x4b}},
+			// Failed write.
+			{W: append(match(), 0x5a, 0xfd, 0x02), R: []byte{0xff, 0xff}},
+			// Corrupted read.
+			{W: append(match(), 0xf5), R: []byte{0xff}},
+		},
+	}
+	d, err := New(&bus, 0x740000070e41ac3a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := d.String(); s != "DS2413{{playback 8358680938703596602}}" {
+		t.Fatal(s)
+	}
+	if err := d.Halt(); err != nil {
+		t.Fatal(err)
+	}
+	p := d.Pins()
+	if len(p) != 2 || p[1].Number() != 1 {
+		t.Fatal(p)
+	}
+	if s := p[0].String(); s != "DS2413-740000070e41ac3a-PIOA" {
+		t.Fatal(s)
+	}
+	if err := p[0].Out(gpio.Low); err != nil {
+		t.Fatal(err)
+	}
+	if f := p[0].Function(); f != "Out/Low" {
+		t.Fatal(f)
+	}
+	if l := p[1].Read(); l != gpio.Low {
+		t.Fatal(l)
+	}
+	if f := p[1].Function(); f != "In/Low" {
+		t.Fatal(f)
+	}
+	if err := p[0].In(gpio.PullUp, gpio.NoEdge); err != nil {
+		t.Fatal(err)
+	}
+	if p[0].In(gpio.PullNoChange, gpio.RisingEdge) == nil {
+		t.Fatal("edge detection is not supported")
+	}
+	if p[0].In(gpio.PullDown, gpio.NoEdge) == nil {
+		t.Fatal("pull-down is not supported")
+	}
+	if p[1].Out(gpio.Low) == nil {
+		t.Fatal("failed write")
+	}
+	if l := p[0].Read(); l != gpio.Low {
+		t.Fatal("corrupted read")
+	}
+	if p[0].WaitForEdge(-1) || p[0].Pull() != gpio.PullNoChange || p[0].Halt() != nil {
+		t.Fatal("unexpected")
+	}
+	if err := bus.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRegisterPins(t *testing.T) {
+	bus := onewiretest.Playback{
+		Ops: []onewiretest.IO{{W: append(match(), 0xf5), R: []byte{0x0f}}},
+	}
+	d, err := New(&bus, 0x740000070e41ac3a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.RegisterPins(); err != nil {
+		t.Fatal(err)
+	}
+	defer gpioreg.Unregister("DS2413-740000070e41ac3a-PIOA")
+	defer gpioreg.Unregister("DS2413-740000070e41ac3a-PIOB")
+	if p := gpioreg.ByName("DS2413-740000070e41ac3a-PIOB"); p != d.Pins()[1] {
+		t.Fatal(p)
+	}
+	if d.RegisterPins() == nil {
+		t.Fatal("already registered")
+	}
+	if gpioreg.ByName("DS2413-740000070e41ac3a-PIOA") == nil {
+		t.Fatal("must not be unregistered")
+	}
+}
+
+//
+
+// match returns the Match ROM command for the device 0x740000070e41ac3a.
+func match() []byte {
+	return []byte{0x55, 0x3a, 0xac, 0x41, 0x0e, 0x07, 0x00, 0x00, 0x74}
+}
@@ -0,0 +1,207 @@
+// Copyright 2018 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+// Package ds2431 controls a Maxim DS2431 1024 bits EEPROM on a 1-wire bus.
+//
+// The 128 bytes of memory are accessed as an io.ReaderAt and an io.WriterAt.
+// The protection registers are not exposed.
+//
+// Datasheet
+//
+// https://datasheets.maximintegrated.com/en/ds/DS2431.pdf
+package ds2431
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"periph.io/x/periph/conn"
+	"periph.io/x/periph/conn/onewire"
+)
+
+// Size is the size of the memory in bytes.
+const Size = 128
+
+// New returns an object that communicates over 1-wire to the DS2431 with the
+// specified 64-bit address.
+func New(o onewire.Bus, addr onewire.Address) (*Dev, error) {
+	if family := byte(addr); family != 0x2d {
+		return nil, fmt.Errorf("ds2431: unsupported family code %#02x", family)
+	}
+	return &Dev{onewire: onewire.Dev{Bus: o, Addr: addr}}, nil
+}
+
+// Dev is a handle to a DS2431 on a 1-wire bus.
+type Dev struct {
+	onewire onewire.Dev
+	mu      sync.Mutex
+}
+
+func (d *Dev) String() string {
+	return fmt.Sprintf("DS2431{%v}", d.onewire)
+}
+
+// Halt implements conn.Resource.
+func (d *Dev) Halt() error {
+	return nil
+}
+
+// ReadAt implements io.ReaderAt.
+//
+// It returns io.EOF when reading past Size.
+func (d *Dev) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("ds2431: invalid offset")
+	}
+	if off >= Size {
+		return 0, io.EOF
+	}
+	n := len(p)
+	if off+int64(n) > Size {
+		n = int(Size - off)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.readMemory(int(off), p[:n]); err != nil {
+		return 0, err
+	}
+	if n != len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// WriteAt implements io.WriterAt.
+//
+// The memory is written by rows of 8 bytes: each row is written to the
+// scratchpad, read back to be verified and then copied to the EEPROM, which
+// takes 10ms. A partial row is read first to keep the other bytes.
+//
+// It returns an error when writing past Size or when the row is write
+// protected.
+func (d *Dev) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 || off > Size {
+		return 0, errors.New("ds2431: invalid offset")
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	n := 0
+	for len(p) != 0 && off < Size {
+		var row [8]byte
+		addr := int(off) &^ 7
+		start := int(off) - addr
+		if start != 0 || len(p) < len(row) {
+			if err := d.readMemory(addr, row[:]); err != nil {
+				return n, err
+			}
+		}
+		c := copy(row[start:], p)
+		if err := d.writeRow(addr, row[:]); err != nil {
+			return n, err
+		}
+		n += c
+		off += int64(c)
+		p = p[c:]
+	}
+	if len(p) != 0 {
+		return n, errors.New("ds2431: write past the end of the memory")
+	}
+	return n, nil
+}
+
+//
+
+const (
+	cmdWriteScratchpad = 0x0f
+	cmdReadScratchpad  = 0xaa
+	cmdCopyScratchpad  = 0x55
+	cmdReadMemory      = 0xf0
+)
+
+// readMemory reads from the memory at addr.
+//
+// d.mu must be held.
+func (d *Dev) readMemory(addr int, p []byte) error {
+	return d.onewire.Tx([]byte{cmdReadMemory, byte(addr), byte(addr >> 8)}, p)
+}
+
+// writeRow writes the 8 bytes of the row at addr in the scratchpad, verifies
+// the scratchpad and then copies it to the EEPROM.
+//
+// d.mu must be held.
+func (d *Dev) writeRow(addr int, row []byte) error {
+	w := append([]byte{cmdWriteScratchpad, byte(addr), byte(addr >> 8)}, row...)
+	var crc [2]byte
+	if err := d.onewire.Tx(w, crc[:]); err != nil {
+		return err
+	}
+	if !onewire.CheckCRC16(append(w, crc[:]...)) {
+		return busError("ds2431: incorrect CRC writing the scratchpad")
+	}
+
+	// Verify the scratchpad. The ending offset is 7 and the partial flag is
+	// cleared since the whole row was written.
+	es, err := d.readScratchpad(addr, row)
+	if err != nil {
+		return err
+	}
+	if es != 0x07 {
+		return busError(fmt.Sprintf("ds2431: incomplete scratchpad write, E/S %#02x", es))
+	}
+
+	// The address and E/S are the authorization pattern. The device is
+	// parasitically powered so the strong pull-up powers the copy.
+	if err := d.onewire.TxPower([]byte{cmdCopyScratchpad, byte(addr), byte(addr >> 8), es}, nil); err != nil {
+		return err
+	}
+	sleep(10 * time.Millisecond)
+
+	// The authorization accepted flag is set once the copy is done.
+	if es, err = d.readScratchpad(addr, row); err != nil {
+		return err
+	}
+	if es&0x80 == 0 {
+		return fmt.Errorf("ds2431: failed to copy the scratchpad at %#02x, the row may be write protected", addr)
+	}
+	return nil
+}
+
+// readScratchpad reads the scratchpad, checks its CRC and that it holds row
+// for addr, and returns the E/S register.
+//
+// d.mu must be held.
+func (d *Dev) readScratchpad(addr int, row []byte) (byte, error) {
+	var r [13]byte
+	if err := d.onewire.Tx([]byte{cmdReadScratchpad}, r[:]); err != nil {
+		return 0, err
+	}
+	if !onewire.CheckCRC16(append([]byte{cmdReadScratchpad}, r[:]...)) {
+		return 0, busError("ds2431: incorrect CRC reading the scratchpad")
+	}
+	if int(r[0])|int(r[1])<<8 != addr {
+		return 0, busError(fmt.Sprintf("ds2431: scratchpad is for %#02x, expected %#02x", int(r[0])|int(r[1])<<8, addr))
+	}
+	for i := range row {
+		if r[3+i] != row[i] {
+			return 0, busError("ds2431: scratchpad verification failed")
+		}
+	}
+	return r[2], nil
+}
+
+// busError implements error and onewire.BusError.
+type busError string
+
+func (e busError) Error() string  { return string(e) }
+func (e busError) BusError() bool { return true }
+
+var sleep = time.Sleep
+
+var _ conn.Resource = &Dev{}
+var _ fmt.Stringer = &Dev{}
+var _ io.ReaderAt = &Dev{}
+var _ io.WriterAt = &Dev{}
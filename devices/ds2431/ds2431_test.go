@@ -0,0 +1,166 @@
+// Copyright 2018 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package ds2431
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"periph.io/x/periph/conn/onewire"
+	"periph.io/x/periph/conn/onewire/onewiretest"
+)
+
+func TestNew_fail(t *testing.T) {
+	if d, err := New(&onewiretest.Playback{}, 0x740000070e41ac28); d != nil || err == nil {
+		t.Fatal("invalid family")
+	}
+}
+
+func TestReadAt(t *testing.T) {
+	bus := onewiretest.Playback{
+		Ops: []onewiretest.IO{
+			{W: append(match(), 0xf0, 0x10, 0x00), R: []byte{1, 2, 3}},
+			{W: append(match(), 0xf0, 0x7c, 0x00), R: []byte{4, 5, 6, 7}},
+		},
+	}
+	d, err := New(&bus, 0x740000070e41ac2d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := d.String(); s != "DS2431{{playback 8358680938703596589}}" {
+		t.Fatal(s)
+	}
+	if err := d.Halt(); err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 8)
+	if n, err := d.ReadAt(b[:3], 16); n != 3 || err != nil || !bytes.Equal(b[:3], []byte{1, 2, 3}) {
+		t.Fatal(n, err, b)
+	}
+	if n, err := d.ReadAt(b, 124); n != 4 || err != io.EOF || !bytes.Equal(b[:4], []byte{4, 5, 6, 7}) {
+		t.Fatal(n, err, b)
+	}
+	if n, err := d.ReadAt(b, Size); n != 0 || err != io.EOF {
+		t.Fatal(n, err)
+	}
+	if _, err := d.ReadAt(b, -1); err == nil {
+		t.Fatal("invalid offset")
+	}
+	if err := bus.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWriteAt(t *testing.T) {
+	row1 := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	row2 := []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xaa, 0xbb}
+	row3 := []byte{0xcc, 0xdd, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	row4 := []byte{0xff, 0xff, 0xff, 0xff, 0x10, 0x11, 0x12, 0x13}
+	var ops []onewiretest.IO
+	ops = append(ops, writeRow(0x08, row1, 0x87)...)
+	ops = append(ops, onewiretest.IO{W: append(match(), 0xf0, 0x10, 0x00), R: []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}})
+	ops = append(ops, writeRow(0x10, row2, 0x87)...)
+	ops = append(ops, onewiretest.IO{W: append(match(), 0xf0, 0x18, 0x00), R: []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}})
+	ops = append(ops, writeRow(0x18, row3, 0x87)...)
+	ops = append(ops, onewiretest.IO{W: append(match(), 0xf0, 0x78, 0x00), R: []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}})
+	ops = append(ops, writeRow(0x78, row4, 0x87)...)
+	bus := onewiretest.Playback{Ops: ops}
+	d, err := New(&bus, 0x740000070e41ac2d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sleeps []time.Duration
+	sleep = func(d time.Duration) { sleeps = append(sleeps, d) }
+	defer func() { sleep = func(time.Duration) {} }()
+
+	// A full row.
+	if n, err := d.WriteAt(row1, 8); n != 8 || err != nil {
+		t.Fatal(n, err)
+	}
+	if !reflect.DeepEqual(sleeps, []time.Duration{10 * time.Millisecond}) {
+		t.Fatal(sleeps)
+	}
+	// Across two rows.
+	if n, err := d.WriteAt([]byte{0xaa, 0xbb, 0xcc, 0xdd}, 22); n != 4 || err != nil {
+		t.Fatal(n, err)
+	}
+	// Past the end.
+	if n, err := d.WriteAt([]byte{0x10, 0x11, 0x12, 0x13, 0x14}, 124); n != 4 || err == nil {
+		t.Fatal(n, err)
+	}
+	if _, err := d.WriteAt(row1, Size+1); err == nil {
+		t.Fatal("invalid offset")
+	}
+	if err := bus.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWriteAt_fail(t *testing.T) {
+	row := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	// Write protected.
+	ops := writeRow(0, row, 0x07)
+	// Corrupted CRC.
+	bad := writeRow(0, row, 0x87)[0]
+	bad.R[0]++
+	ops = append(ops, bad)
+	// Not verified.
+	ops = append(ops, writeRow(0, row, 0x87)[0])
+	ops = append(ops, onewiretest.IO{W: append(match(), 0xaa), R: scratchpad(0, 0x07, []byte{1, 2, 3, 4, 5, 6, 7, 0})})
+	// Partial write.
+	ops = append(ops, writeRow(0, row, 0x87)[0])
+	ops = append(ops, onewiretest.IO{W: append(match(), 0xaa), R: scratchpad(0, 0x27, row)})
+	bus := onewiretest.Playback{Ops: ops}
+	d, err := New(&bus, 0x740000070e41ac2d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		if n, err := d.WriteAt(row, 0); n != 0 || err == nil {
+			t.Fatal(i, n, err)
+		}
+	}
+	if err := bus.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+//
+
+func init() {
+	sleep = func(time.Duration) {}
+}
+
+// match returns the Match ROM command for the device 0x740000070e41ac2d.
+func match() []byte {
+	return []byte{0x55, 0x2d, 0xac, 0x41, 0x0e, 0x07, 0x00, 0x00, 0x74}
+}
+
+// writeRow returns the operations to write row at addr, the E/S register
+// being es after the copy.
+func writeRow(addr byte, row []byte, es byte) []onewiretest.IO {
+	w := append([]byte{0x0f, addr, 0}, row...)
+	return []onewiretest.IO{
+		{W: append(match(), w...), R: crc16(w)},
+		{W: append(match(), 0xaa), R: scratchpad(addr, 0x07, row)},
+		{W: append(match(), 0x55, addr, 0, 0x07), Pull: onewire.StrongPullup},
+		{W: append(match(), 0xaa), R: scratchpad(addr, es, row)},
+	}
+}
+
+// scratchpad returns the bytes read by the read scratchpad command.
+func scratchpad(addr, es byte, row []byte) []byte {
+	r := append([]byte{addr, 0, es}, row...)
+	return append(r, crc16(append([]byte{0xaa}, r...))...)
+}
+
+// crc16 returns the inverted CRC-16 of b as sent by the device.
+func crc16(b []byte) []byte {
+	c := ^onewire.CalcCRC16(b)
+	return []byte{byte(c), byte(c >> 8)}
+}
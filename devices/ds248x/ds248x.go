@@ -2,24 +2,32 @@
 // Use of this source code is governed under the Apache License, Version 2.0
 // that can be found in the LICENSE file.
 
-// Package ds248x controls a Maxim DS2483 or DS2482-100 1-wire interface chip over I²C.
+// Package ds248x controls a Maxim DS2483, DS2482-100 or DS2482-800 1-wire
+// interface chip over I²C.
+//
+// The 8 channels of the DS2482-800 are each exposed as a separate 1-wire bus
+// via Dev.Channels.
 //
 // Datasheets
 //
 // https://www.maximintegrated.com/en/products/digital/one-wire/DS2483.html
 //
 // https://www.maximintegrated.com/en/products/interface/controllers-expanders/DS2482-100.html
+//
+// https://www.maximintegrated.com/en/products/interface/controllers-expanders/DS2482-800.html
 package ds248x
 
 import (
 	"errors"
 	"fmt"
+	"strconv"
 	"sync"
 	"time"
 
 	"periph.io/x/periph/conn"
 	"periph.io/x/periph/conn/i2c"
 	"periph.io/x/periph/conn/onewire"
+	"periph.io/x/periph/conn/onewire/onewirereg"
 )
 
 // PupOhm controls the strength of the passive pull-up resistor
@@ -56,7 +64,7 @@ func New(i i2c.Bus, opts *Opts) (*Dev, error) {
 	addr := uint16(0x18)
 	if opts != nil {
 		switch opts.Addr {
-		case 0x18, 0x19, 0x20, 0x21:
+		case 0x18, 0x19, 0x1a, 0x1b, 0x1c, 0x1d, 0x1e, 0x1f, 0x20, 0x21:
 			addr = opts.Addr
 		case 0x00:
 		default:
@@ -82,20 +90,28 @@ func New(i i2c.Bus, opts *Opts) (*Dev, error) {
 // device itself (or the I²C bus used to access it). Errors on the 1-wire bus
 // do not cause persistent errors and implement the onewire.BusError interface
 // to indicate this fact.
+//
+// On a DS2482-800, Dev is the channel IO0.
 type Dev struct {
-	sync.Mutex               // lock for the bus while a transaction is in progress
-	i2c        conn.Conn     // i2c device handle for the ds248x
-	isDS2483   bool          // true: ds2483, false: ds2482-100
-	confReg    byte          // value written to configuration register
-	tReset     time.Duration // time to perform a 1-wire reset
-	tSlot      time.Duration // time to perform a 1-bit 1-wire read/write
-	err        error         // persistent error, device will no longer operate
+	sync.Mutex                // lock for the bus while a transaction is in progress
+	i2c         conn.Conn     // i2c device handle for the ds248x
+	isDS2483    bool          // true: ds2483, false: ds2482-100 or ds2482-800
+	isDS2482800 bool          // true: ds2482-800
+	channel     int           // channel selected on the ds2482-800
+	confReg     byte          // value written to configuration register
+	overdrive   bool          // the 1WS bit is set, confReg must be restored
+	tReset      time.Duration // time to perform a 1-wire reset
+	tSlot       time.Duration // time to perform a 1-bit 1-wire read/write
+	err         error         // persistent error, device will no longer operate
 }
 
 func (d *Dev) String() string {
 	if d.isDS2483 {
 		return fmt.Sprintf("DS2483{%s}", d.i2c)
 	}
+	if d.isDS2482800 {
+		return fmt.Sprintf("DS2482-800{%s}", d.i2c)
+	}
 	return fmt.Sprintf("DS2482-100{%s}", d.i2c)
 }
 
@@ -113,19 +129,185 @@ func (d *Dev) Halt() error {
 func (d *Dev) Tx(w, r []byte, power onewire.Pullup) error {
 	d.Lock()
 	defer d.Unlock()
+	d.selectChannel(0)
+	return d.tx(w, r, power)
+}
+
+// TxOverdrive implements onewire.BusOverdrive.
+//
+// The reset and the ROM command are sent at standard speed, then the 1WS bit
+// of the configuration register is set to transfer w and r at overdrive
+// speed. The standard speed is restored at the start of the next transaction.
+func (d *Dev) TxOverdrive(rom byte, w, r []byte, power onewire.Pullup) error {
+	d.Lock()
+	defer d.Unlock()
+	d.selectChannel(0)
+	return d.txOverdrive(rom, w, r, power)
+}
+
+// Search performs a "search" cycle on the 1-wire bus and returns the addresses
+// of all devices on the bus if alarmOnly is false and of all devices in alarm
+// state if alarmOnly is true.
+//
+// If an error occurs during the search the already-discovered devices are
+// returned with the error.
+func (d *Dev) Search(alarmOnly bool) ([]onewire.Address, error) {
+	return onewire.Search(d, alarmOnly)
+}
+
+// SearchTriplet performs a single bit search triplet command on the bus, waits
+// for it to complete and returs the outcome.
+//
+// SearchTriplet should not be used directly, use Search instead.
+func (d *Dev) SearchTriplet(direction byte) (onewire.TripletResult, error) {
+	d.Lock()
+	defer d.Unlock()
+	d.selectChannel(0)
+	return d.searchTriplet(direction)
+}
+
+// Channels returns the 8 channels of a DS2482-800, each being a separate
+// 1-wire bus.
+//
+// It returns nil on the DS2483 and DS2482-100, which have a single channel.
+func (d *Dev) Channels() []*Channel {
+	if !d.isDS2482800 {
+		return nil
+	}
+	out := make([]*Channel, 8)
+	for i := range out {
+		out[i] = &Channel{d: d, n: i}
+	}
+	return out
+}
+
+// RegisterChannels registers the channels of a DS2482-800 in onewirereg as
+// name followed by "/IO" and the channel number, e.g. "ds2482/IO3".
+func (d *Dev) RegisterChannels(name string) error {
+	if !d.isDS2482800 {
+		return errors.New("ds248x: only the DS2482-800 has channels")
+	}
+	for _, c := range d.Channels() {
+		c := c
+		if err := onewirereg.Register(name+"/IO"+strconv.Itoa(c.n), nil, -1, func() (onewire.BusCloser, error) { return c, nil }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Channel is one of the 8 channels of a DS2482-800.
+//
+// The channel is selected transparently before each operation.
+type Channel struct {
+	d *Dev
+	n int
+}
+
+func (c *Channel) String() string {
+	return fmt.Sprintf("%s/IO%d", c.d, c.n)
+}
+
+// Close implements onewire.BusCloser.
+//
+// It is a noop.
+func (c *Channel) Close() error {
+	return nil
+}
+
+// Number returns the channel number, between 0 and 7.
+func (c *Channel) Number() int {
+	return c.n
+}
+
+// Tx implements onewire.Bus.
+//
+// It selects the channel and then behaves like Dev.Tx.
+func (c *Channel) Tx(w, r []byte, power onewire.Pullup) error {
+	c.d.Lock()
+	defer c.d.Unlock()
+	c.d.selectChannel(c.n)
+	return c.d.tx(w, r, power)
+}
+
+// TxOverdrive implements onewire.BusOverdrive.
+//
+// It selects the channel and then behaves like Dev.TxOverdrive.
+func (c *Channel) TxOverdrive(rom byte, w, r []byte, power onewire.Pullup) error {
+	c.d.Lock()
+	defer c.d.Unlock()
+	c.d.selectChannel(c.n)
+	return c.d.txOverdrive(rom, w, r, power)
+}
+
+// Search implements onewire.Bus.
+func (c *Channel) Search(alarmOnly bool) ([]onewire.Address, error) {
+	return onewire.Search(c, alarmOnly)
+}
+
+// SearchTriplet implements onewire.BusSearcher.
+func (c *Channel) SearchTriplet(direction byte) (onewire.TripletResult, error) {
+	c.d.Lock()
+	defer c.d.Unlock()
+	c.d.selectChannel(c.n)
+	return c.d.searchTriplet(direction)
+}
+
+//
+
+func (d *Dev) tx(w, r []byte, power onewire.Pullup) error {
+	if err := d.start(); err != nil {
+		return err
+	}
+	d.transfer(w, r, power)
+	return d.err
+}
+
+func (d *Dev) txOverdrive(rom byte, w, r []byte, power onewire.Pullup) error {
+	if err := d.start(); err != nil {
+		return err
+	}
+	// The ROM command is sent at standard speed, it switches the devices to
+	// overdrive.
+	d.i2cTx([]byte{cmd1WWrite, rom}, nil)
+	d.waitIdle(7 * d.tSlot)
+	d.i2cTx([]byte{cmdWriteConfig, d.confReg&0x7f | 0x8}, nil)
+	d.overdrive = true
+	d.transfer(w, r, power)
+	return d.err
+}
 
-	// Issue 1-wire bus reset.
+// start restores the standard speed if needed and issues a 1-wire bus reset.
+//
+// The standard speed is restored lazily so that a strong pull-up activated at
+// the end of the previous transaction is not canceled early.
+func (d *Dev) start() error {
+	if d.overdrive {
+		d.i2cTx([]byte{cmdWriteConfig, d.confReg}, nil)
+		d.overdrive = false
+	}
 	if present, err := d.reset(); err != nil {
 		return err
 	} else if !present {
 		return busError("ds248x: no device present")
 	}
+	return nil
+}
+
+// transfer writes w and then reads r on the 1-wire bus. It uses the
+// persistent error model.
+func (d *Dev) transfer(w, r []byte, power onewire.Pullup) {
+	// The strong pull-up bit is cleared by the ds248x once used.
+	spu := d.confReg&0xbf | 0x4
+	if d.overdrive {
+		spu = d.confReg&0x3f | 0xc
+	}
 
 	// Send bytes onto 1-wire bus.
 	for i, b := range w {
 		if power == onewire.StrongPullup && i == len(w)-1 && len(r) == 0 {
 			// This is the last byte, need to activate strong pull-up.
-			d.i2cTx([]byte{cmdWriteConfig, d.confReg&0xbf | 0x4}, nil)
+			d.i2cTx([]byte{cmdWriteConfig, spu}, nil)
 		}
 		d.i2cTx([]byte{cmd1WWrite, b}, nil)
 		d.waitIdle(7 * d.tSlot)
@@ -135,31 +317,15 @@ func (d *Dev) Tx(w, r []byte, power onewire.Pullup) error {
 	for i := range r {
 		if power == onewire.StrongPullup && i == len(r)-1 {
 			// This is the last byte, need to activate strong-pull-up
-			d.i2cTx([]byte{cmdWriteConfig, d.confReg&0xbf | 0x4}, nil)
+			d.i2cTx([]byte{cmdWriteConfig, spu}, nil)
 		}
 		d.i2cTx([]byte{cmd1WRead}, r[i:i+1])
 		d.waitIdle(7 * d.tSlot)
 		d.i2cTx([]byte{cmdSetReadPtr, regRDR}, r[i:i+1])
 	}
-
-	return d.err
 }
 
-// Search performs a "search" cycle on the 1-wire bus and returns the addresses
-// of all devices on the bus if alarmOnly is false and of all devices in alarm
-// state if alarmOnly is true.
-//
-// If an error occurs during the search the already-discovered devices are
-// returned with the error.
-func (d *Dev) Search(alarmOnly bool) ([]onewire.Address, error) {
-	return onewire.Search(d, alarmOnly)
-}
-
-// SearchTriplet performs a single bit search triplet command on the bus, waits
-// for it to complete and returs the outcome.
-//
-// SearchTriplet should not be used directly, use Search instead.
-func (d *Dev) SearchTriplet(direction byte) (onewire.TripletResult, error) {
+func (d *Dev) searchTriplet(direction byte) (onewire.TripletResult, error) {
 	// Send one-wire triplet command.
 	var dir byte
 	if direction != 0 {
@@ -176,7 +342,25 @@ func (d *Dev) SearchTriplet(direction byte) (onewire.TripletResult, error) {
 	return tr, d.err
 }
 
-//
+// selectChannel selects the channel n on a DS2482-800 if it is not already
+// selected. It uses the persistent error model.
+func (d *Dev) selectChannel(n int) {
+	if !d.isDS2482800 || d.channel == n || d.err != nil {
+		return
+	}
+	// The read pointer is set to the channel selection register, which returns
+	// a different code than the one written.
+	var sel [1]byte
+	d.i2cTx([]byte{cmdChannelSelect, channelCodes[n]}, sel[:])
+	if d.err != nil {
+		return
+	}
+	if sel[0] != channelSelected[n] {
+		d.err = fmt.Errorf("ds248x: failure to select channel %d, got %#x", n, sel[0])
+		return
+	}
+	d.channel = n
+}
 
 // reset issues a reset signal on the 1-wire bus and returns true if any device
 // responded with a presence pulse.
@@ -298,6 +482,12 @@ func (d *Dev) makeDev(opts *Opts) error {
 	// register, such as the ds2482-100.
 	d.isDS2483 = d.i2c.Tx([]byte{cmdSetReadPtr, regPCR}, nil) == nil
 
+	// Only the ds2482-800 has a channel selection register. The channel IO0 is
+	// selected upon reset.
+	if !d.isDS2483 {
+		d.isDS2482800 = d.i2c.Tx([]byte{cmdSetReadPtr, regCSR}, nil) == nil
+	}
+
 	// Set the options for the ds2483.
 	if d.isDS2483 {
 		buf := []byte{cmdAdjPort,
@@ -334,6 +524,12 @@ var sleep = time.Sleep
 
 var _ conn.Resource = &Dev{}
 var _ fmt.Stringer = &Dev{}
+var _ onewire.BusSearcher = &Dev{}
+var _ onewire.BusOverdrive = &Dev{}
+var _ onewire.BusCloser = &Channel{}
+var _ onewire.BusOverdrive = &Channel{}
+var _ onewire.BusSearcher = &Channel{}
+var _ fmt.Stringer = &Channel{}
 
 // defaults holds default values for optional parameters.
 var defaults = Opts{
@@ -356,8 +552,18 @@ const (
 	cmd1WRead      = 0x96 // perform a byte read on the 1-wire bus
 	cmd1WTriplet   = 0x78 // perform a triplet operation (2 bit reads, a bit write)
 
+	cmdChannelSelect = 0xc3 // select the 1-wire channel of the ds2482-800
+
 	regDCR    = 0xc3 // read ptr for device configuration register
 	regStatus = 0xf0 // read ptr for status register
 	regRDR    = 0xe1 // read ptr for read-data register
 	regPCR    = 0xb4 // read ptr for port configuration register
+	regCSR    = 0xd2 // read ptr for channel selection register, ds2482-800 only
+)
+
+// channelCodes are the codes written by the channel select command of the
+// ds2482-800 and channelSelected the ones read back, datasheet p.10.
+var (
+	channelCodes    = [8]byte{0xf0, 0xe1, 0xd2, 0xc3, 0xb4, 0xa5, 0x96, 0x87}
+	channelSelected = [8]byte{0xb8, 0xb1, 0xaa, 0xa3, 0x9c, 0x95, 0x8e, 0x87}
 )
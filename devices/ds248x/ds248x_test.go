@@ -5,10 +5,14 @@
 package ds248x
 
 import (
+	"errors"
+	"strconv"
 	"testing"
 	"time"
 
 	"periph.io/x/periph/conn/i2c/i2ctest"
+	"periph.io/x/periph/conn/onewire"
+	"periph.io/x/periph/conn/onewire/onewirereg"
 )
 
 func TestNew(t *testing.T) {
@@ -55,6 +59,204 @@ func TestNew_opts(t *testing.T) {
 	}
 }
 
+func TestNew_ds2482_100(t *testing.T) {
+	bus := i2ctest.Playback{
+		Ops: []i2ctest.IO{
+			{Addr: 0x18, W: []byte{0xf0}},
+			{Addr: 0x18, W: []byte{0xe1, 0xf0}, R: []byte{0x18}},
+			{Addr: 0x18, W: []byte{0xd2, 0xe1}, R: []byte{0x1}},
+			{Addr: 0x18, W: []byte{0xe1, 0xb4}, Err: errors.New("nack")},
+			{Addr: 0x18, W: []byte{0xe1, 0xd2}, Err: errors.New("nack")},
+		},
+	}
+	d, err := New(&bus, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := d.String(); s != "DS2482-100{playback(24)}" {
+		t.Fatal(s)
+	}
+	if c := d.Channels(); c != nil {
+		t.Fatal(c)
+	}
+	if err := d.RegisterChannels("ds2482"); err == nil {
+		t.Fatal("no channels")
+	}
+	if err := bus.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDev_TxOverdrive(t *testing.T) {
+	bus := i2ctest.Playback{
+		Ops: []i2ctest.IO{
+			{Addr: 0x18, W: []byte{0xf0}},
+			{Addr: 0x18, W: []byte{0xe1, 0xf0}, R: []byte{0x18}},
+			{Addr: 0x18, W: []byte{0xd2, 0xe1}, R: []byte{0x1}},
+			{Addr: 0x18, W: []byte{0xe1, 0xb4}},
+			{Addr: 0x18, W: []byte{0xc3, 0x6, 0x26, 0x46, 0x66, 0x86}},
+			// Reset and overdrive skip ROM at standard speed.
+			{Addr: 0x18, W: []byte{0xb4}},
+			{Addr: 0x18, R: []byte{0x2}},
+			{Addr: 0x18, W: []byte{0xa5, 0x3c}},
+			{Addr: 0x18, R: []byte{0x0}},
+			// 1WS, then 1WS and SPU for the last byte.
+			{Addr: 0x18, W: []byte{0xd2, 0x69}},
+			{Addr: 0x18, W: []byte{0xd2, 0x2d}},
+			{Addr: 0x18, W: []byte{0xa5, 0x44}},
+			{Addr: 0x18, R: []byte{0x0}},
+			// Standard speed is restored before the next reset.
+			{Addr: 0x18, W: []byte{0xd2, 0xe1}},
+			{Addr: 0x18, W: []byte{0xb4}},
+			{Addr: 0x18, R: []byte{0x2}},
+			{Addr: 0x18, W: []byte{0xa5, 0xcc}},
+			{Addr: 0x18, R: []byte{0x0}},
+			// No device.
+			{Addr: 0x18, W: []byte{0xb4}},
+			{Addr: 0x18, R: []byte{0x0}},
+		},
+	}
+	d, err := New(&bus, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := onewire.OverdriveSkip(d, []byte{0x44}, nil, onewire.StrongPullup); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Tx([]byte{0xcc}, nil, onewire.WeakPullup); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.TxOverdrive(onewire.OverdriveSkipROM, nil, nil, onewire.WeakPullup); err == nil {
+		t.Fatal("no device")
+	}
+	if err := bus.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNew_ds2482_800(t *testing.T) {
+	bus := i2ctest.Playback{
+		Ops: append(initDS2482800(),
+			// Channel IO3: select, reset, write 0xcc.
+			i2ctest.IO{Addr: 0x1c, W: []byte{0xc3, 0xc3}, R: []byte{0xa3}},
+			i2ctest.IO{Addr: 0x1c, W: []byte{0xb4}},
+			i2ctest.IO{Addr: 0x1c, R: []byte{0x2}},
+			i2ctest.IO{Addr: 0x1c, W: []byte{0xa5, 0xcc}},
+			i2ctest.IO{Addr: 0x1c, R: []byte{0x0}},
+			// Channel IO3 is still selected.
+			i2ctest.IO{Addr: 0x1c, W: []byte{0xb4}},
+			i2ctest.IO{Addr: 0x1c, R: []byte{0x2}},
+			// Dev is channel IO0.
+			i2ctest.IO{Addr: 0x1c, W: []byte{0xc3, 0xf0}, R: []byte{0xb8}},
+			i2ctest.IO{Addr: 0x1c, W: []byte{0xb4}},
+			i2ctest.IO{Addr: 0x1c, R: []byte{0x2}},
+			// Triplet on channel IO7.
+			i2ctest.IO{Addr: 0x1c, W: []byte{0xc3, 0x87}, R: []byte{0x87}},
+			i2ctest.IO{Addr: 0x1c, W: []byte{0x78, 0x80}},
+			i2ctest.IO{Addr: 0x1c, R: []byte{0xa0}},
+		),
+	}
+	d, err := New(&bus, &Opts{Addr: 0x1c})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := d.String(); s != "DS2482-800{playback(28)}" {
+		t.Fatal(s)
+	}
+	c := d.Channels()
+	if len(c) != 8 || c[3].Number() != 3 {
+		t.Fatal(c)
+	}
+	if s := c[3].String(); s != "DS2482-800{playback(28)}/IO3" {
+		t.Fatal(s)
+	}
+	if err := c[3].Tx([]byte{0xcc}, nil, onewire.WeakPullup); err != nil {
+		t.Fatal(err)
+	}
+	if err := c[3].Tx(nil, nil, onewire.WeakPullup); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Tx(nil, nil, onewire.WeakPullup); err != nil {
+		t.Fatal(err)
+	}
+	r, err := c[7].SearchTriplet(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.GotZero || !r.GotOne || r.Taken != 1 {
+		t.Fatalf("%#v", r)
+	}
+	if err := c[7].Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := bus.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDS2482_800_select_fail(t *testing.T) {
+	bus := i2ctest.Playback{
+		Ops: append(initDS2482800(),
+			i2ctest.IO{Addr: 0x1c, W: []byte{0xc3, 0xe1}, R: []byte{0xb8}},
+		),
+	}
+	d, err := New(&bus, &Opts{Addr: 0x1c})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := d.Channels()
+	if err := c[1].Tx([]byte{0xcc}, nil, onewire.WeakPullup); err == nil || err.Error() != "ds248x: failure to select channel 1, got 0xb8" {
+		t.Fatal(err)
+	}
+	// The error is persistent.
+	if _, err := c[2].Search(false); err == nil {
+		t.Fatal("persistent error")
+	}
+	if err := bus.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDS2482_800_RegisterChannels(t *testing.T) {
+	bus := i2ctest.Playback{Ops: initDS2482800()}
+	d, err := New(&bus, &Opts{Addr: 0x1c})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.RegisterChannels("ds2482"); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		for i := 0; i < 8; i++ {
+			if err := onewirereg.Unregister("ds2482/IO" + strconv.Itoa(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}()
+	b, err := onewirereg.Open("ds2482/IO5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, ok := b.(*Channel); !ok || c.Number() != 5 {
+		t.Fatal(b)
+	}
+	if err := d.RegisterChannels("ds2482"); err == nil {
+		t.Fatal("already registered")
+	}
+}
+
+//
+
+func initDS2482800() []i2ctest.IO {
+	return []i2ctest.IO{
+		{Addr: 0x1c, W: []byte{0xf0}},
+		{Addr: 0x1c, W: []byte{0xe1, 0xf0}, R: []byte{0x18}},
+		{Addr: 0x1c, W: []byte{0xd2, 0xe1}, R: []byte{0x1}},
+		{Addr: 0x1c, W: []byte{0xe1, 0xb4}, Err: errors.New("nack")},
+		{Addr: 0x1c, W: []byte{0xe1, 0xd2}},
+	}
+}
+
 func init() {
 	sleep = func(time.Duration) {}
 }
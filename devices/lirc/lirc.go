@@ -6,6 +6,7 @@ package lirc
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -24,20 +25,15 @@ func New() (*Conn, error) {
 	if err != nil {
 		return nil, err
 	}
-	c := &Conn{w: w, c: make(chan ir.Message), list: map[string][]string{}}
-	// Unconditionally retrieve the list of all known keys at start.
-	if _, err := w.Write([]byte("LIST\n")); err != nil {
-		_ = w.Close()
-		return nil, err
-	}
-	go c.loop(bufio.NewReader(w))
-	return c, nil
+	return newConn(w)
 }
 
 // Conn is an open port to lirc.
 type Conn struct {
-	w net.Conn
-	c chan ir.Message
+	w      net.Conn
+	c      chan ir.Message
+	closed chan struct{}
+	once   sync.Once
 
 	mu          sync.Mutex
 	list        map[string][]string // list of remotes and associated keys
@@ -50,7 +46,10 @@ func (c *Conn) String() string {
 
 // Close closes the socket to lirc. It is not a requirement to close before
 // process termination.
+//
+// It also unblocks the reception of a message that no one is listening to.
 func (c *Conn) Close() error {
+	c.once.Do(func() { close(c.closed) })
 	return c.w.Close()
 }
 
@@ -66,6 +65,34 @@ func (c *Conn) Channel() <-chan ir.Message {
 	return c.c
 }
 
+// ChannelContext implements ir.ConnContext.
+//
+// The returned channel is closed when the device is closed or when ctx is
+// done, whichever comes first.
+func (c *Conn) ChannelContext(ctx context.Context) <-chan ir.Message {
+	in := c.c
+	out := make(chan ir.Message)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-in:
+				if !ok {
+					return
+				}
+				select {
+				case out <- msg:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
+
 // Codes returns all the known codes.
 //
 // Empty if the list was not retrieved yet.
@@ -77,6 +104,18 @@ func (c *Conn) Codes() map[string][]string {
 
 //
 
+// newConn returns a handle on the connection w to lircd.
+func newConn(w net.Conn) (*Conn, error) {
+	c := &Conn{w: w, c: make(chan ir.Message), closed: make(chan struct{}), list: map[string][]string{}}
+	// Unconditionally retrieve the list of all known keys at start.
+	if _, err := w.Write([]byte("LIST\n")); err != nil {
+		_ = w.Close()
+		return nil, err
+	}
+	go c.loop(bufio.NewReader(w))
+	return c, nil
+}
+
 func (c *Conn) loop(r *bufio.Reader) {
 	defer func() {
 		close(c.c)
@@ -95,7 +134,11 @@ func (c *Conn) loop(r *bufio.Reader) {
 				if i, err2 := strconv.Atoi(parts[1]); err2 != nil {
 					log.Printf("ir: corrupted line: %v", line)
 				} else if len(parts[2]) != 0 && len(parts[3]) != 0 {
-					c.c <- ir.Message{Key: ir.Key(parts[2]), RemoteType: parts[3], Repeat: i != 0}
+					select {
+					case c.c <- ir.Message{Key: ir.Key(parts[2]), RemoteType: parts[3], Repeat: i != 0}:
+					case <-c.closed:
+						return
+					}
 				}
 			}
 		}
@@ -264,4 +307,5 @@ func getPins() (int, int) {
 }
 
 var _ ir.Conn = &Conn{}
+var _ ir.ConnContext = &Conn{}
 var _ fmt.Stringer = &Conn{}
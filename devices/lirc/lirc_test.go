@@ -0,0 +1,90 @@
+// Copyright 2018 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package lirc
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"periph.io/x/periph/conn/ir"
+)
+
+func TestConn_ChannelContext(t *testing.T) {
+	c, lircd := newFakeLircd(t)
+	defer c.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := c.ChannelContext(ctx)
+	lircd.send(t, "000000037ff07bef 00 KEY_POWER remote\n")
+	if msg := <-ch; msg != (ir.Message{Key: "KEY_POWER", RemoteType: "remote"}) {
+		t.Fatal(msg)
+	}
+	cancel()
+	select {
+	case msg, ok := <-ch:
+		if ok {
+			t.Fatal(msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the channel must be closed when ctx is done")
+	}
+
+	// The messages still flow to the other listeners.
+	lircd.send(t, "000000037ff07bef 01 KEY_POWER remote\n")
+	if msg := <-c.Channel(); msg != (ir.Message{Key: "KEY_POWER", RemoteType: "remote", Repeat: true}) {
+		t.Fatal(msg)
+	}
+}
+
+func TestConn_ChannelContext_close(t *testing.T) {
+	c, _ := newFakeLircd(t)
+	ch := c.ChannelContext(context.Background())
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg, ok := <-ch:
+		if ok {
+			t.Fatal(msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the channel must be closed when the device is closed")
+	}
+}
+
+//
+
+// fakeLircd is the lircd end of the socket.
+type fakeLircd struct {
+	net.Conn
+}
+
+func newFakeLircd(t *testing.T) (*Conn, *fakeLircd) {
+	client, server := net.Pipe()
+	l := &fakeLircd{server}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// New() unconditionally requests the list of the keys.
+		if line, err := bufio.NewReader(server).ReadString('\n'); err != nil || line != "LIST\n" {
+			t.Errorf("%q %v", line, err)
+		}
+	}()
+	c, err := newConn(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	return c, l
+}
+
+func (l *fakeLircd) send(t *testing.T, line string) {
+	if _, err := l.Write([]byte(line)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -54,14 +54,18 @@ func mainFunc() error {
 		return fmt.Errorf("Strobe pin %s can not be found", *ePin)
 	}
 
-	var dataPins [4]gpio.PinOut
+	var dataPins [4]gpio.PinIO
 	for i, pinName := range pinsStr {
 		if dataPins[i] = gpioreg.ByName(pinName); dataPins[i] == nil {
 			return fmt.Errorf("Data pin %s can not be found", pinName)
 		}
 	}
+	dataGroup, err := gpio.NewGroup(dataPins[:]...)
+	if err != nil {
+		return err
+	}
 
-	dev, err := hd44780.New(dataPins[:], rsPinReg, ePinReg)
+	dev, err := hd44780.NewGroup(dataGroup, rsPinReg, ePinReg)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,533 @@
+// Copyright 2018 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+// Package modbus implements a Modbus master over a serial line.
+//
+// Both the RTU framing, binary with a CRC-16, and the ASCII framing,
+// hexadecimal with a LRC, are supported.
+//
+// The functions to read coils, discrete inputs, holding and input registers
+// and to write coils and holding registers are implemented.
+//
+// Specification
+//
+// http://www.modbus.org/docs/Modbus_Application_Protocol_V1_1b3.pdf
+//
+// http://www.modbus.org/docs/Modbus_over_serial_line_V1_02.pdf
+package modbus
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"periph.io/x/periph/experimental/conn/uart"
+)
+
+// Mode is the framing used on the serial line.
+type Mode int
+
+const (
+	// RTU is the binary framing, with frames delimited by silent intervals.
+	RTU Mode = 0
+	// ASCII is the hexadecimal framing, with frames delimited by ':' and CRLF.
+	ASCII Mode = 1
+)
+
+func (m Mode) String() string {
+	switch m {
+	case RTU:
+		return "RTU"
+	case ASCII:
+		return "ASCII"
+	default:
+		return "Mode(" + strconv.Itoa(int(m)) + ")"
+	}
+}
+
+// Opts are the options of a Master.
+type Opts struct {
+	// Mode is the framing; RTU by default.
+	Mode Mode
+	// Baud is the speed the port was configured at, used to compute the RTU
+	// silent intervals. It defaults to 19200.
+	Baud int
+	// Timeout is the maximum duration to wait for a response. It defaults to 1
+	// second.
+	Timeout time.Duration
+	// Retries is the number of times a request is sent again when the response
+	// timed out or was corrupted. Exceptions are not retried.
+	Retries int
+}
+
+// DefaultOpts is the recommended default options.
+var DefaultOpts = Opts{
+	Mode:    RTU,
+	Baud:    19200,
+	Timeout: time.Second,
+}
+
+// New returns a Modbus master on the serial connection c.
+//
+// c must implement uart.Stream. The port must be configured by the caller; the
+// default for Modbus is 19200 bauds and even parity.
+func New(c uart.Conn, opts *Opts) (*Master, error) {
+	s, ok := c.(uart.Stream)
+	if !ok {
+		return nil, errors.New("modbus: the connection must implement uart.Stream")
+	}
+	if opts == nil {
+		opts = &DefaultOpts
+	}
+	o := *opts
+	if o.Mode != RTU && o.Mode != ASCII {
+		return nil, fmt.Errorf("modbus: invalid mode %s", o.Mode)
+	}
+	if o.Baud < 0 || o.Timeout < 0 || o.Retries < 0 {
+		return nil, errors.New("modbus: invalid options")
+	}
+	if o.Baud == 0 {
+		o.Baud = DefaultOpts.Baud
+	}
+	if o.Timeout == 0 {
+		o.Timeout = DefaultOpts.Timeout
+	}
+	return &Master{c: c, s: s, opts: o, silence: frameDelay(o.Baud)}, nil
+}
+
+// Master is a Modbus master (client) on a serial line.
+//
+// It is safe for concurrent use; the requests are serialized.
+type Master struct {
+	c       uart.Conn
+	s       uart.Stream
+	opts    Opts
+	silence time.Duration
+
+	mu   sync.Mutex
+	last time.Time // End of the last frame on the line.
+}
+
+func (m *Master) String() string {
+	return fmt.Sprintf("modbus{%s, %s}", m.c, m.opts.Mode)
+}
+
+// ReadCoils reads n coils starting at addr on slave.
+//
+// n must be between 1 and 2000.
+func (m *Master) ReadCoils(slave byte, addr, n uint16) ([]bool, error) {
+	return m.readBits(slave, readCoils, addr, n)
+}
+
+// ReadDiscreteInputs reads n discrete inputs starting at addr on slave.
+//
+// n must be between 1 and 2000.
+func (m *Master) ReadDiscreteInputs(slave byte, addr, n uint16) ([]bool, error) {
+	return m.readBits(slave, readDiscreteInputs, addr, n)
+}
+
+// ReadHoldingRegisters reads n holding registers starting at addr on slave.
+//
+// n must be between 1 and 125.
+func (m *Master) ReadHoldingRegisters(slave byte, addr, n uint16) ([]uint16, error) {
+	return m.readRegisters(slave, readHoldingRegisters, addr, n)
+}
+
+// ReadInputRegisters reads n input registers starting at addr on slave.
+//
+// n must be between 1 and 125.
+func (m *Master) ReadInputRegisters(slave byte, addr, n uint16) ([]uint16, error) {
+	return m.readRegisters(slave, readInputRegisters, addr, n)
+}
+
+// WriteSingleCoil writes the coil at addr on slave.
+//
+// slave 0 broadcasts the request to all slaves, which do not respond.
+func (m *Master) WriteSingleCoil(slave byte, addr uint16, v bool) error {
+	var val uint16
+	if v {
+		val = 0xFF00
+	}
+	req := []byte{writeSingleCoil, byte(addr >> 8), byte(addr), byte(val >> 8), byte(val)}
+	return m.write(slave, req)
+}
+
+// WriteSingleRegister writes the holding register at addr on slave.
+//
+// slave 0 broadcasts the request to all slaves, which do not respond.
+func (m *Master) WriteSingleRegister(slave byte, addr, v uint16) error {
+	req := []byte{writeSingleRegister, byte(addr >> 8), byte(addr), byte(v >> 8), byte(v)}
+	return m.write(slave, req)
+}
+
+// WriteMultipleCoils writes the coils starting at addr on slave.
+//
+// Between 1 and 1968 coils can be written. slave 0 broadcasts the request to
+// all slaves, which do not respond.
+func (m *Master) WriteMultipleCoils(slave byte, addr uint16, v []bool) error {
+	if len(v) < 1 || len(v) > 1968 {
+		return fmt.Errorf("modbus: invalid number of coils %d", len(v))
+	}
+	n := (len(v) + 7) / 8
+	req := make([]byte, 6+n)
+	req[0] = writeMultipleCoils
+	req[1] = byte(addr >> 8)
+	req[2] = byte(addr)
+	req[3] = byte(len(v) >> 8)
+	req[4] = byte(len(v))
+	req[5] = byte(n)
+	for i, b := range v {
+		if b {
+			req[6+i/8] |= 1 << uint(i%8)
+		}
+	}
+	return m.write(slave, req)
+}
+
+// WriteMultipleRegisters writes the holding registers starting at addr on
+// slave.
+//
+// Between 1 and 123 registers can be written. slave 0 broadcasts the request
+// to all slaves, which do not respond.
+func (m *Master) WriteMultipleRegisters(slave byte, addr uint16, v []uint16) error {
+	if len(v) < 1 || len(v) > 123 {
+		return fmt.Errorf("modbus: invalid number of registers %d", len(v))
+	}
+	req := make([]byte, 6+2*len(v))
+	req[0] = writeMultipleRegisters
+	req[1] = byte(addr >> 8)
+	req[2] = byte(addr)
+	req[3] = byte(len(v) >> 8)
+	req[4] = byte(len(v))
+	req[5] = byte(2 * len(v))
+	for i, r := range v {
+		req[6+2*i] = byte(r >> 8)
+		req[7+2*i] = byte(r)
+	}
+	return m.write(slave, req)
+}
+
+// ExceptionCode is the code returned by a slave that can't process a request.
+type ExceptionCode byte
+
+// Exception codes defined in the specification.
+const (
+	IllegalFunction                    ExceptionCode = 0x01
+	IllegalDataAddress                 ExceptionCode = 0x02
+	IllegalDataValue                   ExceptionCode = 0x03
+	SlaveDeviceFailure                 ExceptionCode = 0x04
+	Acknowledge                        ExceptionCode = 0x05
+	SlaveDeviceBusy                    ExceptionCode = 0x06
+	MemoryParityError                  ExceptionCode = 0x08
+	GatewayPathUnavailable             ExceptionCode = 0x0A
+	GatewayTargetDeviceFailedToRespond ExceptionCode = 0x0B
+)
+
+func (e ExceptionCode) String() string {
+	switch e {
+	case IllegalFunction:
+		return "IllegalFunction"
+	case IllegalDataAddress:
+		return "IllegalDataAddress"
+	case IllegalDataValue:
+		return "IllegalDataValue"
+	case SlaveDeviceFailure:
+		return "SlaveDeviceFailure"
+	case Acknowledge:
+		return "Acknowledge"
+	case SlaveDeviceBusy:
+		return "SlaveDeviceBusy"
+	case MemoryParityError:
+		return "MemoryParityError"
+	case GatewayPathUnavailable:
+		return "GatewayPathUnavailable"
+	case GatewayTargetDeviceFailedToRespond:
+		return "GatewayTargetDeviceFailedToRespond"
+	default:
+		return "ExceptionCode(" + strconv.Itoa(int(e)) + ")"
+	}
+}
+
+// ExceptionError is returned when a slave responds with an exception.
+type ExceptionError struct {
+	Slave    byte
+	Function byte
+	Code     ExceptionCode
+}
+
+func (e *ExceptionError) Error() string {
+	return fmt.Sprintf("modbus: slave %d returned exception %s for function %d", e.Slave, e.Code, e.Function)
+}
+
+//
+
+// Function codes.
+const (
+	readCoils              = 0x01
+	readDiscreteInputs     = 0x02
+	readHoldingRegisters   = 0x03
+	readInputRegisters     = 0x04
+	writeSingleCoil        = 0x05
+	writeSingleRegister    = 0x06
+	writeMultipleCoils     = 0x0F
+	writeMultipleRegisters = 0x10
+)
+
+// frameDelay returns the RTU silent interval between frames, 3.5 characters
+// of 11 bits.
+//
+// Above 19200 bauds, the specification recommends a fixed 1.75ms.
+func frameDelay(baud int) time.Duration {
+	if baud > 19200 {
+		return 1750 * time.Microsecond
+	}
+	return time.Duration(int64(time.Second) * 77 / int64(2*baud))
+}
+
+// crc16 returns the Modbus CRC-16 of b; polynomial 0xA001 reflected, seeded
+// with 0xFFFF.
+func crc16(b []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, c := range b {
+		crc ^= uint16(c)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xA001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
+
+// lrc returns the longitudinal redundancy check of b used by the ASCII mode.
+func lrc(b []byte) byte {
+	var s byte
+	for _, c := range b {
+		s += c
+	}
+	return -s
+}
+
+func (m *Master) readBits(slave, fn byte, addr, n uint16) ([]bool, error) {
+	if n < 1 || n > 2000 {
+		return nil, fmt.Errorf("modbus: invalid number of bits %d", n)
+	}
+	resp, err := m.request(slave, []byte{fn, byte(addr >> 8), byte(addr), byte(n >> 8), byte(n)})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) != 2+(int(n)+7)/8 || int(resp[1]) != (int(n)+7)/8 {
+		return nil, fmt.Errorf("modbus: unexpected response % x", resp)
+	}
+	out := make([]bool, n)
+	for i := range out {
+		out[i] = resp[2+i/8]&(1<<uint(i%8)) != 0
+	}
+	return out, nil
+}
+
+func (m *Master) readRegisters(slave, fn byte, addr, n uint16) ([]uint16, error) {
+	if n < 1 || n > 125 {
+		return nil, fmt.Errorf("modbus: invalid number of registers %d", n)
+	}
+	resp, err := m.request(slave, []byte{fn, byte(addr >> 8), byte(addr), byte(n >> 8), byte(n)})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) != 2+2*int(n) || int(resp[1]) != 2*int(n) {
+		return nil, fmt.Errorf("modbus: unexpected response % x", resp)
+	}
+	out := make([]uint16, n)
+	for i := range out {
+		out[i] = uint16(resp[2+2*i])<<8 | uint16(resp[3+2*i])
+	}
+	return out, nil
+}
+
+// write sends a write request. The response is an echo of the first 5 bytes
+// of the request.
+func (m *Master) write(slave byte, req []byte) error {
+	resp, err := m.request(slave, req)
+	if err != nil || slave == 0 {
+		return err
+	}
+	if len(resp) != 5 {
+		return fmt.Errorf("modbus: unexpected response % x", resp)
+	}
+	for i := range resp {
+		if resp[i] != req[i] {
+			return fmt.Errorf("modbus: unexpected response % x", resp)
+		}
+	}
+	return nil
+}
+
+// request sends the PDU req to slave and returns the response PDU, starting
+// with the function code, retrying as requested.
+//
+// It returns nil without waiting for a response when slave is 0.
+func (m *Master) request(slave byte, req []byte) ([]byte, error) {
+	if slave > 247 {
+		return nil, fmt.Errorf("modbus: invalid slave address %d", slave)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	defer m.s.SetReadDeadline(time.Time{})
+	var err error
+	for i := 0; i <= m.opts.Retries; i++ {
+		var resp []byte
+		if resp, err = m.requestLocked(slave, req); err == nil {
+			return resp, nil
+		}
+		if _, ok := err.(*ExceptionError); ok {
+			return nil, err
+		}
+		m.drainLocked()
+	}
+	return nil, err
+}
+
+func (m *Master) requestLocked(slave byte, req []byte) ([]byte, error) {
+	var frame []byte
+	if m.opts.Mode == RTU {
+		// Wait for the line to be silent.
+		if d := m.silence - time.Since(m.last); d > 0 {
+			time.Sleep(d)
+		}
+		frame = append([]byte{slave}, req...)
+		crc := crc16(frame)
+		frame = append(frame, byte(crc), byte(crc>>8))
+	} else {
+		adu := append([]byte{slave}, req...)
+		adu = append(adu, lrc(adu))
+		frame = make([]byte, 1+2*len(adu)+2)
+		frame[0] = ':'
+		hex.Encode(frame[1:], adu)
+		for i := 1; i < len(frame)-2; i++ {
+			if frame[i] >= 'a' {
+				frame[i] -= 'a' - 'A'
+			}
+		}
+		frame[len(frame)-2] = '\r'
+		frame[len(frame)-1] = '\n'
+	}
+	if _, err := m.s.Write(frame); err != nil {
+		return nil, fmt.Errorf("modbus: %v", err)
+	}
+	// The frame takes this long to be sent on the line.
+	m.last = time.Now().Add(time.Duration(int64(len(frame)) * 11 * int64(time.Second) / int64(m.opts.Baud)))
+	if slave == 0 {
+		return nil, nil
+	}
+	if err := m.s.SetReadDeadline(m.last.Add(m.opts.Timeout)); err != nil {
+		return nil, fmt.Errorf("modbus: %v", err)
+	}
+	var adu []byte
+	var err error
+	if m.opts.Mode == RTU {
+		adu, err = m.readRTU()
+	} else {
+		adu, err = m.readASCII()
+	}
+	m.last = time.Now()
+	if err != nil {
+		return nil, err
+	}
+	if adu[0] != slave {
+		return nil, fmt.Errorf("modbus: response from slave %d instead of %d", adu[0], slave)
+	}
+	if adu[1] == req[0]|0x80 {
+		return nil, &ExceptionError{Slave: slave, Function: req[0], Code: ExceptionCode(adu[2])}
+	}
+	if adu[1] != req[0] {
+		return nil, fmt.Errorf("modbus: response for function %d instead of %d", adu[1], req[0])
+	}
+	return adu[1:], nil
+}
+
+// readRTU reads a RTU response and returns it without the CRC.
+//
+// The length of the response is derived from the function code.
+func (m *Master) readRTU() ([]byte, error) {
+	b := make([]byte, 3, 256)
+	if _, err := io.ReadFull(m.s, b); err != nil {
+		return nil, fmt.Errorf("modbus: %v", err)
+	}
+	// Number of bytes left, including the CRC.
+	n := 0
+	switch fn := b[1]; {
+	case fn&0x80 != 0:
+		n = 2
+	case fn <= readInputRegisters:
+		n = int(b[2]) + 2
+	default:
+		n = 5
+	}
+	b = b[:3+n]
+	if _, err := io.ReadFull(m.s, b[3:]); err != nil {
+		return nil, fmt.Errorf("modbus: %v", err)
+	}
+	l := len(b) - 2
+	if crc := crc16(b[:l]); b[l] != byte(crc) || b[l+1] != byte(crc>>8) {
+		return nil, errors.New("modbus: invalid CRC")
+	}
+	return b[:l], nil
+}
+
+// readASCII reads an ASCII response and returns it decoded, without the LRC.
+func (m *Master) readASCII() ([]byte, error) {
+	var line []byte
+	c := make([]byte, 1)
+	for {
+		if _, err := io.ReadFull(m.s, c); err != nil {
+			return nil, fmt.Errorf("modbus: %v", err)
+		}
+		if c[0] == ':' {
+			// Start of a frame; discard anything before.
+			line = line[:0]
+			continue
+		}
+		if c[0] == '\n' {
+			break
+		}
+		line = append(line, c[0])
+	}
+	if len(line) < 9 || line[len(line)-1] != '\r' || len(line)%2 != 1 {
+		return nil, fmt.Errorf("modbus: invalid frame %q", line)
+	}
+	adu := make([]byte, (len(line)-1)/2)
+	if _, err := hex.Decode(adu, line[:len(line)-1]); err != nil {
+		return nil, fmt.Errorf("modbus: invalid frame %q", line)
+	}
+	l := len(adu) - 1
+	if lrc(adu[:l]) != adu[l] {
+		return nil, errors.New("modbus: invalid LRC")
+	}
+	return adu[:l], nil
+}
+
+// drainLocked discards the bytes received until the line is silent, so a late
+// or corrupted response doesn't corrupt the next one.
+func (m *Master) drainLocked() {
+	b := make([]byte, 64)
+	// Give up on a line that never becomes silent.
+	for i := 0; i < 64; i++ {
+		if err := m.s.SetReadDeadline(time.Now().Add(m.silence)); err != nil {
+			return
+		}
+		if _, err := m.s.Read(b); err != nil {
+			break
+		}
+	}
+	m.last = time.Now()
+}
+
+var _ fmt.Stringer = &Master{}
+var _ error = &ExceptionError{}
@@ -0,0 +1,522 @@
+// Copyright 2018 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package modbus
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"periph.io/x/periph/conn"
+	"periph.io/x/periph/experimental/conn/uart"
+)
+
+func TestNew(t *testing.T) {
+	if _, err := New(&noStream{}, nil); err == nil {
+		t.Fatal("uart.Stream is required")
+	}
+	bad := []Opts{
+		{Mode: Mode(2)},
+		{Baud: -1},
+		{Timeout: -1},
+		{Retries: -1},
+	}
+	for i, o := range bad {
+		if _, err := New(&fakeSlave{}, &o); err == nil {
+			t.Fatalf("#%d: invalid options", i)
+		}
+	}
+	m, err := New(&fakeSlave{}, &Opts{Mode: ASCII})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.opts.Baud != 19200 || m.opts.Timeout != time.Second {
+		t.Fatalf("%#v", m.opts)
+	}
+	if s := m.String(); s != "modbus{fake, ASCII}" {
+		t.Fatal(s)
+	}
+}
+
+func TestMaster_RTU(t *testing.T) {
+	f := newFakeSlave(RTU)
+	m, err := New(f, &Opts{Baud: 9600})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range f.regs {
+		f.regs[i] = uint16(i)
+	}
+	r, err := m.ReadHoldingRegisters(1, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range r {
+		if r[i] != uint16(i) {
+			t.Fatal(r)
+		}
+	}
+	if !bytes.Equal(f.frames[0], []byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x0A, 0xC5, 0xCD}) {
+		t.Fatalf("% x", f.frames[0])
+	}
+
+	if err := m.WriteSingleRegister(1, 3, 0x1234); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.WriteMultipleRegisters(1, 4, []uint16{0xABCD, 0x5678}); err != nil {
+		t.Fatal(err)
+	}
+	if r, err = m.ReadHoldingRegisters(1, 3, 3); err != nil || r[0] != 0x1234 || r[1] != 0xABCD || r[2] != 0x5678 {
+		t.Fatal(r, err)
+	}
+	f.inputRegs[7] = 0x4242
+	if r, err = m.ReadInputRegisters(1, 7, 1); err != nil || r[0] != 0x4242 {
+		t.Fatal(r, err)
+	}
+
+	if err := m.WriteSingleCoil(1, 9, true); err != nil {
+		t.Fatal(err)
+	}
+	v := []bool{true, false, true, true, false, false, false, false, true}
+	if err := m.WriteMultipleCoils(1, 10, v); err != nil {
+		t.Fatal(err)
+	}
+	c, err := m.ReadCoils(1, 9, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c[0] || !equalBits(c[1:], v) {
+		t.Fatal(c)
+	}
+	f.inputs[1] = true
+	if c, err = m.ReadDiscreteInputs(1, 0, 3); err != nil || !equalBits(c, []bool{false, true, false}) {
+		t.Fatal(c, err)
+	}
+	if err := m.WriteSingleCoil(1, 9, false); err != nil {
+		t.Fatal(err)
+	}
+	if f.coils[9] {
+		t.Fatal("coil not cleared")
+	}
+
+	// The line is silent for 3.5 characters between frames.
+	for i := 1; i < len(f.times); i++ {
+		if d := f.times[i].Sub(f.times[i-1]); d < frameDelay(9600) {
+			t.Fatalf("#%d: %s", i, d)
+		}
+	}
+}
+
+func TestMaster_ASCII(t *testing.T) {
+	f := newFakeSlave(ASCII)
+	f.addr = 0x11
+	m, err := New(f, &Opts{Mode: ASCII})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.regs[0x6B] = 0x022B
+	f.regs[0x6D] = 0x0064
+	r, err := m.ReadHoldingRegisters(0x11, 0x6B, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r[0] != 0x022B || r[1] != 0 || r[2] != 0x0064 {
+		t.Fatal(r)
+	}
+	if s := string(f.frames[0]); s != ":1103006B00037E\r\n" {
+		t.Fatalf("%q", s)
+	}
+	if err := m.WriteMultipleCoils(0x11, 0, []bool{true, true}); err != nil {
+		t.Fatal(err)
+	}
+	if !f.coils[0] || !f.coils[1] {
+		t.Fatal("coils not set")
+	}
+	if _, err := m.ReadCoils(0x11, 0xFFFF, 1); err == nil {
+		t.Fatal("exception")
+	}
+
+	// Garbage before the frame is discarded.
+	f.prefix = "xx"
+	if _, err := m.ReadHoldingRegisters(0x11, 0, 1); err != nil {
+		t.Fatal(err)
+	}
+	f.prefix = ""
+	f.corrupt = 1
+	if _, err := m.ReadHoldingRegisters(0x11, 0, 1); err == nil || !strings.Contains(err.Error(), "LRC") {
+		t.Fatal(err)
+	}
+}
+
+func TestMaster_Exception(t *testing.T) {
+	f := newFakeSlave(RTU)
+	m, err := New(f, &Opts{Retries: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.ReadHoldingRegisters(1, 199, 2)
+	e, ok := err.(*ExceptionError)
+	if !ok || e.Slave != 1 || e.Function != 3 || e.Code != IllegalDataAddress {
+		t.Fatal(err)
+	}
+	if s := e.Error(); s != "modbus: slave 1 returned exception IllegalDataAddress for function 3" {
+		t.Fatal(s)
+	}
+	// Exceptions are not retried.
+	if len(f.frames) != 1 {
+		t.Fatal(len(f.frames))
+	}
+	f.exception = SlaveDeviceBusy
+	if err := m.WriteSingleRegister(1, 0, 1); err.(*ExceptionError).Code != SlaveDeviceBusy {
+		t.Fatal(err)
+	}
+}
+
+func TestMaster_Retries(t *testing.T) {
+	f := newFakeSlave(RTU)
+	m, err := New(f, &Opts{Baud: 115200, Retries: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.silent = 1
+	f.corrupt = 1
+	if _, err := m.ReadCoils(1, 0, 8); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.frames) != 3 {
+		t.Fatal(len(f.frames))
+	}
+	f.silent = 3
+	if _, err = m.ReadCoils(1, 0, 8); err == nil || !strings.Contains(err.Error(), "timeout") {
+		t.Fatal(err)
+	}
+	if len(f.frames) != 6 {
+		t.Fatal(len(f.frames))
+	}
+
+	m.opts.Retries = 0
+	f.corrupt = 1
+	if _, err := m.ReadCoils(1, 0, 8); err == nil || !strings.Contains(err.Error(), "CRC") {
+		t.Fatal(err)
+	}
+	f.other = true
+	if _, err := m.ReadCoils(1, 0, 8); err == nil {
+		t.Fatal("response from another slave")
+	}
+}
+
+func TestMaster_Broadcast(t *testing.T) {
+	f := newFakeSlave(RTU)
+	m, err := New(f, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.WriteSingleRegister(0, 1, 2); err != nil {
+		t.Fatal(err)
+	}
+	if f.regs[1] != 2 || f.out.Len() != 0 {
+		t.Fatal("broadcast")
+	}
+}
+
+func TestMaster_invalid(t *testing.T) {
+	m, err := New(newFakeSlave(RTU), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.ReadCoils(1, 0, 0); err == nil {
+		t.Fatal("0 coil")
+	}
+	if _, err := m.ReadDiscreteInputs(1, 0, 2001); err == nil {
+		t.Fatal("too many inputs")
+	}
+	if _, err := m.ReadHoldingRegisters(1, 0, 126); err == nil {
+		t.Fatal("too many registers")
+	}
+	if _, err := m.ReadInputRegisters(1, 0, 0); err == nil {
+		t.Fatal("0 register")
+	}
+	if err := m.WriteMultipleCoils(1, 0, nil); err == nil {
+		t.Fatal("0 coil")
+	}
+	if err := m.WriteMultipleRegisters(1, 0, make([]uint16, 124)); err == nil {
+		t.Fatal("too many registers")
+	}
+	if err := m.WriteSingleCoil(248, 0, true); err == nil {
+		t.Fatal("invalid slave")
+	}
+}
+
+func TestFrameDelay(t *testing.T) {
+	data := []struct {
+		baud     int
+		expected time.Duration
+	}{
+		{9600, 4010416 * time.Nanosecond},
+		{19200, 2005208 * time.Nanosecond},
+		{115200, 1750 * time.Microsecond},
+	}
+	for i, line := range data {
+		if d := frameDelay(line.baud); d != line.expected {
+			t.Fatalf("#%d: %s", i, d)
+		}
+	}
+}
+
+func TestCRC16(t *testing.T) {
+	if c := crc16([]byte("123456789")); c != 0x4B37 {
+		t.Fatalf("0x%04x", c)
+	}
+}
+
+func TestLRC(t *testing.T) {
+	if c := lrc([]byte{0x11, 0x03, 0x00, 0x6B, 0x00, 0x03}); c != 0x7E {
+		t.Fatalf("0x%02x", c)
+	}
+}
+
+func TestStrings(t *testing.T) {
+	if s := Mode(3).String(); s != "Mode(3)" {
+		t.Fatal(s)
+	}
+	if s := RTU.String(); s != "RTU" {
+		t.Fatal(s)
+	}
+	for c := ExceptionCode(0); c < 12; c++ {
+		if c.String() == "" {
+			t.Fatal(c)
+		}
+	}
+	if s := ExceptionCode(7).String(); s != "ExceptionCode(7)" {
+		t.Fatal(s)
+	}
+}
+
+//
+
+func equalBits(a, b []bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// noStream implements uart.Conn but not uart.Stream.
+type noStream struct{}
+
+func (n *noStream) String() string                                       { return "noStream" }
+func (n *noStream) Tx(w, r []byte) error                                 { return nil }
+func (n *noStream) Duplex() conn.Duplex                                  { return conn.Full }
+func (n *noStream) Speed(baud int) error                                 { return nil }
+func (n *noStream) Configure(s uart.Stop, p uart.Parity, bits int) error { return nil }
+
+// fakeSlave is an in-memory Modbus slave.
+//
+// Each Write is a request frame and the response is returned by Read. Read
+// times out immediately when there's no response.
+type fakeSlave struct {
+	mode      Mode
+	addr      byte
+	coils     [100]bool
+	inputs    [100]bool
+	regs      [200]uint16
+	inputRegs [100]uint16
+
+	exception ExceptionCode // Returned for the next request.
+	silent    int           // Number of requests to ignore.
+	corrupt   int           // Number of responses to corrupt.
+	other     bool          // Responds as another slave.
+	prefix    string        // Sent before the response.
+
+	frames [][]byte    // Requests received.
+	times  []time.Time // When the requests were received.
+	out    bytes.Buffer
+}
+
+func newFakeSlave(mode Mode) *fakeSlave {
+	return &fakeSlave{mode: mode, addr: 1}
+}
+
+func (f *fakeSlave) String() string {
+	return "fake"
+}
+
+func (f *fakeSlave) Tx(w, r []byte) error {
+	return errors.New("not implemented")
+}
+
+func (f *fakeSlave) Duplex() conn.Duplex {
+	return conn.Half
+}
+
+func (f *fakeSlave) Speed(baud int) error {
+	return nil
+}
+
+func (f *fakeSlave) Configure(s uart.Stop, p uart.Parity, bits int) error {
+	return nil
+}
+
+func (f *fakeSlave) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+func (f *fakeSlave) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+func (f *fakeSlave) Read(b []byte) (int, error) {
+	if f.out.Len() == 0 {
+		return 0, &timeoutError{}
+	}
+	return f.out.Read(b)
+}
+
+func (f *fakeSlave) Write(b []byte) (int, error) {
+	f.frames = append(f.frames, append([]byte{}, b...))
+	f.times = append(f.times, time.Now())
+	var adu []byte
+	if f.mode == RTU {
+		adu = b[:len(b)-2]
+	} else {
+		adu = make([]byte, (len(b)-3)/2)
+		if _, err := hex.Decode(adu, b[1:len(b)-2]); err != nil {
+			return 0, err
+		}
+		adu = adu[:len(adu)-1]
+	}
+	if f.silent > 0 {
+		f.silent--
+		return len(b), nil
+	}
+	if adu[0] != f.addr && adu[0] != 0 {
+		return len(b), nil
+	}
+	resp := f.handle(adu[1:])
+	if adu[0] == 0 {
+		return len(b), nil
+	}
+	addr := f.addr
+	if f.other {
+		addr++
+	}
+	resp = append([]byte{addr}, resp...)
+	f.out.WriteString(f.prefix)
+	if f.mode == RTU {
+		crc := crc16(resp)
+		resp = append(resp, byte(crc), byte(crc>>8))
+		if f.corrupt > 0 {
+			f.corrupt--
+			resp[len(resp)-1]++
+		}
+		f.out.Write(resp)
+	} else {
+		resp = append(resp, lrc(resp))
+		if f.corrupt > 0 {
+			f.corrupt--
+			resp[len(resp)-1]++
+		}
+		f.out.WriteString(":" + strings.ToUpper(hex.EncodeToString(resp)) + "\r\n")
+	}
+	return len(b), nil
+}
+
+// handle processes a request PDU and returns the response PDU.
+func (f *fakeSlave) handle(req []byte) []byte {
+	fn := req[0]
+	if f.exception != 0 {
+		e := f.exception
+		f.exception = 0
+		return []byte{fn | 0x80, byte(e)}
+	}
+	addr := int(req[1])<<8 | int(req[2])
+	v := int(req[3])<<8 | int(req[4])
+	exception := func(e ExceptionCode) []byte {
+		return []byte{fn | 0x80, byte(e)}
+	}
+	switch fn {
+	case readCoils, readDiscreteInputs:
+		bits := f.coils[:]
+		if fn == readDiscreteInputs {
+			bits = f.inputs[:]
+		}
+		if addr+v > len(bits) {
+			return exception(IllegalDataAddress)
+		}
+		resp := make([]byte, 2+(v+7)/8)
+		resp[0] = fn
+		resp[1] = byte((v + 7) / 8)
+		for i := 0; i < v; i++ {
+			if bits[addr+i] {
+				resp[2+i/8] |= 1 << uint(i%8)
+			}
+		}
+		return resp
+	case readHoldingRegisters, readInputRegisters:
+		regs := f.regs[:]
+		if fn == readInputRegisters {
+			regs = f.inputRegs[:]
+		}
+		if addr+v > len(regs) {
+			return exception(IllegalDataAddress)
+		}
+		resp := []byte{fn, byte(2 * v)}
+		for i := 0; i < v; i++ {
+			resp = append(resp, byte(regs[addr+i]>>8), byte(regs[addr+i]))
+		}
+		return resp
+	case writeSingleCoil:
+		if addr >= len(f.coils) {
+			return exception(IllegalDataAddress)
+		}
+		f.coils[addr] = v == 0xFF00
+		return req[:5]
+	case writeSingleRegister:
+		if addr >= len(f.regs) {
+			return exception(IllegalDataAddress)
+		}
+		f.regs[addr] = uint16(v)
+		return req[:5]
+	case writeMultipleCoils:
+		if addr+v > len(f.coils) {
+			return exception(IllegalDataAddress)
+		}
+		for i := 0; i < v; i++ {
+			f.coils[addr+i] = req[6+i/8]&(1<<uint(i%8)) != 0
+		}
+		return req[:5]
+	case writeMultipleRegisters:
+		if addr+v > len(f.regs) {
+			return exception(IllegalDataAddress)
+		}
+		for i := 0; i < v; i++ {
+			f.regs[addr+i] = uint16(req[6+2*i])<<8 | uint16(req[7+2*i])
+		}
+		return req[:5]
+	default:
+		return exception(IllegalFunction)
+	}
+}
+
+type timeoutError struct{}
+
+func (t *timeoutError) Error() string {
+	return "timeout"
+}
+
+func (t *timeoutError) Timeout() bool {
+	return true
+}
+
+var _ uart.Conn = &fakeSlave{}
+var _ uart.Stream = &fakeSlave{}
@@ -7,6 +7,9 @@ package uart
 
 import (
 	"io"
+	"strconv"
+	"strings"
+	"time"
 
 	"periph.io/x/periph/conn"
 	"periph.io/x/periph/conn/gpio"
@@ -40,9 +43,72 @@ const (
 	Two Stop = 2
 )
 
+// Flow determines the flow control.
+type Flow int
+
+const (
+	// NoFlow means no flow control.
+	NoFlow Flow = 0
+	// RTSCTS means hardware flow control with the RTS and CTS lines.
+	RTSCTS Flow = 1
+	// XOnXOff means software flow control with the XON (0x11) and XOFF (0x13)
+	// characters.
+	XOnXOff Flow = 2
+)
+
+func (f Flow) String() string {
+	switch f {
+	case NoFlow:
+		return "NoFlow"
+	case RTSCTS:
+		return "RTSCTS"
+	case XOnXOff:
+		return "XOnXOff"
+	default:
+		return "Flow(" + strconv.Itoa(int(f)) + ")"
+	}
+}
+
+// ModemLine is a bitmask of modem control lines.
+type ModemLine uint16
+
+const (
+	// DTR is Data Terminal Ready, an output.
+	DTR ModemLine = 1 << iota
+	// RTS is Request To Send, an output.
+	RTS
+	// CTS is Clear To Send, an input.
+	CTS
+	// DCD is Data Carrier Detect, an input.
+	DCD
+	// DSR is Data Set Ready, an input.
+	DSR
+	// RI is Ring Indicator, an input.
+	RI
+)
+
+func (m ModemLine) String() string {
+	if m == 0 {
+		return "0"
+	}
+	var out []string
+	for i, n := range []string{"DTR", "RTS", "CTS", "DCD", "DSR", "RI"} {
+		if m&(1<<uint(i)) != 0 {
+			out = append(out, n)
+			m &^= 1 << uint(i)
+		}
+	}
+	if m != 0 {
+		out = append(out, "0x"+strconv.FormatUint(uint64(m), 16))
+	}
+	return strings.Join(out, "|")
+}
+
 // Conn defines the interface a concrete UART driver must implement.
 //
 // It implements conn.Conn.
+//
+// Tx() writes w then waits until len(r) bytes are read.
 type Conn interface {
 	conn.Conn
 	// Speed changes the bus speed.
@@ -54,6 +120,78 @@ type Conn interface {
 	Configure(stopBit Stop, parity Parity, bits int) error
 }
 
+// Stream is optionally implemented by a Conn that can be used as a byte
+// stream, independently of Tx().
+//
+// Read returns as soon as at least one byte is available.
+type Stream interface {
+	io.Reader
+	io.Writer
+	// SetReadDeadline sets the deadline for the pending and future Read calls.
+	// A Read past the deadline fails with an error with a Timeout() method
+	// returning true. The zero value means no deadline.
+	SetReadDeadline(t time.Time) error
+	// SetWriteDeadline is the equivalent of SetReadDeadline for Write.
+	SetWriteDeadline(t time.Time) error
+}
+
+// FlowController is optionally implemented by a Conn that supports flow
+// control.
+type FlowController interface {
+	// SetFlow changes the flow control.
+	SetFlow(f Flow) error
+}
+
+// Breaker is optionally implemented by a Conn that can send a break
+// condition.
+type Breaker interface {
+	// SendBreak holds TX low for the duration d.
+	SendBreak(d time.Duration) error
+}
+
+// Modem is optionally implemented by a Conn that exposes the modem control
+// lines.
+type Modem interface {
+	// ModemLines returns the current state of the modem control lines.
+	ModemLines() (ModemLine, error)
+	// SetModemLines sets the output lines DTR and RTS to the state in l. The
+	// other bits are ignored.
+	//
+	// RTS is controlled by the driver when RTSCTS flow control is used.
+	SetModemLines(l ModemLine) error
+}
+
+// RS485 is the configuration of the RS-485 half-duplex mode, where the
+// transceiver driver is enabled only while transmitting.
+type RS485 struct {
+	// DE is the pin connected to the driver enable input of the transceiver.
+	//
+	// When nil, the UART driver toggles its own line, generally RTS, if it
+	// supports it.
+	DE gpio.PinOut
+	// ActiveLow means the driver is enabled when DE is low.
+	ActiveLow bool
+	// DelayBeforeSend is the delay between enabling the driver and sending the
+	// first bit.
+	DelayBeforeSend time.Duration
+	// DelayAfterSend is the delay between the last bit sent and disabling the
+	// driver.
+	DelayAfterSend time.Duration
+	// RxDuringTx keeps the receiver enabled while transmitting, so the bytes
+	// sent are also received. It is only supported when DE is nil.
+	RxDuringTx bool
+}
+
+// RS485Conn is optionally implemented by a Conn that supports the RS-485
+// half-duplex mode.
+type RS485Conn interface {
+	// SetRS485 enables the RS-485 mode with the configuration c, or disables it
+	// when c is nil.
+	//
+	// While enabled, Duplex() returns conn.Half.
+	SetRS485(c *RS485) error
+}
+
 // ConnCloser is a connection that can be closed.
 type ConnCloser interface {
 	io.Closer
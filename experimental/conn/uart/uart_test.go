@@ -0,0 +1,41 @@
+// Copyright 2018 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package uart
+
+import "testing"
+
+func TestFlow_String(t *testing.T) {
+	data := []struct {
+		f        Flow
+		expected string
+	}{
+		{NoFlow, "NoFlow"},
+		{RTSCTS, "RTSCTS"},
+		{XOnXOff, "XOnXOff"},
+		{Flow(10), "Flow(10)"},
+	}
+	for i, line := range data {
+		if s := line.f.String(); s != line.expected {
+			t.Fatalf("#%d: %q", i, s)
+		}
+	}
+}
+
+func TestModemLine_String(t *testing.T) {
+	data := []struct {
+		m        ModemLine
+		expected string
+	}{
+		{0, "0"},
+		{DTR, "DTR"},
+		{DTR | RTS | CTS | DCD | DSR | RI, "DTR|RTS|CTS|DCD|DSR|RI"},
+		{CTS | 0x100, "CTS|0x100"},
+	}
+	for i, line := range data {
+		if s := line.m.String(); s != line.expected {
+			t.Fatalf("#%d: %q", i, s)
+		}
+	}
+}
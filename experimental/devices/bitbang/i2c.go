@@ -25,9 +25,6 @@ const SkipAddr uint16 = 0xFFFF
 
 // New returns an object that communicates I²C over two pins.
 //
-// BUG(maruel): It is close to working but not yet, the signal is incorrect
-// during ACK.
-//
 // It has two special features:
 // - Special address SkipAddr can be used to skip the address from being
 //   communicated
@@ -52,6 +49,7 @@ func New(clk gpio.PinIO, data gpio.PinIO, speedHz int) (*I2C, error) {
 		scl:       clk,
 		sda:       data,
 		halfCycle: time.Second / time.Duration(speedHz) / time.Duration(2),
+		timeout:   defaultTimeout,
 	}
 	return i, nil
 }
@@ -62,6 +60,7 @@ type I2C struct {
 	scl       gpio.PinIO // Clock line
 	sda       gpio.PinIO // Data line
 	halfCycle time.Duration
+	timeout   time.Duration
 }
 
 func (i *I2C) String() string {
@@ -75,48 +74,83 @@ func (i *I2C) Close() error {
 
 // Tx implements i2c.Bus.
 func (i *I2C) Tx(addr uint16, w, r []byte) error {
+	var flags i2c.MsgFlags
+	if addr == SkipAddr {
+		flags = i2c.NoStart
+	}
+	var buf [2]i2c.Msg
+	msgs := buf[:0]
+	if len(w) != 0 || len(r) == 0 {
+		msgs = append(msgs, i2c.Msg{Addr: addr, Flags: flags, Buf: w})
+	}
+	if len(r) != 0 {
+		msgs = append(msgs, i2c.Msg{Addr: addr, Flags: flags | i2c.Read, Buf: r})
+	}
+	return i.TxMsgs(msgs)
+}
+
+// TxMsgs implements i2c.MsgBus.
+//
+// NoStart on the first message skips the address, like SkipAddr.
+func (i *I2C) TxMsgs(msgs []i2c.Msg) error {
+	for _, m := range msgs {
+		if m.Flags&i2c.NoStart == 0 {
+			if m.Addr >= 0x400 || (m.Addr >= 0x80 && m.Flags&i2c.TenBit == 0) {
+				return errors.New("bitbang-i2c: invalid address")
+			}
+		}
+		if m.Flags&i2c.RecvLen != 0 && (m.Flags&i2c.Read == 0 || len(m.Buf) < 33) {
+			return errors.New("bitbang-i2c: RecvLen requires a read of at least 33 bytes")
+		}
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
 	i.mu.Lock()
 	defer i.mu.Unlock()
 	runtime.LockOSThread()
 	defer runtime.UnlockOSThread()
 	//syscall.Setpriority(which, who, prio)
 
-	i.start()
-	defer i.stop()
-	if addr != SkipAddr {
-		if addr > 0xFF {
-			// Page 15, section 3.1.11 10-bit addressing
-			// TODO(maruel): Implement if desired; prefix 0b11110xx.
-			return errors.New("bitbang-i2c: invalid address")
-		}
-		// Page 13, section 3.1.10 The slave address and R/W bit
-		addr <<= 1
-		if len(r) == 0 {
-			addr |= 1
-		}
-		ack, err := i.writeByte(byte(addr))
-		if err != nil {
-			return err
-		}
-		if !ack {
-			return errors.New("bitbang-i2c: got NACK")
-		}
+	if err := i.start(); err != nil {
+		return err
 	}
-	for _, b := range w {
-		ack, err := i.writeByte(b)
-		if err != nil {
-			return err
+	defer i.stop()
+	for j := range msgs {
+		m := &msgs[j]
+		if m.Flags&i2c.NoStart == 0 {
+			if j != 0 {
+				i.restart()
+			}
+			if err := i.writeAddr(m); err != nil {
+				return err
+			}
 		}
-		if !ack {
-			return errors.New("bitbang-i2c: got NACK")
+		if m.Flags&i2c.Read == 0 {
+			for _, b := range m.Buf {
+				if err := i.write(b, m.Flags); err != nil {
+					return err
+				}
+			}
+			continue
 		}
-	}
-	for x := range r {
-		var err error
-		r[x], err = i.readByte()
-		if err != nil {
-			return err
+		// The last byte read before a repeated START or a STOP is not
+		// acknowledged.
+		last := j == len(msgs)-1 || msgs[j+1].Flags&i2c.NoStart == 0
+		n := len(m.Buf)
+		for x := 0; x < n; x++ {
+			var err error
+			if m.Buf[x], err = i.readByte(x != n-1 || !last); err != nil {
+				return err
+			}
+			if x == 0 && m.Flags&i2c.RecvLen != 0 {
+				if int(m.Buf[0]) > len(m.Buf)-1 {
+					return fmt.Errorf("bitbang-i2c: device sent invalid length %d", m.Buf[0])
+				}
+				n = 1 + int(m.Buf[0])
+			}
 		}
+		m.Buf = m.Buf[:n]
 	}
 	return nil
 }
@@ -129,6 +163,20 @@ func (i *I2C) SetSpeed(hz int64) error {
 	return nil
 }
 
+// SetTimeout implements i2c.BusTimeout.
+//
+// It bounds how long a device may stretch the clock, like the linux
+// i2c-algo-bit driver. It defaults to 35ms, the SMBus timeout.
+func (i *I2C) SetTimeout(d time.Duration) error {
+	if d <= 0 {
+		return fmt.Errorf("bitbang-i2c: invalid timeout %s", d)
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.timeout = d
+	return nil
+}
+
 // SCL implements i2c.Pins.
 func (i *I2C) SCL() gpio.PinIO {
 	return i.scl
@@ -144,12 +192,40 @@ func (i *I2C) SDA() gpio.PinIO {
 // "When CLK is a high level and DIO changes from high to low level, data input
 // starts."
 //
+// Fails if a device holds SDA low, since the bus is then busy.
+//
 // Ends with SDA and SCL low.
 //
 // Lasts 1/2 cycle.
-func (i *I2C) start() {
+func (i *I2C) start() error {
 	// Page 9, section 3.1.4 START and STOP conditions
-	// In multi-master mode, it would have to sense SDA first and after the sleep.
+	// In multi-master mode, it would have to sense SDA after the sleep too.
+	if err := i.sda.In(gpio.PullUp, gpio.NoEdge); err != nil {
+		return err
+	}
+	if i.sda.Read() == gpio.Low {
+		_ = i.sda.Out(gpio.High)
+		return busBusyError("bitbang-i2c: SDA is held low")
+	}
+	_ = i.sda.Out(gpio.Low)
+	i.sleepHalfCycle()
+	_ = i.scl.Out(gpio.Low)
+	return nil
+}
+
+// restart sends a repeated START.
+//
+// Expects SCL low.
+//
+// Ends with SDA and SCL low.
+//
+// Lasts 3/2 cycle.
+func (i *I2C) restart() {
+	// Page 9, section 3.1.4 START and STOP conditions
+	_ = i.sda.Out(gpio.High)
+	i.sleepHalfCycle()
+	_ = i.scl.Out(gpio.High)
+	i.sleepHalfCycle()
 	_ = i.sda.Out(gpio.Low)
 	i.sleepHalfCycle()
 	_ = i.scl.Out(gpio.Low)
@@ -158,10 +234,13 @@ func (i *I2C) start() {
 // "When CLK is a high level and DIO changes from low level to high level, data
 // input ends."
 //
+// SDA is driven low while SCL is low first, since it may be high after a NACK.
+//
 // Lasts 3/2 cycle.
 func (i *I2C) stop() {
 	// Page 9, section 3.1.4 START and STOP conditions
 	_ = i.scl.Out(gpio.Low)
+	_ = i.sda.Out(gpio.Low)
 	i.sleepHalfCycle()
 	_ = i.scl.Out(gpio.High)
 	i.sleepHalfCycle()
@@ -195,17 +274,22 @@ func (i *I2C) writeByte(b byte) (bool, error) {
 	// Page 10, section 3.1.6 ACK and NACK
 	// 9th clock is ACK.
 	i.sleepHalfCycle()
-	// SCL was already set as pull-up. PullNoChange
-	if err := i.scl.In(gpio.PullUp, gpio.NoEdge); err != nil {
-		return false, err
-	}
+	// SDA is released before the clock pulse so the device can drive it.
 	// SDA was already set as pull-up.
 	if err := i.sda.In(gpio.PullUp, gpio.NoEdge); err != nil {
 		return false, err
 	}
+	// SCL was already set as pull-up. PullNoChange
+	if err := i.scl.In(gpio.PullUp, gpio.NoEdge); err != nil {
+		return false, err
+	}
 	// Implement clock stretching, the device may keep the line low.
-	for i.scl.Read() == gpio.Low {
-		i.sleepHalfCycle()
+	for start := time.Now(); i.scl.Read() == gpio.Low; i.sleepHalfCycle() {
+		if time.Since(start) > i.timeout {
+			_ = i.scl.Out(gpio.Low)
+			_ = i.sda.Out(gpio.Low)
+			return false, timeoutError("bitbang-i2c: SCL held low for more than " + i.timeout.String())
+		}
 	}
 	// ACK == Low.
 	ack := i.sda.Read() == gpio.Low
@@ -218,14 +302,14 @@ func (i *I2C) writeByte(b byte) (bool, error) {
 	return ack, nil
 }
 
-// readByte reads 8 bits and an ACK.
+// readByte reads 8 bits and sends an ACK, or a NACK if ack is false.
 //
-// Expects SDA and SCL low.
+// Expects SCL low.
 //
-// Ends with SDA low and SCL high.
+// Ends with SCL low.
 //
 // Lasts 9 cycles.
-func (i *I2C) readByte() (byte, error) {
+func (i *I2C) readByte(ack bool) (byte, error) {
 	var b byte
 	if err := i.sda.In(gpio.PullUp, gpio.NoEdge); err != nil {
 		return b, err
@@ -240,19 +324,103 @@ func (i *I2C) readByte() (byte, error) {
 		}
 		_ = i.scl.Out(gpio.Low)
 	}
-	if err := i.sda.Out(gpio.Low); err != nil {
+	// Page 10, section 3.1.6 ACK and NACK
+	// ACK == Low.
+	if err := i.sda.Out(gpio.Level(!ack)); err != nil {
 		return 0, err
 	}
 	i.sleepHalfCycle()
 	_ = i.scl.Out(gpio.High)
 	i.sleepHalfCycle()
+	_ = i.scl.Out(gpio.Low)
 	return b, nil
 }
 
+// writeAddr sends the address of m with the R/W bit.
+func (i *I2C) writeAddr(m *i2c.Msg) error {
+	var rw byte
+	if m.Flags&i2c.Read != 0 {
+		rw = 1
+	}
+	if m.Flags&i2c.TenBit == 0 {
+		// Page 13, section 3.1.10 The slave address and R/W bit
+		return i.write(byte(m.Addr<<1)|rw, m.Flags)
+	}
+	// Page 15, section 3.1.11 10-bit addressing
+	hi := 0xF0 | byte(m.Addr>>7)&6
+	if err := i.write(hi, m.Flags); err != nil {
+		return err
+	}
+	if err := i.write(byte(m.Addr), m.Flags); err != nil {
+		return err
+	}
+	if rw == 0 {
+		return nil
+	}
+	// A read is addressed with a repeated START followed by the first byte
+	// of the address with the R/W bit set.
+	i.restart()
+	return i.write(hi|1, m.Flags)
+}
+
+// write writes a byte and checks for the ACK, unless IgnoreNAK is set.
+func (i *I2C) write(b byte, flags i2c.MsgFlags) error {
+	ack, err := i.writeByte(b)
+	if err != nil {
+		return err
+	}
+	if !ack && flags&i2c.IgnoreNAK == 0 {
+		return nackError("bitbang-i2c: got NACK")
+	}
+	return nil
+}
+
+// defaultTimeout is the longest a device may stretch the clock by default.
+const defaultTimeout = 35 * time.Millisecond
+
+// nackError implements i2c.NACKError.
+type nackError string
+
+func (e nackError) Error() string {
+	return string(e)
+}
+
+func (e nackError) NACK() bool {
+	return true
+}
+
+// timeoutError implements i2c.TimeoutError.
+type timeoutError string
+
+func (e timeoutError) Error() string {
+	return string(e)
+}
+
+func (e timeoutError) Timeout() bool {
+	return true
+}
+
+// busBusyError implements i2c.BusBusyError.
+type busBusyError string
+
+func (e busBusyError) Error() string {
+	return string(e)
+}
+
+func (e busBusyError) BusBusy() bool {
+	return true
+}
+
 // sleep does a busy loop to act as fast as possible.
 func (i *I2C) sleepHalfCycle() {
 	cpu.Nanospin(i.halfCycle)
 }
 
 var _ i2c.Bus = &I2C{}
+var _ i2c.MsgBus = &I2C{}
+var _ i2c.BusTimeout = &I2C{}
+var _ i2c.Pins = &I2C{}
 var _ fmt.Stringer = &I2C{}
+var _ i2c.NACKError = nackError("")
+var _ i2c.TimeoutError = timeoutError("")
+var _ i2c.BusBusyError = busBusyError("")
@@ -0,0 +1,339 @@
+// Copyright 2018 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package bitbang
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"periph.io/x/periph/conn/gpio"
+	"periph.io/x/periph/conn/gpio/gpiotest"
+	"periph.io/x/periph/conn/i2c"
+)
+
+func TestI2C_Tx(t *testing.T) {
+	b, d := newFakeI2C(t, 0x50)
+	d.tx = []byte{0xaa, 0xbb}
+	r := make([]byte, 2)
+	if err := b.Tx(0x50, []byte{0x01}, r); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(r, []byte{0xaa, 0xbb}) {
+		t.Fatalf("%#x", r)
+	}
+	// The last byte is not acknowledged and the bus is released with a STOP
+	// even though SDA is high after the NACK.
+	d.check(t, "S", "W 0xa0 A", "W 0x01 A", "Sr", "W 0xa1 A", "R 0xaa A", "R 0xbb N", "P")
+
+	// The next transaction starts with a START, not a repeated START.
+	if err := b.Tx(0x50, []byte{0x02, 0x03}, nil); err != nil {
+		t.Fatal(err)
+	}
+	d.check(t, "S", "W 0xa0 A", "W 0x02 A", "W 0x03 A", "P")
+
+	// Read only.
+	d.tx = []byte{0xcc}
+	if err := b.Tx(0x50, nil, r[:1]); err != nil || r[0] != 0xcc {
+		t.Fatal(r, err)
+	}
+	d.check(t, "S", "W 0xa1 A", "R 0xcc N", "P")
+}
+
+func TestI2C_Tx_NACK(t *testing.T) {
+	b, d := newFakeI2C(t, 0x50)
+	if err := b.Tx(0x51, []byte{0x01}, nil); err == nil {
+		t.Fatal("address NACK")
+	}
+	d.check(t, "S", "W 0xa2 N", "P")
+
+	d.nackData = true
+	if err := b.Tx(0x50, []byte{0x01, 0x02}, nil); err == nil {
+		t.Fatal("data NACK")
+	}
+	d.check(t, "S", "W 0xa0 A", "W 0x01 N", "P")
+
+	// IgnoreNAK.
+	msgs := []i2c.Msg{{Addr: 0x50, Flags: i2c.IgnoreNAK, Buf: []byte{0x01, 0x02}}}
+	if err := b.TxMsgs(msgs); err != nil {
+		t.Fatal(err)
+	}
+	d.check(t, "S", "W 0xa0 A", "W 0x01 N", "W 0x02 N", "P")
+}
+
+func TestI2C_TxMsgs_RecvLen(t *testing.T) {
+	b, d := newFakeI2C(t, 0x50)
+	d.tx = []byte{2, 0x11, 0x22, 0x33}
+	msgs := []i2c.Msg{
+		{Addr: 0x50, Buf: []byte{0x10}},
+		{Addr: 0x50, Flags: i2c.Read | i2c.RecvLen, Buf: make([]byte, 33)},
+	}
+	if err := b.TxMsgs(msgs); err != nil {
+		t.Fatal(err)
+	}
+	// The buffer is truncated to the count byte and the data.
+	if !bytes.Equal(msgs[1].Buf, []byte{2, 0x11, 0x22}) {
+		t.Fatalf("%#x", msgs[1].Buf)
+	}
+	d.check(t, "S", "W 0xa0 A", "W 0x10 A", "Sr", "W 0xa1 A", "R 0x02 A", "R 0x11 A", "R 0x22 N", "P")
+
+	// The device sent a length larger than the buffer.
+	d.tx = []byte{40}
+	msgs = []i2c.Msg{{Addr: 0x50, Flags: i2c.Read | i2c.RecvLen, Buf: make([]byte, 33)}}
+	if err := b.TxMsgs(msgs); err == nil {
+		t.Fatal("invalid length")
+	}
+	d.check(t, "S", "W 0xa1 A", "R 0x28 A", "P")
+
+	// Too short buffers are refused before touching the bus.
+	msgs = []i2c.Msg{{Addr: 0x50, Flags: i2c.Read | i2c.RecvLen, Buf: make([]byte, 32)}}
+	if err := b.TxMsgs(msgs); err == nil {
+		t.Fatal("buffer too short")
+	}
+	d.check(t)
+}
+
+func TestI2C_TxMsgs_NoStart(t *testing.T) {
+	b, d := newFakeI2C(t, 0x50)
+	msgs := []i2c.Msg{
+		{Addr: 0x50, Buf: []byte{0x01}},
+		{Flags: i2c.NoStart, Buf: []byte{0x02}},
+	}
+	if err := b.TxMsgs(msgs); err != nil {
+		t.Fatal(err)
+	}
+	d.check(t, "S", "W 0xa0 A", "W 0x01 A", "W 0x02 A", "P")
+
+	// A read continued without a START acknowledges the last byte of the
+	// first message.
+	d.tx = []byte{0xaa, 0xbb}
+	msgs = []i2c.Msg{
+		{Addr: 0x50, Flags: i2c.Read, Buf: make([]byte, 1)},
+		{Flags: i2c.Read | i2c.NoStart, Buf: make([]byte, 1)},
+	}
+	if err := b.TxMsgs(msgs); err != nil {
+		t.Fatal(err)
+	}
+	if msgs[0].Buf[0] != 0xaa || msgs[1].Buf[0] != 0xbb {
+		t.Fatal(msgs)
+	}
+	d.check(t, "S", "W 0xa1 A", "R 0xaa A", "R 0xbb N", "P")
+
+	// SkipAddr sends the data without the address.
+	if err := b.Tx(SkipAddr, []byte{0xa0, 0x03}, nil); err != nil {
+		t.Fatal(err)
+	}
+	d.check(t, "S", "W 0xa0 A", "W 0x03 A", "P")
+}
+
+func TestI2C_TxMsgs_TenBit(t *testing.T) {
+	b, d := newFakeI2C(t, 0x50)
+	d.tx = []byte{0xaa}
+	msgs := []i2c.Msg{
+		{Addr: 0x2a5, Flags: i2c.TenBit, Buf: []byte{0x01}},
+		{Addr: 0x2a5, Flags: i2c.TenBit | i2c.Read, Buf: make([]byte, 1)},
+	}
+	if err := b.TxMsgs(msgs); err != nil {
+		t.Fatal(err)
+	}
+	d.check(t, "S", "W 0xf4 A", "W 0xa5 A", "W 0x01 A", "Sr", "W 0xf4 A", "W 0xa5 A", "Sr", "W 0xf5 A", "R 0xaa N", "P")
+
+	if err := b.TxMsgs([]i2c.Msg{{Addr: 0x80}}); err == nil {
+		t.Fatal("invalid address")
+	}
+	d.check(t)
+}
+
+//
+
+// fakeI2C is an I²C device decoding the levels set on a pair of fake pins.
+//
+// It acknowledges its 7-bit address and any 10-bit address, and records the
+// conditions and the bytes transferred.
+type fakeI2C struct {
+	addr     byte
+	tx       []byte // Bytes to send when read.
+	nackData bool   // Do not acknowledge the data bytes.
+	log      []string
+
+	scl     gpio.Level // Driven by the master.
+	sda     gpio.Level // Driven by the master.
+	devSDA  gpio.Level // Driven by the device.
+	started bool
+	mode    int  // 0: idle, 1: receiving, 2: transmitting.
+	isAddr  bool // The byte being received is an address.
+	n       int  // Clock pulses in the current byte, including the ACK.
+	cur     byte
+}
+
+func newFakeI2C(t *testing.T, addr byte) (*I2C, *fakeI2C) {
+	d := &fakeI2C{addr: addr, scl: gpio.High, sda: gpio.High, devSDA: gpio.High}
+	scl := &fakeI2CPin{Pin: gpiotest.Pin{N: "SCL", Num: 1}, d: d, scl: true}
+	sda := &fakeI2CPin{Pin: gpiotest.Pin{N: "SDA", Num: 2}, d: d}
+	// The half cycle rounds down to 0.
+	b, err := New(scl, sda, 1000000000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b, d
+}
+
+// check verifies the conditions and bytes seen since the last call.
+func (d *fakeI2C) check(t *testing.T, expected ...string) {
+	if len(expected) == 0 && len(d.log) == 0 {
+		return
+	}
+	if !reflect.DeepEqual(d.log, expected) {
+		t.Fatalf("unexpected sequence\ngot:  %q\nwant: %q", d.log, expected)
+	}
+	d.log = nil
+}
+
+func (d *fakeI2C) line() gpio.Level {
+	return d.sda && d.devSDA
+}
+
+func (d *fakeI2C) set(isSCL bool, l gpio.Level) {
+	if isSCL {
+		if l == d.scl {
+			return
+		}
+		d.scl = l
+		if l == gpio.High {
+			d.rise()
+		} else {
+			d.fall()
+		}
+		return
+	}
+	old := d.line()
+	d.sda = l
+	if d.scl == gpio.Low || old == d.line() {
+		return
+	}
+	// SDA changed while SCL is high.
+	if d.line() == gpio.Low {
+		if d.started {
+			d.log = append(d.log, "Sr")
+		} else {
+			d.log = append(d.log, "S")
+		}
+		d.started = true
+		d.mode = 1
+		d.isAddr = true
+		d.n = 0
+		d.devSDA = gpio.High
+		return
+	}
+	d.log = append(d.log, "P")
+	d.started = false
+	d.mode = 0
+	d.devSDA = gpio.High
+}
+
+// rise is called on a SCL rising edge, when the receiver samples SDA.
+func (d *fakeI2C) rise() {
+	if d.mode == 0 {
+		return
+	}
+	if d.n < 8 {
+		if d.mode == 1 {
+			d.cur <<= 1
+			if d.line() == gpio.High {
+				d.cur |= 1
+			}
+		}
+	} else if d.n == 8 {
+		ack := "N"
+		if d.line() == gpio.Low {
+			ack = "A"
+		}
+		dir := "W"
+		if d.mode == 2 {
+			dir = "R"
+		}
+		d.log = append(d.log, fmt.Sprintf("%s %#02x %s", dir, d.cur, ack))
+	}
+	d.n++
+}
+
+// fall is called on a SCL falling edge, when the transmitter changes SDA.
+func (d *fakeI2C) fall() {
+	switch {
+	case d.mode == 0:
+	case d.n == 8 && d.mode == 1:
+		// ACK slot of a received byte.
+		if d.acks() {
+			d.devSDA = gpio.Low
+		}
+	case d.n == 8 && d.mode == 2:
+		// Let the master ACK.
+		d.devSDA = gpio.High
+	case d.n == 9 && d.mode == 1:
+		acked := d.devSDA == gpio.Low
+		d.devSDA = gpio.High
+		d.n = 0
+		if d.isAddr {
+			d.isAddr = false
+			if !acked {
+				d.mode = 0
+			} else if d.cur&1 != 0 {
+				d.mode = 2
+				d.next()
+			}
+		}
+	case d.n == 9 && d.mode == 2:
+		d.n = 0
+		if d.line() == gpio.Low {
+			d.next()
+		} else {
+			d.mode = 0
+		}
+	case d.mode == 2:
+		d.devSDA = gpio.Level(d.cur&(0x80>>uint(d.n)) != 0)
+	}
+}
+
+func (d *fakeI2C) acks() bool {
+	if d.isAddr {
+		return d.cur>>1 == d.addr || d.cur&0xf8 == 0xf0
+	}
+	return !d.nackData
+}
+
+// next loads the next byte to send and drives its first bit.
+func (d *fakeI2C) next() {
+	d.cur = 0xff
+	if len(d.tx) != 0 {
+		d.cur = d.tx[0]
+		d.tx = d.tx[1:]
+	}
+	d.devSDA = gpio.Level(d.cur&0x80 != 0)
+}
+
+// fakeI2CPin is SCL or SDA; the lines are pulled up when released.
+type fakeI2CPin struct {
+	gpiotest.Pin
+	d   *fakeI2C
+	scl bool
+}
+
+func (p *fakeI2CPin) In(pull gpio.Pull, edge gpio.Edge) error {
+	p.d.set(p.scl, gpio.High)
+	return nil
+}
+
+func (p *fakeI2CPin) Out(l gpio.Level) error {
+	p.d.set(p.scl, l)
+	return nil
+}
+
+func (p *fakeI2CPin) Read() gpio.Level {
+	if p.scl {
+		return p.d.scl
+	}
+	return p.d.line()
+}
@@ -0,0 +1,345 @@
+// Copyright 2018 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+// Specification
+//
+// https://www.maximintegrated.com/en/app-notes/index.mvp/id/126
+//
+// https://www.maximintegrated.com/en/app-notes/index.mvp/id/187
+
+package bitbang
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"periph.io/x/periph/conn/gpio"
+	"periph.io/x/periph/conn/onewire"
+	"periph.io/x/periph/conn/onewire/onewirereg"
+	"periph.io/x/periph/host/cpu"
+)
+
+// NewOneWire returns a 1-wire bus master bit-banged on the pin q.
+//
+// q must be pulled up by an external resistor, typically 4.7kΩ; the pin only
+// ever drives the bus low, or high for the strong pull-up.
+//
+// readTime is the monotonic clock used to time the slots, like
+// bcm283x.ReadTime. When nil, a clock based on time.Now is used, which is
+// usually too slow for the 1µs precision the slots require.
+//
+// The pin must be memory mapped for the timing to be met. Standard speed is
+// used, except for TxOverdrive. The overdrive slots last 10µs and the bus is
+// sampled 2µs after the start of a read slot, which requires both readTime and
+// the pin to be accurate to a fraction of a microsecond.
+func NewOneWire(q gpio.PinIO, readTime func() time.Duration) (*OneWire, error) {
+	if readTime == nil {
+		readTime = defaultReadTime
+	}
+	o := &OneWire{q: q, readTime: readTime}
+	if err := o.release(); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// RegisterOneWire registers a 1-wire bus bit-banged on q in onewirereg, so it
+// can be opened with onewirereg.Open(name).
+func RegisterOneWire(name string, aliases []string, q gpio.PinIO, readTime func() time.Duration) error {
+	return onewirereg.Register(name, aliases, -1, func() (onewire.BusCloser, error) {
+		return NewOneWire(q, readTime)
+	})
+}
+
+// OneWire represents a 1-wire bus master implemented as bit-banging on a GPIO
+// pin.
+type OneWire struct {
+	q        gpio.PinIO
+	readTime func() time.Duration
+
+	mu sync.Mutex
+}
+
+func (o *OneWire) String() string {
+	return fmt.Sprintf("bitbang/onewire(%s)", o.q)
+}
+
+// Close implements onewire.BusCloser.
+//
+// It releases the bus.
+func (o *OneWire) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.release()
+}
+
+// Tx implements onewire.Bus.
+//
+// It resets the bus, writes w, then reads r. With StrongPullup, the bus is
+// driven high after the last bit until the next transaction.
+func (o *OneWire) Tx(w, r []byte, power onewire.Pullup) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	if err := o.reset(); err != nil {
+		return err
+	}
+	return o.transfer(w, r, power, &owStandard)
+}
+
+// TxOverdrive implements onewire.BusOverdrive.
+//
+// The reset and the ROM command rom are sent at standard speed, then w and r
+// are transferred with the overdrive timings of app note 126.
+func (o *OneWire) TxOverdrive(rom byte, w, r []byte, power onewire.Pullup) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	if err := o.reset(); err != nil {
+		return err
+	}
+	if err := o.writeByte(rom, &owStandard); err != nil {
+		return err
+	}
+	return o.transfer(w, r, power, &owOverdrive)
+}
+
+// Search implements onewire.Bus using onewire.Search.
+func (o *OneWire) Search(alarmOnly bool) ([]onewire.Address, error) {
+	return onewire.Search(o, alarmOnly)
+}
+
+// SearchTriplet implements onewire.BusSearcher.
+//
+// It reads the bit and its complement and then writes the direction taken.
+func (o *OneWire) SearchTriplet(direction byte) (onewire.TripletResult, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	var res onewire.TripletResult
+	b, err := o.readBit(&owStandard)
+	if err != nil {
+		return res, err
+	}
+	c, err := o.readBit(&owStandard)
+	if err != nil {
+		return res, err
+	}
+	// The devices with a 0 pull the line low on the first read, the ones with a
+	// 1 on the second.
+	res.GotZero = b == gpio.Low
+	res.GotOne = c == gpio.Low
+	switch {
+	case res.GotZero && res.GotOne:
+		res.Taken = direction
+	case res.GotOne:
+		res.Taken = 1
+	}
+	err = o.writeBit(res.Taken == 1, &owStandard)
+	return res, err
+}
+
+// Q implements onewire.Pins.
+func (o *OneWire) Q() gpio.PinIO {
+	return o.q
+}
+
+//
+
+// Standard speed reset timings, from app note 126. The reset is always done at
+// standard speed, which returns the devices in overdrive to standard speed.
+const (
+	owResetLow      = 480 * time.Microsecond // Reset pulse.
+	owPresenceRead  = 70 * time.Microsecond  // Sampling of the presence pulse after the reset.
+	owResetRecovery = 410 * time.Microsecond // End of the presence pulse.
+)
+
+// owSpeed is the timing of the time slots at a bus speed.
+type owSpeed struct {
+	write1Low  time.Duration // Write 1 and read slots.
+	write0Low  time.Duration // Write 0 slot.
+	readSample time.Duration // Sampling of a read slot.
+	slot       time.Duration // Slot including the recovery.
+}
+
+// The slot timings from app note 126.
+var (
+	owStandard  = owSpeed{6 * time.Microsecond, 60 * time.Microsecond, 15 * time.Microsecond, 70 * time.Microsecond}
+	owOverdrive = owSpeed{time.Microsecond, 7500 * time.Nanosecond, 2 * time.Microsecond, 10 * time.Microsecond}
+)
+
+// nanospin is overridden in tests.
+var nanospin = cpu.Nanospin
+
+var processStart = time.Now()
+
+func defaultReadTime() time.Duration {
+	return time.Since(processStart)
+}
+
+// release lets the external resistor pull the bus up.
+func (o *OneWire) release() error {
+	return o.q.In(gpio.PullUp, gpio.NoEdge)
+}
+
+// spinUntil busy loops until the clock reaches t.
+func (o *OneWire) spinUntil(t time.Duration) {
+	for {
+		d := t - o.readTime()
+		if d <= 0 {
+			return
+		}
+		nanospin(d)
+	}
+}
+
+// reset sends a reset pulse and detects the presence pulse.
+//
+// Lasts 960µs.
+func (o *OneWire) reset() error {
+	if err := o.q.Out(gpio.Low); err != nil {
+		return err
+	}
+	t := o.readTime()
+	o.spinUntil(t + owResetLow)
+	if err := o.release(); err != nil {
+		return err
+	}
+	t = o.readTime()
+	o.spinUntil(t + owPresenceRead)
+	present := o.q.Read() == gpio.Low
+	o.spinUntil(t + owPresenceRead + owResetRecovery)
+	if o.q.Read() == gpio.Low {
+		return shortedBusError("bitbang-onewire: bus is shorted")
+	}
+	if !present {
+		return noDevicesError("bitbang-onewire: no device present")
+	}
+	return nil
+}
+
+// transfer writes w, then reads r at speed sp. With StrongPullup, the bus is
+// driven high after the last bit.
+func (o *OneWire) transfer(w, r []byte, power onewire.Pullup, sp *owSpeed) error {
+	for _, b := range w {
+		if err := o.writeByte(b, sp); err != nil {
+			return err
+		}
+	}
+	for i := range r {
+		var err error
+		if r[i], err = o.readByte(sp); err != nil {
+			return err
+		}
+	}
+	if power == onewire.StrongPullup {
+		return o.q.Out(gpio.High)
+	}
+	return nil
+}
+
+// writeBit writes a time slot.
+//
+// Lasts 70µs at standard speed.
+func (o *OneWire) writeBit(b bool, sp *owSpeed) error {
+	if err := o.q.Out(gpio.Low); err != nil {
+		return err
+	}
+	t := o.readTime()
+	if b {
+		o.spinUntil(t + sp.write1Low)
+	} else {
+		o.spinUntil(t + sp.write0Low)
+	}
+	if err := o.release(); err != nil {
+		return err
+	}
+	o.spinUntil(t + sp.slot)
+	return nil
+}
+
+// readBit reads a time slot.
+//
+// Lasts 70µs at standard speed.
+func (o *OneWire) readBit(sp *owSpeed) (gpio.Level, error) {
+	if err := o.q.Out(gpio.Low); err != nil {
+		return gpio.Low, err
+	}
+	t := o.readTime()
+	o.spinUntil(t + sp.write1Low)
+	if err := o.release(); err != nil {
+		return gpio.Low, err
+	}
+	o.spinUntil(t + sp.readSample)
+	l := o.q.Read()
+	o.spinUntil(t + sp.slot)
+	return l, nil
+}
+
+// writeByte writes 8 bits, least significant bit first.
+func (o *OneWire) writeByte(b byte, sp *owSpeed) error {
+	for i := uint(0); i < 8; i++ {
+		if err := o.writeBit(b&(1<<i) != 0, sp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readByte reads 8 bits, least significant bit first.
+func (o *OneWire) readByte(sp *owSpeed) (byte, error) {
+	var b byte
+	for i := uint(0); i < 8; i++ {
+		l, err := o.readBit(sp)
+		if err != nil {
+			return 0, err
+		}
+		if l == gpio.High {
+			b |= 1 << i
+		}
+	}
+	return b, nil
+}
+
+// noDevicesError implements onewire.NoDevicesError.
+type noDevicesError string
+
+func (e noDevicesError) Error() string {
+	return string(e)
+}
+
+func (e noDevicesError) NoDevices() bool {
+	return true
+}
+
+// shortedBusError implements onewire.ShortedBusError and onewire.BusError.
+type shortedBusError string
+
+func (e shortedBusError) Error() string {
+	return string(e)
+}
+
+func (e shortedBusError) IsShorted() bool {
+	return true
+}
+
+func (e shortedBusError) BusError() bool {
+	return true
+}
+
+var _ onewire.BusCloser = &OneWire{}
+var _ onewire.BusOverdrive = &OneWire{}
+var _ onewire.BusSearcher = &OneWire{}
+var _ onewire.Pins = &OneWire{}
+var _ fmt.Stringer = &OneWire{}
+var _ onewire.NoDevicesError = noDevicesError("")
+var _ onewire.ShortedBusError = shortedBusError("")
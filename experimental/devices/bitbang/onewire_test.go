@@ -0,0 +1,470 @@
+// Copyright 2018 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package bitbang
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"periph.io/x/periph/conn/gpio"
+	"periph.io/x/periph/conn/gpio/gpiotest"
+	"periph.io/x/periph/conn/onewire"
+	"periph.io/x/periph/conn/onewire/onewirereg"
+	"periph.io/x/periph/devices/ds18b20"
+	"periph.io/x/periph/host/cpu"
+)
+
+func TestOneWire_Search(t *testing.T) {
+	b, q := newFakeBus(t,
+		newFakeDS18B20(0x1600000123456728),
+		newFakeDS18B20(0x6a00000123456628),
+		newFakeDS18B20(0xf3000008d4b2a228))
+	defer q.done()
+	addrs, err := b.Search(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := q.addrs()
+	if len(addrs) != len(expected) {
+		t.Fatalf("%#x", addrs)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+	for i := range addrs {
+		if addrs[i] != expected[i] {
+			t.Fatalf("%#x != %#x", addrs, expected)
+		}
+	}
+
+	// Only the device in alarm.
+	q.devices[1].alarm = true
+	if addrs, err = b.Search(true); err != nil || len(addrs) != 1 || addrs[0] != q.devices[1].addr {
+		t.Fatalf("%#x %v", addrs, err)
+	}
+	q.checkTiming(t)
+}
+
+func TestOneWire_DS18B20(t *testing.T) {
+	b, q := newFakeBus(t, newFakeDS18B20(0x1600000123456728), newFakeDS18B20(0xf3000008d4b2a228))
+	defer q.done()
+	d, err := ds18b20.New(b, q.devices[1].addr, 9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The resolution was written with Match ROM and copied.
+	if q.devices[1].spad[4] != 0x1f || q.devices[0].spad[4] != 0x7f || !q.devices[1].copied {
+		t.Fatal(q.devices[1].spad, q.devices[0].spad)
+	}
+	// The conversion is powered.
+	if !q.strong {
+		t.Fatal("expected strong pull-up")
+	}
+	c, err := d.Temperature()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c != 25062 {
+		t.Fatal(c)
+	}
+	if q.strong {
+		t.Fatal("the strong pull-up must be released on the next transaction")
+	}
+	// ConvertAll uses Skip ROM.
+	q.devices[0].converted = false
+	if err := ds18b20.ConvertAll(b, 9); err != nil {
+		t.Fatal(err)
+	}
+	if !q.devices[0].converted {
+		t.Fatal("expected conversion")
+	}
+	q.checkTiming(t)
+}
+
+func TestOneWire_Overdrive(t *testing.T) {
+	b, q := newFakeBus(t, newFakeDS18B20(0x1600000123456728), newFakeDS18B20(0xf3000008d4b2a228))
+	defer q.done()
+	var r [9]byte
+	if err := onewire.OverdriveMatch(b, q.devices[1].addr, []byte{0xbe}, r[:], onewire.WeakPullup); err != nil {
+		t.Fatal(err)
+	}
+	if r != q.devices[1].spad || !q.devices[1].overdrive || q.devices[0].overdrive {
+		t.Fatal(r)
+	}
+	// Reset, rom command at standard speed, then 64+8+72 overdrive slots.
+	pulses := q.pulses[1+8:]
+	if len(pulses) != 64+8+72 {
+		t.Fatal(len(pulses))
+	}
+	for i, p := range pulses {
+		if p != time.Microsecond && p != 7500*time.Nanosecond {
+			t.Fatalf("#%d: invalid overdrive low pulse %s", i, p)
+		}
+	}
+	// All the reads were done at overdrive speed.
+	for i, s := range q.samples {
+		if s > 2*time.Microsecond {
+			t.Fatalf("#%d: late overdrive sample %s", i, s)
+		}
+	}
+
+	// The next Tx resets the devices back to standard speed.
+	if err := onewire.OverdriveSkip(b, []byte{0x44}, nil, onewire.StrongPullup); err != nil {
+		t.Fatal(err)
+	}
+	if !q.devices[0].converted || !q.devices[0].overdrive || !q.strong {
+		t.Fatal("expected overdrive conversion")
+	}
+	if err := b.Tx([]byte{0xcc, 0xbe}, r[:1], onewire.WeakPullup); err != nil {
+		t.Fatal(err)
+	}
+	if q.devices[0].overdrive || q.devices[1].overdrive {
+		t.Fatal("a standard reset must leave overdrive")
+	}
+	q.checkTiming(t)
+}
+
+func TestOneWire_errors(t *testing.T) {
+	b, q := newFakeBus(t)
+	defer q.done()
+	err := b.Tx([]byte{0xcc}, nil, onewire.WeakPullup)
+	if e, ok := err.(onewire.NoDevicesError); !ok || !e.NoDevices() {
+		t.Fatal(err)
+	}
+	if _, err := b.Search(false); err == nil {
+		t.Fatal("no device")
+	}
+	q.shorted = true
+	err = b.Tx([]byte{0xcc}, nil, onewire.WeakPullup)
+	if e, ok := err.(onewire.ShortedBusError); !ok || !e.IsShorted() {
+		t.Fatal(err)
+	}
+	if e, ok := err.(onewire.BusError); !ok || !e.BusError() {
+		t.Fatal(err)
+	}
+}
+
+func TestOneWire_String(t *testing.T) {
+	b, q := newFakeBus(t)
+	defer q.done()
+	if s := b.String(); s != "bitbang/onewire(Q(1))" {
+		t.Fatal(s)
+	}
+	if b.Q() != q {
+		t.Fatal("Q")
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRegisterOneWire(t *testing.T) {
+	q := &fakeBus{Pin: gpiotest.Pin{N: "Q", Num: 1}}
+	if err := RegisterOneWire("bitbang", []string{"1W"}, q, q.readTime); err != nil {
+		t.Fatal(err)
+	}
+	defer onewirereg.Unregister("bitbang")
+	b, err := onewirereg.Open("1W")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := b.(*OneWire); !ok {
+		t.Fatal(b)
+	}
+}
+
+//
+
+// fakeBus is a timing-aware fake pin on a 1-wire bus.
+//
+// It decodes the slots from the duration of the low pulses measured on a fake
+// clock, which is advanced by nanospin.
+type fakeBus struct {
+	gpiotest.Pin
+	devices []*fakeDS18B20
+	shorted bool
+
+	now      time.Duration
+	low      bool          // The master drives the bus low.
+	strong   bool          // The master drives the bus high.
+	fall     time.Duration // Start of the current low pulse.
+	rise     time.Duration // End of the last low pulse.
+	presence time.Duration // End of the reset pulse.
+	hold     time.Duration // A device holds the bus low until then.
+	pulses   []time.Duration
+	gaps     []time.Duration
+	samples  []time.Duration // Time of the reads since the start of the slot.
+}
+
+func newFakeBus(t *testing.T, devices ...*fakeDS18B20) (*OneWire, *fakeBus) {
+	q := &fakeBus{Pin: gpiotest.Pin{N: "Q", Num: 1}, devices: devices, presence: -time.Hour, rise: -time.Hour}
+	nanospin = func(d time.Duration) {
+		q.now += d
+	}
+	b, err := NewOneWire(q, q.readTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b, q
+}
+
+func (f *fakeBus) done() {
+	nanospin = cpu.Nanospin
+}
+
+func (f *fakeBus) readTime() time.Duration {
+	return f.now
+}
+
+func (f *fakeBus) addrs() []onewire.Address {
+	var out []onewire.Address
+	for _, d := range f.devices {
+		out = append(out, d.addr)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
+
+// checkTiming checks the slots meet the standard speed timings.
+func (f *fakeBus) checkTiming(t *testing.T) {
+	for i, p := range f.pulses {
+		if p < time.Microsecond || (p > 15*time.Microsecond && p < 60*time.Microsecond) || (p > 120*time.Microsecond && p < 480*time.Microsecond) {
+			t.Fatalf("#%d: invalid low pulse %s", i, p)
+		}
+	}
+	for i, g := range f.gaps {
+		if g < time.Microsecond {
+			t.Fatalf("#%d: invalid recovery %s", i, g)
+		}
+	}
+	for i, s := range f.samples {
+		if s > 15*time.Microsecond && s < 60*time.Microsecond {
+			t.Fatalf("#%d: late sample %s", i, s)
+		}
+	}
+}
+
+func (f *fakeBus) In(pull gpio.Pull, edge gpio.Edge) error {
+	f.strong = false
+	if f.low {
+		f.low = false
+		f.release()
+	}
+	return f.Pin.In(pull, edge)
+}
+
+func (f *fakeBus) Out(l gpio.Level) error {
+	if l == gpio.High {
+		f.strong = true
+		if f.low {
+			f.low = false
+			f.release()
+		}
+	} else if !f.low {
+		f.strong = false
+		f.low = true
+		f.gaps = append(f.gaps, f.now-f.rise)
+		f.fall = f.now
+		f.slot()
+	}
+	return f.Pin.Out(l)
+}
+
+func (f *fakeBus) Read() gpio.Level {
+	if f.shorted || f.low || f.now < f.hold {
+		return gpio.Low
+	}
+	if !f.strong && f.now-f.fall < 480*time.Microsecond {
+		f.samples = append(f.samples, f.now-f.fall)
+	}
+	// Presence pulse, from 15µs to 135µs after the reset.
+	if d := f.now - f.presence; len(f.devices) != 0 && d >= 15*time.Microsecond && d < 135*time.Microsecond {
+		return gpio.Low
+	}
+	return gpio.High
+}
+
+// slot is called on a falling edge, when the devices that send a 0 start
+// holding the bus low.
+func (f *fakeBus) slot() {
+	for _, d := range f.devices {
+		if b, ok := d.send(); ok && !b {
+			if d.overdrive {
+				f.hold = f.now + 3*time.Microsecond
+			} else {
+				f.hold = f.now + 30*time.Microsecond
+			}
+		}
+	}
+}
+
+// release is called on a rising edge, when the devices sample the bus.
+func (f *fakeBus) release() {
+	p := f.now - f.fall
+	f.pulses = append(f.pulses, p)
+	f.rise = f.now
+	if p >= 480*time.Microsecond {
+		f.presence = f.now
+		for _, d := range f.devices {
+			d.reset()
+		}
+		return
+	}
+	for _, d := range f.devices {
+		if d.overdrive {
+			d.receive(p < 2*time.Microsecond)
+		} else {
+			d.receive(p < 15*time.Microsecond)
+		}
+	}
+}
+
+// fakeDS18B20 is a DS18B20 decoding the bits sent on the bus.
+//
+// Unlike the real device, it supports overdrive speed so it can be used to
+// test TxOverdrive.
+type fakeDS18B20 struct {
+	addr      onewire.Address
+	alarm     bool
+	spad      [9]byte
+	copied    bool
+	converted bool
+	overdrive bool
+
+	active bool
+	tx     []bool // Bits to send.
+	sent   bool   // A bit was sent in the current slot.
+	rx     uint64 // Bits received.
+	nrx    uint
+	need   uint
+	next   func(v uint64)
+}
+
+func newFakeDS18B20(addr onewire.Address) *fakeDS18B20 {
+	// Fix the CRC.
+	var b [8]byte
+	for i := range b {
+		b[i] = byte(addr >> uint(8*i))
+	}
+	addr = addr&0x00ffffffffffffff | onewire.Address(onewire.CalcCRC(b[:7]))<<56
+	d := &fakeDS18B20{addr: addr}
+	// Power-on value: 85°C, 12 bits.
+	copy(d.spad[:], []byte{0x50, 0x05, 0x4b, 0x46, 0x7f, 0xff, 0x0c, 0x10})
+	d.spad[8] = onewire.CalcCRC(d.spad[:8])
+	return d
+}
+
+func (d *fakeDS18B20) reset() {
+	d.active = true
+	d.overdrive = false
+	d.tx = nil
+	d.expect(8, d.romCommand)
+}
+
+// send returns the bit to send in this slot, if any.
+func (d *fakeDS18B20) send() (bool, bool) {
+	d.sent = false
+	if !d.active || len(d.tx) == 0 {
+		return false, false
+	}
+	b := d.tx[0]
+	d.tx = d.tx[1:]
+	d.sent = true
+	return b, true
+}
+
+func (d *fakeDS18B20) receive(b bool) {
+	if !d.active || d.sent || d.need == 0 {
+		return
+	}
+	if b {
+		d.rx |= 1 << d.nrx
+	}
+	if d.nrx++; d.nrx == d.need {
+		d.need = 0
+		d.next(d.rx)
+	}
+}
+
+func (d *fakeDS18B20) expect(n uint, next func(v uint64)) {
+	d.rx = 0
+	d.nrx = 0
+	d.need = n
+	d.next = next
+}
+
+func (d *fakeDS18B20) sendBytes(b []byte) {
+	for _, c := range b {
+		for i := uint(0); i < 8; i++ {
+			d.tx = append(d.tx, c&(1<<i) != 0)
+		}
+	}
+}
+
+func (d *fakeDS18B20) romCommand(c uint64) {
+	switch c {
+	case 0x55, 0x69:
+		d.overdrive = c == 0x69
+		d.expect(64, func(v uint64) {
+			if onewire.Address(v) != d.addr {
+				d.active = false
+				d.overdrive = false
+				return
+			}
+			d.expect(8, d.functionCommand)
+		})
+	case 0xcc, 0x3c:
+		d.overdrive = c == 0x3c
+		d.expect(8, d.functionCommand)
+	case 0xf0, 0xec:
+		if c == 0xec && !d.alarm {
+			d.active = false
+			return
+		}
+		d.searchBit(0)
+	default:
+		d.active = false
+	}
+}
+
+func (d *fakeDS18B20) searchBit(i uint) {
+	if i == 64 {
+		d.active = false
+		return
+	}
+	b := d.addr&(1<<i) != 0
+	d.tx = []bool{b, !b}
+	d.expect(1, func(v uint64) {
+		if (v == 1) != b {
+			d.active = false
+			return
+		}
+		d.searchBit(i + 1)
+	})
+}
+
+func (d *fakeDS18B20) functionCommand(c uint64) {
+	switch c {
+	case 0x44:
+		// 25.0625°C
+		d.spad[0] = 0x91
+		d.spad[1] = 0x01
+		d.spad[8] = onewire.CalcCRC(d.spad[:8])
+		d.converted = true
+	case 0xbe:
+		d.sendBytes(d.spad[:])
+	case 0x4e:
+		d.expect(24, func(v uint64) {
+			d.spad[2] = byte(v)
+			d.spad[3] = byte(v >> 8)
+			d.spad[4] = byte(v >> 16)
+			d.spad[8] = onewire.CalcCRC(d.spad[:8])
+		})
+	case 0x48:
+		d.copied = true
+	case 0xb4:
+		// Parasitically powered.
+		d.tx = []bool{false}
+	}
+}
@@ -71,6 +71,7 @@ type SPI struct {
 	mode      spi.Mode
 	bits      int
 	halfCycle time.Duration
+	csActive  bool // CS was left asserted by a last packet with KeepCS:true.
 }
 
 func (s *SPI) String() string {
@@ -78,7 +79,12 @@ func (s *SPI) String() string {
 }
 
 // Close implements spi.ConnCloser.
+//
+// It releases CS if it was left asserted.
 func (s *SPI) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.releaseCS()
 	return nil
 }
 
@@ -102,61 +108,93 @@ func (s *SPI) LimitSpeed(maxHz int64) error {
 	return nil
 }
 
-// Connect implements spi.Conn.
-func (s *SPI) Connect(maxHz int64, mode spi.Mode, bits int) error {
+// Connect implements spi.Port.
+//
+// It returns the port itself as the connection.
+func (s *SPI) Connect(maxHz int64, mode spi.Mode, bits int) (spi.Conn, error) {
+	if err := s.Configure(maxHz, mode, bits); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Configure implements spi.Configurer.
+//
+// HalfDuplex is not supported and only 8 bits words are supported.
+func (s *SPI) Configure(maxHz int64, mode spi.Mode, bits int) error {
 	if maxHz < 0 {
 		return errors.New("bitbang-spi: invalid maxHz")
 	}
-	if mode != spi.Mode3 {
+	if mode&^(spi.Mode3|spi.NoCS|spi.LSBFirst) != 0 {
 		return fmt.Errorf("bitbang-spi: mode %v is not implemented", mode)
 	}
+	if bits != 8 {
+		return fmt.Errorf("bitbang-spi: %d bits per word is not implemented", bits)
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	// The parameters change for another device.
+	s.releaseCS()
 	s.maxHzDev = maxHz
 	if s.maxHzDev != 0 && (s.maxHzPort == 0 || s.maxHzDev < s.maxHzPort) {
 		s.halfCycle = time.Second / time.Duration(maxHz) / time.Duration(2)
 	}
 	s.mode = mode
 	s.bits = bits
-	return nil
+	// The clock idles at CPOL.
+	return s.sck.Out(s.mode&spi.Mode2 != 0)
 }
 
 // Tx implements spi.Conn.
 //
-// BUG(maruel): Implement mode.
-// BUG(maruel): Implement bits.
 // BUG(maruel): Test if read works.
 func (s *SPI) Tx(w, r []byte) error {
-	if len(r) != 0 && len(w) != len(r) {
+	if len(w) != 0 && len(r) != 0 && len(w) != len(r) {
 		return errors.New("bitbang-spi: write and read buffers must be the same length")
 	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if s.csn != nil {
-		_ = s.csn.Out(gpio.Low)
-		s.sleepHalfCycle()
-	}
-	for i := uint(0); i < uint(len(w)*8); i++ {
-		_ = s.sdo.Out(w[i/8]&(1<<(i%8)) != 0)
-		s.sleepHalfCycle()
-		_ = s.sck.Out(gpio.Low)
-		s.sleepHalfCycle()
-		if len(r) != 0 {
-			if s.sdi.Read() == gpio.High {
-				r[i/8] |= 1 << (i % 8)
-			}
-		}
-		_ = s.sck.Out(gpio.Low)
-	}
-	if s.csn != nil {
-		_ = s.csn.Out(gpio.High)
-	}
+	s.assertCS()
+	s.txLocked(w, r, s.halfCycle)
+	s.releaseCS()
 	return nil
 }
 
 // TxPackets implements spi.Conn.
+//
+// CS is kept asserted between packets with KeepCS:true. KeepCS:true on the
+// last packet keeps CS asserted after the call returns, until the next
+// transaction.
+//
+// Only single wire transfers are supported.
 func (s *SPI) TxPackets(p []spi.Packet) error {
-	return errors.New("bitbang-spi: not implemented")
+	for i := range p {
+		if len(p[i].W) != 0 && len(p[i].R) != 0 && len(p[i].W) != len(p[i].R) {
+			return errors.New("bitbang-spi: write and read buffers must be the same length")
+		}
+		if p[i].BitsPerWord != 0 && p[i].BitsPerWord != 8 {
+			return fmt.Errorf("bitbang-spi: %d bits per word is not implemented", p[i].BitsPerWord)
+		}
+		if p[i].TxWidth > 1 || p[i].RxWidth > 1 {
+			return errors.New("bitbang-spi: dual and quad SPI are not supported")
+		}
+		if p[i].MaxHz < 0 {
+			return fmt.Errorf("bitbang-spi: invalid packet speed %d", p[i].MaxHz)
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range p {
+		s.assertCS()
+		s.txLocked(p[i].W, p[i].R, s.packetHalfCycle(p[i].MaxHz))
+		if p[i].Delay > 0 {
+			time.Sleep(p[i].Delay)
+		}
+		if !p[i].KeepCS {
+			s.releaseCS()
+		}
+	}
+	return nil
 }
 
 // Write implements io.Writer.
@@ -189,10 +227,91 @@ func (s *SPI) CS() gpio.PinOut {
 
 //
 
+// packetHalfCycle returns the half clock period of a packet with the speed
+// maxHz, limited by the port speed.
+func (s *SPI) packetHalfCycle(maxHz int64) time.Duration {
+	if maxHz == 0 {
+		return s.halfCycle
+	}
+	if s.maxHzPort != 0 && maxHz > s.maxHzPort {
+		maxHz = s.maxHzPort
+	}
+	return time.Second / time.Duration(maxHz) / time.Duration(2)
+}
+
+// txLocked clocks the bits of w out and the bits read into r, with a clock
+// period of twice halfCycle.
+//
+// s.mu must be held.
+func (s *SPI) txLocked(w, r []byte, halfCycle time.Duration) {
+	n := len(w)
+	if n == 0 {
+		n = len(r)
+	}
+	idle := gpio.Level(s.mode&spi.Mode2 != 0)
+	// When CPHA is set, the data is sampled on the trailing edge.
+	cpha := s.mode&spi.Mode1 != 0
+	for i := 0; i < n*8; i++ {
+		mask := byte(0x80) >> uint(i%8)
+		if s.mode&spi.LSBFirst != 0 {
+			mask = 1 << uint(i%8)
+		}
+		out := gpio.Level(len(w) != 0 && w[i/8]&mask != 0)
+		if cpha {
+			_ = s.sck.Out(!idle)
+			_ = s.sdo.Out(out)
+			cpu.Nanospin(halfCycle)
+			_ = s.sck.Out(idle)
+			s.sample(r, i/8, mask)
+			cpu.Nanospin(halfCycle)
+		} else {
+			_ = s.sdo.Out(out)
+			cpu.Nanospin(halfCycle)
+			_ = s.sck.Out(!idle)
+			s.sample(r, i/8, mask)
+			cpu.Nanospin(halfCycle)
+			_ = s.sck.Out(idle)
+		}
+	}
+}
+
+// sample reads MISO into the bit mask of r[i].
+func (s *SPI) sample(r []byte, i int, mask byte) {
+	if len(r) == 0 || s.sdi == nil {
+		return
+	}
+	if s.sdi.Read() == gpio.High {
+		r[i] |= mask
+	} else {
+		r[i] &^= mask
+	}
+}
+
+// assertCS asserts CS, unless NoCS was specified or it is already asserted.
+func (s *SPI) assertCS() {
+	if s.csn != nil && s.mode&spi.NoCS == 0 && !s.csActive {
+		_ = s.csn.Out(gpio.Low)
+		s.csActive = true
+		s.sleepHalfCycle()
+	}
+}
+
+// releaseCS releases CS if it is asserted.
+func (s *SPI) releaseCS() {
+	if s.csActive {
+		s.sleepHalfCycle()
+		_ = s.csn.Out(gpio.High)
+		s.csActive = false
+	}
+}
+
 // sleep does a busy loop to act as fast as possible.
 func (s *SPI) sleepHalfCycle() {
 	cpu.Nanospin(s.halfCycle)
 }
 
 var _ spi.Conn = &SPI{}
+var _ spi.Configurer = &SPI{}
+var _ spi.PortCloser = &SPI{}
+var _ spi.Pins = &SPI{}
 var _ fmt.Stringer = &SPI{}
@@ -0,0 +1,92 @@
+// Copyright 2018 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package bitbang
+
+import (
+	"testing"
+	"time"
+
+	"periph.io/x/periph/conn/gpio"
+	"periph.io/x/periph/conn/gpio/gpiotest"
+	"periph.io/x/periph/conn/spi"
+)
+
+func TestSPI_TxPackets(t *testing.T) {
+	s, cs := newTestSPI(t)
+	r := make([]byte, 1)
+	p := []spi.Packet{{W: []byte{1}, KeepCS: true}, {R: r, KeepCS: true}}
+	if err := s.TxPackets(p); err != nil {
+		t.Fatal(err)
+	}
+	if r[0] != 0xff {
+		t.Fatal(r)
+	}
+	// KeepCS:true on the last packet keeps CS asserted until the next
+	// transaction.
+	if cs.L != gpio.Low {
+		t.Fatal("CS must be kept asserted")
+	}
+	if err := s.Tx([]byte{2}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if cs.L != gpio.High {
+		t.Fatal("CS must be released")
+	}
+
+	// Close releases CS.
+	if err := s.TxPackets([]spi.Packet{{W: []byte{3}, KeepCS: true}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if cs.L != gpio.High {
+		t.Fatal("CS must be released")
+	}
+}
+
+func TestSPI_TxPackets_params(t *testing.T) {
+	s, _ := newTestSPI(t)
+	start := time.Now()
+	if err := s.TxPackets([]spi.Packet{{W: []byte{1}, Delay: 10 * time.Millisecond}}); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 10*time.Millisecond {
+		t.Fatalf("Delay ignored: %s", d)
+	}
+	if err := s.LimitSpeed(1000000); err != nil {
+		t.Fatal(err)
+	}
+	if h := s.packetHalfCycle(0); h != s.halfCycle {
+		t.Fatal(h)
+	}
+	if h := s.packetHalfCycle(100000); h != 5*time.Microsecond {
+		t.Fatal(h)
+	}
+	// Limited by the port speed.
+	if h := s.packetHalfCycle(2000000); h != 500*time.Nanosecond {
+		t.Fatal(h)
+	}
+
+	for _, p := range []spi.Packet{{W: []byte{1}, TxWidth: 2}, {R: []byte{1}, RxWidth: 4}, {W: []byte{1}, MaxHz: -1}} {
+		if err := s.TxPackets([]spi.Packet{p}); err == nil {
+			t.Fatalf("%#v", p)
+		}
+	}
+}
+
+//
+
+func newTestSPI(t *testing.T) (*SPI, *gpiotest.Pin) {
+	cs := &gpiotest.Pin{N: "CS", Num: 4}
+	s, err := NewSPI(&gpiotest.Pin{N: "CLK", Num: 1}, &gpiotest.Pin{N: "MOSI", Num: 2}, &gpiotest.Pin{N: "MISO", Num: 3, L: gpio.High}, cs, 1000000000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Connect(0, spi.Mode0, 8); err != nil {
+		t.Fatal(err)
+	}
+	return s, cs
+}
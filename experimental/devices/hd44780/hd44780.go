@@ -25,6 +25,9 @@ type Dev struct {
 	// data pins
 	dataPins []gpio.PinOut
 
+	// data pins as a group, when set it is used instead of dataPins
+	dataGroup gpio.Group
+
 	// register select pin
 	rsPin gpio.PinOut
 
@@ -51,6 +54,26 @@ func New(data []gpio.PinOut, rs, e gpio.PinOut) (*Dev, error) {
 	return dev, nil
 }
 
+// NewGroup creates and initializes the LCD device using a group of data pins,
+// so that the 4 data bits are set at once when the pins driver supports it.
+//	data - group of the 4 data pins, DB4 to DB7
+//	rs - rs pin
+//	e - strobe pin
+func NewGroup(data gpio.Group, rs, e gpio.PinOut) (*Dev, error) {
+	if l := len(data.Pins()); l != 4 {
+		return nil, fmt.Errorf("expected 4 data pins, passed %d", l)
+	}
+	dev := &Dev{
+		dataGroup: data,
+		enablePin: e,
+		rsPin:     rs,
+	}
+	if err := dev.Reset(); err != nil {
+		return nil, err
+	}
+	return dev, nil
+}
+
 // Reset resets the HC-44780 chipset, clears the screen buffer and moves cursor to the
 // home of screen (line 0, column 0).
 func (r *Dev) Reset() error {
@@ -160,6 +183,9 @@ func (r *Dev) bulkSendData(seq [][]uint, f func(_data uint8) error) error {
 }
 
 func (r *Dev) clearBits() error {
+	if r.dataGroup != nil {
+		return r.dataGroup.Out(0)
+	}
 	for _, v := range r.dataPins {
 		if err := v.Out(gpio.Low); err != nil {
 			return err
@@ -169,6 +195,12 @@ func (r *Dev) clearBits() error {
 }
 
 func (r *Dev) write4Bits(data uint8) error {
+	if r.dataGroup != nil {
+		if err := r.dataGroup.Out(uint64(data & 0x0F)); err != nil {
+			return err
+		}
+		return r.strobe()
+	}
 	for i, v := range r.dataPins {
 		if data&(1<<uint(i)) > 0 {
 			if err := v.Out(gpio.High); err != nil {
@@ -0,0 +1,59 @@
+// Copyright 2018 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package tca9548a_test
+
+import (
+	"fmt"
+	"log"
+
+	"periph.io/x/periph/conn/i2c/i2creg"
+	"periph.io/x/periph/devices"
+	"periph.io/x/periph/devices/bmxx80"
+	"periph.io/x/periph/experimental/devices/tca9548a"
+	"periph.io/x/periph/host"
+)
+
+func Example() {
+	if _, err := host.Init(); err != nil {
+		log.Fatal(err)
+	}
+
+	// Open the I²C bus to which the multiplexer is connected.
+	bus, err := i2creg.Open("")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer bus.Close()
+
+	mux, err := tca9548a.New(bus, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer mux.Halt()
+
+	// Register the channels as "MUX0-0" to "MUX0-7".
+	if err := mux.Register("MUX0"); err != nil {
+		log.Fatal(err)
+	}
+	defer mux.Unregister()
+
+	// Two sensors with the same address on channels 0 and 3.
+	for _, name := range []string{"MUX0-0", "MUX0-3"} {
+		b, err := i2creg.Open(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		dev, err := bmxx80.NewI2C(b, 0x76, nil)
+		if err != nil {
+			log.Fatal(err)
+		}
+		var env devices.Environment
+		if err := dev.Sense(&env); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%s: %8s %10s %9s\n", name, env.Temperature, env.Pressure, env.Humidity)
+		b.Close()
+	}
+}
@@ -0,0 +1,235 @@
+// Copyright 2018 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+// Package tca9548a controls a TCA9548A or PCA954x I²C multiplexer.
+//
+// Each downstream channel is exposed as an i2c.BusCloser that can be
+// registered in i2creg, so device drivers can be used unmodified on devices
+// sharing the same address behind the multiplexer.
+//
+// Datasheet
+//
+// http://www.ti.com/lit/ds/symlink/tca9548a.pdf
+//
+// https://www.nxp.com/docs/en/data-sheet/PCA9544A.pdf
+package tca9548a
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"periph.io/x/periph/conn/gpio"
+	"periph.io/x/periph/conn/i2c"
+	"periph.io/x/periph/conn/i2c/i2creg"
+)
+
+// Opts is the options to pass to New.
+type Opts struct {
+	// Addr is the I²C address of the multiplexer, between 0x70 and 0x77.
+	// Defaults to 0x70.
+	Addr uint16
+	// Channels is the number of downstream channels; 8 for TCA9548A and
+	// PCA9548A, 4 for PCA9544A and PCA9546A, 2 for PCA9542A and PCA9543A.
+	// Defaults to 8.
+	Channels int
+	// Encoded must be set for multiplexers selecting the channel with its
+	// number instead of a bit mask, like the PCA9542A and PCA9544A.
+	Encoded bool
+}
+
+// DefaultOpts are the recommended default options for a TCA9548A.
+var DefaultOpts = Opts{Addr: 0x70, Channels: 8}
+
+// New returns a handle to a multiplexer on the bus b.
+//
+// All the channels are initially deselected.
+func New(b i2c.Bus, opts *Opts) (*Dev, error) {
+	o := DefaultOpts
+	if opts != nil {
+		if opts.Addr != 0 {
+			o.Addr = opts.Addr
+		}
+		if opts.Channels != 0 {
+			o.Channels = opts.Channels
+		}
+		o.Encoded = opts.Encoded
+	}
+	if o.Addr < 0x70 || o.Addr > 0x77 {
+		return nil, errors.New("tca9548a: invalid address " + strconv.Itoa(int(o.Addr)))
+	}
+	if o.Channels < 1 || o.Channels > 8 || (o.Encoded && o.Channels > 4) {
+		return nil, errors.New("tca9548a: invalid number of channels " + strconv.Itoa(o.Channels))
+	}
+	d := &Dev{c: i2c.Dev{Bus: b, Addr: o.Addr}, opts: o, selected: unknown}
+	if err := d.Halt(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Dev is a handle to a TCA9548A or PCA954x I²C multiplexer.
+//
+// The selection of the channels is serialized and cached, so that consecutive
+// transactions on the same channel don't select it again.
+type Dev struct {
+	c    i2c.Dev
+	opts Opts
+
+	mu       sync.Mutex
+	selected int // Currently selected channel, -1 if none.
+	names    []string
+}
+
+func (d *Dev) String() string {
+	return fmt.Sprintf("tca9548a{%s}", &d.c)
+}
+
+// Channel returns the downstream bus for the channel number ch, 0 based.
+func (d *Dev) Channel(ch int) (*Channel, error) {
+	if ch < 0 || ch >= d.opts.Channels {
+		return nil, errors.New("tca9548a: invalid channel " + strconv.Itoa(ch))
+	}
+	return &Channel{d: d, ch: ch}, nil
+}
+
+// Register registers each channel in i2creg as name followed by "-" and the
+// channel number, e.g. "MUX0-3" for the channel 3 when name is "MUX0".
+func (d *Dev) Register(name string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.names) != 0 {
+		return errors.New("tca9548a: channels are already registered")
+	}
+	for i := 0; i < d.opts.Channels; i++ {
+		n := name + "-" + strconv.Itoa(i)
+		c := &Channel{d: d, ch: i}
+		if err := i2creg.Register(n, nil, -1, c.open); err != nil {
+			for _, r := range d.names {
+				_ = i2creg.Unregister(r)
+			}
+			d.names = nil
+			return fmt.Errorf("tca9548a: %v", err)
+		}
+		d.names = append(d.names, n)
+	}
+	return nil
+}
+
+// Unregister removes the channels registered with Register from i2creg.
+func (d *Dev) Unregister() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var err error
+	for _, n := range d.names {
+		if err2 := i2creg.Unregister(n); err2 != nil && err == nil {
+			err = fmt.Errorf("tca9548a: %v", err2)
+		}
+	}
+	d.names = nil
+	return err
+}
+
+// Halt deselects all the channels.
+func (d *Dev) Halt() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.selectLocked(-1)
+}
+
+//
+
+// unknown means that the channel selected in the multiplexer is unknown.
+const unknown = -2
+
+// selectLocked selects the channel ch, or none if -1.
+//
+// d.mu must be held.
+func (d *Dev) selectLocked(ch int) error {
+	if d.selected == ch {
+		return nil
+	}
+	var b byte
+	switch {
+	case ch == -1:
+	case d.opts.Encoded:
+		b = 4 | byte(ch)
+	default:
+		b = 1 << uint(ch)
+	}
+	if err := d.c.Tx([]byte{b}, nil); err != nil {
+		// The state of the multiplexer is unknown.
+		d.selected = unknown
+		return fmt.Errorf("tca9548a: %v", err)
+	}
+	d.selected = ch
+	return nil
+}
+
+// Channel is a downstream bus of the multiplexer.
+//
+// It implements i2c.BusCloser.
+type Channel struct {
+	d  *Dev
+	ch int
+}
+
+func (c *Channel) String() string {
+	return fmt.Sprintf("%s-%d", c.d, c.ch)
+}
+
+// Close implements i2c.BusCloser.
+//
+// It is a no-op; the parent bus is not closed.
+func (c *Channel) Close() error {
+	return nil
+}
+
+// Tx implements i2c.Bus.
+//
+// The channel is selected first if it wasn't the last one used.
+func (c *Channel) Tx(addr uint16, w, r []byte) error {
+	if addr == c.d.c.Addr {
+		return errors.New("tca9548a: address " + strconv.Itoa(int(addr)) + " is used by the multiplexer")
+	}
+	c.d.mu.Lock()
+	defer c.d.mu.Unlock()
+	if err := c.d.selectLocked(c.ch); err != nil {
+		return err
+	}
+	return c.d.c.Bus.Tx(addr, w, r)
+}
+
+// SetSpeed implements i2c.Bus.
+//
+// It changes the speed of the parent bus, thus affecting all the channels.
+func (c *Channel) SetSpeed(hz int64) error {
+	return c.d.c.Bus.SetSpeed(hz)
+}
+
+// SCL implements i2c.Pins.
+func (c *Channel) SCL() gpio.PinIO {
+	if p, ok := c.d.c.Bus.(i2c.Pins); ok {
+		return p.SCL()
+	}
+	return gpio.INVALID
+}
+
+// SDA implements i2c.Pins.
+func (c *Channel) SDA() gpio.PinIO {
+	if p, ok := c.d.c.Bus.(i2c.Pins); ok {
+		return p.SDA()
+	}
+	return gpio.INVALID
+}
+
+func (c *Channel) open() (i2c.BusCloser, error) {
+	return c, nil
+}
+
+var _ i2c.BusCloser = &Channel{}
+var _ i2c.Pins = &Channel{}
+var _ fmt.Stringer = &Channel{}
+var _ fmt.Stringer = &Dev{}
@@ -0,0 +1,187 @@
+// Copyright 2018 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package tca9548a
+
+import (
+	"testing"
+
+	"periph.io/x/periph/conn/gpio"
+	"periph.io/x/periph/conn/i2c/i2creg"
+	"periph.io/x/periph/conn/i2c/i2ctest"
+)
+
+func TestNew(t *testing.T) {
+	bus := i2ctest.Playback{
+		Ops: []i2ctest.IO{
+			{Addr: 0x70, W: []byte{0}},
+			// Channel 3 is selected once.
+			{Addr: 0x70, W: []byte{0x08}},
+			{Addr: 0x76, W: []byte{0xd0}, R: []byte{0x60}},
+			{Addr: 0x76, W: []byte{0xd0}, R: []byte{0x60}},
+			{Addr: 0x70, W: []byte{0x01}},
+			{Addr: 0x76, W: []byte{0xd0}, R: []byte{0x58}},
+			{Addr: 0x70, W: []byte{0}},
+		},
+	}
+	d, err := New(&bus, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := d.String(); s != "tca9548a{playback(112)}" {
+		t.Fatal(s)
+	}
+	if _, err := d.Channel(8); err == nil {
+		t.Fatal("invalid channel")
+	}
+	c3, err := d.Channel(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c0, err := d.Channel(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := c3.String(); s != "tca9548a{playback(112)}-3" {
+		t.Fatal(s)
+	}
+	var b [1]byte
+	for i := 0; i < 2; i++ {
+		if err := c3.Tx(0x76, []byte{0xd0}, b[:]); err != nil || b[0] != 0x60 {
+			t.Fatal(b, err)
+		}
+	}
+	if err := c0.Tx(0x76, []byte{0xd0}, b[:]); err != nil || b[0] != 0x58 {
+		t.Fatal(b, err)
+	}
+	if c0.Tx(0x70, nil, nil) == nil {
+		t.Fatal("address used by the multiplexer")
+	}
+	if err := c0.SetSpeed(100000); err != nil {
+		t.Fatal(err)
+	}
+	if p := c0.SCL(); p != nil {
+		t.Fatal(p)
+	}
+	if p := c0.SDA(); p != nil {
+		t.Fatal(p)
+	}
+	if err := c0.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Halt(); err != nil {
+		t.Fatal(err)
+	}
+	if err := bus.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNew_encoded(t *testing.T) {
+	bus := i2ctest.Playback{
+		Ops: []i2ctest.IO{
+			{Addr: 0x74, W: []byte{0}},
+			{Addr: 0x74, W: []byte{0x06}},
+			{Addr: 0x10, W: []byte{1}},
+		},
+		SCLPin: gpio.INVALID,
+	}
+	d, err := New(&bus, &Opts{Addr: 0x74, Channels: 4, Encoded: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := d.Channel(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Tx(0x10, []byte{1}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := bus.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNew_fail(t *testing.T) {
+	if _, err := New(&i2ctest.Playback{}, &Opts{Addr: 0x20}); err == nil {
+		t.Fatal("invalid address")
+	}
+	if _, err := New(&i2ctest.Playback{}, &Opts{Channels: 9}); err == nil {
+		t.Fatal("invalid channels")
+	}
+	if _, err := New(&i2ctest.Playback{}, &Opts{Channels: 8, Encoded: true}); err == nil {
+		t.Fatal("invalid channels")
+	}
+	if _, err := New(&i2ctest.Playback{DontPanic: true}, nil); err == nil {
+		t.Fatal("I/O failure")
+	}
+	bus := i2ctest.Playback{
+		Ops:       []i2ctest.IO{{Addr: 0x70, W: []byte{0}}},
+		DontPanic: true,
+	}
+	d, err := New(&bus, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := d.Channel(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Tx(0x10, nil, nil) == nil {
+		t.Fatal("I/O failure")
+	}
+	if d.selected != unknown {
+		t.Fatal(d.selected)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	bus := i2ctest.Playback{
+		Ops: []i2ctest.IO{
+			{Addr: 0x70, W: []byte{0}},
+			{Addr: 0x70, W: []byte{0x02}},
+			{Addr: 0x10, W: []byte{1}},
+		},
+	}
+	d, err := New(&bus, &Opts{Channels: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Register("MUX0"); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Unregister()
+	if d.Register("MUX0") == nil {
+		t.Fatal("already registered")
+	}
+	b, err := i2creg.Open("MUX0-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Tx(0x10, []byte{1}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Unregister(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := i2creg.Open("MUX0-1"); err == nil {
+		t.Fatal("unregistered")
+	}
+
+	// Conflicting name.
+	d2, err := New(&i2ctest.Playback{Ops: []i2ctest.IO{{Addr: 0x71, W: []byte{0}}}}, &Opts{Addr: 0x71})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d2.Register("MUX1"); err != nil {
+		t.Fatal(err)
+	}
+	defer d2.Unregister()
+	if d.Register("MUX1") == nil {
+		t.Fatal("name conflict")
+	}
+	if len(d.names) != 0 {
+		t.Fatal(d.names)
+	}
+}
@@ -8,13 +8,20 @@ package sysfs
 import (
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
+	"sync"
+	"time"
+	"unsafe"
 
+	"periph.io/x/periph"
 	"periph.io/x/periph/conn"
 	"periph.io/x/periph/conn/gpio"
 	"periph.io/x/periph/experimental/conn/uart"
+	"periph.io/x/periph/experimental/conn/uart/uartreg"
 )
 
 // EnumerateUART returns the available serial buses.
@@ -36,59 +43,297 @@ func EnumerateUART() ([]int, error) {
 	return out, nil
 }
 
-// UART is an open serial bus via sysfs.
+// NewUART opens a serial port via its tty device, e.g. "/dev/ttyS0" or
+// "/dev/ttyUSB0".
 //
-// TODO(maruel): It's not yet implemented. Should probably defer to an already
-// working library like https://github.com/tarm/serial
-type UART struct {
-	f         *os.File
-	busNumber int
-}
-
-func newUART(busNumber int) (*UART, error) {
-	// Use the devfs path for now.
-	f, err := os.OpenFile(fmt.Sprintf("/dev/ttyS%d", busNumber), os.O_RDWR, os.ModeExclusive)
+// The port is put in raw mode at 9600 bauds, 8N1, without flow control.
+//
+// Do not use sysfs.NewUART() directly; use
+// https://periph.io/x/periph/experimental/conn/uart/uartreg#Open instead.
+func NewUART(name string) (*UART, error) {
+	if !isLinux {
+		return nil, errors.New("sysfs-uart: is not supported on this platform")
+	}
+	f, err := os.OpenFile(name, os.O_RDWR|uartOpenFlags, 0)
 	if err != nil {
+		return nil, fmt.Errorf("sysfs-uart: %v", err)
+	}
+	u := &UART{f: f, name: name}
+	if err := u.init(); err != nil {
+		f.Close()
 		return nil, err
 	}
-	u := &UART{f: f, busNumber: busNumber}
 	return u, nil
 }
 
+// UART is an open serial port via its tty device.
+//
+// Read and Write can be called concurrently from different goroutines.
+type UART struct {
+	f    *os.File
+	name string
+
+	mu    sync.Mutex  // Serializes the termios changes.
+	wmu   sync.Mutex  // Serializes Write and the RS-485 configuration.
+	rs485 *uart.RS485 // Copy of the RS-485 configuration, nil when disabled.
+}
+
 // Close implements uart.ConnCloser.
+//
+// A pending Read or Write returns with an error.
 func (u *UART) Close() error {
-	err := u.f.Close()
-	u.f = nil
-	return err
+	if err := u.f.Close(); err != nil {
+		return fmt.Errorf("sysfs-uart: %v", err)
+	}
+	return nil
 }
 
 func (u *UART) String() string {
-	return "uart"
+	return u.name
+}
+
+// Speed implements uart.Conn.
+//
+// Only the standard speeds from 50 to 4000000 bauds are supported.
+func (u *UART) Speed(baud int) error {
+	b, ok := bauds[baud]
+	if !ok {
+		return fmt.Errorf("sysfs-uart: unsupported speed %d", baud)
+	}
+	return u.modify(func(t *termios) error {
+		t.cflag = t.cflag&^(cBAUD|cBAUDEX) | b
+		return nil
+	})
 }
 
 // Configure implements uart.Conn.
+//
+// uart.OneHalf stop bit is not supported.
 func (u *UART) Configure(stopBit uart.Stop, parity uart.Parity, bits int) error {
-	return errors.New("sysfs-uart: not implemented")
+	var c uint32
+	switch stopBit {
+	case uart.One:
+	case uart.Two:
+		c |= cSTOPB
+	default:
+		return fmt.Errorf("sysfs-uart: unsupported stop bit %d", stopBit)
+	}
+	switch parity {
+	case uart.None:
+	case uart.Odd:
+		c |= cPARENB | cPARODD
+	case uart.Even:
+		c |= cPARENB
+	case uart.Mark:
+		c |= cPARENB | cPARODD | cMSPAR
+	case uart.Space:
+		c |= cPARENB | cMSPAR
+	default:
+		return fmt.Errorf("sysfs-uart: unsupported parity %q", parity)
+	}
+	switch bits {
+	case 5:
+		c |= cS5
+	case 6:
+		c |= cS6
+	case 7:
+		c |= cS7
+	case 8:
+		c |= cS8
+	default:
+		return fmt.Errorf("sysfs-uart: unsupported %d bits per character", bits)
+	}
+	return u.modify(func(t *termios) error {
+		t.cflag = t.cflag&^(cSTOPB|cPARENB|cPARODD|cMSPAR|cSIZE) | c
+		if c&cPARENB != 0 {
+			t.iflag |= iINPCK
+		} else {
+			t.iflag &^= iINPCK
+		}
+		return nil
+	})
+}
+
+// SetFlow implements uart.FlowController.
+func (u *UART) SetFlow(f uart.Flow) error {
+	return u.modify(func(t *termios) error {
+		t.cflag &^= cRTSCTS
+		t.iflag &^= iIXON | iIXOFF | iIXANY
+		switch f {
+		case uart.NoFlow:
+		case uart.RTSCTS:
+			t.cflag |= cRTSCTS
+		case uart.XOnXOff:
+			t.iflag |= iIXON | iIXOFF
+		default:
+			return fmt.Errorf("sysfs-uart: unsupported flow control %s", f)
+		}
+		return nil
+	})
+}
+
+// SetRS485 implements uart.RS485Conn.
+//
+// When c.DE is nil, the kernel driver toggles RTS via the TIOCSRS485 ioctl;
+// the delays are then rounded up to the millisecond. Not all drivers support
+// it, in which case a GPIO pin connected to the transceiver must be specified
+// as c.DE.
+//
+// When c.DE is set, it is asserted in Write() and released once the output
+// is drained and c.DelayAfterSend elapsed.
+func (u *UART) SetRS485(c *uart.RS485) error {
+	if c != nil {
+		if c.DelayBeforeSend < 0 || c.DelayAfterSend < 0 {
+			return errors.New("sysfs-uart: invalid RS-485 delay")
+		}
+		if c.DE != nil && c.RxDuringTx {
+			return errors.New("sysfs-uart: RxDuringTx is not supported with a DE pin")
+		}
+	}
+	u.wmu.Lock()
+	defer u.wmu.Unlock()
+	if u.rs485 != nil && u.rs485.DE == nil && (c == nil || c.DE != nil) {
+		if err := u.setKernelRS485(nil); err != nil {
+			return err
+		}
+	}
+	if c == nil {
+		u.rs485 = nil
+		return nil
+	}
+	if c.DE == nil {
+		if err := u.setKernelRS485(c); err != nil {
+			return err
+		}
+	} else if err := c.DE.Out(rs485Level(c, false)); err != nil {
+		return fmt.Errorf("sysfs-uart: %v", err)
+	}
+	cc := *c
+	u.rs485 = &cc
+	return nil
 }
 
 // Duplex implements uart.Conn.
+//
+// It is conn.Half when the RS-485 mode is enabled.
 func (u *UART) Duplex() conn.Duplex {
-	return conn.DuplexUnknown
+	u.wmu.Lock()
+	defer u.wmu.Unlock()
+	if u.rs485 != nil {
+		return conn.Half
+	}
+	return conn.Full
+}
+
+// Read implements uart.Stream.
+//
+// It returns as soon as at least one byte is available.
+func (u *UART) Read(b []byte) (int, error) {
+	n, err := u.f.Read(b)
+	if err != nil {
+		err = wrapUARTErr(err)
+	}
+	return n, err
 }
 
-// Write implements uart.Conn.
+// Write implements uart.Stream.
 func (u *UART) Write(b []byte) (int, error) {
-	return 0, errors.New("sysfs-uart: not implemented")
+	u.wmu.Lock()
+	defer u.wmu.Unlock()
+	if u.rs485 != nil && u.rs485.DE != nil {
+		return u.writeDE(b)
+	}
+	n, err := u.f.Write(b)
+	if err != nil {
+		err = wrapUARTErr(err)
+	}
+	return n, err
+}
+
+// SetReadDeadline implements uart.Stream.
+func (u *UART) SetReadDeadline(t time.Time) error {
+	if err := u.f.SetReadDeadline(t); err != nil {
+		return fmt.Errorf("sysfs-uart: %v", err)
+	}
+	return nil
+}
+
+// SetWriteDeadline implements uart.Stream.
+func (u *UART) SetWriteDeadline(t time.Time) error {
+	if err := u.f.SetWriteDeadline(t); err != nil {
+		return fmt.Errorf("sysfs-uart: %v", err)
+	}
+	return nil
 }
 
 // Tx implements uart.Conn.
+//
+// It writes w then reads until r is full or the read deadline expires.
 func (u *UART) Tx(w, r []byte) error {
-	return errors.New("sysfs-uart: not implemented")
+	if len(w) != 0 {
+		if _, err := u.Write(w); err != nil {
+			return err
+		}
+	}
+	if len(r) != 0 {
+		if _, err := io.ReadFull(u, r); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// Speed implements uart.Conn.
-func (u *UART) Speed(baud int) error {
-	return errors.New("sysfs-uart: not implemented")
+// SendBreak implements uart.Breaker.
+func (u *UART) SendBreak(d time.Duration) error {
+	if d <= 0 {
+		return fmt.Errorf("sysfs-uart: invalid break duration %s", d)
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if err := u.ioctl(ioctlTIOCSBRK, 0); err != nil {
+		return fmt.Errorf("sysfs-uart: %v", err)
+	}
+	time.Sleep(d)
+	if err := u.ioctl(ioctlTIOCCBRK, 0); err != nil {
+		return fmt.Errorf("sysfs-uart: %v", err)
+	}
+	return nil
+}
+
+// ModemLines implements uart.Modem.
+func (u *UART) ModemLines() (uart.ModemLine, error) {
+	var v uint32
+	if err := u.ioctl(ioctlTIOCMGET, uintptr(unsafe.Pointer(&v))); err != nil {
+		return 0, fmt.Errorf("sysfs-uart: %v", err)
+	}
+	var l uart.ModemLine
+	for _, m := range modemLines {
+		if v&m.tiocm != 0 {
+			l |= m.line
+		}
+	}
+	return l, nil
+}
+
+// SetModemLines implements uart.Modem.
+func (u *UART) SetModemLines(l uart.ModemLine) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	var v uint32
+	if err := u.ioctl(ioctlTIOCMGET, uintptr(unsafe.Pointer(&v))); err != nil {
+		return fmt.Errorf("sysfs-uart: %v", err)
+	}
+	v &^= tiocmDTR | tiocmRTS
+	if l&uart.DTR != 0 {
+		v |= tiocmDTR
+	}
+	if l&uart.RTS != 0 {
+		v |= tiocmRTS
+	}
+	if err := u.ioctl(ioctlTIOCMSET, uintptr(unsafe.Pointer(&v))); err != nil {
+		return fmt.Errorf("sysfs-uart: %v", err)
+	}
+	return nil
 }
 
 // RX implements uart.Pins.
@@ -111,16 +356,345 @@ func (u *UART) CTS() gpio.PinIO {
 	return gpio.INVALID
 }
 
-var _ uart.Conn = &UART{}
+//
+
+// init puts the port in raw mode, like cfmakeraw(), at 9600 bauds 8N1.
+func (u *UART) init() error {
+	return u.modify(func(t *termios) error {
+		t.iflag &^= iIGNBRK | iBRKINT | iPARMRK | iISTRIP | iINLCR | iIGNCR | iICRNL | iIXON | iIXOFF | iIXANY | iINPCK
+		t.oflag &^= oOPOST
+		t.lflag &^= lECHO | lECHONL | lICANON | lISIG | lIEXTEN
+		t.cflag &^= cSIZE | cPARENB | cPARODD | cMSPAR | cSTOPB | cRTSCTS | cBAUD | cBAUDEX
+		t.cflag |= cS8 | cREAD | cLOCAL | bauds[9600]
+		t.cc[vMIN] = 1
+		t.cc[vTIME] = 0
+		return nil
+	})
+}
+
+// writeDE writes b with the transceiver driver enabled via the DE pin.
+//
+// The output is drained before releasing DE, so the last byte is not cut.
+func (u *UART) writeDE(b []byte) (int, error) {
+	c := u.rs485
+	if err := c.DE.Out(rs485Level(c, true)); err != nil {
+		return 0, fmt.Errorf("sysfs-uart: %v", err)
+	}
+	time.Sleep(c.DelayBeforeSend)
+	n, err := u.f.Write(b)
+	if err != nil {
+		err = wrapUARTErr(err)
+	} else if err = u.ioctl(ioctlTCSBRK, 1); err != nil {
+		err = fmt.Errorf("sysfs-uart: %v", err)
+	}
+	time.Sleep(c.DelayAfterSend)
+	if err2 := c.DE.Out(rs485Level(c, false)); err == nil && err2 != nil {
+		err = fmt.Errorf("sysfs-uart: %v", err2)
+	}
+	return n, err
+}
+
+// setKernelRS485 enables the RS-485 mode of the kernel driver with the
+// configuration c, or disables it when c is nil.
+func (u *UART) setKernelRS485(c *uart.RS485) error {
+	var s serialRS485
+	if c != nil {
+		s.flags = serRS485Enabled
+		if c.ActiveLow {
+			s.flags |= serRS485RTSAfterSend
+		} else {
+			s.flags |= serRS485RTSOnSend
+		}
+		if c.RxDuringTx {
+			s.flags |= serRS485RxDuringTx
+		}
+		s.delayRTSBeforeSend = uint32((c.DelayBeforeSend + time.Millisecond - 1) / time.Millisecond)
+		s.delayRTSAfterSend = uint32((c.DelayAfterSend + time.Millisecond - 1) / time.Millisecond)
+	}
+	if err := u.ioctl(ioctlTIOCSRS485, uintptr(unsafe.Pointer(&s))); err != nil {
+		return fmt.Errorf("sysfs-uart: kernel RS-485 mode: %v", err)
+	}
+	return nil
+}
+
+// rs485Level returns the level of DE to enable or disable the transceiver
+// driver.
+func rs485Level(c *uart.RS485, enabled bool) gpio.Level {
+	return gpio.Level(enabled != c.ActiveLow)
+}
+
+// modify reads the termios settings, calls fn and writes them back.
+func (u *UART) modify(fn func(t *termios) error) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	var t termios
+	if err := u.ioctl(ioctlTCGETS, uintptr(unsafe.Pointer(&t))); err != nil {
+		return fmt.Errorf("sysfs-uart: %v", err)
+	}
+	if err := fn(&t); err != nil {
+		return err
+	}
+	if err := u.ioctl(ioctlTCSETS, uintptr(unsafe.Pointer(&t))); err != nil {
+		return fmt.Errorf("sysfs-uart: %v", err)
+	}
+	return nil
+}
+
+// ioctl sends an ioctl without putting the file handle in blocking mode, so
+// the deadlines keep working.
+func (u *UART) ioctl(op uint, data uintptr) error {
+	rc, err := u.f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var errno error
+	if err := rc.Control(func(fd uintptr) { errno = ioctl(fd, op, data) }); err != nil {
+		return err
+	}
+	return errno
+}
+
+// wrapUARTErr adds the package prefix while keeping the Timeout() method of
+// deadline errors.
+func wrapUARTErr(err error) error {
+	if err == io.EOF {
+		return err
+	}
+	t, ok := err.(interface {
+		Timeout() bool
+	})
+	return &uartError{msg: "sysfs-uart: " + err.Error(), timeout: ok && t.Timeout()}
+}
+
+// uartError is an error returned by Read or Write.
+type uartError struct {
+	msg     string
+	timeout bool
+}
+
+func (e *uartError) Error() string {
+	return e.msg
+}
+
+// Timeout returns true if the deadline expired.
+func (e *uartError) Timeout() bool {
+	return e.timeout
+}
+
+// termios is struct termios as used by TCGETS and TCSETS.
+//
+// The speed is stored in cflag.
+type termios struct {
+	iflag uint32
+	oflag uint32
+	cflag uint32
+	lflag uint32
+	line  uint8
+	cc    [19]uint8
+}
+
+// tty ioctl control codes.
+//
+// Constants can be found at /usr/include/asm-generic/ioctls.h.
+const (
+	ioctlTCGETS     = 0x5401
+	ioctlTCSETS     = 0x5402
+	ioctlTCSBRK     = 0x5409 // With 1 as argument, it is tcdrain().
+	ioctlTIOCMGET   = 0x5415
+	ioctlTIOCMSET   = 0x5418
+	ioctlTIOCSBRK   = 0x5427
+	ioctlTIOCCBRK   = 0x5428
+	ioctlTIOCSRS485 = 0x542F
+)
+
+// serialRS485 is struct serial_rs485 as used by TIOCSRS485.
+//
+// Definition can be found at /usr/include/linux/serial.h.
+type serialRS485 struct {
+	flags              uint32
+	delayRTSBeforeSend uint32 // In ms.
+	delayRTSAfterSend  uint32 // In ms.
+	padding            [5]uint32
+}
+
+// serialRS485 flags.
+const (
+	serRS485Enabled      = 1 << 0
+	serRS485RTSOnSend    = 1 << 1
+	serRS485RTSAfterSend = 1 << 2
+	serRS485RxDuringTx   = 1 << 4
+)
+
+// termios flags.
+//
+// Constants can be found at /usr/include/asm-generic/termbits.h.
+const (
+	iIGNBRK = 0000001
+	iBRKINT = 0000002
+	iPARMRK = 0000010
+	iINPCK  = 0000020
+	iISTRIP = 0000040
+	iINLCR  = 0000100
+	iIGNCR  = 0000200
+	iICRNL  = 0000400
+	iIXON   = 0002000
+	iIXANY  = 0004000
+	iIXOFF  = 0010000
+
+	oOPOST = 0000001
+
+	cBAUD   = 0010017
+	cSIZE   = 0000060
+	cS5     = 0000000
+	cS6     = 0000020
+	cS7     = 0000040
+	cS8     = 0000060
+	cSTOPB  = 0000100
+	cREAD   = 0000200
+	cPARENB = 0000400
+	cPARODD = 0001000
+	cLOCAL  = 0004000
+	cBAUDEX = 0010000
+	cMSPAR  = 010000000000
+	cRTSCTS = 020000000000
+
+	lISIG   = 0000001
+	lICANON = 0000002
+	lECHO   = 0000010
+	lECHONL = 0000100
+	lIEXTEN = 0100000
+
+	vTIME = 5
+	vMIN  = 6
+)
+
+// Modem lines as used by TIOCMGET and TIOCMSET.
+const (
+	tiocmDTR = 0x002
+	tiocmRTS = 0x004
+	tiocmCTS = 0x020
+	tiocmCAR = 0x040
+	tiocmRNG = 0x080
+	tiocmDSR = 0x100
+)
+
+var modemLines = []struct {
+	tiocm uint32
+	line  uart.ModemLine
+}{
+	{tiocmDTR, uart.DTR},
+	{tiocmRTS, uart.RTS},
+	{tiocmCTS, uart.CTS},
+	{tiocmCAR, uart.DCD},
+	{tiocmDSR, uart.DSR},
+	{tiocmRNG, uart.RI},
+}
+
+// bauds maps the speeds to their cflag value.
+var bauds = map[int]uint32{
+	50:      0000001,
+	75:      0000002,
+	110:     0000003,
+	134:     0000004,
+	150:     0000005,
+	200:     0000006,
+	300:     0000007,
+	600:     0000010,
+	1200:    0000011,
+	1800:    0000012,
+	2400:    0000013,
+	4800:    0000014,
+	9600:    0000015,
+	19200:   0000016,
+	38400:   0000017,
+	57600:   0010001,
+	115200:  0010002,
+	230400:  0010003,
+	460800:  0010004,
+	500000:  0010005,
+	576000:  0010006,
+	921600:  0010007,
+	1000000: 0010010,
+	1152000: 0010011,
+	1500000: 0010012,
+	2000000: 0010013,
+	2500000: 0010014,
+	3000000: 0010015,
+	3500000: 0010016,
+	4000000: 0010017,
+}
 
 // driverUART implements periph.Driver.
 type driverUART struct {
+	ports []string
 }
 
 func (d *driverUART) String() string {
 	return "sysfs-uart"
 }
 
+func (d *driverUART) Prerequisites() []string {
+	return nil
+}
+
+func (d *driverUART) After() []string {
+	return nil
+}
+
 func (d *driverUART) Init() (bool, error) {
+	numbers := map[int]bool{}
+	for _, prefix := range uartPrefixes {
+		items, err := filepath.Glob(prefix + "*")
+		if err != nil {
+			return true, err
+		}
+		// Make sure they are registered in order.
+		sort.Strings(items)
+		for _, item := range items {
+			// The port number is the device number, unless already taken by a port
+			// with a prefix listed earlier.
+			n := -1
+			if i, err := strconv.Atoi(item[len(prefix):]); err == nil && !numbers[i] {
+				n = i
+				numbers[i] = true
+			}
+			d.ports = append(d.ports, item)
+			if err := uartreg.Register(item, nil, n, openerUART(item).Open); err != nil {
+				return true, err
+			}
+		}
+	}
+	if len(d.ports) == 0 {
+		return false, errors.New("no serial port found")
+	}
 	return true, nil
 }
+
+// uartPrefixes are the tty devices considered, in order of preference for the
+// port numbers.
+var uartPrefixes = []string{"/dev/ttyAMA", "/dev/ttyS", "/dev/ttyUSB", "/dev/ttyACM"}
+
+type openerUART string
+
+func (o openerUART) Open() (uart.ConnCloser, error) {
+	u, err := NewUART(string(o))
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func init() {
+	if isLinux {
+		periph.MustRegister(&driverUART{})
+	}
+}
+
+var _ uart.Breaker = &UART{}
+var _ uart.ConnCloser = &UART{}
+var _ uart.FlowController = &UART{}
+var _ uart.Modem = &UART{}
+var _ uart.Pins = &UART{}
+var _ uart.RS485Conn = &UART{}
+var _ uart.Stream = &UART{}
+var _ fmt.Stringer = &UART{}
@@ -0,0 +1,21 @@
+// Copyright 2018 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package sysfs
+
+import "syscall"
+
+const isLinux = true
+
+// uartOpenFlags makes sure the port doesn't become the controlling terminal
+// and that the file handle uses the runtime poller, which is needed for the
+// deadlines.
+const uartOpenFlags = syscall.O_NOCTTY | syscall.O_NONBLOCK
+
+func ioctl(f uintptr, op uint, arg uintptr) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f, uintptr(op), arg); errno != 0 {
+		return syscall.Errno(errno)
+	}
+	return nil
+}
@@ -0,0 +1,377 @@
+// Copyright 2018 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package sysfs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"testing"
+	"time"
+	"unsafe"
+
+	"periph.io/x/periph/conn"
+	"periph.io/x/periph/conn/gpio"
+	"periph.io/x/periph/conn/gpio/gpiotest"
+	"periph.io/x/periph/experimental/conn/uart"
+)
+
+func TestNewUART(t *testing.T) {
+	if u, err := NewUART("/dev/ttyDoesNotExist"); u != nil || err == nil {
+		t.Fatal("invalid port")
+	}
+}
+
+func TestUART_Stream(t *testing.T) {
+	u, m := newPTY(t)
+	defer m.Close()
+	defer u.Close()
+	if s := u.String(); s == "" {
+		t.Fatal(s)
+	}
+	if d := u.Duplex(); d != conn.Full {
+		t.Fatal(d)
+	}
+
+	// Raw mode: no translation of CR and NL.
+	if _, err := m.Write([]byte("a\rb\n")); err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 4)
+	if _, err := io.ReadFull(u, b); err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "a\rb\n" {
+		t.Fatalf("%q", b)
+	}
+	if _, err := u.Write([]byte("c\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(m, b[:2]); err != nil {
+		t.Fatal(err)
+	}
+	if string(b[:2]) != "c\n" {
+		t.Fatalf("%q", b[:2])
+	}
+
+	// Tx.
+	if _, err := m.Write([]byte("de")); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Tx([]byte("f"), b[:2]); err != nil {
+		t.Fatal(err)
+	}
+	if string(b[:2]) != "de" {
+		t.Fatalf("%q", b[:2])
+	}
+	if _, err := io.ReadFull(m, b[:1]); err != nil || b[0] != 'f' {
+		t.Fatal(err, b[0])
+	}
+
+	// Deadline.
+	if err := u.SetReadDeadline(time.Now().Add(10 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	_, err := u.Read(b)
+	if e, ok := err.(interface {
+		Timeout() bool
+	}); !ok || !e.Timeout() {
+		t.Fatal(err)
+	}
+	if err := u.Tx(nil, b); err == nil {
+		t.Fatal("deadline expired")
+	}
+	if err := u.SetReadDeadline(time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.SetWriteDeadline(time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Tx([]byte("g"), nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUART_Close(t *testing.T) {
+	u, m := newPTY(t)
+	defer m.Close()
+	done := make(chan error)
+	go func() {
+		_, err := u.Read(make([]byte, 1))
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if err := u.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err == nil {
+		t.Fatal("read after close")
+	}
+	if err := u.Close(); err == nil {
+		t.Fatal("double close")
+	}
+}
+
+func TestUART_termios(t *testing.T) {
+	u, m := newPTY(t)
+	defer m.Close()
+	defer u.Close()
+	tios := getTermios(t, u)
+	if tios.cflag&(cBAUD|cSIZE|cPARENB|cSTOPB|cRTSCTS) != bauds[9600]|cS8 {
+		t.Fatalf("0%o", tios.cflag)
+	}
+	if tios.lflag&(lICANON|lECHO) != 0 || tios.cc[vMIN] != 1 {
+		t.Fatalf("%#v", tios)
+	}
+
+	if err := u.Speed(115200); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Speed(12345); err == nil {
+		t.Fatal("unsupported speed")
+	}
+	if err := u.Configure(uart.Two, uart.Odd, 7); err != nil {
+		t.Fatal(err)
+	}
+	tios = getTermios(t, u)
+	// A pseudo-terminal forces CS8 and drops PARENB, so they are not checked.
+	if tios.cflag&(cBAUD|cPARODD|cSTOPB) != bauds[115200]|cPARODD|cSTOPB {
+		t.Fatalf("0%o", tios.cflag)
+	}
+	if tios.iflag&iINPCK == 0 {
+		t.Fatalf("0%o", tios.iflag)
+	}
+	if err := u.Configure(uart.One, uart.None, 8); err != nil {
+		t.Fatal(err)
+	}
+	tios = getTermios(t, u)
+	if tios.cflag&(cSIZE|cPARENB|cSTOPB) != cS8 || tios.iflag&iINPCK != 0 {
+		t.Fatalf("%#v", tios)
+	}
+	bad := []struct {
+		s uart.Stop
+		p uart.Parity
+		b int
+	}{
+		{uart.OneHalf, uart.None, 8},
+		{uart.One, 'X', 8},
+		{uart.One, uart.None, 9},
+	}
+	for i, l := range bad {
+		if err := u.Configure(l.s, l.p, l.b); err == nil {
+			t.Fatalf("#%d: invalid configuration", i)
+		}
+	}
+
+	if err := u.SetFlow(uart.XOnXOff); err != nil {
+		t.Fatal(err)
+	}
+	tios = getTermios(t, u)
+	if tios.iflag&(iIXON|iIXOFF) != iIXON|iIXOFF || tios.cflag&cRTSCTS != 0 {
+		t.Fatalf("%#v", tios)
+	}
+	if err := u.SetFlow(uart.NoFlow); err != nil {
+		t.Fatal(err)
+	}
+	tios = getTermios(t, u)
+	if tios.iflag&(iIXON|iIXOFF) != 0 {
+		t.Fatalf("%#v", tios)
+	}
+	if err := u.SetFlow(uart.Flow(10)); err == nil {
+		t.Fatal("invalid flow control")
+	}
+}
+
+func TestUART_Break(t *testing.T) {
+	u, m := newPTY(t)
+	defer m.Close()
+	defer u.Close()
+	if err := u.SendBreak(0); err == nil {
+		t.Fatal("invalid duration")
+	}
+	if err := u.SendBreak(time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUART_Modem(t *testing.T) {
+	u, m := newPTY(t)
+	defer m.Close()
+	defer u.Close()
+	// A pseudo-terminal has no modem lines.
+	if _, err := u.ModemLines(); err == nil {
+		t.Fatal("pty has no modem lines")
+	}
+	if err := u.SetModemLines(uart.DTR | uart.RTS); err == nil {
+		t.Fatal("pty has no modem lines")
+	}
+}
+
+func TestUART_RS485_DE(t *testing.T) {
+	u, m := newPTY(t)
+	defer m.Close()
+	defer u.Close()
+	de := &recordPin{Pin: gpiotest.Pin{N: "DE", L: gpio.High}}
+	c := &uart.RS485{DE: de, DelayBeforeSend: 5 * time.Millisecond, DelayAfterSend: 10 * time.Millisecond}
+	if err := u.SetRS485(c); err != nil {
+		t.Fatal(err)
+	}
+	// The configuration is copied.
+	c.DelayAfterSend = 0
+	if d := u.Duplex(); d != conn.Half {
+		t.Fatal(d)
+	}
+	if err := u.Tx([]byte("ab"), nil); err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 2)
+	if _, err := io.ReadFull(m, b); err != nil || string(b) != "ab" {
+		t.Fatal(err, b)
+	}
+	if len(de.levels) != 3 || de.levels[0] != gpio.Low || de.levels[1] != gpio.High || de.levels[2] != gpio.Low {
+		t.Fatal(de.levels)
+	}
+	if d := de.times[2].Sub(de.times[1]); d < 15*time.Millisecond {
+		t.Fatal(d)
+	}
+
+	// Active low.
+	de.levels = nil
+	de.times = nil
+	if err := u.SetRS485(&uart.RS485{DE: de, ActiveLow: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := u.Write([]byte("c")); err != nil {
+		t.Fatal(err)
+	}
+	if len(de.levels) != 3 || de.levels[0] != gpio.High || de.levels[1] != gpio.Low || de.levels[2] != gpio.High {
+		t.Fatal(de.levels)
+	}
+
+	if err := u.SetRS485(nil); err != nil {
+		t.Fatal(err)
+	}
+	if d := u.Duplex(); d != conn.Full {
+		t.Fatal(d)
+	}
+	if _, err := u.Write([]byte("d")); err != nil {
+		t.Fatal(err)
+	}
+	if len(de.levels) != 3 {
+		t.Fatal(de.levels)
+	}
+
+	bad := []*uart.RS485{
+		{DE: de, DelayBeforeSend: -1},
+		{DE: de, DelayAfterSend: -1},
+		{DE: de, RxDuringTx: true},
+	}
+	for i, c := range bad {
+		if err := u.SetRS485(c); err == nil {
+			t.Fatalf("#%d: invalid configuration", i)
+		}
+	}
+}
+
+func TestUART_RS485_kernel(t *testing.T) {
+	u, m := newPTY(t)
+	defer m.Close()
+	defer u.Close()
+	// A pseudo-terminal doesn't support the kernel RS-485 mode.
+	if err := u.SetRS485(&uart.RS485{}); err == nil {
+		t.Fatal("pty doesn't support TIOCSRS485")
+	}
+	if d := u.Duplex(); d != conn.Full {
+		t.Fatal(d)
+	}
+}
+
+func TestUART_Pins(t *testing.T) {
+	u := &UART{}
+	if u.RX().String() != "INVALID" || u.TX().String() != "INVALID" || u.RTS().String() != "INVALID" || u.CTS().String() != "INVALID" {
+		t.Fatal("no pins")
+	}
+}
+
+func TestWrapUARTErr(t *testing.T) {
+	if err := wrapUARTErr(io.EOF); err != io.EOF {
+		t.Fatal(err)
+	}
+	err := wrapUARTErr(os.ErrClosed)
+	if e, ok := err.(*uartError); !ok || e.Timeout() || e.Error() != "sysfs-uart: file already closed" {
+		t.Fatal(err)
+	}
+}
+
+func TestDriverUART(t *testing.T) {
+	d := driverUART{}
+	if s := d.String(); s != "sysfs-uart" {
+		t.Fatal(s)
+	}
+	if d.Prerequisites() != nil || d.After() != nil {
+		t.Fatal("unexpected dependencies")
+	}
+}
+
+//
+
+// newPTY opens a pseudo-terminal pair and returns the UART for the slave side
+// and the master side.
+func newPTY(t *testing.T) (*UART, *os.File) {
+	if !isLinux {
+		t.Skip("pseudo-terminals are only tested on linux")
+	}
+	m, err := os.OpenFile("/dev/ptmx", os.O_RDWR|uartOpenFlags, 0)
+	if err != nil {
+		t.Skip(err)
+	}
+	var n uint32
+	rc, err := m.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var errno error
+	err = rc.Control(func(fd uintptr) {
+		if errno = ioctl(fd, ioctlTIOCSPTLCK, uintptr(unsafe.Pointer(&n))); errno == nil {
+			errno = ioctl(fd, ioctlTIOCGPTN, uintptr(unsafe.Pointer(&n)))
+		}
+	})
+	if err != nil || errno != nil {
+		m.Close()
+		t.Fatal(err, errno)
+	}
+	u, err := NewUART(fmt.Sprintf("/dev/pts/%d", n))
+	if err != nil {
+		m.Close()
+		t.Fatal(err)
+	}
+	return u, m
+}
+
+// recordPin records the levels set and when.
+type recordPin struct {
+	gpiotest.Pin
+	levels []gpio.Level
+	times  []time.Time
+}
+
+func (r *recordPin) Out(l gpio.Level) error {
+	r.levels = append(r.levels, l)
+	r.times = append(r.times, time.Now())
+	return r.Pin.Out(l)
+}
+
+func getTermios(t *testing.T, u *UART) termios {
+	var tios termios
+	if err := u.ioctl(ioctlTCGETS, uintptr(unsafe.Pointer(&tios))); err != nil {
+		t.Fatal(err)
+	}
+	return tios
+}
+
+const (
+	ioctlTIOCGPTN   = 0x80045430
+	ioctlTIOCSPTLCK = 0x40045431
+)
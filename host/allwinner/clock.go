@@ -41,6 +41,18 @@ const (
 	clockSPIDiv16b clockSPI = 15 << 0 //
 )
 
+const (
+	clockAHBGatingSPI2 clockAHBGating0 = 1 << 22 // SPI2_AHB_GATING
+	clockAHBGatingSPI1 clockAHBGating0 = 1 << 21 // SPI1_AHB_GATING
+	clockAHBGatingSPI0 clockAHBGating0 = 1 << 20 // SPI0_AHB_GATING
+	clockAHBGatingDMA  clockAHBGating0 = 1 << 6  // DMA_AHB_GATING
+)
+
+// AHB_GATING_REG0
+//
+// R8 only; the A64 uses a different layout.
+type clockAHBGating0 uint32
+
 // Also valid for IR.
 //
 // SPI0_SCLK_CFG_REG / SPI1_SCLK_CFG_REG / SPI2_SCLK_CFG_REG / IR_SCLK_CFG_REG
@@ -107,10 +119,12 @@ type clockPLL6R8Ctl uint32
 
 // clockMap is the mapping of important registers across CPUs.
 type clockMap struct {
-	reserved0 [0xA0 / 4]uint32 //
-	spi0Clk   clockSPI         // 0x0A0 SPI0_SCLK_CFG_REG SPI0 Clock
-	spi1Clk   clockSPI         // 0x0A4 SPI1_SCLK_CFG_REG SPI1 Clock
-	spi2Clk   clockSPI         // 0x0A8 SPI2_SCLK_CFG_REG SPI2 Clock (Not on A64)
+	reserved0  [0x60 / 4]uint32 //
+	ahbGating0 clockAHBGating0  // 0x060 AHB_GATING_REG0 AHB Module Clock Gating 0
+	reserved1  [0x3C / 4]uint32 //
+	spi0Clk    clockSPI         // 0x0A0 SPI0_SCLK_CFG_REG SPI0 Clock
+	spi1Clk    clockSPI         // 0x0A4 SPI1_SCLK_CFG_REG SPI1 Clock
+	spi2Clk    clockSPI         // 0x0A8 SPI2_SCLK_CFG_REG SPI2 Clock (Not on A64)
 }
 
 // R8: Page 57-59.
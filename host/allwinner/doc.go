@@ -8,6 +8,12 @@
 // This driver implements memory-mapped GPIO pin manipulation and leverages
 // sysfs-gpio for edge detection.
 //
+// On the R8, it also implements a memory-mapped SPI driver that replaces the
+// ports registered by sysfs-spi, under the same names. The buses where a kernel
+// driver other than spidev is bound to a device are left to the kernel. The
+// kernel is not told about the replacement, so the /dev/spidev* files of a
+// replaced bus must not be used by another process at the same time.
+//
 // If you are looking at the actual implementation, open doc.go for further
 // implementation details.
 //
@@ -8,6 +8,7 @@
 package allwinner
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
@@ -19,6 +20,7 @@ import (
 	"periph.io/x/periph"
 	"periph.io/x/periph/conn/gpio"
 	"periph.io/x/periph/conn/gpio/gpioreg"
+	"periph.io/x/periph/conn/pin"
 	"periph.io/x/periph/host/pmem"
 	"periph.io/x/periph/host/sysfs"
 )
@@ -116,6 +118,42 @@ func (p *Pin) Function() string {
 	}
 }
 
+// SetFunction implements pin.PinFunc.
+//
+// f must be a value previously returned by Function(), e.g. "I2C0_SDA" or
+// "<Alt2>".
+func (p *Pin) SetFunction(f string) error {
+	if !p.available {
+		// We do not want the error message about uninitialized system.
+		return p.wrap(errors.New("not available on this CPU architecture"))
+	}
+	if gpioMemory == nil {
+		return p.wrap(errors.New("subsystem not initialized"))
+	}
+	switch {
+	case strings.HasPrefix(f, "In/"):
+		return p.In(gpio.PullNoChange, gpio.NoEdge)
+	case strings.HasPrefix(f, "Out/"):
+		return p.Out(f == "Out/"+gpio.High.String())
+	case f == "<Disabled>":
+		if err := p.Halt(); err != nil {
+			return err
+		}
+		p.setFunction(disabled)
+		return nil
+	}
+	for i, a := range [...]function{alt1, alt2, alt3, alt4, alt5} {
+		if (f != "" && f == p.altFunc[i]) || f == "<Alt"+strconv.Itoa(i+1)+">" {
+			if err := p.Halt(); err != nil {
+				return err
+			}
+			p.setFunction(a)
+			return nil
+		}
+	}
+	return p.wrap(errors.New("unknown function " + strconv.Quote(f)))
+}
+
 // Halt implements conn.Resource.
 //
 // It stops edge detection if enabled.
@@ -202,6 +240,29 @@ func (p *Pin) WaitForEdge(timeout time.Duration) bool {
 	return false
 }
 
+// ReadEdge waits for an edge as previously set using In() or the expiration
+// of a timeout and returns the edge event.
+//
+// The edge detection is done via gpio sysfs, which doesn't timestamp the
+// edges. See sysfs.Pin.ReadEdge for the details.
+func (p *Pin) ReadEdge(timeout time.Duration) (gpio.EdgeEvent, bool) {
+	if p.edge != nil {
+		return p.edge.ReadEdge(timeout)
+	}
+	return gpio.EdgeEvent{}, false
+}
+
+// WaitForEdgeContext waits for an edge as previously set using In() or until
+// ctx is done and implements gpio.PinInContext.
+//
+// The edge detection is done via gpio sysfs.
+func (p *Pin) WaitForEdgeContext(ctx context.Context) bool {
+	if p.edge != nil {
+		return p.edge.WaitForEdgeContext(ctx)
+	}
+	return false
+}
+
 // Pull returns the current pull-up/down registor setting.
 func (p *Pin) Pull() gpio.Pull {
 	if gpioMemory == nil || !p.available {
@@ -238,6 +299,33 @@ func (p *Pin) Out(l gpio.Level) error {
 	return nil
 }
 
+// Group implements gpio.PinGrouper.
+//
+// All the pins must be available Allwinner pins from the groups PB to PH. The
+// pins of each group are set with a single write to the data register and read
+// with a single read operation.
+//
+// Since the data register has no atomic set and clear operation, Out() must
+// not be called concurrently with an output change on another pin of the same
+// group.
+func (p *Pin) Group(pins []gpio.PinIO) (gpio.Group, error) {
+	if gpioMemory == nil {
+		return nil, p.wrap(errors.New("subsystem not initialized"))
+	}
+	g := &pinGroup{pins: pins, cpu: make([]*Pin, len(pins))}
+	for i, pin := range pins {
+		c, ok := pin.(*Pin)
+		if !ok {
+			return nil, p.wrap(fmt.Errorf("%s is not an Allwinner pin", pin))
+		}
+		if !c.available {
+			return nil, p.wrap(fmt.Errorf("%s is not available on this CPU architecture", c))
+		}
+		g.cpu[i] = c
+	}
+	return g, nil
+}
+
 // FastOut sets a pin output level with Absolutely No error checking.
 //
 // Out() Must be called once first before calling FastOut(), otherwise the
@@ -653,6 +741,54 @@ type gpioGroup struct {
 	pull [2]uint32
 }
 
+// pinGroup implements gpio.Group for Allwinner pins.
+type pinGroup struct {
+	pins []gpio.PinIO
+	cpu  []*Pin
+}
+
+func (g *pinGroup) Pins() []gpio.PinIO {
+	return g.pins
+}
+
+func (g *pinGroup) Out(bits uint64) error {
+	var set, mask [len(gpioMap{}.groups)]uint32
+	for i, p := range g.cpu {
+		l := gpio.Level(bits&(1<<uint(i)) != 0)
+		if p.function() != out {
+			if err := p.Out(l); err != nil {
+				return err
+			}
+		}
+		mask[p.group] |= 1 << p.offset
+		if l == gpio.High {
+			set[p.group] |= 1 << p.offset
+		}
+	}
+	for i := range mask {
+		if mask[i] != 0 {
+			gpioMemory.groups[i].data = (gpioMemory.groups[i].data &^ mask[i]) | set[i]
+		}
+	}
+	return nil
+}
+
+func (g *pinGroup) Read() uint64 {
+	var data [len(gpioMap{}.groups)]uint32
+	var read [len(gpioMap{}.groups)]bool
+	var out uint64
+	for i, p := range g.cpu {
+		if !read[p.group] {
+			data[p.group] = gpioMemory.groups[p.group].data
+			read[p.group] = true
+		}
+		if data[p.group]&(1<<p.offset) != 0 {
+			out |= 1 << uint(i)
+		}
+	}
+	return out
+}
+
 // gpioMap memory-maps all the gpio pin groups.
 type gpioMap struct {
 	// PB to PH. The first group is unused.
@@ -672,7 +808,7 @@ func (d *driverGPIO) Prerequisites() []string {
 }
 
 func (d *driverGPIO) After() []string {
-	return []string{"sysfs-gpio"}
+	return []string{"sysfs-gpio", "sysfs-gpiochip"}
 }
 
 // Init does nothing if an allwinner processor is not detected. If one is
@@ -737,6 +873,11 @@ func getBaseAddress() uint64 {
 // Ensure that the various structs implement the interfaces they're supposed to.
 
 var _ gpio.PinDefaultPull = &Pin{}
+var _ gpio.PinEdgeEvents = &Pin{}
+var _ gpio.PinGrouper = &Pin{}
+var _ gpio.PinInContext = &Pin{}
 var _ gpio.PinIO = &Pin{}
 var _ gpio.PinIn = &Pin{}
 var _ gpio.PinOut = &Pin{}
+var _ gpio.Group = &pinGroup{}
+var _ pin.PinFunc = &Pin{}
@@ -5,6 +5,7 @@
 package allwinner
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
@@ -162,6 +163,24 @@ func (p *PinPL) WaitForEdge(timeout time.Duration) bool {
 	return false
 }
 
+// ReadEdge implements gpio.PinEdgeEvents. See Pin.ReadEdge for more
+// information.
+func (p *PinPL) ReadEdge(timeout time.Duration) (gpio.EdgeEvent, bool) {
+	if p.edge != nil {
+		return p.edge.ReadEdge(timeout)
+	}
+	return gpio.EdgeEvent{}, false
+}
+
+// WaitForEdgeContext implements gpio.PinInContext. See Pin.WaitForEdgeContext
+// for more information.
+func (p *PinPL) WaitForEdgeContext(ctx context.Context) bool {
+	if p.edge != nil {
+		return p.edge.WaitForEdgeContext(ctx)
+	}
+	return false
+}
+
 // Pull implements gpio.PinIn. See Pin.Pull for more information.
 func (p *PinPL) Pull() gpio.Pull {
 	if gpioMemoryPL == nil {
@@ -406,6 +425,8 @@ func init() {
 }
 
 var _ gpio.PinDefaultPull = &Pin{}
+var _ gpio.PinEdgeEvents = &PinPL{}
+var _ gpio.PinInContext = &PinPL{}
 var _ gpio.PinIO = &PinPL{}
 var _ gpio.PinIn = &PinPL{}
 var _ gpio.PinOut = &PinPL{}
@@ -0,0 +1,61 @@
+// Copyright 2018 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package allwinner
+
+import (
+	"testing"
+
+	"periph.io/x/periph/conn/gpio"
+)
+
+func TestPin_Group(t *testing.T) {
+	defer func(old *gpioMap) { gpioMemory = old }(gpioMemory)
+	gpioMemory = nil
+	p0 := &Pin{group: 1, offset: 2, name: "PB2", available: true}
+	p1 := &Pin{group: 3, offset: 5, name: "PD5", available: true}
+	p2 := &Pin{group: 3, offset: 7, name: "PD7", available: true}
+	if _, err := p0.Group([]gpio.PinIO{p0, p1}); err == nil {
+		t.Fatal("not initialized")
+	}
+	gpioMemory = &gpioMap{}
+	if _, err := p0.Group([]gpio.PinIO{p0, gpio.INVALID}); err == nil {
+		t.Fatal("not an allwinner pin")
+	}
+	if _, err := p0.Group([]gpio.PinIO{p0, &Pin{group: 1, offset: 3, name: "PB3"}}); err == nil {
+		t.Fatal("not available")
+	}
+	g, err := gpio.NewGroup(p0, p1, p2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := g.(*pinGroup); !ok {
+		t.Fatal("expected allwinner group")
+	}
+	if l := g.Pins(); len(l) != 3 || l[1] != p1 {
+		t.Fatal(l)
+	}
+	// Bits of other pins in the groups are left alone.
+	gpioMemory.groups[1].data = 1 << 8
+	gpioMemory.groups[3].data = 1<<5 | 1<<9
+	if err := g.Out(5); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []*Pin{p0, p1, p2} {
+		if f := p.function(); f != out {
+			t.Fatal(p, f)
+		}
+	}
+	if d := gpioMemory.groups[1].data; d != 1<<8|1<<2 {
+		t.Fatalf("%#x", d)
+	}
+	if d := gpioMemory.groups[3].data; d != 1<<9|1<<7 {
+		t.Fatalf("%#x", d)
+	}
+	gpioMemory.groups[1].data = 0
+	gpioMemory.groups[3].data = 1 << 5
+	if v := g.Read(); v != 2 {
+		t.Fatal(v)
+	}
+}
@@ -7,17 +7,175 @@ package allwinner
 import (
 	"errors"
 	"fmt"
-	"log"
+	"os"
+	"path/filepath"
+	"sync"
 	"time"
+	"unsafe"
+
+	"periph.io/x/periph"
+	"periph.io/x/periph/conn"
+	"periph.io/x/periph/conn/gpio"
+	"periph.io/x/periph/conn/spi"
+	"periph.io/x/periph/conn/spi/spireg"
+	"periph.io/x/periph/host/pmem"
 )
 
+// NewSPI returns a SPI port driving the CPU SPI controller directly, without
+// going through spidev.
+//
+// bus is the controller number and cs the chip select line, e.g. 2 and 1 for
+// the pin SPI2_CS1. Only the R8 controller is supported.
+//
+// Transfers are done through the controller FIFO. When a transfer is larger
+// than the FIFO and the DMA controller is accessible, the dedicated DMA
+// channels are used instead. In both cases, a transfer is not limited by the
+// spidev buffer size.
+//
+// The chip selects of a bus share the controller; their ports serialize their
+// transactions. The kernel is not told about it, so the controller must not be
+// used via spidev at the same time.
+func NewSPI(bus, cs int) (*SPI, error) {
+	if spiMemory == nil || clockMemory == nil {
+		return nil, errors.New("allwinner-spi: subsystem not initialized")
+	}
+	if bus < 0 || bus >= len(spiMemory.groups) {
+		return nil, fmt.Errorf("allwinner-spi: invalid bus %d", bus)
+	}
+	if cs < 0 || cs > 3 {
+		return nil, fmt.Errorf("allwinner-spi: invalid chip select %d", cs)
+	}
+	return &SPI{bus: bus, cs: cs, r: &spiMemory.groups[bus], b: &spiBuses[bus]}, nil
+}
+
+// SPI is a SPI port driven by the CPU SPI controller.
+//
+// It implements spi.PortCloser.
+type SPI struct {
+	// Immutable.
+	bus int
+	cs  int
+	r   spiR8Regs
+	b   *spiBus
+
+	// Protected by b.mu.
+	initialized bool
+	maxHzPort   int64
+	maxHzDev    int64
+	mode        spi.Mode
+}
+
+func (s *SPI) String() string {
+	return fmt.Sprintf("SPI%d.%d", s.bus, s.cs)
+}
+
+// Close implements spi.PortCloser.
+//
+// It releases CS if it was left asserted by this port, and disables the
+// controller once all the ports of the bus are closed.
+func (s *SPI) Close() error {
+	s.b.mu.Lock()
+	defer s.b.mu.Unlock()
+	if !s.initialized {
+		return nil
+	}
+	if s.b.active == s {
+		s.releaseLocked()
+	}
+	s.initialized = false
+	if s.b.connected--; s.b.connected == 0 {
+		s.r.write(spiR8RegCtl, 0)
+	}
+	return nil
+}
+
+// LimitSpeed implements spi.PortCloser.
+func (s *SPI) LimitSpeed(maxHz int64) error {
+	if maxHz < 1 {
+		return fmt.Errorf("allwinner-spi: invalid speed %d", maxHz)
+	}
+	s.b.mu.Lock()
+	defer s.b.mu.Unlock()
+	s.maxHzPort = maxHz
+	return nil
+}
+
+// Connect implements spi.Port.
+//
+// The supported flags are Mode0 to Mode3, NoCS, LSBFirst and CSHigh. Only 8
+// bits words are supported.
+//
+// It sets the function of the pins of the port.
+func (s *SPI) Connect(maxHz int64, mode spi.Mode, bits int) (spi.Conn, error) {
+	if err := checkSPIParams(maxHz, mode, bits); err != nil {
+		return nil, err
+	}
+	s.b.mu.Lock()
+	defer s.b.mu.Unlock()
+	if s.initialized {
+		return nil, errors.New("allwinner-spi: Connect() can only be called exactly once")
+	}
+	if err := s.initLocked(); err != nil {
+		return nil, err
+	}
+	s.maxHzDev = maxHz
+	s.mode = mode
+	if s.b.active == nil {
+		s.r.write(spiR8RegCtl, uint32(s.ctlLocked(false)))
+	}
+	s.b.connected++
+	s.initialized = true
+	return &spiConn{s}, nil
+}
+
+// MaxTxSize implements conn.Limits.
+func (s *SPI) MaxTxSize() int {
+	return spiR8MaxTxSize
+}
+
+// CLK implements spi.Pins.
+func (s *SPI) CLK() gpio.PinOut {
+	return spiPinOrInvalid(fmt.Sprintf("SPI%d_CLK", s.bus))
+}
+
+// MOSI implements spi.Pins.
+func (s *SPI) MOSI() gpio.PinOut {
+	return spiPinOrInvalid(fmt.Sprintf("SPI%d_MOSI", s.bus))
+}
+
+// MISO implements spi.Pins.
+func (s *SPI) MISO() gpio.PinIn {
+	return spiPinOrInvalid(fmt.Sprintf("SPI%d_MISO", s.bus))
+}
+
+// CS implements spi.Pins.
+func (s *SPI) CS() gpio.PinOut {
+	return spiPinOrInvalid(fmt.Sprintf("SPI%d_CS%d", s.bus, s.cs))
+}
+
+//
+
 var (
 	// spiMemory is the memory mapping for the spi CPU registers.
 	spiMemory *spiMap
-	// spiBaseAddr is the physical base address of the clock registers.
+	// spiBaseAddr is the physical base address of the SPI registers.
 	spiBaseAddr uint32
+	// spiSysfsDevices is where the kernel lists the SPI devices.
+	spiSysfsDevices = "/sys/bus/spi/devices"
+	// spiBuses is the state of each controller, shared by the ports of its
+	// chip selects.
+	spiBuses [len(spiMap{}.groups)]spiBus
 )
 
+// spiBus is the state of a SPI controller.
+type spiBus struct {
+	mu sync.Mutex
+	// connected is the number of ports connected and not closed yet.
+	connected int
+	// active is the port asserting CS, if any.
+	active *SPI
+}
+
 const (
 	// 31:20 reserved
 	// Set this bit to ‘1’ to make the internal read sample point with a delay of
@@ -150,65 +308,612 @@ type spiR8Group struct {
 	reserved        [(0x1000 - 0x02C) / 4]uint32
 }
 
-func (s *spiR8Group) setup() {
-	s.intCtl = 0
-	s.status = 0
-	//s.dmaCtl = spiR8DMARXByte
-	s.dmaCtl = 0
-	s.wait = 2
-	s.clockCtl = spiR8DivRateSelect2 | spiR8Div1024
-	// spiR8DDMA
-	s.ctl = spiR8CSManual | spiR8LSB | spiR8Master | spiR8Enable
-}
-
 // spiMap is the mapping of SPI registers.
 // R8: Page 152-153.
 type spiMap struct {
 	groups [3]spiR8Group
 }
 
-// spi2Write do a write on SPI2_MOSI via polling.
-func spi2Write(w []byte) error {
-	if clockMemory == nil || spiMemory == nil {
-		return errors.New("subsystem not initialized")
+// spiR8Reg is the offset of a register in spiR8Group.
+type spiR8Reg uintptr
+
+const (
+	spiR8RegRX              spiR8Reg = 0x00
+	spiR8RegTX              spiR8Reg = 0x04
+	spiR8RegCtl             spiR8Reg = 0x08
+	spiR8RegIntCtl          spiR8Reg = 0x0C
+	spiR8RegStatus          spiR8Reg = 0x10
+	spiR8RegDMACtl          spiR8Reg = 0x14
+	spiR8RegWait            spiR8Reg = 0x18
+	spiR8RegClockCtl        spiR8Reg = 0x1C
+	spiR8RegBurstCounter    spiR8Reg = 0x20
+	spiR8RegTransmitCounter spiR8Reg = 0x24
+	spiR8RegFIFOStatus      spiR8Reg = 0x28
+)
+
+// spiR8Regs is the access to the registers of one SPI controller.
+//
+// It is implemented by spiR8Group for the memory mapped registers, and by a
+// simulated controller in the unit tests.
+type spiR8Regs interface {
+	read(r spiR8Reg) uint32
+	write(r spiR8Reg, v uint32)
+	// writeTX pushes one byte in the TX FIFO.
+	writeTX(b byte)
+	// readRX pops one byte from the RX FIFO.
+	readRX() byte
+}
+
+func (s *spiR8Group) read(r spiR8Reg) uint32 {
+	return *s.reg(r)
+}
+
+func (s *spiR8Group) write(r spiR8Reg, v uint32) {
+	*s.reg(r) = v
+}
+
+// writeTX does a 8 bits access; a 32 bits access would push 4 bytes.
+func (s *spiR8Group) writeTX(b byte) {
+	*(*uint8)(unsafe.Pointer(&s.tx)) = b
+}
+
+// readRX does a 8 bits access; a 32 bits access would pop 4 bytes.
+func (s *spiR8Group) readRX() byte {
+	return *(*uint8)(unsafe.Pointer(&s.rx))
+}
+
+func (s *spiR8Group) reg(r spiR8Reg) *uint32 {
+	return (*uint32)(unsafe.Pointer(uintptr(unsafe.Pointer(s)) + uintptr(r)))
+}
+
+const (
+	// spiR8FIFODepth is the size of the RX and TX FIFOs.
+	spiR8FIFODepth = 64
+	// spiR8MaxTxSize is the maximum burst size, as SPI_BC is 24 bits.
+	spiR8MaxTxSize = 1<<24 - 1
+	// spiModClkHz is the speed of the module clock; the controller is fed by
+	// OSC24M without divider. The SPI clock is at most half of it.
+	spiModClkHz = 24000000
+)
+
+// spiR8DRQ is the DMA request type of each SPI controller.
+var spiR8DRQ = [3]struct {
+	tx ddmaR8Cfg
+	rx ddmaR8Cfg
+}{
+	{ddmaDstDrqSPI0TX, ddmaSrcDrqSPI0RX},
+	{ddmaDstDrqSPI1TX, ddmaSrcDrqSPI1RX},
+	{ddmaDstDrqSPI2TX, ddmaSrcDrqSPI2RX},
+}
+
+// spiDMAAlloc allocates the physically contiguous buffers used for DMA
+// transfers.
+var spiDMAAlloc = func(size int) (pmem.Mem, error) {
+	return pmem.Alloc(size)
+}
+
+// checkSPIParams verifies the arguments to Connect() and Configure().
+func checkSPIParams(maxHz int64, mode spi.Mode, bits int) error {
+	if maxHz < 0 {
+		return fmt.Errorf("allwinner-spi: invalid speed %d", maxHz)
+	}
+	if mode&^(spi.Mode3|spi.NoCS|spi.LSBFirst|spi.CSHigh) != 0 {
+		return fmt.Errorf("allwinner-spi: mode %v is not supported", mode)
+	}
+	if bits != 8 {
+		return fmt.Errorf("allwinner-spi: %d bits per word is not supported", bits)
+	}
+	return nil
+}
+
+// spiR8ClockDiv returns the clock control register value to get the fastest
+// SPI clock not above hz, and the resulting speed.
+//
+// 0 means the fastest speed. The slowest speed is 366Hz.
+func spiR8ClockDiv(hz int64) (spiR8ClockCtl, int64) {
+	if hz <= 0 || hz > spiModClkHz/2 {
+		hz = spiModClkHz / 2
+	}
+	// SPI_CLK = mclk / (2*(n+1))
+	if n := (spiModClkHz + 2*hz - 1) / (2 * hz); n <= 256 {
+		return spiR8DivRateSelect2 | spiR8ClockCtl(n-1), spiModClkHz / (2 * n)
+	}
+	// SPI_CLK = mclk / 2^(n+1)
+	n := uint(0)
+	for spiModClkHz>>(n+1) > hz && n < 15 {
+		n++
+	}
+	return spiR8ClockCtl(n) << 8, spiModClkHz >> (n + 1)
+}
+
+// spiTimeout returns the maximum duration of a transfer of n bytes at hz.
+func spiTimeout(n int, hz int64) time.Duration {
+	return time.Duration(int64(n)*8*int64(time.Second)/hz)*2 + 100*time.Millisecond
+}
+
+// spiPin returns the pin having the function f, if any.
+func spiPin(f string) *Pin {
+	for _, p := range cpupins {
+		if !p.available {
+			continue
+		}
+		for _, a := range p.altFunc {
+			if a == f {
+				return p
+			}
+		}
+	}
+	return nil
+}
+
+func spiPinOrInvalid(f string) gpio.PinIO {
+	if p := spiPin(f); p != nil {
+		return p
+	}
+	return gpio.INVALID
+}
+
+// initLocked sets the pins function and enables the controller clocks.
+func (s *SPI) initLocked() error {
+	names := []string{
+		fmt.Sprintf("SPI%d_CLK", s.bus),
+		fmt.Sprintf("SPI%d_MOSI", s.bus),
+		fmt.Sprintf("SPI%d_MISO", s.bus),
+		fmt.Sprintf("SPI%d_CS%d", s.bus, s.cs),
+	}
+	for i, n := range names {
+		p := spiPin(n)
+		if p == nil {
+			if i == 0 {
+				return fmt.Errorf("allwinner-spi: %s is not available", n)
+			}
+			// The port can be used without MOSI, MISO or CS.
+			continue
+		}
+		if err := p.SetFunction(n); err != nil {
+			return fmt.Errorf("allwinner-spi: %v", err)
+		}
+	}
+	clockMemory.ahbGating0 |= clockAHBGatingSPI0 << uint(s.bus)
+	clk := []*clockSPI{&clockMemory.spi0Clk, &clockMemory.spi1Clk, &clockMemory.spi2Clk}[s.bus]
+	*clk = clockSPIEnable | clockSPIOSC24M | clockSPIDiv1a | clockSPIDiv1b
+	s.r.write(spiR8RegIntCtl, 0)
+	s.r.write(spiR8RegDMACtl, 0)
+	s.r.write(spiR8RegWait, 0)
+	return nil
+}
+
+// ctlLocked returns the value of the control register for the current mode.
+//
+// The CS line is asserted if assert is true, unless NoCS was specified.
+func (s *SPI) ctlLocked(assert bool) spiR8Ctl {
+	c := spiR8TransmitPause | spiR8CSManual | spiR8Ctl(s.cs)<<12 | spiR8Master | spiR8Enable
+	if s.mode&spi.Mode1 != 0 {
+		c |= spiR8PHA
+	}
+	if s.mode&spi.Mode2 != 0 {
+		c |= spiR8ClkActiveLow
+	}
+	if s.mode&spi.LSBFirst != 0 {
+		c |= spiR8LSB
+	}
+	// spiR8CSLevel is the level driven on the line in manual mode; the polarity
+	// bit sets the idle level of the other CS lines.
+	activeHigh := s.mode&spi.CSHigh != 0
+	if !activeHigh {
+		c |= spiR8CSActiveLow
+	}
+	level := !activeHigh
+	if assert && s.mode&spi.NoCS == 0 {
+		level = activeHigh
+	}
+	if level {
+		c |= spiR8CSLevel
+	}
+	return c
+}
+
+// speedLocked returns the speed to use for a packet.
+func (s *SPI) speedLocked(pkt int64) int64 {
+	hz := s.maxHzDev
+	if pkt != 0 {
+		hz = pkt
+	}
+	if s.maxHzPort != 0 && (hz == 0 || hz > s.maxHzPort) {
+		hz = s.maxHzPort
+	}
+	return hz
+}
+
+func (s *SPI) txPackets(p []spi.Packet) error {
+	total := 0
+	for i := range p {
+		lW := len(p[i].W)
+		lR := len(p[i].R)
+		if lW != 0 && lR != 0 && lW != lR {
+			return fmt.Errorf("allwinner-spi: when both w and r are used, they must be the same size; got %d and %d bytes", lW, lR)
+		}
+		if lW > spiR8MaxTxSize || lR > spiR8MaxTxSize {
+			return fmt.Errorf("allwinner-spi: maximum packet length is %d", spiR8MaxTxSize)
+		}
+		if p[i].BitsPerWord != 0 && p[i].BitsPerWord != 8 {
+			return fmt.Errorf("allwinner-spi: %d bits per word is not supported", p[i].BitsPerWord)
+		}
+		if p[i].TxWidth > 1 || p[i].RxWidth > 1 {
+			return errors.New("allwinner-spi: dual and quad SPI are not supported")
+		}
+		if p[i].MaxHz < 0 {
+			return fmt.Errorf("allwinner-spi: invalid packet speed %d", p[i].MaxHz)
+		}
+		total += lW + lR
+	}
+	if total == 0 {
+		return errors.New("allwinner-spi: empty packets")
+	}
+
+	s.b.mu.Lock()
+	defer s.b.mu.Unlock()
+	if !s.initialized {
+		return errors.New("allwinner-spi: Connect wasn't called")
+	}
+	if a := s.b.active; a != nil && a != s {
+		// The other chip select was left asserted.
+		a.releaseLocked()
 	}
-	// Make sure the source clock is disabled. Set it at 250kHz.
-	//clockMemory.spi2Clk &^= clockSPIEnable
-	clockMemory.spi2Clk |= clockSPIEnable
-	clockMemory.spi2Clk = clockSPIDiv8a | clockSPIDiv12b
-	ch := &spiMemory.groups[2]
-	ch.setup()
-	fmt.Printf("Setup done\n")
-	for i := 0; i < len(w)/4; i++ {
-		// TODO(maruel): Access it in 8bit mode.
-		ch.tx = uint32(w[0])
-		for ch.fifoStatus.tx() == 0 {
-			log.Printf("Waiting for bit %# v\n", ch)
-			time.Sleep(time.Second)
+	for i := range p {
+		if len(p[i].W) != 0 || len(p[i].R) != 0 {
+			s.b.active = s
+			if err := s.txLocked(p[i].W, p[i].R, s.speedLocked(p[i].MaxHz)); err != nil {
+				s.releaseLocked()
+				return err
+			}
+		}
+		if p[i].Delay > 0 {
+			time.Sleep(p[i].Delay)
+		}
+		if !p[i].KeepCS {
+			s.releaseLocked()
 		}
 	}
-	fmt.Printf("Done\n")
 	return nil
 }
 
-// spi2Read do a read on SPI2_MISO via polling.
-func spi2Read(r []byte) error {
-	if clockMemory == nil || spiMemory == nil {
-		return errors.New("subsystem not initialized")
+// releaseLocked releases CS.
+func (s *SPI) releaseLocked() {
+	s.r.write(spiR8RegCtl, uint32(s.ctlLocked(false)))
+	s.b.active = nil
+}
+
+// txLocked does one burst. CS is asserted and left asserted.
+func (s *SPI) txLocked(w, r []byte, hz int64) error {
+	n := len(w)
+	if n == 0 {
+		n = len(r)
+	}
+	cc, actual := spiR8ClockDiv(hz)
+	ctl := s.ctlLocked(true)
+	if len(r) == 0 {
+		// Do not fill the RX FIFO while writing.
+		ctl |= spiR8DiscardHash
+	}
+	s.r.write(spiR8RegClockCtl, uint32(cc))
+	s.r.write(spiR8RegCtl, uint32(ctl|spiR8RXFIFOReset|spiR8TXFIFOReset))
+	s.r.write(spiR8RegStatus, ^uint32(0))
+	s.r.write(spiR8RegBurstCounter, uint32(n))
+	// The bytes after the transmit counter are dummy bytes.
+	s.r.write(spiR8RegTransmitCounter, uint32(len(w)))
+	timeout := spiTimeout(n, actual)
+	var err error
+	if n > spiR8FIFODepth && dmaMemory != nil {
+		err = s.txDMALocked(w, r, ctl, timeout)
+	} else {
+		err = s.txFIFOLocked(w, r, ctl, timeout)
+	}
+	s.r.write(spiR8RegStatus, uint32(spiR8TC))
+	return err
+}
+
+// txFIFOLocked does a burst by polling the FIFOs.
+func (s *SPI) txFIFOLocked(w, r []byte, ctl spiR8Ctl, timeout time.Duration) error {
+	sent := 0
+	for ; sent < len(w) && sent < spiR8FIFODepth; sent++ {
+		s.r.writeTX(w[sent])
+	}
+	s.r.write(spiR8RegCtl, uint32(ctl|spiR8ExchangeBurst))
+	recv := 0
+	start := time.Now()
+	for {
+		// Once the transfer is completed, the RX FIFO contains all the remaining
+		// bytes.
+		done := spiR8IntStatus(s.r.read(spiR8RegStatus))&spiR8TC != 0
+		fs := spiR8FIFOStatus(s.r.read(spiR8RegFIFOStatus))
+		for i := fs.rx(); i > 0 && recv < len(r); i-- {
+			r[recv] = s.r.readRX()
+			recv++
+		}
+		for i := int(fs.tx()); i < spiR8FIFODepth && sent < len(w); i++ {
+			s.r.writeTX(w[sent])
+			sent++
+		}
+		if done {
+			if recv != len(r) {
+				return fmt.Errorf("allwinner-spi: received %d bytes, expected %d", recv, len(r))
+			}
+			return nil
+		}
+		if time.Since(start) > timeout {
+			return errors.New("allwinner-spi: transfer timed out")
+		}
+	}
+}
+
+// txDMALocked does a burst with the dedicated DMA channels feeding and
+// draining the FIFOs.
+func (s *SPI) txDMALocked(w, r []byte, ctl spiR8Ctl, timeout time.Duration) error {
+	n := len(w)
+	if n == 0 {
+		n = len(r)
+	}
+	// One page aligned buffer for each direction.
+	size := (n + 4095) &^ 4095
+	mem, err := spiDMAAlloc(2 * size)
+	if err != nil {
+		return fmt.Errorf("allwinner-spi: %v", err)
 	}
-	// Make sure the source clock is disabled. Set it at 250kHz.
-	//clockMemory.spi2Clk &^= clockSPIEnable
-	clockMemory.spi2Clk |= clockSPIEnable
-	clockMemory.spi2Clk = clockSPIDiv8a | clockSPIDiv12b
-	ch := &spiMemory.groups[2]
-	ch.setup()
-	for i := 0; i < len(r)/4; i++ {
-		ch.tx = 0
-		for ch.status&spiR8TC == 0 {
+	defer mem.Close()
+	buf := mem.Bytes()
+	copy(buf, w)
+	phys := uint32(mem.PhysAddr())
+	regs := spiBaseAddr + uint32(s.bus)*uint32(unsafe.Sizeof(spiR8Group{}))
+	var dmaCtl spiR8DMACtl
+	var rx *dmaDedicatedGroup
+	if len(r) != 0 {
+		if rx, err = getSPIDMAChannel(); err != nil {
+			return err
 		}
-		// TODO(maruel): Access it in 8bit mode.
-		r[i] = uint8(ch.rx)
+		defer rx.release()
+		rx.set(regs+uint32(spiR8RegRX), phys+uint32(size), uint32(n), true, false, spiR8DRQ[s.bus].rx|ddmaDstDrqSDRAM)
+		// Despite its name, this bit requests DMA when the RX FIFO has data.
+		dmaCtl |= spiR8DMARXEmpty
+	}
+	if len(w) != 0 {
+		tx, err := getSPIDMAChannel()
+		if err != nil {
+			return err
+		}
+		defer tx.release()
+		tx.set(phys, regs+uint32(spiR8RegTX), uint32(n), false, true, spiR8DRQ[s.bus].tx|ddmaSrcDrqSDRAM)
+		dmaCtl |= spiR8DMATXHalf
+	}
+	s.r.write(spiR8RegDMACtl, uint32(dmaCtl))
+	defer s.r.write(spiR8RegDMACtl, 0)
+	s.r.write(spiR8RegCtl, uint32(ctl|spiR8DDMA|spiR8ExchangeBurst))
+	start := time.Now()
+	for spiR8IntStatus(s.r.read(spiR8RegStatus))&spiR8TC == 0 {
+		if time.Since(start) > timeout {
+			return errors.New("allwinner-spi: DMA transfer timed out")
+		}
+	}
+	if rx != nil {
+		// The last bytes may still be in flight.
+		for rx.cfg&ddmaBusy != 0 {
+			if time.Since(start) > timeout {
+				return errors.New("allwinner-spi: DMA transfer timed out")
+			}
+		}
+		copy(r, buf[size:size+n])
 	}
-	fmt.Printf("Done\n")
 	return nil
 }
+
+// getSPIDMAChannel returns an unused dedicated DMA channel.
+func getSPIDMAChannel() (*dmaDedicatedGroup, error) {
+	n := dmaMemory.getDedicated()
+	if n == -1 {
+		return nil, errors.New("allwinner-spi: no DMA channel available")
+	}
+	// Disable the interrupts and clear the pending ones, the transfer is polled.
+	dmaMemory.irqEn &^= 3 << uint(2*n+16)
+	dmaMemory.irqPendStas = 3 << uint(2*n+16)
+	return &dmaMemory.dedicated[n], nil
+}
+
+// spiConn implements spi.Conn.
+type spiConn struct {
+	s *SPI
+}
+
+func (s *spiConn) String() string {
+	return s.s.String()
+}
+
+// Tx implements spi.Conn.
+func (s *spiConn) Tx(w, r []byte) error {
+	if len(w) == 0 && len(r) == 0 {
+		return errors.New("allwinner-spi: Tx with empty buffers")
+	}
+	return s.s.txPackets([]spi.Packet{{W: w, R: r}})
+}
+
+// Write implements io.Writer.
+func (s *spiConn) Write(b []byte) (int, error) {
+	if err := s.Tx(b, nil); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// TxPackets implements spi.Conn.
+//
+// KeepCS:true on the last packet keeps CS asserted after the call returns,
+// until the next transaction.
+func (s *spiConn) TxPackets(p []spi.Packet) error {
+	return s.s.txPackets(p)
+}
+
+// Configure implements spi.Configurer.
+func (s *spiConn) Configure(maxHz int64, mode spi.Mode, bits int) error {
+	if err := checkSPIParams(maxHz, mode, bits); err != nil {
+		return err
+	}
+	s.s.b.mu.Lock()
+	defer s.s.b.mu.Unlock()
+	s.s.maxHzDev = maxHz
+	s.s.mode = mode
+	// Do not touch the controller while the other chip select is asserted.
+	if s.s.b.active == nil || s.s.b.active == s.s {
+		s.s.releaseLocked()
+	}
+	return nil
+}
+
+// Duplex implements spi.Conn.
+func (s *spiConn) Duplex() conn.Duplex {
+	return conn.Full
+}
+
+// MaxTxSize implements conn.Limits.
+func (s *spiConn) MaxTxSize() int {
+	return spiR8MaxTxSize
+}
+
+// CLK implements spi.Pins.
+func (s *spiConn) CLK() gpio.PinOut {
+	return s.s.CLK()
+}
+
+// MOSI implements spi.Pins.
+func (s *spiConn) MOSI() gpio.PinOut {
+	return s.s.MOSI()
+}
+
+// MISO implements spi.Pins.
+func (s *spiConn) MISO() gpio.PinIn {
+	return s.s.MISO()
+}
+
+// CS implements spi.Pins.
+func (s *spiConn) CS() gpio.PinOut {
+	return s.s.CS()
+}
+
+// driverSPI implements periph.Driver.
+type driverSPI struct {
+}
+
+func (d *driverSPI) String() string {
+	return "allwinner-spi"
+}
+
+func (d *driverSPI) Prerequisites() []string {
+	return []string{"allwinner-gpio"}
+}
+
+func (d *driverSPI) After() []string {
+	// The ports registered by sysfs-spi are replaced, unless the bus is used by
+	// another kernel driver.
+	return []string{"sysfs-spi"}
+}
+
+func (d *driverSPI) Init() (bool, error) {
+	if !IsR8() {
+		return false, errors.New("SPI controller is only supported on R8")
+	}
+	// Page 151.
+	spiBaseAddr = 0x01C05000
+	// Page 57.
+	clockBaseAddr = 0x1C20000
+	// Page 124.
+	dmaBaseAddr = 0x1C02000
+	if spiMemory == nil {
+		if err := pmem.MapAsPOD(uint64(spiBaseAddr), &spiMemory); err != nil {
+			if os.IsPermission(err) {
+				return true, fmt.Errorf("need more access, try as root: %v", err)
+			}
+			return true, err
+		}
+	}
+	if clockMemory == nil {
+		if err := pmem.MapAsPOD(uint64(clockBaseAddr), &clockMemory); err != nil {
+			return true, err
+		}
+	}
+	if dmaMemory == nil {
+		// DMA is optional; the FIFO is used without it.
+		if err := pmem.MapAsPOD(uint64(dmaBaseAddr), &dmaMemory); err != nil {
+			dmaMemory = nil
+		}
+	}
+	return true, registerSPI()
+}
+
+// registerSPI registers the ports whose CS line is available, with the same
+// names as sysfs-spi.
+//
+// The ports registered by sysfs-spi are replaced, except on the buses where a
+// kernel driver other than spidev is bound to a device, since the kernel uses
+// the controller then.
+func registerSPI() error {
+	for bus := range spiMemory.groups {
+		if spiKernelDriver(bus) != "" {
+			continue
+		}
+		for cs := 0; cs < 4; cs++ {
+			if spiPin(fmt.Sprintf("SPI%d_CS%d", bus, cs)) == nil {
+				continue
+			}
+			name := fmt.Sprintf("/dev/spidev%d.%d", bus, cs)
+			aliases := []string{fmt.Sprintf("SPI%d.%d", bus, cs)}
+			n := bus
+			if cs != 0 {
+				n = -1
+			}
+			// Unregister the port if already registered by sysfs-spi. Do not error
+			// on it, since sysfs-spi may have failed to load.
+			_ = spireg.Unregister(name)
+			if err := spireg.Register(name, aliases, n, (&openerSPI{bus, cs}).Open); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// spiKernelDriver returns the name of the kernel driver other than spidev
+// bound to a device on the bus, if any.
+func spiKernelDriver(bus int) string {
+	items, _ := filepath.Glob(fmt.Sprintf("%s/spi%d.*/driver", spiSysfsDevices, bus))
+	for _, item := range items {
+		if l, err := os.Readlink(item); err == nil {
+			if d := filepath.Base(l); d != "spidev" {
+				return d
+			}
+		}
+	}
+	return ""
+}
+
+type openerSPI struct {
+	bus int
+	cs  int
+}
+
+func (o *openerSPI) Open() (spi.PortCloser, error) {
+	return NewSPI(o.bus, o.cs)
+}
+
+func init() {
+	if isArm {
+		periph.MustRegister(&driverSPI{})
+	}
+}
+
+var _ conn.Limits = &SPI{}
+var _ conn.Limits = &spiConn{}
+var _ spi.Configurer = &spiConn{}
+var _ spi.Conn = &spiConn{}
+var _ spi.Pins = &SPI{}
+var _ spi.Pins = &spiConn{}
+var _ spi.PortCloser = &SPI{}
+var _ spiR8Regs = &spiR8Group{}
+var _ fmt.Stringer = &SPI{}
@@ -0,0 +1,708 @@
+// Copyright 2018 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package allwinner
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"periph.io/x/periph/conn"
+	"periph.io/x/periph/conn/spi"
+	"periph.io/x/periph/conn/spi/spireg"
+	"periph.io/x/periph/host/pmem"
+)
+
+func TestNewSPI(t *testing.T) {
+	if s, err := NewSPI(0, 0); s != nil || err == nil {
+		t.Fatal("not initialized")
+	}
+	defer initSPITest()()
+	if s, err := NewSPI(3, 0); s != nil || err == nil {
+		t.Fatal("invalid bus")
+	}
+	if s, err := NewSPI(0, 4); s != nil || err == nil {
+		t.Fatal("invalid chip select")
+	}
+	s, err := NewSPI(2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if str := s.String(); str != "SPI2.1" {
+		t.Fatal(str)
+	}
+	if p := s.CLK(); p != cpupins["PE1"] {
+		t.Fatal(p)
+	}
+	if p := s.CS(); p != cpupins["PB10"] {
+		t.Fatal(p)
+	}
+	if p := (&SPI{bus: 1, cs: 3}).CS(); p.String() != "INVALID" {
+		t.Fatal(p)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSPI_Tx(t *testing.T) {
+	defer initSPITest()()
+	s, f := newFakeSPI(t, 2, 0)
+	c, err := s.Connect(1000000, spi.Mode3, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clockMemory.ahbGating0 != clockAHBGatingSPI2 {
+		t.Fatalf("0x%x", clockMemory.ahbGating0)
+	}
+	if clockMemory.spi2Clk != clockSPIEnable {
+		t.Fatalf("0x%x", clockMemory.spi2Clk)
+	}
+	for _, n := range []string{"PE0", "PE1", "PE2", "PE3"} {
+		if fn := cpupins[n].function(); fn != alt3 {
+			t.Fatal(n, fn)
+		}
+	}
+	ctl := spiR8Ctl(f.regs[spiR8RegCtl])
+	if ctl&(spiR8PHA|spiR8ClkActiveLow|spiR8Master|spiR8Enable) != spiR8PHA|spiR8ClkActiveLow|spiR8Master|spiR8Enable {
+		t.Fatalf("0x%x", ctl)
+	}
+	if _, err := s.Connect(1000000, spi.Mode3, 8); err == nil {
+		t.Fatal("double Connect")
+	}
+	if d := c.Duplex(); d != conn.Full {
+		t.Fatal(d)
+	}
+	if l := c.(conn.Limits).MaxTxSize(); l != spiR8MaxTxSize {
+		t.Fatal(l)
+	}
+	if p := c.(spi.Pins).MISO(); p != cpupins["PE3"] {
+		t.Fatal(p)
+	}
+
+	// Larger than the FIFO.
+	w := make([]byte, 200)
+	for i := range w {
+		w[i] = byte(i)
+	}
+	r := make([]byte, len(w))
+	if err := c.Tx(w, r); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(f.wire, w) {
+		t.Fatal(f.wire)
+	}
+	for i := range r {
+		if r[i] != ^w[i] {
+			t.Fatal(r)
+		}
+	}
+	if f.cs != "HLH" {
+		t.Fatal(f.cs)
+	}
+	if f.clock[0] != spiR8DivRateSelect2|11 {
+		t.Fatalf("0x%x", f.clock[0])
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if f.regs[spiR8RegCtl] != 0 {
+		t.Fatal("not disabled")
+	}
+}
+
+func TestSPI_TxPackets(t *testing.T) {
+	defer initSPITest()()
+	s, f := newFakeSPI(t, 0, 0)
+	if err := s.LimitSpeed(4000000); err != nil {
+		t.Fatal(err)
+	}
+	c, err := s.Connect(1000000, spi.Mode0, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := make([]byte, 100)
+	p := []spi.Packet{
+		{W: []byte{1, 2}, KeepCS: true},
+		{R: r, MaxHz: 6000000},
+		{W: []byte{3}},
+	}
+	if err := c.TxPackets(p); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(f.wire, append(append([]byte{1, 2}, make([]byte, 100)...), 3)) {
+		t.Fatal(f.wire)
+	}
+	for i := range r {
+		if r[i] != 0xFF {
+			t.Fatal(r)
+		}
+	}
+	if f.cs != "HLHLH" {
+		t.Fatal(f.cs)
+	}
+	expected := []spiR8ClockCtl{spiR8DivRateSelect2 | 11, spiR8DivRateSelect2 | 2, spiR8DivRateSelect2 | 11}
+	if len(f.clock) != len(expected) {
+		t.Fatal(f.clock)
+	}
+	for i := range expected {
+		if f.clock[i] != expected[i] {
+			t.Fatalf("#%d: 0x%x", i, f.clock[i])
+		}
+	}
+
+	// KeepCS on the last packet leaves CS asserted.
+	if err := c.TxPackets([]spi.Packet{{W: []byte{4}, KeepCS: true}}); err != nil {
+		t.Fatal(err)
+	}
+	if f.cs != "HLHLHL" {
+		t.Fatal(f.cs)
+	}
+}
+
+func TestSPI_CS(t *testing.T) {
+	defer initSPITest()()
+	s, f := newFakeSPI(t, 1, 0)
+	c, err := s.Connect(0, spi.Mode0|spi.CSHigh|spi.LSBFirst, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Tx([]byte{1}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if f.cs != "LHL" {
+		t.Fatal(f.cs)
+	}
+	ctl := spiR8Ctl(f.regs[spiR8RegCtl])
+	if ctl&(spiR8CSActiveLow|spiR8LSB) != spiR8LSB {
+		t.Fatalf("0x%x", ctl)
+	}
+	if err := c.(spi.Configurer).Configure(0, spi.Mode0|spi.NoCS, 8); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Tx([]byte{1}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if f.cs != "LHLH" {
+		t.Fatal(f.cs)
+	}
+}
+
+func TestSPI_DMA(t *testing.T) {
+	defer initSPITest()()
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+	dmaMemory = &dmaMap{}
+	spiBaseAddr = 0x01C05000
+	m := &fakeMem{phys: 0x40000000}
+	spiDMAAlloc = func(size int) (pmem.Mem, error) {
+		m.b = make([]byte, size)
+		return m, nil
+	}
+	s, f := newFakeSPI(t, 1, 0)
+	f.mem = m
+	c, err := s.Connect(0, spi.Mode0, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := make([]byte, 300)
+	for i := range w {
+		w[i] = byte(i)
+	}
+	r := make([]byte, len(w))
+	if err := c.Tx(w, r); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(f.wire, w) {
+		t.Fatal(f.wire)
+	}
+	for i := range r {
+		if r[i] != ^w[i] {
+			t.Fatal(r)
+		}
+	}
+	if f.dmaTX.dstAddr != 0x01C06004 || f.dmaTX.srcAddr != 0x40000000 || f.dmaTX.byteCounter != 300 {
+		t.Fatalf("%#v", f.dmaTX)
+	}
+	if f.dmaTX.cfg&(0x1F<<16|0x1F|ddmaDstIOMode) != ddmaDstDrqSPI1TX|ddmaSrcDrqSDRAM|ddmaDstIOMode {
+		t.Fatalf("0x%x", f.dmaTX.cfg)
+	}
+	if f.dmaRX.srcAddr != 0x01C06000 || f.dmaRX.dstAddr != 0x40001000 || f.dmaRX.byteCounter != 300 {
+		t.Fatalf("%#v", f.dmaRX)
+	}
+	if f.dmaRX.cfg&(0x1F<<16|0x1F|ddmaSrcIOMode) != ddmaDstDrqSDRAM|ddmaSrcDrqSPI1RX|ddmaSrcIOMode {
+		t.Fatalf("0x%x", f.dmaRX.cfg)
+	}
+	if f.regs[spiR8RegDMACtl] != 0 {
+		t.Fatal("DMA requests must be disabled")
+	}
+
+	// Write only.
+	f.wire = nil
+	if _, err := c.(interface {
+		Write(b []byte) (int, error)
+	}).Write(w); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(f.wire, w) {
+		t.Fatal(f.wire)
+	}
+
+	spiDMAAlloc = func(size int) (pmem.Mem, error) {
+		return nil, errors.New("no memory")
+	}
+	if err := c.Tx(w, r); err == nil {
+		t.Fatal("allocation failed")
+	}
+}
+
+func TestSPI_shared(t *testing.T) {
+	defer initSPITest()()
+	s0, f := newFakeSPI(t, 0, 0)
+	s1, _ := newFakeSPI(t, 0, 1)
+	s1.r = f
+	c0, err := s0.Connect(0, spi.Mode0, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c1, err := s1.Connect(0, spi.Mode0, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c0.TxPackets([]spi.Packet{{W: []byte{1}, KeepCS: true}}); err != nil {
+		t.Fatal(err)
+	}
+	// The other port doesn't touch the controller while CS0 is asserted.
+	if err := c1.(spi.Configurer).Configure(0, spi.Mode3, 8); err != nil {
+		t.Fatal(err)
+	}
+	if f.cs != "HL" || spiR8Ctl(f.regs[spiR8RegCtl])&(3<<12|spiR8PHA) != 0 {
+		t.Fatalf("%s 0x%x", f.cs, f.regs[spiR8RegCtl])
+	}
+	// A transaction on CS1 releases CS0 first.
+	if err := c1.Tx([]byte{2}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if f.cs != "HLHLH" || spiR8Ctl(f.regs[spiR8RegCtl])&(3<<12) != 1<<12 {
+		t.Fatalf("%s 0x%x", f.cs, f.regs[spiR8RegCtl])
+	}
+	// The controller is disabled once both ports are closed.
+	if err := s0.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if f.regs[spiR8RegCtl] == 0 {
+		t.Fatal("CS1 is still connected")
+	}
+	if err := s1.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if f.regs[spiR8RegCtl] != 0 {
+		t.Fatal("controller must be disabled")
+	}
+	if err := c1.Tx([]byte{2}, nil); err == nil {
+		t.Fatal("closed")
+	}
+}
+
+func TestSPI_errors(t *testing.T) {
+	defer initSPITest()()
+	s, _ := newFakeSPI(t, 0, 0)
+	if err := s.LimitSpeed(0); err == nil {
+		t.Fatal("invalid speed")
+	}
+	if s.txPackets([]spi.Packet{{W: []byte{1}}}) == nil {
+		t.Fatal("not initialized")
+	}
+	if _, err := s.Connect(-1, spi.Mode0, 8); err == nil {
+		t.Fatal("invalid speed")
+	}
+	if _, err := s.Connect(0, spi.Mode0|spi.HalfDuplex, 8); err == nil {
+		t.Fatal("half duplex")
+	}
+	if _, err := s.Connect(0, spi.Mode0, 9); err == nil {
+		t.Fatal("9 bits")
+	}
+	c, err := s.Connect(0, spi.Mode0, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.(spi.Configurer).Configure(0, spi.Mode0|spi.TxDual, 8); err == nil {
+		t.Fatal("dual")
+	}
+	if err := c.Tx(nil, nil); err == nil {
+		t.Fatal("empty")
+	}
+	bad := [][]spi.Packet{
+		nil,
+		{{}},
+		{{W: []byte{1}, R: []byte{1, 2}}},
+		{{W: []byte{1}, BitsPerWord: 16}},
+		{{W: []byte{1}, TxWidth: 2}},
+		{{R: []byte{1}, RxWidth: 4}},
+		{{W: []byte{1}, MaxHz: -1}},
+		{{W: make([]byte, spiR8MaxTxSize+1)}},
+	}
+	for i, p := range bad {
+		if err := c.TxPackets(p); err == nil {
+			t.Fatalf("#%d: invalid packets", i)
+		}
+	}
+}
+
+func TestSPIR8ClockDiv(t *testing.T) {
+	data := []struct {
+		hz     int64
+		cc     spiR8ClockCtl
+		actual int64
+	}{
+		{0, spiR8DivRateSelect2, 12000000},
+		{100000000, spiR8DivRateSelect2, 12000000},
+		{12000000, spiR8DivRateSelect2, 12000000},
+		{11999999, spiR8DivRateSelect2 | 1, 6000000},
+		{1000000, spiR8DivRateSelect2 | 11, 1000000},
+		{46875, spiR8DivRateSelect2 | 255, 46875},
+		{46874, spiR8Div1024, 23437},
+		{1000, spiR8Div32768, 732},
+		{1, spiR8Div65536, 366},
+	}
+	for i, line := range data {
+		cc, actual := spiR8ClockDiv(line.hz)
+		if cc != line.cc || actual != line.actual {
+			t.Fatalf("#%d: spiR8ClockDiv(%d) = 0x%x, %d", i, line.hz, cc, actual)
+		}
+	}
+}
+
+func TestSPIR8Group(t *testing.T) {
+	g := &spiR8Group{}
+	g.write(spiR8RegCtl, 1)
+	g.write(spiR8RegStatus, 2)
+	g.write(spiR8RegClockCtl, 3)
+	g.write(spiR8RegTransmitCounter, 4)
+	g.fifoStatus = 5
+	if g.ctl != 1 || g.status != 2 || g.clockCtl != 3 || g.transmitCounter != 4 {
+		t.Fatalf("%#v", g)
+	}
+	if v := g.read(spiR8RegFIFOStatus); v != 5 {
+		t.Fatal(v)
+	}
+	g.writeTX(0xAB)
+	if g.tx != 0xAB {
+		t.Fatal(g.tx)
+	}
+	g.rx = 0xCD
+	if b := g.readRX(); b != 0xCD {
+		t.Fatal(b)
+	}
+}
+
+func TestRegisterSPI(t *testing.T) {
+	defer initSPITest()()
+	if err := registerSPI(); err != nil {
+		t.Fatal(err)
+	}
+	names := []string{"/dev/spidev0.0", "/dev/spidev1.0", "/dev/spidev2.0", "/dev/spidev2.1"}
+	defer func() {
+		for _, n := range names {
+			if err := spireg.Unregister(n); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}()
+	if l := spireg.All(); len(l) != len(names) {
+		t.Fatal(l)
+	}
+	p, err := spireg.Open("SPI2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, ok := p.(*SPI); !ok || s.bus != 2 || s.cs != 1 {
+		t.Fatalf("%#v", p)
+	}
+}
+
+func TestRegisterSPI_kernelDriver(t *testing.T) {
+	defer initSPITest()()
+	d, err := ioutil.TempDir("", "allwinner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+	spiSysfsDevices = d
+	// A kernel driver uses bus 2; spidev is bound on bus 1.
+	for n, drv := range map[string]string{"spi1.0": "spidev", "spi2.1": "fbtft"} {
+		if err := os.Mkdir(filepath.Join(d, n), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(filepath.Join("..", "drivers", drv), filepath.Join(d, n, "driver")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := registerSPI(); err != nil {
+		t.Fatal(err)
+	}
+	names := []string{"/dev/spidev0.0", "/dev/spidev1.0"}
+	defer func() {
+		for _, n := range names {
+			if err := spireg.Unregister(n); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}()
+	if l := spireg.All(); len(l) != len(names) {
+		t.Fatal(l)
+	}
+}
+
+func TestDriverSPI(t *testing.T) {
+	d := driverSPI{}
+	if s := d.String(); s != "allwinner-spi" {
+		t.Fatal(s)
+	}
+	if p := d.Prerequisites(); len(p) != 1 || p[0] != "allwinner-gpio" {
+		t.Fatal(p)
+	}
+	if a := d.After(); len(a) != 1 || a[0] != "sysfs-spi" {
+		t.Fatal(a)
+	}
+}
+
+//
+
+// initSPITest sets up the fake memory maps and the R8 pins, and returns a
+// function to restore the previous state.
+func initSPITest() func() {
+	oldGPIO, oldClock, oldSPI, oldDMA := gpioMemory, clockMemory, spiMemory, dmaMemory
+	oldAlloc, oldBase, oldSysfs := spiDMAAlloc, spiBaseAddr, spiSysfsDevices
+	gpioMemory = &gpioMap{}
+	clockMemory = &clockMap{}
+	spiMemory = &spiMap{}
+	dmaMemory = nil
+	spiSysfsDevices = "/nonexistent"
+	type pinState struct {
+		altFunc   [5]string
+		available bool
+	}
+	pins := map[string]pinState{}
+	for name, f := range mappingR8 {
+		p := cpupins[name]
+		pins[name] = pinState{p.altFunc, p.available}
+		p.altFunc = f
+		p.available = true
+	}
+	return func() {
+		gpioMemory, clockMemory, spiMemory, dmaMemory = oldGPIO, oldClock, oldSPI, oldDMA
+		spiDMAAlloc, spiBaseAddr, spiSysfsDevices = oldAlloc, oldBase, oldSysfs
+		for i := range spiBuses {
+			spiBuses[i].connected = 0
+			spiBuses[i].active = nil
+		}
+		for name, s := range pins {
+			cpupins[name].altFunc = s.altFunc
+			cpupins[name].available = s.available
+		}
+	}
+}
+
+// newFakeSPI returns a SPI port connected to a simulated controller.
+func newFakeSPI(t *testing.T, bus, cs int) (*SPI, *fakeSPIRegs) {
+	s, err := NewSPI(bus, cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeSPIRegs{t: t, regs: map[spiR8Reg]uint32{}}
+	s.r = f
+	return s, f
+}
+
+// fakeSPIRegs simulates the registers of a SPI controller.
+//
+// The device on the bus returns the inverted byte it receives. A few bytes are
+// exchanged each time the status registers are read, and the exchange stalls
+// when the TX FIFO is empty or the RX FIFO is full, like the hardware does.
+type fakeSPIRegs struct {
+	t      *testing.T
+	regs   map[spiR8Reg]uint32
+	tx, rx []byte // The FIFOs.
+	sent   int    // Bytes exchanged in the current burst.
+
+	wire  []byte          // Bytes sent on MOSI.
+	cs    string          // Levels driven on CS, on each change.
+	clock []spiR8ClockCtl // Values written to the clock control register.
+
+	mem          *fakeMem
+	dmaTX, dmaRX dmaDedicatedGroup // DMA channels used by the last transfer.
+}
+
+func (f *fakeSPIRegs) read(r spiR8Reg) uint32 {
+	switch r {
+	case spiR8RegStatus:
+		f.step()
+	case spiR8RegFIFOStatus:
+		f.step()
+		return uint32(len(f.tx))<<spiR8FIFOTXShift | uint32(len(f.rx))<<spiR8FIFORXShift
+	}
+	return f.regs[r]
+}
+
+func (f *fakeSPIRegs) write(r spiR8Reg, v uint32) {
+	switch r {
+	case spiR8RegStatus:
+		// Write 1 to clear.
+		f.regs[r] &^= v
+	case spiR8RegClockCtl:
+		f.clock = append(f.clock, spiR8ClockCtl(v))
+		f.regs[r] = v
+	case spiR8RegCtl:
+		c := spiR8Ctl(v)
+		if c&spiR8RXFIFOReset != 0 {
+			f.rx = nil
+		}
+		if c&spiR8TXFIFOReset != 0 {
+			f.tx = nil
+		}
+		c &^= spiR8RXFIFOReset | spiR8TXFIFOReset
+		if c&spiR8Enable != 0 {
+			l := "L"
+			if c&spiR8CSLevel != 0 {
+				l = "H"
+			}
+			if f.cs == "" || f.cs[len(f.cs)-1:] != l {
+				f.cs += l
+			}
+		}
+		start := c&spiR8ExchangeBurst != 0 && spiR8Ctl(f.regs[r])&spiR8ExchangeBurst == 0
+		f.regs[r] = uint32(c)
+		if start {
+			f.sent = 0
+			if c&spiR8DDMA != 0 {
+				f.runDMA()
+			}
+		}
+	default:
+		f.regs[r] = v
+	}
+}
+
+func (f *fakeSPIRegs) writeTX(b byte) {
+	if len(f.tx) == spiR8FIFODepth {
+		f.t.Fatal("TX FIFO overflow")
+	}
+	f.tx = append(f.tx, b)
+}
+
+func (f *fakeSPIRegs) readRX() byte {
+	if len(f.rx) == 0 {
+		f.t.Fatal("RX FIFO underflow")
+	}
+	b := f.rx[0]
+	f.rx = f.rx[1:]
+	return b
+}
+
+// step exchanges up to 8 bytes through the FIFOs.
+func (f *fakeSPIRegs) step() {
+	ctl := spiR8Ctl(f.regs[spiR8RegCtl])
+	if ctl&spiR8ExchangeBurst == 0 || ctl&spiR8DDMA != 0 {
+		return
+	}
+	for i := 0; i < 8 && f.sent < int(f.regs[spiR8RegBurstCounter]); i++ {
+		data := f.sent < int(f.regs[spiR8RegTransmitCounter])
+		keep := !data || ctl&spiR8DiscardHash == 0
+		if (data && len(f.tx) == 0) || (keep && len(f.rx) == spiR8FIFODepth) {
+			return
+		}
+		var o byte
+		if data {
+			o = f.tx[0]
+			f.tx = f.tx[1:]
+		}
+		if b := f.exchange(o); keep {
+			f.rx = append(f.rx, b)
+		}
+	}
+	f.done()
+}
+
+// runDMA does the whole burst at once with the loaded DMA channels.
+func (f *fakeSPIRegs) runDMA() {
+	var tx, rx *dmaDedicatedGroup
+	for i := range dmaMemory.dedicated {
+		d := &dmaMemory.dedicated[i]
+		if d.cfg&ddmaLoad == 0 || d.byteCounter == 0 {
+			continue
+		}
+		d.cfg = d.cfg&^ddmaLoad | ddmaBusy
+		if d.cfg&ddmaSrcIOMode != 0 {
+			rx = d
+			f.dmaRX = *d
+		} else {
+			tx = d
+			f.dmaTX = *d
+		}
+	}
+	ctl := spiR8Ctl(f.regs[spiR8RegCtl])
+	base := uint32(f.mem.phys)
+	for f.sent < int(f.regs[spiR8RegBurstCounter]) {
+		data := f.sent < int(f.regs[spiR8RegTransmitCounter])
+		var o byte
+		if data && tx != nil {
+			o = f.mem.b[tx.srcAddr-base+uint32(f.sent)]
+		}
+		i := f.sent
+		if b := f.exchange(o); rx != nil && (!data || ctl&spiR8DiscardHash == 0) {
+			f.mem.b[rx.dstAddr-base+uint32(i)] = b
+		}
+	}
+	if tx != nil {
+		tx.cfg &^= ddmaBusy
+	}
+	if rx != nil {
+		rx.cfg &^= ddmaBusy
+	}
+	f.done()
+}
+
+func (f *fakeSPIRegs) exchange(o byte) byte {
+	f.wire = append(f.wire, o)
+	f.sent++
+	return ^o
+}
+
+func (f *fakeSPIRegs) done() {
+	if f.sent == int(f.regs[spiR8RegBurstCounter]) {
+		f.regs[spiR8RegCtl] &^= uint32(spiR8ExchangeBurst)
+		f.regs[spiR8RegStatus] |= uint32(spiR8TC)
+	}
+}
+
+// fakeMem is a buffer with a fake physical address.
+type fakeMem struct {
+	b    []byte
+	phys uint64
+}
+
+func (f *fakeMem) Close() error {
+	return nil
+}
+
+func (f *fakeMem) Bytes() []byte {
+	return f.b
+}
+
+func (f *fakeMem) AsPOD(pp interface{}) error {
+	return errors.New("not implemented")
+}
+
+func (f *fakeMem) PhysAddr() uint64 {
+	return f.phys
+}
+
+var _ spiR8Regs = &fakeSPIRegs{}
+var _ pmem.Mem = &fakeMem{}
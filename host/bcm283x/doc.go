@@ -7,6 +7,12 @@
 // This driver implements memory-mapped GPIO pin manipulation and leverages
 // sysfs-gpio for edge detection.
 //
+// SPI0 is driven directly via NewSPI(), with DMA for large transfers. Its ports
+// are registered as bcm283x-SPI0.0 and bcm283x-SPI0.1, alongside the spidev
+// ports, unless the kernel driver spi-bcm2835 is bound to the controller. The
+// I²C buses 0 and 1 are driven directly via NewI2C() and are registered as
+// BSC0 and BSC1, except the ones i2c-bcm2835 is bound to.
+//
 // If you are looking at the actual implementation, open doc.go for further
 // implementation details.
 //
@@ -5,6 +5,7 @@
 package bcm283x
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
@@ -16,6 +17,7 @@ import (
 	"periph.io/x/periph/conn/gpio"
 	"periph.io/x/periph/conn/gpio/gpioreg"
 	"periph.io/x/periph/conn/gpio/gpiostream"
+	"periph.io/x/periph/conn/pin"
 	"periph.io/x/periph/host/distro"
 	"periph.io/x/periph/host/pmem"
 	"periph.io/x/periph/host/sysfs"
@@ -216,6 +218,32 @@ func (p *Pin) Function() string {
 	}
 }
 
+// SetFunction implements pin.PinFunc.
+//
+// f must be a value previously returned by Function(), e.g. "I2C1_SDA" or
+// "<Alt0>".
+func (p *Pin) SetFunction(f string) error {
+	if gpioMemory == nil {
+		return p.wrap(errors.New("subsystem not initialized"))
+	}
+	switch {
+	case strings.HasPrefix(f, "In/"):
+		return p.In(gpio.PullNoChange, gpio.NoEdge)
+	case strings.HasPrefix(f, "Out/"):
+		return p.Out(f == "Out/"+gpio.High.String())
+	}
+	for i, a := range [...]function{alt0, alt1, alt2, alt3, alt4, alt5} {
+		if (f != "" && f == mapping[p.number][i]) || f == "<Alt"+strconv.Itoa(i)+">" {
+			if err := p.Halt(); err != nil {
+				return err
+			}
+			p.setFunction(a)
+			return nil
+		}
+	}
+	return p.wrap(errors.New("unknown function " + strconv.Quote(f)))
+}
+
 // Halt implements conn.Resource.
 //
 // If the pin is running a clock, PWM or waiting for edges, it is halted.
@@ -331,6 +359,27 @@ func (p *Pin) WaitForEdge(timeout time.Duration) bool {
 	return false
 }
 
+// ReadEdge does edge detection and implements gpio.PinEdgeEvents.
+//
+// The edge detection is done via gpio sysfs, which doesn't timestamp the
+// edges. See sysfs.Pin.ReadEdge for the details.
+func (p *Pin) ReadEdge(timeout time.Duration) (gpio.EdgeEvent, bool) {
+	if p.edge != nil {
+		return p.edge.ReadEdge(timeout)
+	}
+	return gpio.EdgeEvent{}, false
+}
+
+// WaitForEdgeContext does edge detection and implements gpio.PinInContext.
+//
+// The edge detection is done via gpio sysfs.
+func (p *Pin) WaitForEdgeContext(ctx context.Context) bool {
+	if p.edge != nil {
+		return p.edge.WaitForEdgeContext(ctx)
+	}
+	return false
+}
+
 // Pull implemented gpio.PinIn.
 //
 // bcm283x doesn't support querying the pull resistor of any GPIO pin.
@@ -378,6 +427,26 @@ func (p *Pin) FastOut(l gpio.Level) {
 	}
 }
 
+// Group implements gpio.PinGrouper.
+//
+// All the pins must be bcm283x pins. The pins in each bank, GPIO0 to GPIO31 and
+// GPIO32 to GPIO53, are set with a single write to the set register and a
+// single write to the clear register, and read with a single read operation.
+func (p *Pin) Group(pins []gpio.PinIO) (gpio.Group, error) {
+	if gpioMemory == nil {
+		return nil, p.wrap(errors.New("subsystem not initialized"))
+	}
+	g := &pinGroup{pins: pins, cpu: make([]*Pin, len(pins))}
+	for i, pin := range pins {
+		c, ok := pin.(*Pin)
+		if !ok {
+			return nil, p.wrap(fmt.Errorf("%s is not a bcm283x pin", pin))
+		}
+		g.cpu[i] = c
+	}
+	return g, nil
+}
+
 // BUG(maruel): PWM(): There is no conflict verification when multiple pins are
 // used simultaneously. The last call to PWM() will affect all pins of the same
 // type (GPCLK0, GPCLK2, PWM0 or PWM1).
@@ -611,6 +680,54 @@ func (p *Pin) wrap(err error) error {
 	return fmt.Errorf("bcm283x-gpio (%s): %v", p, err)
 }
 
+// pinGroup implements gpio.Group for bcm283x pins.
+type pinGroup struct {
+	pins []gpio.PinIO
+	cpu  []*Pin
+}
+
+func (g *pinGroup) Pins() []gpio.PinIO {
+	return g.pins
+}
+
+func (g *pinGroup) Out(bits uint64) error {
+	var set, clear [2]uint32
+	for i, p := range g.cpu {
+		l := gpio.Level(bits&(1<<uint(i)) != 0)
+		if p.function() != out {
+			if err := p.Out(l); err != nil {
+				return err
+			}
+		}
+		mask := uint32(1) << uint(p.number&31)
+		if l == gpio.High {
+			set[p.number/32] |= mask
+		} else {
+			clear[p.number/32] |= mask
+		}
+	}
+	for i := range set {
+		if set[i] != 0 {
+			gpioMemory.outputSet[i] = set[i]
+		}
+		if clear[i] != 0 {
+			gpioMemory.outputClear[i] = clear[i]
+		}
+	}
+	return nil
+}
+
+func (g *pinGroup) Read() uint64 {
+	level := [2]uint32{gpioMemory.level[0], gpioMemory.level[1]}
+	var out uint64
+	for i, p := range g.cpu {
+		if level[p.number/32]&(1<<uint(p.number&31)) != 0 {
+			out |= 1 << uint(i)
+		}
+	}
+	return out
+}
+
 //
 
 // Each pin can have one of 7 functions.
@@ -900,7 +1017,7 @@ func (d *driverGPIO) Prerequisites() []string {
 }
 
 func (d *driverGPIO) After() []string {
-	return []string{"sysfs-gpio"}
+	return []string{"sysfs-gpio", "sysfs-gpiochip"}
 }
 
 func (d *driverGPIO) Init() (bool, error) {
@@ -1033,9 +1150,14 @@ func init() {
 }
 
 var _ gpio.PinDefaultPull = &Pin{}
+var _ gpio.PinEdgeEvents = &Pin{}
+var _ gpio.PinGrouper = &Pin{}
+var _ gpio.PinInContext = &Pin{}
 var _ gpio.PinIO = &Pin{}
 var _ gpio.PinIn = &Pin{}
 var _ gpio.PinOut = &Pin{}
 var _ gpio.PinPWM = &Pin{}
 var _ gpiostream.PinIn = &Pin{}
 var _ gpiostream.PinOut = &Pin{}
+var _ pin.PinFunc = &Pin{}
+var _ gpio.Group = &pinGroup{}
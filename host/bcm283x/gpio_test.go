@@ -52,6 +52,9 @@ func TestPin(t *testing.T) {
 	if p.WaitForEdge(-1) {
 		t.Fatal("edge not initialized")
 	}
+	if _, ok := p.ReadEdge(-1); ok {
+		t.Fatal("edge not initialized")
+	}
 	if p.Out(gpio.Low) == nil {
 		t.Fatal("not initialized")
 	}
@@ -137,6 +140,79 @@ func TestPin(t *testing.T) {
 	}
 }
 
+func TestPin_SetFunction(t *testing.T) {
+	defer resetGPIOMemory()
+	gpioMemory = nil
+	p := Pin{name: "GPIO45", number: 45}
+	if p.SetFunction("In/Low") == nil {
+		t.Fatal("not initialized")
+	}
+	gpioMemory = &gpioMap{}
+	for _, f := range []string{"I2C1_SCL", "<Alt0>", "SPI2_CS2", "I2C0_SCL"} {
+		if err := p.SetFunction(f); err != nil {
+			t.Fatal(f, err)
+		}
+		if s := p.Function(); s != f && !(f == "<Alt0>" && s == "PWM1_OUT") {
+			t.Fatal(f, s)
+		}
+	}
+	if err := p.SetFunction("In/High"); err != nil {
+		t.Fatal(err)
+	}
+	if f := p.function(); f != in {
+		t.Fatal(f)
+	}
+	if err := p.SetFunction("Out/High"); err != nil {
+		t.Fatal(err)
+	}
+	if f := p.function(); f != out || gpioMemory.outputSet[1] != 1<<(45-32) {
+		t.Fatal(f)
+	}
+	if p.SetFunction("UART0_RXD") == nil {
+		t.Fatal("not a function of GPIO45")
+	}
+	if p.SetFunction("") == nil {
+		t.Fatal("empty function")
+	}
+}
+
+func TestPin_Group(t *testing.T) {
+	defer resetGPIOMemory()
+	gpioMemory = nil
+	p0 := &Pin{name: "GPIO4", number: 4}
+	p1 := &Pin{name: "GPIO40", number: 40}
+	if _, err := p0.Group([]gpio.PinIO{p0, p1}); err == nil {
+		t.Fatal("not initialized")
+	}
+	gpioMemory = &gpioMap{}
+	if _, err := p0.Group([]gpio.PinIO{p0, gpio.INVALID}); err == nil {
+		t.Fatal("not a bcm283x pin")
+	}
+	g, err := gpio.NewGroup(p0, p1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := g.(*pinGroup); !ok {
+		t.Fatal("expected bcm283x group")
+	}
+	if l := g.Pins(); len(l) != 2 || l[1] != p1 {
+		t.Fatal(l)
+	}
+	if err := g.Out(1); err != nil {
+		t.Fatal(err)
+	}
+	if f := p0.function(); f != out {
+		t.Fatal(f)
+	}
+	if gpioMemory.outputSet[0] != 1<<4 || gpioMemory.outputClear[1] != 1<<8 {
+		t.Fatal(gpioMemory.outputSet, gpioMemory.outputClear)
+	}
+	gpioMemory.level[1] = 1 << 8
+	if v := g.Read(); v != 2 {
+		t.Fatal(v)
+	}
+}
+
 func TestPinPWM(t *testing.T) {
 	defer func() {
 		clockMemory = nil
@@ -0,0 +1,595 @@
+// Copyright 2018 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package bcm283x
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+	"unsafe"
+
+	"periph.io/x/periph"
+	"periph.io/x/periph/conn/gpio"
+	"periph.io/x/periph/conn/i2c"
+	"periph.io/x/periph/conn/i2c/i2creg"
+	"periph.io/x/periph/host/pmem"
+)
+
+// NewI2C returns an I²C bus driving the BSC (Broadcom Serial Controller)
+// directly, without going through the kernel driver.
+//
+// Only buses 0 and 1 are supported. BSC2 is reserved for HDMI.
+//
+// Unlike the kernel driver, the speed is set per bus and the clock stretching
+// timeout is configurable with SetClockStretchTimeout().
+//
+// The BSC has a known bug where clock stretching is ignored when the device
+// stretches the clock during the first half of the clock period. Reducing the
+// speed makes it less likely to happen.
+//
+// The objects returned for the same bus share its state: the speed and the
+// timeouts set on one apply to all, and the controller is disabled once all of
+// them are closed.
+//
+// An error is returned when the kernel driver i2c-bcm2835 is bound to the
+// controller.
+func NewI2C(bus int) (*I2C, error) {
+	if bus != 0 && bus != 1 {
+		return nil, fmt.Errorf("bcm283x-i2c: only buses 0 and 1 are supported, got %d", bus)
+	}
+	if i2cMemory[bus] == nil {
+		return nil, errors.New("bcm283x-i2c: subsystem not initialized")
+	}
+	if kernelDriverBound("i2c-bcm2835", i2cOffsets[bus]) {
+		return nil, fmt.Errorf("bcm283x-i2c: BSC%d is used by the kernel driver i2c-bcm2835", bus)
+	}
+	b := &i2cBuses[bus]
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	i := &I2C{bus: bus, b: b, r: i2cMemory[bus]}
+	i.scl, i.sda = i2cPins(bus)
+	for _, n := range []struct {
+		p *Pin
+		f string
+	}{
+		{i.scl, fmt.Sprintf("I2C%d_SCL", bus)},
+		{i.sda, fmt.Sprintf("I2C%d_SDA", bus)},
+	} {
+		if err := n.p.SetFunction(n.f); err != nil {
+			return nil, fmt.Errorf("bcm283x-i2c: %v", err)
+		}
+	}
+	if b.open == 0 {
+		b.stretch = i2cDefaultStretch
+		b.timeout = 0
+		i.setSpeedLocked(i2cDefaultSpeed)
+	}
+	b.open++
+	return i, nil
+}
+
+// I2C is an I²C bus driven by the CPU BSC controller.
+//
+// It can be used to communicate with multiple devices from multiple goroutines.
+type I2C struct {
+	// Immutable.
+	bus int
+	b   *i2cBus
+	r   i2cRegs
+	scl *Pin
+	sda *Pin
+
+	// Protected by b.mu.
+	closed bool
+}
+
+func (i *I2C) String() string {
+	return fmt.Sprintf("BSC%d", i.bus)
+}
+
+// Close implements i2c.BusCloser.
+//
+// The controller is disabled once all the objects of the bus are closed. The
+// pins are left as is.
+func (i *I2C) Close() error {
+	i.b.mu.Lock()
+	defer i.b.mu.Unlock()
+	if i.closed {
+		return nil
+	}
+	i.closed = true
+	if i.b.open--; i.b.open == 0 {
+		i.r.write(i2cRegC, uint32(i2cClear))
+	}
+	return nil
+}
+
+// Tx implements i2c.Bus.
+//
+// When both w and r are specified, a repeated START is sent between the write
+// and the read. This requires w to fit in the FIFO, which is 16 bytes.
+//
+// The controller can't reliably do a transaction without data, so at least one
+// of w or r must be specified.
+func (i *I2C) Tx(addr uint16, w, r []byte) error {
+	if addr >= 0x80 {
+		return errors.New("bcm283x-i2c: invalid address")
+	}
+	if len(w) == 0 && len(r) == 0 {
+		return errors.New("bcm283x-i2c: a transaction without data is not supported")
+	}
+	if len(w) > i2cMaxTxSize || len(r) > i2cMaxTxSize {
+		return fmt.Errorf("bcm283x-i2c: maximum transfer length is %d", i2cMaxTxSize)
+	}
+	if len(w) > i2cFIFOSize && len(r) != 0 {
+		return fmt.Errorf("bcm283x-i2c: the write before a repeated START must be at most %d bytes, got %d", i2cFIFOSize, len(w))
+	}
+	i.b.mu.Lock()
+	defer i.b.mu.Unlock()
+	if i.closed {
+		return errors.New("bcm283x-i2c: bus is closed")
+	}
+	return i.txLocked(addr, w, r)
+}
+
+// TxMsgs implements i2c.MsgBus.
+//
+// The controller can't do arbitrary combined transactions; only a single
+// message or a write of at most 16 bytes followed by a read are supported.
+// Only the Read flag is supported.
+func (i *I2C) TxMsgs(msgs []i2c.Msg) error {
+	switch len(msgs) {
+	case 0:
+		return nil
+	case 1:
+	case 2:
+		if msgs[0].Flags&i2c.Read != 0 || msgs[1].Flags&i2c.Read == 0 || msgs[0].Addr != msgs[1].Addr {
+			return errors.New("bcm283x-i2c: only a write followed by a read to the same address can be combined")
+		}
+	default:
+		return fmt.Errorf("bcm283x-i2c: maximum 2 messages per transaction, got %d", len(msgs))
+	}
+	for j := range msgs {
+		if f := msgs[j].Flags &^ i2c.Read; f != 0 {
+			return fmt.Errorf("bcm283x-i2c: flags %s are not supported", f)
+		}
+	}
+	if len(msgs) == 2 {
+		return i.Tx(msgs[0].Addr, msgs[0].Buf, msgs[1].Buf)
+	}
+	if msgs[0].Flags&i2c.Read != 0 {
+		return i.Tx(msgs[0].Addr, nil, msgs[0].Buf)
+	}
+	return i.Tx(msgs[0].Addr, msgs[0].Buf, nil)
+}
+
+// SetSpeed implements i2c.Bus.
+//
+// Unlike the kernel driver, it only affects this bus. The actual speed is the
+// fastest speed not above hz that the clock divider can generate.
+func (i *I2C) SetSpeed(hz int64) error {
+	if hz < 1 || hz > i2cMaxSpeed {
+		return fmt.Errorf("bcm283x-i2c: invalid speed %d", hz)
+	}
+	i.b.mu.Lock()
+	defer i.b.mu.Unlock()
+	i.setSpeedLocked(hz)
+	return nil
+}
+
+// SetTimeout implements i2c.BusTimeout.
+//
+// By default, the timeout is derived from the transaction length and the
+// speed.
+func (i *I2C) SetTimeout(d time.Duration) error {
+	if d <= 0 {
+		return fmt.Errorf("bcm283x-i2c: invalid timeout %s", d)
+	}
+	i.b.mu.Lock()
+	defer i.b.mu.Unlock()
+	i.b.timeout = d
+	return nil
+}
+
+// SetClockStretchTimeout sets the maximum duration a device can stretch the
+// clock before the transaction is aborted with an error implementing
+// i2c.TimeoutError.
+//
+// The controller counts it in SCL cycles, so it is capped at 65535 cycles at
+// the current speed. 0 disables the timeout. The default is 35ms, as the SMBus
+// timeout.
+func (i *I2C) SetClockStretchTimeout(d time.Duration) error {
+	if d < 0 {
+		return fmt.Errorf("bcm283x-i2c: invalid clock stretch timeout %s", d)
+	}
+	i.b.mu.Lock()
+	defer i.b.mu.Unlock()
+	i.b.stretch = d
+	i.r.write(i2cRegCLKT, i2cStretchCycles(d, i.b.hz))
+	return nil
+}
+
+// SCL implements i2c.Pins.
+func (i *I2C) SCL() gpio.PinIO {
+	return i.scl
+}
+
+// SDA implements i2c.Pins.
+func (i *I2C) SDA() gpio.PinIO {
+	return i.sda
+}
+
+//
+
+var (
+	// i2cMemory is the memory map of the BSC0 and BSC1 registers.
+	i2cMemory [2]*i2cMap
+	// i2cBuses is the state of BSC0 and BSC1.
+	i2cBuses [2]i2cBus
+	// i2cOffsets is the offset of the BSC0 and BSC1 registers from the
+	// peripherals base address.
+	i2cOffsets = [2]uint32{0x205000, 0x804000}
+)
+
+// i2cBus is the state of a BSC controller, shared by the I2C objects of the
+// bus.
+type i2cBus struct {
+	mu      sync.Mutex
+	open    int // Number of I2C objects not closed yet.
+	hz      int64
+	stretch time.Duration
+	timeout time.Duration
+}
+
+const (
+	// i2cFIFOSize is the depth of the FIFO.
+	i2cFIFOSize = 16
+	// i2cMaxTxSize is bounded by the DLEN register.
+	i2cMaxTxSize = 65535
+	// i2cDefaultSpeed is the speed used until SetSpeed() is called.
+	i2cDefaultSpeed = 100000
+	// i2cMaxSpeed is the fastest speed supported by the controller.
+	i2cMaxSpeed = 3400000
+	// i2cDefaultStretch is the default clock stretching timeout.
+	i2cDefaultStretch = 35 * time.Millisecond
+)
+
+// Page 29
+type i2cC uint32
+
+const (
+	// 31:16 reserved
+	i2cEnable i2cC = 1 << 15 // I2CEN I2C enable
+	// 14:11 reserved
+	i2cINTR i2cC = 1 << 10 // INTR Interrupt on RX
+	i2cINTT i2cC = 1 << 9  // INTT Interrupt on TX
+	i2cINTD i2cC = 1 << 8  // INTD Interrupt on DONE
+	i2cST   i2cC = 1 << 7  // ST Start transfer
+	// 6 reserved
+	i2cClear i2cC = 1 << 4 // CLEAR FIFO clear; bits 5:4
+	// 3:1 reserved
+	i2cRead i2cC = 1 << 0 // READ Read transfer
+)
+
+// Pages 31-32
+type i2cS uint32
+
+const (
+	// 31:10 reserved
+	i2cCLKT i2cS = 1 << 9 // CLKT Clock stretch timeout; write 1 to clear
+	i2cERR  i2cS = 1 << 8 // ERR ACK error; write 1 to clear
+	i2cRXF  i2cS = 1 << 7 // RXF FIFO full
+	i2cTXE  i2cS = 1 << 6 // TXE FIFO empty
+	i2cRXD  i2cS = 1 << 5 // RXD FIFO contains data
+	i2cTXD  i2cS = 1 << 4 // TXD FIFO can accept data
+	i2cRXR  i2cS = 1 << 3 // RXR FIFO needs reading (full)
+	i2cTXW  i2cS = 1 << 2 // TXW FIFO needs writing (full)
+	i2cDone i2cS = 1 << 1 // DONE Transfer done; write 1 to clear
+	i2cTA   i2cS = 1 << 0 // TA Transfer active
+)
+
+// i2cMap is the registers of a BSC controller.
+//
+// Page 28
+type i2cMap struct {
+	c    i2cC   // C Control
+	s    i2cS   // S Status
+	dlen uint32 // DLEN Data length
+	a    uint32 // A Slave address
+	fifo uint32 // FIFO Data FIFO
+	div  uint32 // DIV Clock divider; only the 16 lower bits are used
+	del  uint32 // DEL Data delay; FEDL in 31:16, REDL in 15:0
+	clkt uint32 // CLKT Clock stretch timeout in SCL cycles; only the 16 lower bits are used
+}
+
+// i2cReg is the offset of a register in i2cMap.
+type i2cReg uint32
+
+const (
+	i2cRegC    i2cReg = 0x00
+	i2cRegS    i2cReg = 0x04
+	i2cRegDLEN i2cReg = 0x08
+	i2cRegA    i2cReg = 0x0C
+	i2cRegFIFO i2cReg = 0x10
+	i2cRegDIV  i2cReg = 0x14
+	i2cRegDEL  i2cReg = 0x18
+	i2cRegCLKT i2cReg = 0x1C
+)
+
+// i2cRegs is the access to the registers of a BSC controller.
+//
+// It is implemented by i2cMap for the memory mapped registers, and by a
+// simulated controller in the unit tests.
+type i2cRegs interface {
+	read(r i2cReg) uint32
+	write(r i2cReg, v uint32)
+}
+
+func (m *i2cMap) read(r i2cReg) uint32 {
+	return *m.reg(r)
+}
+
+func (m *i2cMap) write(r i2cReg, v uint32) {
+	*m.reg(r) = v
+}
+
+func (m *i2cMap) reg(r i2cReg) *uint32 {
+	return (*uint32)(unsafe.Pointer(uintptr(unsafe.Pointer(m)) + uintptr(r)))
+}
+
+// i2cPins returns the pins the bus is muxed to.
+//
+// If no pin is currently set to the bus function, the default pins GPIO0/1 for
+// bus 0 and GPIO2/3 for bus 1 are used.
+func i2cPins(bus int) (*Pin, *Pin) {
+	var pairs [][2]*Pin
+	if bus == 0 {
+		pairs = [][2]*Pin{{GPIO1, GPIO0}, {GPIO29, GPIO28}, {GPIO45, GPIO44}}
+	} else {
+		pairs = [][2]*Pin{{GPIO3, GPIO2}, {GPIO45, GPIO44}}
+	}
+	f := fmt.Sprintf("I2C%d_SDA", bus)
+	for _, p := range pairs {
+		if p[1].Function() == f {
+			return p[0], p[1]
+		}
+	}
+	return pairs[0][0], pairs[0][1]
+}
+
+// i2cClockDiv returns the clock divider to get the fastest SCL clock not above
+// hz, and the resulting speed.
+//
+// The divider is even; 0 means 32768.
+func i2cClockDiv(hz int64) (uint32, int64) {
+	if hz <= 0 || hz > coreClock/2 {
+		hz = coreClock / 2
+	}
+	d := (coreClock + hz - 1) / hz
+	d += d & 1
+	if d >= 32768 {
+		return 0, coreClock / 32768
+	}
+	return uint32(d), coreClock / d
+}
+
+// i2cDelay returns the DEL register value for a clock divider.
+//
+// The data is sampled and changed a fraction of the SCL period after the
+// edges, like the kernel driver does.
+func i2cDelay(div uint32) uint32 {
+	d := div
+	if d == 0 {
+		d = 32768
+	}
+	fedl := d / 16
+	if fedl < 1 {
+		fedl = 1
+	}
+	redl := d / 4
+	if redl < 1 {
+		redl = 1
+	}
+	return fedl<<16 | redl
+}
+
+// i2cStretchCycles converts a clock stretching timeout into SCL cycles at hz.
+func i2cStretchCycles(d time.Duration, hz int64) uint32 {
+	c := int64(d) * hz / int64(time.Second)
+	if d > 0 && c == 0 {
+		c = 1
+	}
+	if c > 0xFFFF {
+		c = 0xFFFF
+	}
+	return uint32(c)
+}
+
+func (i *I2C) setSpeedLocked(hz int64) {
+	div, actual := i2cClockDiv(hz)
+	i.r.write(i2cRegDIV, div)
+	i.r.write(i2cRegDEL, i2cDelay(div))
+	i.b.hz = actual
+	i.r.write(i2cRegCLKT, i2cStretchCycles(i.b.stretch, actual))
+}
+
+// txLocked does a transaction.
+//
+// When both w and r are specified, the read is started as soon as the write is
+// active. The controller then sends a repeated START instead of a STOP once
+// the write is done.
+func (i *I2C) txLocked(addr uint16, w, r []byte) error {
+	m := i.r
+	timeout := i.b.timeout
+	if timeout == 0 {
+		// 9 clocks per byte, including the address.
+		timeout = time.Duration(int64(len(w)+len(r)+2)*9*int64(time.Second)/i.b.hz)*2 + 100*time.Millisecond
+	}
+	deadline := time.Now().Add(timeout)
+
+	// Clear the sticky status bits and the FIFO.
+	if s := i2cS(m.read(i2cRegS)) & (i2cCLKT | i2cERR | i2cDone); s != 0 {
+		m.write(i2cRegS, uint32(s))
+	}
+	m.write(i2cRegC, uint32(i2cEnable|i2cClear))
+	m.write(i2cRegA, uint32(addr))
+	if len(w) != 0 && len(r) != 0 {
+		m.write(i2cRegDLEN, uint32(len(w)))
+		for _, b := range w {
+			m.write(i2cRegFIFO, uint32(b))
+		}
+		m.write(i2cRegC, uint32(i2cEnable|i2cST))
+		for i2cS(m.read(i2cRegS))&(i2cTA|i2cDone) == 0 {
+			if time.Now().After(deadline) {
+				return &i2cError{msg: "bcm283x-i2c: transfer didn't start", timeout: true}
+			}
+		}
+		if err := i2cStatusErr(i2cS(m.read(i2cRegS))); err != nil {
+			return err
+		}
+		m.write(i2cRegDLEN, uint32(len(r)))
+		m.write(i2cRegC, uint32(i2cEnable|i2cST|i2cRead))
+		return i2cXfer(m, nil, r, deadline)
+	}
+	if len(w) != 0 {
+		m.write(i2cRegDLEN, uint32(len(w)))
+		m.write(i2cRegC, uint32(i2cEnable|i2cST))
+		return i2cXfer(m, w, nil, deadline)
+	}
+	m.write(i2cRegDLEN, uint32(len(r)))
+	m.write(i2cRegC, uint32(i2cEnable|i2cST|i2cRead))
+	return i2cXfer(m, nil, r, deadline)
+}
+
+// i2cXfer feeds and drains the FIFO until the transfer is done.
+func i2cXfer(m i2cRegs, w, r []byte, deadline time.Time) error {
+	sent, recv := 0, 0
+	for {
+		s := i2cS(m.read(i2cRegS))
+		if err := i2cStatusErr(s); err != nil {
+			return err
+		}
+		for sent < len(w) && i2cS(m.read(i2cRegS))&i2cTXD != 0 {
+			m.write(i2cRegFIFO, uint32(w[sent]))
+			sent++
+		}
+		for recv < len(r) && i2cS(m.read(i2cRegS))&i2cRXD != 0 {
+			r[recv] = byte(m.read(i2cRegFIFO))
+			recv++
+		}
+		if s&i2cDone != 0 && recv == len(r) {
+			m.write(i2cRegS, uint32(i2cDone))
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return &i2cError{msg: "bcm283x-i2c: transfer timed out", timeout: true}
+		}
+	}
+}
+
+// i2cStatusErr returns the error reported by the status register, if any.
+func i2cStatusErr(s i2cS) error {
+	if s&i2cCLKT != 0 {
+		return &i2cError{msg: "bcm283x-i2c: device stretched the clock for too long", timeout: true}
+	}
+	if s&i2cERR != 0 {
+		return &i2cError{msg: "bcm283x-i2c: device didn't acknowledge", nack: true}
+	}
+	return nil
+}
+
+// i2cError is an error returned by a transaction.
+type i2cError struct {
+	msg     string
+	nack    bool
+	timeout bool
+}
+
+func (e *i2cError) Error() string {
+	return e.msg
+}
+
+// NACK implements i2c.NACKError.
+func (e *i2cError) NACK() bool {
+	return e.nack
+}
+
+// Timeout implements i2c.TimeoutError.
+func (e *i2cError) Timeout() bool {
+	return e.timeout
+}
+
+// driverI2C implements periph.Driver.
+type driverI2C struct {
+}
+
+func (d *driverI2C) String() string {
+	return "bcm283x-i2c"
+}
+
+func (d *driverI2C) Prerequisites() []string {
+	return []string{"bcm283x-gpio"}
+}
+
+func (d *driverI2C) After() []string {
+	return []string{"sysfs-i2c"}
+}
+
+func (d *driverI2C) Init() (bool, error) {
+	if err := initCoreClock(); err != nil {
+		return true, err
+	}
+	// baseAddr is initialized by prerequisite driver bcm283x-gpio.
+	for bus, off := range i2cOffsets {
+		if err := pmem.MapAsPOD(uint64(baseAddr+off), &i2cMemory[bus]); err != nil {
+			if os.IsPermission(err) {
+				return true, fmt.Errorf("need more access, try as root: %v", err)
+			}
+			return true, err
+		}
+	}
+	return true, registerI2C()
+}
+
+// registerI2C registers the buses as BSC0 and BSC1.
+//
+// They are registered alongside the buses registered by sysfs-i2c, so the
+// kernel driver stays the default. The buses the kernel driver is bound to are
+// skipped.
+func registerI2C() error {
+	for bus := 0; bus < 2; bus++ {
+		if kernelDriverBound("i2c-bcm2835", i2cOffsets[bus]) {
+			continue
+		}
+		if err := i2creg.Register(fmt.Sprintf("BSC%d", bus), nil, -1, openerI2C(bus).Open); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type openerI2C int
+
+func (o openerI2C) Open() (i2c.BusCloser, error) {
+	return NewI2C(int(o))
+}
+
+func init() {
+	if isArm {
+		periph.MustRegister(&driverI2C{})
+	}
+}
+
+var _ i2c.Bus = &I2C{}
+var _ i2c.BusCloser = &I2C{}
+var _ i2c.BusTimeout = &I2C{}
+var _ i2c.MsgBus = &I2C{}
+var _ i2c.Pins = &I2C{}
+var _ i2c.NACKError = &i2cError{}
+var _ i2c.TimeoutError = &i2cError{}
+var _ i2cRegs = &i2cMap{}
+var _ fmt.Stringer = &I2C{}
@@ -0,0 +1,682 @@
+// Copyright 2018 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package bcm283x
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"periph.io/x/periph/conn/i2c"
+	"periph.io/x/periph/conn/i2c/i2creg"
+)
+
+func TestNewI2C(t *testing.T) {
+	if i, err := NewI2C(1); i != nil || err == nil {
+		t.Fatal("not initialized")
+	}
+	defer initI2CTest()()
+	if i, err := NewI2C(2); i != nil || err == nil {
+		t.Fatal("BSC2 is not supported")
+	}
+	i, err := NewI2C(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := i.String(); s != "BSC1" {
+		t.Fatal(s)
+	}
+	if p := i.SCL(); p != GPIO3 {
+		t.Fatal(p)
+	}
+	if p := i.SDA(); p != GPIO2 {
+		t.Fatal(p)
+	}
+	for _, p := range []*Pin{GPIO2, GPIO3} {
+		if f := p.function(); f != alt0 {
+			t.Fatal(p, f)
+		}
+	}
+	m := i2cMemory[1]
+	if m.div != 2500 || m.del != 156<<16|625 || m.clkt != 3500 {
+		t.Fatalf("%#v", m)
+	}
+	if err := i.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if m.c != i2cClear {
+		t.Fatalf("0x%x", m.c)
+	}
+}
+
+func TestI2C_Pins(t *testing.T) {
+	defer initI2CTest()()
+	if err := GPIO28.SetFunction("I2C0_SDA"); err != nil {
+		t.Fatal(err)
+	}
+	i, err := NewI2C(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i.SCL() != GPIO29 || i.SDA() != GPIO28 {
+		t.Fatal(i.SCL(), i.SDA())
+	}
+}
+
+func TestI2C_SetSpeed(t *testing.T) {
+	defer initI2CTest()()
+	i, err := NewI2C(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := i.SetSpeed(0); err == nil {
+		t.Fatal("invalid speed")
+	}
+	if err := i.SetSpeed(i2cMaxSpeed + 1); err == nil {
+		t.Fatal("invalid speed")
+	}
+	if err := i.SetSpeed(400000); err != nil {
+		t.Fatal(err)
+	}
+	m := i2cMemory[0]
+	if m.div != 626 || m.clkt != 13977 || i.b.hz != 399361 {
+		t.Fatalf("%#v %d", m, i.b.hz)
+	}
+	// The other bus is unaffected.
+	if i2cMemory[1].div != 0 {
+		t.Fatal(i2cMemory[1].div)
+	}
+	if err := i.SetClockStretchTimeout(-1); err == nil {
+		t.Fatal("invalid timeout")
+	}
+	if err := i.SetClockStretchTimeout(0); err != nil {
+		t.Fatal(err)
+	}
+	if m.clkt != 0 {
+		t.Fatal(m.clkt)
+	}
+	if err := i.SetClockStretchTimeout(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if m.clkt != 0xFFFF {
+		t.Fatal(m.clkt)
+	}
+	if err := i.SetTimeout(0); err == nil {
+		t.Fatal("invalid timeout")
+	}
+	if err := i.SetTimeout(time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestI2C_shared(t *testing.T) {
+	defer initI2CTest()()
+	i1, err := NewI2C(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := i1.SetSpeed(400000); err != nil {
+		t.Fatal(err)
+	}
+	i2, err := NewI2C(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The speed is kept for the other objects of the bus.
+	if m := i2cMemory[1]; m.div != 626 || i2.b.hz != 399361 {
+		t.Fatalf("%#v %d", m, i2.b.hz)
+	}
+	// The controller is disabled only once all the objects are closed.
+	i2cMemory[1].c = i2cEnable
+	if err := i1.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := i1.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if i2cMemory[1].c != i2cEnable {
+		t.Fatalf("0x%x", i2cMemory[1].c)
+	}
+	if err := i1.Tx(0x10, []byte{1}, nil); err == nil {
+		t.Fatal("closed")
+	}
+	if err := i2.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if i2cMemory[1].c != i2cClear {
+		t.Fatalf("0x%x", i2cMemory[1].c)
+	}
+	// Once all closed, the default speed is set again.
+	if _, err := NewI2C(1); err != nil {
+		t.Fatal(err)
+	}
+	if m := i2cMemory[1]; m.div != 2500 {
+		t.Fatalf("%#v", m)
+	}
+}
+
+func TestI2C_Tx(t *testing.T) {
+	defer initI2CTest()()
+	i, err := NewI2C(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := i.Tx(0x80, []byte{1}, nil); err == nil {
+		t.Fatal("invalid address")
+	}
+	if err := i.Tx(0x10, nil, nil); err == nil {
+		t.Fatal("no data")
+	}
+	if err := i.Tx(0x10, make([]byte, i2cFIFOSize+1), []byte{0}); err == nil {
+		t.Fatal("write too long for a repeated START")
+	}
+	if err := i.Tx(0x10, make([]byte, i2cMaxTxSize+1), nil); err == nil {
+		t.Fatal("write too long")
+	}
+
+	// The status register is static, so the transfers end with an error.
+	m := i2cMemory[1]
+	m.s = i2cDone | i2cERR
+	err = i.Tx(0x10, []byte{1, 2}, nil)
+	if e, ok := err.(i2c.NACKError); !ok || !e.NACK() {
+		t.Fatal(err)
+	}
+	if m.a != 0x10 || m.dlen != 2 || m.c != i2cEnable|i2cST {
+		t.Fatalf("%#v", m)
+	}
+	m.s = i2cDone | i2cCLKT
+	err = i.Tx(0x11, nil, []byte{0})
+	if e, ok := err.(i2c.TimeoutError); !ok || !e.Timeout() {
+		t.Fatal(err)
+	}
+	if m.a != 0x11 || m.dlen != 1 || m.c != i2cEnable|i2cST|i2cRead {
+		t.Fatalf("%#v", m)
+	}
+
+	// Repeated START.
+	if err := i.SetTimeout(time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	m.s = 0
+	err = i.Tx(0x12, []byte{1, 2, 3}, []byte{0})
+	if e, ok := err.(i2c.TimeoutError); !ok || !e.Timeout() {
+		t.Fatal(err)
+	}
+	if m.a != 0x12 || m.dlen != 3 || m.fifo != 3 || m.c != i2cEnable|i2cST {
+		t.Fatalf("%#v", m)
+	}
+	m.s = i2cTA
+	err = i.Tx(0x12, []byte{1, 2, 3}, []byte{0, 0})
+	if e, ok := err.(i2c.TimeoutError); !ok || !e.Timeout() {
+		t.Fatal(err)
+	}
+	if m.dlen != 2 || m.c != i2cEnable|i2cST|i2cRead {
+		t.Fatalf("%#v", m)
+	}
+}
+
+func TestI2C_TxMsgs(t *testing.T) {
+	defer initI2CTest()()
+	i, err := NewI2C(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := i.TxMsgs(nil); err != nil {
+		t.Fatal(err)
+	}
+	bad := [][]i2c.Msg{
+		{{Addr: 0x10}, {Addr: 0x10}},
+		{{Addr: 0x10, Flags: i2c.Read}, {Addr: 0x10, Flags: i2c.Read}},
+		{{Addr: 0x10}, {Addr: 0x11, Flags: i2c.Read}},
+		{{Addr: 0x10}, {Addr: 0x10}, {Addr: 0x10, Flags: i2c.Read}},
+		{{Addr: 0x10, Flags: i2c.TenBit}},
+		{{Addr: 0x10, Flags: i2c.Read | i2c.RecvLen}},
+	}
+	for j, msgs := range bad {
+		if err := i.TxMsgs(msgs); err == nil {
+			t.Fatalf("#%d: invalid messages", j)
+		}
+	}
+	m := i2cMemory[1]
+	m.s = i2cDone | i2cERR
+	if err := i.TxMsgs([]i2c.Msg{{Addr: 0x10, Flags: i2c.Read, Buf: []byte{0}}}); err == nil {
+		t.Fatal("NACK")
+	}
+	if m.c != i2cEnable|i2cST|i2cRead {
+		t.Fatalf("0x%x", m.c)
+	}
+	if err := i.TxMsgs([]i2c.Msg{{Addr: 0x10, Buf: []byte{0}}}); err == nil {
+		t.Fatal("NACK")
+	}
+	if m.c != i2cEnable|i2cST {
+		t.Fatalf("0x%x", m.c)
+	}
+	if err := i.TxMsgs([]i2c.Msg{{Addr: 0x10, Buf: []byte{1}}, {Addr: 0x10, Flags: i2c.Read, Buf: []byte{0}}}); err == nil {
+		t.Fatal("NACK")
+	}
+	if m.fifo != 1 {
+		t.Fatal(m.fifo)
+	}
+}
+
+func TestI2C_Tx_write(t *testing.T) {
+	defer initI2CTest()()
+	i, f := newFakeI2C(t, 1)
+	if err := i.Tx(0x50, []byte{1, 2, 3}, nil); err != nil {
+		t.Fatal(err)
+	}
+	f.check(t, "S 0x50 W", "0x01", "0x02", "0x03", "P")
+
+	// Larger than the FIFO.
+	w := make([]byte, 3*i2cFIFOSize)
+	for j := range w {
+		w[j] = byte(j)
+	}
+	if err := i.Tx(0x50, w, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.wire) != len(w)+2 || f.wire[len(w)] != "0x2f" {
+		t.Fatal(f.wire)
+	}
+	f.wire = nil
+
+	// The device doesn't acknowledge its address.
+	err := i.Tx(0x51, []byte{1}, nil)
+	if e, ok := err.(i2c.NACKError); !ok || !e.NACK() {
+		t.Fatal(err)
+	}
+	f.check(t, "S 0x51 W", "P")
+	// The sticky error is cleared by the next transaction.
+	if err := i.Tx(0x50, []byte{4}, nil); err != nil {
+		t.Fatal(err)
+	}
+	f.check(t, "S 0x50 W", "0x04", "P")
+}
+
+func TestI2C_Tx_read(t *testing.T) {
+	defer initI2CTest()()
+	i, f := newFakeI2C(t, 1)
+	f.data = []byte{0xaa, 0xbb}
+	r := make([]byte, 2)
+	if err := i.Tx(0x50, nil, r); err != nil {
+		t.Fatal(err)
+	}
+	if r[0] != 0xaa || r[1] != 0xbb {
+		t.Fatalf("%#x", r)
+	}
+	f.check(t, "S 0x50 R", "0xaa", "0xbb", "P")
+
+	// Larger than the FIFO.
+	r = make([]byte, 3*i2cFIFOSize)
+	for j := range r {
+		f.data = append(f.data, byte(j))
+	}
+	if err := i.TxMsgs([]i2c.Msg{{Addr: 0x50, Flags: i2c.Read, Buf: r}}); err != nil {
+		t.Fatal(err)
+	}
+	for j := range r {
+		if r[j] != byte(j) {
+			t.Fatalf("%#x", r)
+		}
+	}
+}
+
+func TestI2C_Tx_restart(t *testing.T) {
+	defer initI2CTest()()
+	i, f := newFakeI2C(t, 1)
+	f.data = []byte{0xaa, 0xbb}
+	r := make([]byte, 2)
+	if err := i.Tx(0x50, []byte{0x10, 0x11}, r); err != nil {
+		t.Fatal(err)
+	}
+	if r[0] != 0xaa || r[1] != 0xbb {
+		t.Fatalf("%#x", r)
+	}
+	// No STOP between the write and the read.
+	f.check(t, "S 0x50 W", "0x10", "0x11", "Sr 0x50 R", "0xaa", "0xbb", "P")
+
+	f.data = []byte{0xcc}
+	msgs := []i2c.Msg{{Addr: 0x50, Buf: []byte{0x12}}, {Addr: 0x50, Flags: i2c.Read, Buf: r[:1]}}
+	if err := i.TxMsgs(msgs); err != nil {
+		t.Fatal(err)
+	}
+	if r[0] != 0xcc {
+		t.Fatalf("%#x", r)
+	}
+	f.check(t, "S 0x50 W", "0x12", "Sr 0x50 R", "0xcc", "P")
+}
+
+func TestI2CClockDiv(t *testing.T) {
+	defer func(old int64) { coreClock = old }(coreClock)
+	data := []struct {
+		clock  int64
+		hz     int64
+		div    uint32
+		actual int64
+	}{
+		{250000000, 0, 2, 125000000},
+		{250000000, 100000, 2500, 100000},
+		{250000000, 400000, 626, 399361},
+		{250000000, 3400000, 74, 3378378},
+		{250000000, 7630, 32766, 7629},
+		{250000000, 7629, 0, 7629},
+		{250000000, 1, 0, 7629},
+		// core_freq=400 in config.txt.
+		{400000000, 0, 2, 200000000},
+		{400000000, 100000, 4000, 100000},
+		{400000000, 400000, 1000, 400000},
+		{400000000, 3400000, 118, 3389830},
+		{400000000, 1, 0, 12207},
+	}
+	for i, line := range data {
+		coreClock = line.clock
+		div, actual := i2cClockDiv(line.hz)
+		if div != line.div || actual != line.actual {
+			t.Fatalf("#%d: i2cClockDiv(%d) = %d, %d", i, line.hz, div, actual)
+		}
+	}
+}
+
+func TestI2CDelay(t *testing.T) {
+	if d := i2cDelay(2500); d != 156<<16|625 {
+		t.Fatalf("0x%x", d)
+	}
+	if d := i2cDelay(2); d != 1<<16|1 {
+		t.Fatalf("0x%x", d)
+	}
+	if d := i2cDelay(0); d != 2048<<16|8192 {
+		t.Fatalf("0x%x", d)
+	}
+}
+
+func TestI2CStretchCycles(t *testing.T) {
+	data := []struct {
+		d      time.Duration
+		hz     int64
+		cycles uint32
+	}{
+		{0, 100000, 0},
+		{time.Nanosecond, 100000, 1},
+		{35 * time.Millisecond, 100000, 3500},
+		{time.Second, 100000, 0xFFFF},
+	}
+	for i, line := range data {
+		if c := i2cStretchCycles(line.d, line.hz); c != line.cycles {
+			t.Fatalf("#%d: %d", i, c)
+		}
+	}
+}
+
+func TestRegisterI2C(t *testing.T) {
+	defer initI2CTest()()
+	if err := registerI2C(); err != nil {
+		t.Fatal(err)
+	}
+	names := []string{"BSC0", "BSC1"}
+	defer func() {
+		for _, n := range names {
+			if err := i2creg.Unregister(n); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}()
+	if l := i2creg.All(); len(l) != len(names) || l[0].Number != -1 {
+		t.Fatal(l)
+	}
+	b, err := i2creg.Open("BSC1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i, ok := b.(*I2C); !ok || i.bus != 1 {
+		t.Fatalf("%#v", b)
+	}
+}
+
+func TestDriverI2C(t *testing.T) {
+	d := driverI2C{}
+	if s := d.String(); s != "bcm283x-i2c" {
+		t.Fatal(s)
+	}
+	if p := d.Prerequisites(); len(p) != 1 || p[0] != "bcm283x-gpio" {
+		t.Fatal(p)
+	}
+	if a := d.After(); len(a) != 1 || a[0] != "sysfs-i2c" {
+		t.Fatal(a)
+	}
+}
+
+func TestI2C_kernelDriver(t *testing.T) {
+	defer initI2CTest()()
+	d, err := ioutil.TempDir("", "bcm283x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+	platformDrivers = d
+	// The kernel driver is bound to BSC1 only.
+	if err := os.MkdirAll(filepath.Join(d, "i2c-bcm2835", "3f804000.i2c"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if i, err := NewI2C(1); i != nil || err == nil {
+		t.Fatal("used by the kernel")
+	}
+	if err := registerI2C(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := i2creg.Unregister("BSC0"); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	if l := i2creg.All(); len(l) != 1 || l[0].Name != "BSC0" {
+		t.Fatal(l)
+	}
+}
+
+//
+
+// initI2CTest sets up fake memory maps and returns a function to restore the
+// previous state.
+func initI2CTest() func() {
+	old := i2cMemory
+	oldClock, oldDrivers := coreClock, platformDrivers
+	i2cMemory = [2]*i2cMap{{}, {}}
+	gpioMemory = &gpioMap{}
+	coreClock = 250000000
+	return func() {
+		i2cMemory = old
+		coreClock, platformDrivers = oldClock, oldDrivers
+		for j := range i2cBuses {
+			i2cBuses[j].open = 0
+		}
+		resetGPIOMemory()
+	}
+}
+
+// newFakeI2C returns a bus connected to a simulated controller, with a device
+// at address 0x50.
+func newFakeI2C(t *testing.T, bus int) (*I2C, *fakeI2CRegs) {
+	i, err := NewI2C(bus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeI2CRegs{t: t, addr: 0x50}
+	i.r = f
+	return i, f
+}
+
+// fakeI2CRegs simulates the registers of a BSC controller.
+//
+// The transfer progresses by one step, the address or a byte, each time the
+// status register is read. It stalls when the TX FIFO is empty or the RX FIFO
+// is full, like the hardware does. Setting ST while a write is active queues a
+// read that starts with a repeated START once the write is done.
+type fakeI2CRegs struct {
+	t    *testing.T
+	addr uint32 // Address of the simulated device.
+	data []byte // Bytes sent by the device when read.
+
+	c      i2cC   // C register, without the action bits.
+	s      i2cS   // The sticky bits of the S register.
+	dlen   uint32 // DLEN register.
+	a      uint32 // A register.
+	tx, rx []byte // The FIFOs.
+
+	active   bool // A transfer is active.
+	reading  bool // The active transfer is a read.
+	addrSent bool // The address of the active transfer was sent.
+	restart  bool // The active transfer started with a repeated START.
+	left     int  // Bytes left in the active transfer.
+	queued   int  // Length of the queued read, or -1.
+
+	wire []string // The conditions and the bytes on the bus.
+}
+
+// check verifies the conditions and bytes seen since the last call.
+func (f *fakeI2CRegs) check(t *testing.T, expected ...string) {
+	if !reflect.DeepEqual(f.wire, expected) {
+		t.Fatalf("unexpected sequence\ngot:  %q\nwant: %q", f.wire, expected)
+	}
+	f.wire = nil
+}
+
+func (f *fakeI2CRegs) read(r i2cReg) uint32 {
+	switch r {
+	case i2cRegS:
+		f.step()
+		s := f.s
+		if f.active {
+			s |= i2cTA
+		}
+		if len(f.tx) == 0 {
+			s |= i2cTXE
+		}
+		if len(f.tx) < i2cFIFOSize {
+			s |= i2cTXD
+		}
+		if len(f.rx) != 0 {
+			s |= i2cRXD
+		}
+		return uint32(s)
+	case i2cRegFIFO:
+		if len(f.rx) == 0 {
+			f.t.Fatal("RX FIFO underflow")
+		}
+		b := f.rx[0]
+		f.rx = f.rx[1:]
+		return uint32(b)
+	case i2cRegC:
+		return uint32(f.c)
+	case i2cRegDLEN:
+		return f.dlen
+	}
+	f.t.Fatalf("unexpected read of 0x%x", r)
+	return 0
+}
+
+func (f *fakeI2CRegs) write(r i2cReg, v uint32) {
+	switch r {
+	case i2cRegC:
+		c := i2cC(v)
+		if c&i2cClear != 0 {
+			f.tx, f.rx = nil, nil
+		}
+		if c&i2cST != 0 {
+			if c&i2cEnable == 0 {
+				f.t.Fatal("transfer started while disabled")
+			}
+			if f.active {
+				if f.reading || c&i2cRead == 0 {
+					f.t.Fatal("only a read can be queued after a write")
+				}
+				f.queued = int(f.dlen)
+			} else {
+				f.start(c&i2cRead != 0, int(f.dlen), false)
+			}
+		}
+		f.c = c &^ (i2cClear | i2cST)
+	case i2cRegS:
+		f.s &^= i2cS(v) & (i2cCLKT | i2cERR | i2cDone)
+	case i2cRegDLEN:
+		f.dlen = v
+	case i2cRegA:
+		f.a = v
+	case i2cRegFIFO:
+		if len(f.tx) == i2cFIFOSize {
+			f.t.Fatal("TX FIFO overflow")
+		}
+		f.tx = append(f.tx, byte(v))
+	case i2cRegDIV, i2cRegDEL, i2cRegCLKT:
+	default:
+		f.t.Fatalf("unexpected write of 0x%x", r)
+	}
+}
+
+func (f *fakeI2CRegs) start(read bool, n int, restart bool) {
+	f.active, f.reading, f.addrSent, f.restart = true, read, false, restart
+	f.left = n
+	f.queued = -1
+}
+
+// step does the next step of the active transfer.
+func (f *fakeI2CRegs) step() {
+	if !f.active {
+		return
+	}
+	if !f.addrSent {
+		f.addrSent = true
+		cond, dir := "S", "W"
+		if f.restart {
+			cond = "Sr"
+		}
+		if f.reading {
+			dir = "R"
+		}
+		f.wire = append(f.wire, fmt.Sprintf("%s %#02x %s", cond, f.a, dir))
+		if f.a != f.addr {
+			f.stop()
+			f.s |= i2cERR
+		}
+		return
+	}
+	if f.left != 0 {
+		if f.reading {
+			if len(f.rx) == i2cFIFOSize {
+				return
+			}
+			b := byte(0xff)
+			if len(f.data) != 0 {
+				b, f.data = f.data[0], f.data[1:]
+			}
+			f.wire = append(f.wire, fmt.Sprintf("%#02x", b))
+			f.rx = append(f.rx, b)
+		} else {
+			if len(f.tx) == 0 {
+				return
+			}
+			f.wire = append(f.wire, fmt.Sprintf("%#02x", f.tx[0]))
+			f.tx = f.tx[1:]
+		}
+		f.left--
+		return
+	}
+	if f.queued != -1 {
+		f.start(true, f.queued, true)
+		return
+	}
+	f.stop()
+}
+
+func (f *fakeI2CRegs) stop() {
+	f.wire = append(f.wire, "P")
+	f.active = false
+	f.s |= i2cDone
+}
+
+var _ i2cRegs = &fakeI2CRegs{}
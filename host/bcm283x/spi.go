@@ -0,0 +1,769 @@
+// Copyright 2018 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package bcm283x
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"unsafe"
+
+	"periph.io/x/periph"
+	"periph.io/x/periph/conn"
+	"periph.io/x/periph/conn/gpio"
+	"periph.io/x/periph/conn/spi"
+	"periph.io/x/periph/conn/spi/spireg"
+	"periph.io/x/periph/host/pmem"
+	"periph.io/x/periph/host/videocore"
+)
+
+// NewSPI returns a SPI port driving the SPI0 controller directly, without
+// going through spidev.
+//
+// Only bus 0 is supported; cs is 0 or 1.
+//
+// Transfers up to the FIFO size are done by polling the FIFO. Larger transfers
+// are done via DMA when the DMA controller is initialized, and are not limited
+// by the spidev buffer size. The data is sent in chunks of 65532 bytes because
+// of the DLEN register width, but CS is not released between the chunks.
+//
+// Both chip selects share the controller; the ports returned for cs 0 and 1
+// serialize their transactions. An error is returned when the kernel driver
+// spi-bcm2835 is bound to the controller.
+func NewSPI(bus, cs int) (*SPI, error) {
+	if spiMemory == nil {
+		return nil, errors.New("bcm283x-spi: subsystem not initialized")
+	}
+	if kernelDriverBound("spi-bcm2835", spiOffset) {
+		return nil, errors.New("bcm283x-spi: SPI0 is used by the kernel driver spi-bcm2835")
+	}
+	if bus != 0 {
+		return nil, fmt.Errorf("bcm283x-spi: only bus 0 is supported, got %d", bus)
+	}
+	if cs != 0 && cs != 1 {
+		return nil, fmt.Errorf("bcm283x-spi: invalid chip select %d", cs)
+	}
+	return &SPI{cs: cs, r: spiMemory}, nil
+}
+
+// SPI is the SPI0 port driven by the CPU SPI controller.
+//
+// It implements spi.PortCloser.
+type SPI struct {
+	// Immutable.
+	cs int
+	r  spiRegs
+
+	// Protected by spi0.mu.
+	initialized bool
+	maxHzPort   int64
+	maxHzDev    int64
+	mode        spi.Mode
+}
+
+func (s *SPI) String() string {
+	return fmt.Sprintf("SPI0.%d", s.cs)
+}
+
+// Close implements spi.PortCloser.
+//
+// It releases CS and clears the FIFOs if CS was left asserted by this port.
+func (s *SPI) Close() error {
+	spi0.mu.Lock()
+	defer spi0.mu.Unlock()
+	if spi0.active == s {
+		s.releaseLocked(spiClearRX | spiClearTX)
+	}
+	return nil
+}
+
+// LimitSpeed implements spi.PortCloser.
+func (s *SPI) LimitSpeed(maxHz int64) error {
+	if maxHz < 1 {
+		return fmt.Errorf("bcm283x-spi: invalid speed %d", maxHz)
+	}
+	spi0.mu.Lock()
+	defer spi0.mu.Unlock()
+	s.maxHzPort = maxHz
+	return nil
+}
+
+// Connect implements spi.Port.
+//
+// The supported flags are Mode0 to Mode3, NoCS and CSHigh. Only 8 bits words
+// are supported.
+//
+// It sets the function of the pins of the port.
+func (s *SPI) Connect(maxHz int64, mode spi.Mode, bits int) (spi.Conn, error) {
+	if err := checkSPIParams(maxHz, mode, bits); err != nil {
+		return nil, err
+	}
+	spi0.mu.Lock()
+	defer spi0.mu.Unlock()
+	if s.initialized {
+		return nil, errors.New("bcm283x-spi: Connect() can only be called exactly once")
+	}
+	p := spi0Pins()
+	for _, n := range []struct {
+		p *Pin
+		f string
+	}{
+		{p.clk, "SPI0_CLK"},
+		{p.mosi, "SPI0_MOSI"},
+		{p.miso, "SPI0_MISO"},
+		{p.cs[s.cs], fmt.Sprintf("SPI0_CS%d", s.cs)},
+	} {
+		if err := n.p.SetFunction(n.f); err != nil {
+			return nil, fmt.Errorf("bcm283x-spi: %v", err)
+		}
+	}
+	s.maxHzDev = maxHz
+	s.mode = mode
+	if spi0.active == nil {
+		s.r.write(spiRegCS, uint32(spiClearRX|spiClearTX))
+	}
+	s.initialized = true
+	return &spiConn{s}, nil
+}
+
+// MaxTxSize implements conn.Limits.
+func (s *SPI) MaxTxSize() int {
+	return spiMaxTxSize
+}
+
+// CLK implements spi.Pins.
+func (s *SPI) CLK() gpio.PinOut {
+	return spi0Pins().clk
+}
+
+// MOSI implements spi.Pins.
+func (s *SPI) MOSI() gpio.PinOut {
+	return spi0Pins().mosi
+}
+
+// MISO implements spi.Pins.
+func (s *SPI) MISO() gpio.PinIn {
+	return spi0Pins().miso
+}
+
+// CS implements spi.Pins.
+func (s *SPI) CS() gpio.PinOut {
+	return spi0Pins().cs[s.cs]
+}
+
+//
+
+var (
+	// spiMemory is the memory map of the SPI0 registers.
+	spiMemory *spiMap
+	// spi0 is the state of the SPI0 controller, shared by the ports of both
+	// chip selects.
+	spi0 struct {
+		mu sync.Mutex
+		// active is the port asserting CS, if any.
+		active *SPI
+	}
+	// spiBaseAddr is needed for DMA transfers.
+	spiBaseAddr uint32
+	// coreClock is the rate of the VPU core clock feeding the SPI and BSC
+	// controllers. It is read from the firmware when the drivers are
+	// initialized.
+	coreClock int64
+	// clockRate is overridden in tests.
+	clockRate = videocore.ClockRate
+	// platformDrivers is where the kernel drivers bound to the controllers are
+	// listed; it is overridden in tests.
+	platformDrivers = "/sys/bus/platform/drivers"
+)
+
+// spiOffset is the offset of the SPI0 registers from the peripherals base
+// address.
+const spiOffset = 0x204000
+
+// initCoreClock reads the VPU core clock rate from the firmware.
+//
+// The rate changes with the load when the frequency scaling is enabled; set
+// core_freq_min to core_freq in config.txt to keep the bus speeds stable.
+func initCoreClock() error {
+	hz, err := clockRate(videocore.ClockCore)
+	if err != nil {
+		return fmt.Errorf("failed to read the core clock rate: %v", err)
+	}
+	coreClock = hz
+	return nil
+}
+
+// kernelDriverBound returns true if the kernel driver drv is bound to the
+// controller at offset off from the peripherals base address.
+//
+// The platform devices are named after the address of their registers, e.g.
+// 3f204000.spi or 20204000.spi.
+func kernelDriverBound(drv string, off uint32) bool {
+	items, _ := filepath.Glob(fmt.Sprintf("%s/%s/*%06x.*", platformDrivers, drv, off))
+	return len(items) != 0
+}
+
+const (
+	// spiMaxTxSize is the maximum transfer size; it is bounded by the memory
+	// that can be allocated for DMA.
+	spiMaxTxSize = 16 << 20
+	// spiDMAChunk is the maximum number of bytes transferred per DLEN load. It
+	// is kept a multiple of 4 so the chunks start on a DMA word boundary.
+	spiDMAChunk = 65532
+	// spiFIFOSize is the depth of each FIFO. Transfers up to this size are
+	// polled, even when DMA is available.
+	spiFIFOSize = 64
+)
+
+// Pages 153-155
+type spiCS uint32
+
+const (
+	// 31:26 reserved
+	spiLenLong spiCS = 1 << 25 // LEN_LONG Enable long data word in LoSSI mode
+	spiDMALen  spiCS = 1 << 24 // DMA_LEN Enable DMA mode in LoSSI mode
+	spiCSPol2  spiCS = 1 << 23 // CSPOL2 Chip select 2 polarity
+	spiCSPol1  spiCS = 1 << 22 // CSPOL1 Chip select 1 polarity
+	spiCSPol0  spiCS = 1 << 21 // CSPOL0 Chip select 0 polarity
+	spiRXF     spiCS = 1 << 20 // RXF RX FIFO full
+	spiRXR     spiCS = 1 << 19 // RXR RX FIFO needs reading (3/4 full)
+	spiTXD     spiCS = 1 << 18 // TXD TX FIFO can accept data
+	spiRXD     spiCS = 1 << 17 // RXD RX FIFO contains data
+	spiDone    spiCS = 1 << 16 // DONE Transfer done
+	spiTEEn    spiCS = 1 << 15 // TE_EN Unused
+	spiLMono   spiCS = 1 << 14 // LMONO Unused
+	spiLoSSI   spiCS = 1 << 13 // LEN LoSSI enable
+	spiREn     spiCS = 1 << 12 // REN Read enable in bidirectional mode
+	spiADCS    spiCS = 1 << 11 // ADCS Automatically deassert CS at the end of a DMA transfer
+	spiINTR    spiCS = 1 << 10 // INTR Interrupt on RXR
+	spiINTD    spiCS = 1 << 9  // INTD Interrupt on Done
+	spiDMAEn   spiCS = 1 << 8  // DMAEN Generate TX&RX DMA DREQ
+	spiTA      spiCS = 1 << 7  // TA Transfer active; asserts CS
+	spiCSPol   spiCS = 1 << 6  // CSPOL Chip select polarity
+	spiClearRX spiCS = 1 << 5  // CLEAR Clear RX FIFO
+	spiClearTX spiCS = 1 << 4  // CLEAR Clear TX FIFO
+	spiCPOL    spiCS = 1 << 3  // CPOL Clock polarity
+	spiCPHA    spiCS = 1 << 2  // CPHA Clock phase
+	spiCSMask  spiCS = 3 << 0  // CS Chip select; 3 is none
+)
+
+// spiMap is the SPI0 registers.
+//
+// Page 152
+type spiMap struct {
+	cs   spiCS  // CS SPI master control and status
+	fifo uint32 // FIFO SPI master TX and RX FIFOs
+	clk  uint32 // CLK SPI master clock divider; only the 16 lower bits are used
+	dlen uint32 // DLEN SPI master data length, for DMA mode
+	ltoh uint32 // LTOH SPI LoSSI mode TOH
+	dc   uint32 // DC SPI DMA DREQ controls
+}
+
+// spiReg is the offset of a register in spiMap.
+type spiReg uint32
+
+const (
+	spiRegCS   spiReg = 0x00
+	spiRegFIFO spiReg = 0x04
+	spiRegCLK  spiReg = 0x08
+	spiRegDLEN spiReg = 0x0C
+)
+
+// spiRegs is the access to the SPI0 registers.
+//
+// It is implemented by spiMap for the memory mapped registers, and by a
+// simulated controller in the unit tests.
+type spiRegs interface {
+	read(r spiReg) uint32
+	// write to spiRegFIFO pushes one byte in polled mode.
+	write(r spiReg, v uint32)
+}
+
+func (s *spiMap) read(r spiReg) uint32 {
+	return *s.reg(r)
+}
+
+func (s *spiMap) write(r spiReg, v uint32) {
+	*s.reg(r) = v
+}
+
+func (s *spiMap) reg(r spiReg) *uint32 {
+	return (*uint32)(unsafe.Pointer(uintptr(unsafe.Pointer(s)) + uintptr(r)))
+}
+
+// spiPinSet is the pins used by SPI0.
+type spiPinSet struct {
+	clk  *Pin
+	mosi *Pin
+	miso *Pin
+	cs   [2]*Pin
+}
+
+// spi0Pins returns the pins SPI0 is muxed to, GPIO7~GPIO11 by default.
+func spi0Pins() spiPinSet {
+	if GPIO39.Function() == "SPI0_CLK" {
+		return spiPinSet{GPIO39, GPIO38, GPIO37, [2]*Pin{GPIO36, GPIO35}}
+	}
+	return spiPinSet{GPIO11, GPIO10, GPIO9, [2]*Pin{GPIO8, GPIO7}}
+}
+
+// checkSPIParams verifies the arguments to Connect() and Configure().
+func checkSPIParams(maxHz int64, mode spi.Mode, bits int) error {
+	if maxHz < 0 {
+		return fmt.Errorf("bcm283x-spi: invalid speed %d", maxHz)
+	}
+	if mode&^(spi.Mode3|spi.NoCS|spi.CSHigh) != 0 {
+		return fmt.Errorf("bcm283x-spi: mode %v is not supported", mode)
+	}
+	if bits != 8 {
+		return fmt.Errorf("bcm283x-spi: %d bits per word is not supported", bits)
+	}
+	return nil
+}
+
+// spiClockDiv returns the clock divider to get the fastest SPI clock not
+// above hz, and the resulting speed.
+//
+// The divider is even; 0 means 65536. 0 as hz means the fastest speed.
+func spiClockDiv(hz int64) (uint32, int64) {
+	if hz <= 0 || hz > coreClock/2 {
+		hz = coreClock / 2
+	}
+	d := (coreClock + hz - 1) / hz
+	d += d & 1
+	if d >= 65536 {
+		return 0, coreClock / 65536
+	}
+	return uint32(d), coreClock / d
+}
+
+// csLocked returns the control register value for the current mode.
+func (s *SPI) csLocked() spiCS {
+	c := spiCS(s.cs)
+	if s.mode&spi.NoCS != 0 {
+		c = spiCSMask
+	}
+	if s.mode&spi.Mode1 != 0 {
+		c |= spiCPHA
+	}
+	if s.mode&spi.Mode2 != 0 {
+		c |= spiCPOL
+	}
+	if s.mode&spi.CSHigh != 0 {
+		c |= spiCSPol | spiCSPol0<<uint(s.cs)
+	}
+	return c
+}
+
+// speedLocked returns the speed to use for a packet.
+func (s *SPI) speedLocked(pkt int64) int64 {
+	hz := s.maxHzDev
+	if pkt != 0 {
+		hz = pkt
+	}
+	if s.maxHzPort != 0 && (hz == 0 || hz > s.maxHzPort) {
+		hz = s.maxHzPort
+	}
+	return hz
+}
+
+// spiTimeout returns the maximum duration of a transfer of n bytes at hz.
+func spiTimeout(n int, hz int64) time.Duration {
+	return time.Duration(int64(n)*8*int64(time.Second)/hz)*2 + 100*time.Millisecond
+}
+
+func (s *SPI) txPackets(p []spi.Packet) error {
+	total := 0
+	for i := range p {
+		lW := len(p[i].W)
+		lR := len(p[i].R)
+		if lW != 0 && lR != 0 && lW != lR {
+			return fmt.Errorf("bcm283x-spi: when both w and r are used, they must be the same size; got %d and %d bytes", lW, lR)
+		}
+		if lW > spiMaxTxSize || lR > spiMaxTxSize {
+			return fmt.Errorf("bcm283x-spi: maximum packet length is %d", spiMaxTxSize)
+		}
+		if p[i].BitsPerWord != 0 && p[i].BitsPerWord != 8 {
+			return fmt.Errorf("bcm283x-spi: %d bits per word is not supported", p[i].BitsPerWord)
+		}
+		if p[i].TxWidth > 1 || p[i].RxWidth > 1 {
+			return errors.New("bcm283x-spi: dual and quad SPI are not supported")
+		}
+		if p[i].MaxHz < 0 {
+			return fmt.Errorf("bcm283x-spi: invalid packet speed %d", p[i].MaxHz)
+		}
+		total += lW + lR
+	}
+	if total == 0 {
+		return errors.New("bcm283x-spi: empty packets")
+	}
+
+	spi0.mu.Lock()
+	defer spi0.mu.Unlock()
+	if !s.initialized {
+		return errors.New("bcm283x-spi: Connect wasn't called")
+	}
+	for i := range p {
+		if len(p[i].W) != 0 || len(p[i].R) != 0 {
+			if err := s.txLocked(p[i].W, p[i].R, s.speedLocked(p[i].MaxHz)); err != nil {
+				s.releaseLocked(spiClearRX | spiClearTX)
+				return err
+			}
+		}
+		if p[i].Delay > 0 {
+			time.Sleep(p[i].Delay)
+		}
+		if !p[i].KeepCS {
+			s.releaseLocked(0)
+		}
+	}
+	return nil
+}
+
+// assertLocked sets the control register to cs and clears the FIFOs, unless
+// CS is still asserted by the previous packet of this port. cs includes TA
+// when CS is to be asserted right away.
+//
+// If the other chip select was left asserted, it is released first.
+func (s *SPI) assertLocked(cs spiCS) {
+	if spi0.active == s {
+		return
+	}
+	if spi0.active != nil {
+		spi0.active.releaseLocked(0)
+	}
+	s.r.write(spiRegCS, uint32(cs|spiClearRX|spiClearTX))
+	spi0.active = s
+}
+
+// releaseLocked releases CS.
+func (s *SPI) releaseLocked(clear spiCS) {
+	s.r.write(spiRegCS, uint32(s.csLocked()|clear))
+	spi0.active = nil
+}
+
+// txLocked does one transfer. CS is asserted and left asserted.
+func (s *SPI) txLocked(w, r []byte, hz int64) error {
+	n := len(w)
+	if n == 0 {
+		n = len(r)
+	}
+	div, actual := spiClockDiv(hz)
+	s.r.write(spiRegCLK, div)
+	timeout := spiTimeout(n, actual)
+	if n > spiFIFOSize && dmaMemory != nil {
+		return s.txDMALocked(w, r, n, timeout)
+	}
+	return s.txFIFOLocked(w, r, n, timeout)
+}
+
+// txFIFOLocked does a transfer by polling the FIFOs.
+//
+// In polled mode, each write and read of the FIFO register is one byte.
+func (s *SPI) txFIFOLocked(w, r []byte, n int, timeout time.Duration) error {
+	s.assertLocked(s.csLocked() | spiTA)
+	start := time.Now()
+	for sent, recv := 0, 0; recv < n; {
+		for sent < n && sent-recv < spiFIFOSize && spiCS(s.r.read(spiRegCS))&spiTXD != 0 {
+			var b byte
+			if len(w) != 0 {
+				b = w[sent]
+			}
+			s.r.write(spiRegFIFO, uint32(b))
+			sent++
+		}
+		for recv < n && spiCS(s.r.read(spiRegCS))&spiRXD != 0 {
+			b := byte(s.r.read(spiRegFIFO))
+			if len(r) != 0 {
+				r[recv] = b
+			}
+			recv++
+		}
+		if time.Since(start) > timeout {
+			return errors.New("bcm283x-spi: transfer timed out")
+		}
+	}
+	return nil
+}
+
+// txDMALocked does a transfer with one DMA channel feeding the TX FIFO and
+// one draining the RX FIFO.
+//
+// The RX FIFO must always be drained, otherwise the controller stalls once it
+// is full.
+func (s *SPI) txDMALocked(w, r []byte, n int, timeout time.Duration) error {
+	chunks := (n + spiDMAChunk - 1) / spiDMAChunk
+	cbSize := (2*chunks*32 + 0xFFF) &^ 0xFFF
+	bufSize := (n + 0xFFF) &^ 0xFFF
+	mem, err := dmaBufAllocator(cbSize + 2*bufSize)
+	if err != nil {
+		return fmt.Errorf("bcm283x-spi: %v", err)
+	}
+	defer mem.Close()
+	var cb []controlBlock
+	if err := mem.AsPOD(&cb); err != nil {
+		return fmt.Errorf("bcm283x-spi: %v", err)
+	}
+	buf := mem.Bytes()
+	// Zeros are sent from memory when reading, like the kernel driver does.
+	// With the source ignored, the DMA controller clears the write strobes so
+	// the FIFO may not see the writes at all.
+	if copy(buf[cbSize:], w) == 0 {
+		tx := buf[cbSize : cbSize+n]
+		for i := range tx {
+			tx[i] = 0
+		}
+	}
+	phys := uint32(mem.PhysAddr())
+	var rxAddr uint32
+	if len(r) != 0 {
+		rxAddr = phys + uint32(cbSize+bufSize)
+	}
+	if err := spiInitBlocks(cb, phys+uint32(cbSize), rxAddr, n); err != nil {
+		return fmt.Errorf("bcm283x-spi: %v", err)
+	}
+
+	x, rx := pickChannel()
+	if rx == nil {
+		return errors.New("bcm283x-spi: no DMA channel available")
+	}
+	defer rx.reset()
+	_, tx := pickChannel(x)
+	if tx == nil {
+		return errors.New("bcm283x-spi: no DMA channel available")
+	}
+	defer tx.reset()
+
+	cs := s.csLocked() | spiDMAEn
+	s.assertLocked(cs)
+	for i := 0; i < chunks; i++ {
+		l := n - i*spiDMAChunk
+		if l > spiDMAChunk {
+			l = spiDMAChunk
+		}
+		// TA is held between the chunks, so CS stays asserted.
+		s.r.write(spiRegDLEN, uint32(l))
+		s.r.write(spiRegCS, uint32(cs|spiTA))
+		rx.startIO(phys + uint32(64*i+32))
+		tx.startIO(phys + uint32(64*i))
+		start := time.Now()
+		for spiCS(s.r.read(spiRegCS))&spiDone == 0 {
+			if time.Since(start) > timeout {
+				return errors.New("bcm283x-spi: DMA transfer timed out")
+			}
+		}
+		// The last bytes may still be in flight.
+		if err := spiWaitDMA(rx, timeout); err != nil {
+			return err
+		}
+		if err := spiWaitDMA(tx, timeout); err != nil {
+			return err
+		}
+		rx.reset()
+		tx.reset()
+	}
+	// Clear DMAEN but keep CS asserted.
+	s.r.write(spiRegCS, uint32(s.csLocked()|spiTA))
+	copy(r, buf[cbSize+bufSize:])
+	return nil
+}
+
+// spiInitBlocks initializes two control blocks per chunk: one to write to the
+// TX FIFO at cb[2*i] and one to read from the RX FIFO at cb[2*i+1].
+//
+// txAddr and rxAddr are the physical addresses of the data buffers. txAddr is
+// required. When rxAddr is 0, the data read is discarded.
+func spiInitBlocks(cb []controlBlock, txAddr, rxAddr uint32, n int) error {
+	if txAddr == 0 {
+		return errors.New("a buffer to send is required")
+	}
+	fifo := spiBaseAddr + 0x04
+	for i, off := 0, 0; off < n; i, off = i+1, off+spiDMAChunk {
+		l := n - off
+		if l > spiDMAChunk {
+			l = spiDMAChunk
+		}
+		if err := cb[2*i].initBlock(txAddr+uint32(off), fifo, uint32(l), false, true, true, false, dmaSPITX); err != nil {
+			return err
+		}
+		dst := uint32(0)
+		if rxAddr != 0 {
+			dst = rxAddr + uint32(off)
+		}
+		if err := cb[2*i+1].initBlock(fifo, dst, uint32(l), true, false, false, true, dmaSPIRX); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// spiWaitDMA waits for a DMA channel to complete, up to timeout.
+func spiWaitDMA(ch *dmaChannel, timeout time.Duration) error {
+	start := time.Now()
+	for ch.cs&dmaActive != 0 {
+		if ch.debug&(dmaReadError|dmaFIFOError|dmaReadLastNotSetError) != 0 {
+			return ch.wait()
+		}
+		if time.Since(start) > timeout {
+			return errors.New("bcm283x-spi: DMA transfer timed out")
+		}
+	}
+	return ch.wait()
+}
+
+// spiConn implements spi.Conn.
+type spiConn struct {
+	s *SPI
+}
+
+func (s *spiConn) String() string {
+	return s.s.String()
+}
+
+// Tx implements spi.Conn.
+func (s *spiConn) Tx(w, r []byte) error {
+	if len(w) == 0 && len(r) == 0 {
+		return errors.New("bcm283x-spi: Tx with empty buffers")
+	}
+	return s.s.txPackets([]spi.Packet{{W: w, R: r}})
+}
+
+// Write implements io.Writer.
+func (s *spiConn) Write(b []byte) (int, error) {
+	if err := s.Tx(b, nil); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// TxPackets implements spi.Conn.
+//
+// KeepCS:true on the last packet keeps CS asserted after the call returns,
+// until the next transaction.
+func (s *spiConn) TxPackets(p []spi.Packet) error {
+	return s.s.txPackets(p)
+}
+
+// Configure implements spi.Configurer.
+func (s *spiConn) Configure(maxHz int64, mode spi.Mode, bits int) error {
+	if err := checkSPIParams(maxHz, mode, bits); err != nil {
+		return err
+	}
+	spi0.mu.Lock()
+	defer spi0.mu.Unlock()
+	s.s.maxHzDev = maxHz
+	s.s.mode = mode
+	// Do not touch the controller while the other chip select is asserted.
+	if spi0.active == nil || spi0.active == s.s {
+		s.s.releaseLocked(0)
+	}
+	return nil
+}
+
+// Duplex implements spi.Conn.
+func (s *spiConn) Duplex() conn.Duplex {
+	return conn.Full
+}
+
+// MaxTxSize implements conn.Limits.
+func (s *spiConn) MaxTxSize() int {
+	return spiMaxTxSize
+}
+
+// CLK implements spi.Pins.
+func (s *spiConn) CLK() gpio.PinOut {
+	return s.s.CLK()
+}
+
+// MOSI implements spi.Pins.
+func (s *spiConn) MOSI() gpio.PinOut {
+	return s.s.MOSI()
+}
+
+// MISO implements spi.Pins.
+func (s *spiConn) MISO() gpio.PinIn {
+	return s.s.MISO()
+}
+
+// CS implements spi.Pins.
+func (s *spiConn) CS() gpio.PinOut {
+	return s.s.CS()
+}
+
+// driverSPI implements periph.Driver.
+type driverSPI struct {
+}
+
+func (d *driverSPI) String() string {
+	return "bcm283x-spi"
+}
+
+func (d *driverSPI) Prerequisites() []string {
+	return []string{"bcm283x-gpio"}
+}
+
+func (d *driverSPI) After() []string {
+	// DMA is used when bcm283x-dma loaded successfully.
+	return []string{"bcm283x-dma"}
+}
+
+func (d *driverSPI) Init() (bool, error) {
+	// baseAddr is initialized by prerequisite driver bcm283x-gpio.
+	spiBaseAddr = baseAddr + spiOffset
+	if err := initCoreClock(); err != nil {
+		return true, err
+	}
+	if err := pmem.MapAsPOD(uint64(spiBaseAddr), &spiMemory); err != nil {
+		if os.IsPermission(err) {
+			return true, fmt.Errorf("need more access, try as root: %v", err)
+		}
+		return true, err
+	}
+	return true, registerSPI()
+}
+
+// registerSPI registers the SPI0 ports as bcm283x-SPI0.0 and bcm283x-SPI0.1.
+//
+// They are registered alongside the ports registered by sysfs-spi, so the
+// kernel driver stays the default. Nothing is registered when the kernel driver
+// is bound to the controller.
+func registerSPI() error {
+	if kernelDriverBound("spi-bcm2835", spiOffset) {
+		return nil
+	}
+	for cs := 0; cs < 2; cs++ {
+		if err := spireg.Register(fmt.Sprintf("bcm283x-SPI0.%d", cs), nil, -1, (&openerSPI{cs}).Open); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type openerSPI struct {
+	cs int
+}
+
+func (o *openerSPI) Open() (spi.PortCloser, error) {
+	return NewSPI(0, o.cs)
+}
+
+func init() {
+	if isArm {
+		periph.MustRegister(&driverSPI{})
+	}
+}
+
+var _ conn.Limits = &SPI{}
+var _ conn.Limits = &spiConn{}
+var _ spi.Configurer = &spiConn{}
+var _ spi.Conn = &spiConn{}
+var _ spi.Pins = &SPI{}
+var _ spi.Pins = &spiConn{}
+var _ spi.PortCloser = &SPI{}
+var _ spiRegs = &spiMap{}
+var _ fmt.Stringer = &SPI{}
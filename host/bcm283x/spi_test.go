@@ -0,0 +1,747 @@
+// Copyright 2018 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package bcm283x
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"unsafe"
+
+	"periph.io/x/periph/conn"
+	"periph.io/x/periph/conn/spi"
+	"periph.io/x/periph/conn/spi/spireg"
+	"periph.io/x/periph/host/pmem"
+	"periph.io/x/periph/host/videocore"
+)
+
+func TestNewSPI(t *testing.T) {
+	if s, err := NewSPI(0, 0); s != nil || err == nil {
+		t.Fatal("not initialized")
+	}
+	defer func() {
+		spiMemory = nil
+	}()
+	spiMemory = &spiMap{}
+	if s, err := NewSPI(1, 0); s != nil || err == nil {
+		t.Fatal("only SPI0")
+	}
+	if s, err := NewSPI(0, 2); s != nil || err == nil {
+		t.Fatal("invalid chip select")
+	}
+	s, err := NewSPI(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if str := s.String(); str != "SPI0.1" {
+		t.Fatal(str)
+	}
+	if p := s.CLK(); p != GPIO11 {
+		t.Fatal(p)
+	}
+	if p := s.MOSI(); p != GPIO10 {
+		t.Fatal(p)
+	}
+	if p := s.MISO(); p != GPIO9 {
+		t.Fatal(p)
+	}
+	if p := s.CS(); p != GPIO7 {
+		t.Fatal(p)
+	}
+	if l := s.MaxTxSize(); l != spiMaxTxSize {
+		t.Fatal(l)
+	}
+	if err := s.LimitSpeed(0); err == nil {
+		t.Fatal("invalid speed")
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSPI_Connect(t *testing.T) {
+	defer resetGPIOMemory()
+	defer func() {
+		spiMemory = nil
+	}()
+	gpioMemory = &gpioMap{}
+	spiMemory = &spiMap{cs: spiTA}
+	s, err := NewSPI(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.txPackets([]spi.Packet{{W: []byte{1}}}); err == nil {
+		t.Fatal("not initialized")
+	}
+	if _, err := s.Connect(-1, spi.Mode0, 8); err == nil {
+		t.Fatal("invalid speed")
+	}
+	if _, err := s.Connect(1000, spi.Mode0|spi.LSBFirst, 8); err == nil {
+		t.Fatal("LSBFirst is not supported")
+	}
+	if _, err := s.Connect(1000, spi.Mode0, 16); err == nil {
+		t.Fatal("16 bits is not supported")
+	}
+	c, err := s.Connect(1000, spi.Mode3, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Connect(1000, spi.Mode3, 8); err == nil {
+		t.Fatal("double Connect")
+	}
+	for _, p := range []*Pin{GPIO8, GPIO9, GPIO10, GPIO11} {
+		if f := p.function(); f != alt0 {
+			t.Fatal(p, f)
+		}
+	}
+	if f := GPIO7.function(); f != in {
+		t.Fatal(f)
+	}
+	if spiMemory.cs != spiClearRX|spiClearTX {
+		t.Fatalf("0x%x", spiMemory.cs)
+	}
+	if d := c.Duplex(); d != conn.Full {
+		t.Fatal(d)
+	}
+	if l := c.(conn.Limits).MaxTxSize(); l != spiMaxTxSize {
+		t.Fatal(l)
+	}
+	if p := c.(spi.Pins).CS(); p != GPIO8 {
+		t.Fatal(p)
+	}
+	if err := c.(spi.Configurer).Configure(1000, spi.Mode1|spi.CSHigh, 8); err != nil {
+		t.Fatal(err)
+	}
+	if spiMemory.cs != spiCPHA|spiCSPol|spiCSPol0 {
+		t.Fatalf("0x%x", spiMemory.cs)
+	}
+	if err := c.(spi.Configurer).Configure(1000, spi.Mode1|spi.HalfDuplex, 8); err == nil {
+		t.Fatal("half duplex is not supported")
+	}
+	if err := c.Tx(nil, nil); err == nil {
+		t.Fatal("empty")
+	}
+	bad := [][]spi.Packet{
+		nil,
+		{{}},
+		{{W: []byte{1}, R: []byte{1, 2}}},
+		{{W: []byte{1}, BitsPerWord: 16}},
+		{{W: []byte{1}, TxWidth: 2}},
+		{{R: []byte{1}, RxWidth: 4}},
+		{{W: []byte{1}, MaxHz: -1}},
+		{{W: make([]byte, spiMaxTxSize+1)}},
+	}
+	for i, p := range bad {
+		if err := c.TxPackets(p); err == nil {
+			t.Fatalf("#%d: invalid packets", i)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSPI_Tx(t *testing.T) {
+	defer initSPITest()()
+	s, f := newFakeSPI(t, 0)
+	c, err := s.Connect(1000000, spi.Mode0, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Larger than the FIFO; without DMA, it is polled.
+	w := make([]byte, 3*spiFIFOSize+10)
+	for i := range w {
+		w[i] = byte(i)
+	}
+	r := make([]byte, len(w))
+	if err := c.Tx(w, r); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(f.wire, w) {
+		t.Fatal(f.wire)
+	}
+	for i := range r {
+		if r[i] != ^w[i] {
+			t.Fatal(r)
+		}
+	}
+	if f.events != "0-" {
+		t.Fatal(f.events)
+	}
+	if len(f.clk) != 1 || f.clk[0] != 250 {
+		t.Fatal(f.clk)
+	}
+
+	// Read only.
+	f.wire = nil
+	r = make([]byte, 3)
+	if err := c.Tx(nil, r); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(f.wire, []byte{0, 0, 0}) || !bytes.Equal(r, []byte{0xFF, 0xFF, 0xFF}) {
+		t.Fatal(f.wire, r)
+	}
+	if spi0.active != nil {
+		t.Fatal("CS must be released")
+	}
+}
+
+func TestSPI_TxPackets(t *testing.T) {
+	defer initSPITest()()
+	s, f := newFakeSPI(t, 1)
+	c, err := s.Connect(1000000, spi.Mode0, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := make([]byte, 2)
+	p := []spi.Packet{
+		{W: []byte{1}, KeepCS: true},
+		{R: r, MaxHz: 500000},
+	}
+	if err := c.TxPackets(p); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(f.wire, []byte{1, 0, 0}) || !bytes.Equal(r, []byte{0xFF, 0xFF}) {
+		t.Fatal(f.wire, r)
+	}
+	if f.events != "1-" {
+		t.Fatal(f.events)
+	}
+	if len(f.clk) != 2 || f.clk[0] != 250 || f.clk[1] != 500 {
+		t.Fatal(f.clk)
+	}
+
+	// KeepCS on the last packet holds CS until the next transaction.
+	p = []spi.Packet{{W: []byte{2}}, {W: []byte{3}, KeepCS: true}}
+	if err := c.TxPackets(p); err != nil {
+		t.Fatal(err)
+	}
+	if f.events != "1-1-1" || spi0.active != s {
+		t.Fatal(f.events)
+	}
+	if err := c.Tx([]byte{4}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if f.events != "1-1-1-" {
+		t.Fatal(f.events)
+	}
+	if err := c.TxPackets([]spi.Packet{{W: []byte{5}, KeepCS: true}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if f.events != "1-1-1-1-" || spi0.active != nil {
+		t.Fatal(f.events)
+	}
+	if !bytes.Equal(f.wire, []byte{1, 0, 0, 2, 3, 4, 5}) {
+		t.Fatal(f.wire)
+	}
+}
+
+func TestSPI_shared(t *testing.T) {
+	defer initSPITest()()
+	s0, f := newFakeSPI(t, 0)
+	s1, _ := newFakeSPI(t, 1)
+	s1.r = f
+	c0, err := s0.Connect(0, spi.Mode0, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c1, err := s1.Connect(0, spi.Mode0, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c0.TxPackets([]spi.Packet{{W: []byte{1}, KeepCS: true}}); err != nil {
+		t.Fatal(err)
+	}
+	// The other port doesn't touch the controller while CS0 is asserted.
+	if err := s1.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c1.(spi.Configurer).Configure(0, spi.Mode3, 8); err != nil {
+		t.Fatal(err)
+	}
+	if f.events != "0" || f.cs != spiTA {
+		t.Fatal(f.events, f.cs)
+	}
+	// A transaction on CS1 releases CS0 first.
+	if err := c1.TxPackets([]spi.Packet{{W: []byte{2}, KeepCS: true}}); err != nil {
+		t.Fatal(err)
+	}
+	if f.events != "0-1" || spi0.active != s1 {
+		t.Fatal(f.events)
+	}
+	if err := s0.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if f.events != "0-1" || f.cs != spiCPOL|spiCPHA|spiTA|1 {
+		t.Fatal(f.events, f.cs)
+	}
+	if err := s1.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if f.events != "0-1-" {
+		t.Fatal(f.events)
+	}
+}
+
+func TestSPI_DMA(t *testing.T) {
+	defer initSPITest()()
+	dmaMemory = &dmaMap{}
+	s, f := newFakeSPI(t, 0)
+	dmaBufAllocator = func(size int) (*videocore.Mem, error) {
+		// Garbage, to make sure zeros are sent when reading.
+		f.mem = bytes.Repeat([]byte{0xAA}, size)
+		return &videocore.Mem{View: &pmem.View{Slice: f.mem}}, nil
+	}
+	c, err := s.Connect(0, spi.Mode0, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Two chunks, CS is held between them.
+	w := make([]byte, spiDMAChunk+10)
+	for i := range w {
+		w[i] = byte(i)
+	}
+	r := make([]byte, len(w))
+	if err := c.Tx(w, r); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(f.wire, w) {
+		t.Fatal("unexpected data sent")
+	}
+	for i := range r {
+		if r[i] != ^w[i] {
+			t.Fatalf("unexpected data read at %d", i)
+		}
+	}
+	if len(f.chunks) != 2 || f.chunks[0] != spiDMAChunk || f.chunks[1] != 10 {
+		t.Fatal(f.chunks)
+	}
+	if f.events != "0-" {
+		t.Fatal(f.events)
+	}
+	if f.cs&spiDMAEn != 0 {
+		t.Fatal("DMA requests must be disabled")
+	}
+
+	// Read only.
+	f.wire = nil
+	r = make([]byte, spiFIFOSize+1)
+	if err := c.Tx(nil, r); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(f.wire, make([]byte, len(r))) || !bytes.Equal(r, bytes.Repeat([]byte{0xFF}, len(r))) {
+		t.Fatal(f.wire, r)
+	}
+
+	// Write only, up to the FIFO size is polled.
+	f.wire = nil
+	f.chunks = nil
+	w = w[:spiFIFOSize]
+	if _, err := c.(io.Writer).Write(w); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(f.wire, w) || len(f.chunks) != 0 {
+		t.Fatal(f.wire, f.chunks)
+	}
+	if _, err := c.(io.Writer).Write(w[:1]); err != nil {
+		t.Fatal(err)
+	}
+
+	dmaBufAllocator = func(size int) (*videocore.Mem, error) {
+		return nil, errors.New("no memory")
+	}
+	if err := c.Tx(make([]byte, spiFIFOSize+1), nil); err == nil {
+		t.Fatal("allocation failed")
+	}
+}
+
+func TestSPI_csLocked(t *testing.T) {
+	data := []struct {
+		cs   int
+		mode spi.Mode
+		v    spiCS
+	}{
+		{0, spi.Mode0, 0},
+		{1, spi.Mode0, 1},
+		{0, spi.Mode1, spiCPHA},
+		{0, spi.Mode2, spiCPOL},
+		{1, spi.Mode3, spiCPOL | spiCPHA | 1},
+		{1, spi.Mode0 | spi.NoCS, spiCSMask},
+		{1, spi.Mode0 | spi.CSHigh, spiCSPol | spiCSPol1 | 1},
+	}
+	for i, line := range data {
+		s := SPI{cs: line.cs, mode: line.mode}
+		if v := s.csLocked(); v != line.v {
+			t.Fatalf("#%d: 0x%x", i, v)
+		}
+	}
+}
+
+func TestSPI_speedLocked(t *testing.T) {
+	s := SPI{maxHzDev: 1000}
+	if hz := s.speedLocked(0); hz != 1000 {
+		t.Fatal(hz)
+	}
+	if hz := s.speedLocked(5000); hz != 5000 {
+		t.Fatal(hz)
+	}
+	s.maxHzPort = 2000
+	if hz := s.speedLocked(5000); hz != 2000 {
+		t.Fatal(hz)
+	}
+	s.maxHzDev = 0
+	if hz := s.speedLocked(0); hz != 2000 {
+		t.Fatal(hz)
+	}
+}
+
+func TestSPIClockDiv(t *testing.T) {
+	defer func(old int64) { coreClock = old }(coreClock)
+	data := []struct {
+		clock  int64
+		hz     int64
+		div    uint32
+		actual int64
+	}{
+		{250000000, 0, 2, 125000000},
+		{250000000, 200000000, 2, 125000000},
+		{250000000, 125000000, 2, 125000000},
+		{250000000, 100000000, 4, 62500000},
+		{250000000, 1000000, 250, 1000000},
+		{250000000, 999999, 252, 992063},
+		{250000000, 3815, 65532, 3814},
+		{250000000, 3814, 0, 3814},
+		{250000000, 1, 0, 3814},
+		// core_freq=400 in config.txt.
+		{400000000, 0, 2, 200000000},
+		{400000000, 100000000, 4, 100000000},
+		{400000000, 1000000, 400, 1000000},
+		{400000000, 999999, 402, 995024},
+		{400000000, 1, 0, 6103},
+	}
+	for i, line := range data {
+		coreClock = line.clock
+		div, actual := spiClockDiv(line.hz)
+		if div != line.div || actual != line.actual {
+			t.Fatalf("#%d: spiClockDiv(%d) = %d, %d", i, line.hz, div, actual)
+		}
+	}
+}
+
+func TestInitCoreClock(t *testing.T) {
+	defer func(old int64) { coreClock, clockRate = old, videocore.ClockRate }(coreClock)
+	clockRate = func(c videocore.Clock) (int64, error) {
+		return 0, errors.New("no mailbox")
+	}
+	if err := initCoreClock(); err == nil {
+		t.Fatal("the clock rate must be known")
+	}
+	clockRate = func(c videocore.Clock) (int64, error) {
+		if c != videocore.ClockCore {
+			t.Fatal(c)
+		}
+		return 400000000, nil
+	}
+	if err := initCoreClock(); err != nil {
+		t.Fatal(err)
+	}
+	if coreClock != 400000000 {
+		t.Fatal(coreClock)
+	}
+}
+
+func TestSPIInitBlocks(t *testing.T) {
+	defer func() {
+		spiBaseAddr = 0
+	}()
+	spiBaseAddr = 0x3F204000
+	cb := make([]controlBlock, 4)
+	n := spiDMAChunk + 10
+	if err := spiInitBlocks(cb, 0x1000, 0x30000, n); err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		src, dst uint32
+		l        dmaTransferLen
+		ti       dmaTransferInfo
+	}{
+		{0x1000 | dramBus, 0x7E204004, spiDMAChunk, dmaSrcInc | dmaDstDReq | dmaSPITX},
+		{0x7E204004, 0x30000 | dramBus, spiDMAChunk, dmaSrcDReq | dmaDstInc | dmaSPIRX},
+		{(0x1000 + spiDMAChunk) | dramBus, 0x7E204004, 10, dmaSrcInc | dmaDstDReq | dmaSPITX},
+		{0x7E204004, (0x30000 + spiDMAChunk) | dramBus, 10, dmaSrcDReq | dmaDstInc | dmaSPIRX},
+	}
+	for i, e := range expected {
+		ti := dmaNoWideBursts | dmaWaitResp | 1<<dmaWaitCyclesShift | e.ti
+		if cb[i].srcAddr != e.src || cb[i].dstAddr != e.dst || cb[i].txLen != e.l || cb[i].transferInfo != ti || cb[i].nextCB != 0 {
+			t.Fatalf("#%d: %#v", i, &cb[i])
+		}
+	}
+
+	// A buffer to send is always required; write only.
+	if err := spiInitBlocks(cb, 0, 0x30000, 10); err == nil {
+		t.Fatal("TX buffer is required")
+	}
+	if err := spiInitBlocks(cb, 0x1000, 0, 10); err != nil {
+		t.Fatal(err)
+	}
+	if cb[0].transferInfo&dmaSrcIgnore != 0 || cb[1].transferInfo&dmaDstIgnore == 0 {
+		t.Fatalf("%#v", cb[:2])
+	}
+}
+
+func TestRegisterSPI(t *testing.T) {
+	defer func() {
+		spiMemory = nil
+	}()
+	spiMemory = &spiMap{}
+	if err := registerSPI(); err != nil {
+		t.Fatal(err)
+	}
+	names := []string{"bcm283x-SPI0.0", "bcm283x-SPI0.1"}
+	defer func() {
+		for _, n := range names {
+			if err := spireg.Unregister(n); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}()
+	if l := spireg.All(); len(l) != len(names) || l[0].Number != -1 || l[1].Number != -1 {
+		t.Fatal(l)
+	}
+	p, err := spireg.Open("bcm283x-SPI0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, ok := p.(*SPI); !ok || s.cs != 1 {
+		t.Fatalf("%#v", p)
+	}
+}
+
+func TestDriverSPI(t *testing.T) {
+	d := driverSPI{}
+	if s := d.String(); s != "bcm283x-spi" {
+		t.Fatal(s)
+	}
+	if p := d.Prerequisites(); len(p) != 1 || p[0] != "bcm283x-gpio" {
+		t.Fatal(p)
+	}
+	if a := d.After(); len(a) != 1 || a[0] != "bcm283x-dma" {
+		t.Fatal(a)
+	}
+}
+
+func TestSPI_kernelDriver(t *testing.T) {
+	defer initSPITest()()
+	d, err := ioutil.TempDir("", "bcm283x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+	platformDrivers = d
+	if err := os.MkdirAll(filepath.Join(d, "spi-bcm2835", "3f204000.spi"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := NewSPI(0, 0); s != nil || err == nil {
+		t.Fatal("used by the kernel")
+	}
+	if err := registerSPI(); err != nil {
+		t.Fatal(err)
+	}
+	if l := spireg.All(); len(l) != 0 {
+		t.Fatal(l)
+	}
+}
+
+//
+
+// initSPITest sets up the memory maps for a test and returns a function to
+// restore them.
+func initSPITest() func() {
+	oldDMA, oldAlloc := dmaMemory, dmaBufAllocator
+	oldClock, oldDrivers := coreClock, platformDrivers
+	gpioMemory = &gpioMap{}
+	spiMemory = &spiMap{}
+	dmaMemory = nil
+	coreClock = 250000000
+	return func() {
+		resetGPIOMemory()
+		spiMemory, dmaMemory, dmaBufAllocator = nil, oldDMA, oldAlloc
+		coreClock, platformDrivers = oldClock, oldDrivers
+		spi0.active = nil
+	}
+}
+
+// newFakeSPI returns a SPI port connected to a simulated controller.
+func newFakeSPI(t *testing.T, cs int) (*SPI, *fakeSPIRegs) {
+	s, err := NewSPI(0, cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeSPIRegs{t: t}
+	s.r = f
+	return s, f
+}
+
+// fakeSPIRegs simulates the SPI0 registers.
+//
+// The device on the bus returns the inverted byte it receives. In polled mode,
+// a few bytes are exchanged each time the CS register is read, and the
+// exchange stalls when the TX FIFO is empty or the RX FIFO is full, like the
+// hardware does. In DMA mode, DLEN bytes are exchanged at once with the
+// started DMA channels.
+type fakeSPIRegs struct {
+	t      *testing.T
+	cs     spiCS  // CS register, without the status bits.
+	dlen   uint32 // DLEN register.
+	tx, rx []byte // The FIFOs.
+	sent   int    // Bytes exchanged in the current DMA transfer.
+
+	wire   []byte   // Bytes sent on MOSI.
+	events string   // The chip select when TA is set, and "-" when cleared.
+	clk    []uint32 // Values written to the CLK register.
+	chunks []uint32 // Values written to the DLEN register.
+	mem    []byte   // Memory used by the DMA channels.
+}
+
+func (f *fakeSPIRegs) read(r spiReg) uint32 {
+	switch r {
+	case spiRegCS:
+		f.step()
+		c := f.cs
+		if len(f.tx) < spiFIFOSize {
+			c |= spiTXD
+		}
+		if len(f.rx) != 0 {
+			c |= spiRXD
+		}
+		if c&spiTA != 0 {
+			if c&spiDMAEn != 0 {
+				if f.sent == int(f.dlen) {
+					c |= spiDone
+				}
+			} else if len(f.tx) == 0 {
+				c |= spiDone
+			}
+		}
+		return uint32(c)
+	case spiRegFIFO:
+		if len(f.rx) == 0 {
+			f.t.Fatal("RX FIFO underflow")
+		}
+		b := f.rx[0]
+		f.rx = f.rx[1:]
+		return uint32(b)
+	case spiRegDLEN:
+		return f.dlen
+	}
+	f.t.Fatalf("unexpected read of 0x%x", r)
+	return 0
+}
+
+func (f *fakeSPIRegs) write(r spiReg, v uint32) {
+	switch r {
+	case spiRegCS:
+		c := spiCS(v)
+		if c&spiClearRX != 0 {
+			f.rx = nil
+		}
+		if c&spiClearTX != 0 {
+			f.tx = nil
+		}
+		c &^= spiClearRX | spiClearTX
+		if c&spiTA != 0 && f.cs&spiTA == 0 {
+			f.events += string('0' + byte(c&spiCSMask))
+		} else if c&spiTA == 0 && f.cs&spiTA != 0 {
+			f.events += "-"
+		}
+		f.cs = c
+	case spiRegFIFO:
+		if f.cs&spiTA == 0 || f.cs&spiDMAEn != 0 {
+			f.t.Fatal("FIFO written while not in polled mode")
+		}
+		if len(f.tx) == spiFIFOSize {
+			f.t.Fatal("TX FIFO overflow")
+		}
+		f.tx = append(f.tx, byte(v))
+	case spiRegCLK:
+		f.clk = append(f.clk, v)
+	case spiRegDLEN:
+		f.chunks = append(f.chunks, v)
+		f.dlen = v
+		f.sent = 0
+	default:
+		f.t.Fatalf("unexpected write of 0x%x", r)
+	}
+}
+
+// step exchanges up to 8 bytes through the FIFOs in polled mode, or runs the
+// started DMA channels in DMA mode.
+func (f *fakeSPIRegs) step() {
+	if f.cs&spiTA == 0 {
+		return
+	}
+	if f.cs&spiDMAEn != 0 {
+		f.runDMA()
+		return
+	}
+	for i := 0; i < 8 && len(f.tx) != 0 && len(f.rx) != spiFIFOSize; i++ {
+		f.rx = append(f.rx, f.exchange(f.tx[0]))
+		f.tx = f.tx[1:]
+	}
+}
+
+// runDMA does the whole DLEN bytes at once with the started DMA channels.
+//
+// The memory buffer has a physical address of 0.
+func (f *fakeSPIRegs) runDMA() {
+	var tx, rx *controlBlock
+	for i := range dmaMemory.channels {
+		ch := &dmaMemory.channels[i]
+		if ch.cs&dmaActive == 0 {
+			continue
+		}
+		ch.cs &^= dmaActive
+		cb := (*controlBlock)(unsafe.Pointer(&f.mem[ch.cbAddr]))
+		if cb.transferInfo&dmaDstDReq != 0 {
+			tx = cb
+		} else {
+			rx = cb
+		}
+	}
+	if tx == nil && rx == nil {
+		return
+	}
+	if tx == nil || rx == nil {
+		f.t.Fatal("both DMA channels must be started")
+	}
+	if tx.transferInfo&dmaSrcIgnore != 0 {
+		f.t.Fatal("the DMA source must not be ignored")
+	}
+	if uint32(tx.txLen) != f.dlen || uint32(rx.txLen) != f.dlen {
+		f.t.Fatalf("DMA length mismatch: %d, %d, %d", tx.txLen, rx.txLen, f.dlen)
+	}
+	src := tx.srcAddr &^ dramBus
+	dst := rx.dstAddr &^ dramBus
+	for ; f.sent < int(f.dlen); f.sent++ {
+		b := f.exchange(f.mem[src+uint32(f.sent)])
+		if rx.transferInfo&dmaDstIgnore == 0 {
+			f.mem[dst+uint32(f.sent)] = b
+		}
+	}
+}
+
+func (f *fakeSPIRegs) exchange(o byte) byte {
+	f.wire = append(f.wire, o)
+	return ^o
+}
+
+var _ spiRegs = &fakeSPIRegs{}
@@ -9,6 +9,7 @@
 package fs
 
 import (
+	"context"
 	"errors"
 	"os"
 	"sync"
@@ -82,11 +83,30 @@ func (e *Event) MakeEvent(fd uintptr) error {
 	return e.event.makeEvent(fd)
 }
 
+// MakeReadEvent initializes an epoll *level* triggered event on linux that is
+// signaled as long as data is available to be read on the file handle.
+//
+// This is the notification mechanism used by character devices that queue
+// records, like the lines requested on a /dev/gpiochipN device. Unlike
+// MakeEvent, the event stays signaled until all the queued data is read.
+func (e *Event) MakeReadEvent(fd uintptr) error {
+	return e.event.makeReadEvent(fd)
+}
+
 // Wait waits for an event or the specified amount of time.
 func (e *Event) Wait(timeoutms int) (int, error) {
 	return e.event.wait(timeoutms)
 }
 
+// WaitContext waits for an event or until ctx is done, in which case it
+// returns ctx.Err().
+//
+// On linux, a pipe is registered along the file handle when the event is made,
+// so that the wait can be interrupted.
+func (e *Event) WaitContext(ctx context.Context) (int, error) {
+	return e.event.waitContext(ctx)
+}
+
 //
 
 var (
@@ -4,7 +4,11 @@
 
 package fs
 
-import "syscall"
+import (
+	"context"
+	"syscall"
+	"time"
+)
 
 const isLinux = true
 
@@ -17,6 +21,7 @@ func ioctl(f uintptr, op uint, arg uintptr) error {
 
 const (
 	epollET     = 1 << 31
+	epollIN     = 1
 	epollPRI    = 2
 	epollCTLAdd = 1
 	epollCTLDel = 2
@@ -27,6 +32,8 @@ type event struct {
 	event   [1]syscall.EpollEvent
 	epollFd int
 	fd      int
+	// wake is a pipe registered along fd to interrupt waitContext().
+	wake [2]int
 }
 
 // makeEvent creates an epoll *edge* triggered event.
@@ -48,10 +55,118 @@ func (e *event) makeEvent(fd uintptr) error {
 	// outside the scope of this interface.
 	e.event[0].Events = epollPRI | epollET
 	e.event[0].Fd = int32(e.fd)
-	return syscall.EpollCtl(e.epollFd, epollCTLAdd, e.fd, &e.event[0])
+	if err := syscall.EpollCtl(e.epollFd, epollCTLAdd, e.fd, &e.event[0]); err != nil {
+		return err
+	}
+	return e.makeWake()
+}
+
+// makeReadEvent creates an epoll *level* triggered event on readable data.
+func (e *event) makeReadEvent(fd uintptr) error {
+	epollFd, err := syscall.EpollCreate(1)
+	if err != nil {
+		return err
+	}
+	e.epollFd = epollFd
+	e.fd = int(fd)
+	e.event[0].Events = epollIN
+	e.event[0].Fd = int32(e.fd)
+	if err := syscall.EpollCtl(e.epollFd, epollCTLAdd, e.fd, &e.event[0]); err != nil {
+		return err
+	}
+	return e.makeWake()
 }
 
 func (e *event) wait(timeoutms int) (int, error) {
-	// http://man7.org/linux/man-pages/man2/epoll_wait.2.html
-	return syscall.EpollWait(e.epollFd, e.event[:], timeoutms)
+	return e.epollWait(nil, timeoutms)
+}
+
+func (e *event) waitContext(ctx context.Context) (int, error) {
+	if ctx.Done() == nil {
+		return e.wait(-1)
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		select {
+		case <-ctx.Done():
+			_, _ = syscall.Write(e.wake[1], []byte{0})
+		case <-done:
+		}
+	}()
+	defer func() {
+		// Once the goroutine exited, nothing can write to the pipe anymore so
+		// drain it; otherwise the next wait would be woken up spuriously.
+		close(done)
+		<-exited
+		var b [8]byte
+		for {
+			if n, _ := syscall.Read(e.wake[0], b[:]); n <= 0 {
+				break
+			}
+		}
+	}()
+	return e.epollWait(ctx, -1)
+}
+
+// epollWait waits for fd to be signaled, for timeoutms or until ctx is done.
+//
+// The wake pipe may be signaled on behalf of a concurrent waitContext() whose
+// context is done; it is then ignored until it is drained.
+func (e *event) epollWait(ctx context.Context, timeoutms int) (int, error) {
+	var deadline time.Time
+	if timeoutms > 0 {
+		deadline = time.Now().Add(time.Duration(timeoutms) * time.Millisecond)
+	}
+	var events [2]syscall.EpollEvent
+	for {
+		// http://man7.org/linux/man-pages/man2/epoll_wait.2.html
+		n, err := syscall.EpollWait(e.epollFd, events[:], timeoutms)
+		if err == syscall.EINTR && ctx != nil {
+			continue
+		}
+		if err != nil || n == 0 {
+			return n, err
+		}
+		for i := 0; i < n; i++ {
+			if int(events[i].Fd) == e.fd {
+				return 1, nil
+			}
+		}
+		if ctx != nil {
+			if err := ctx.Err(); err != nil {
+				return 0, err
+			}
+		}
+		if timeoutms == 0 {
+			return 0, nil
+		}
+		if timeoutms > 0 {
+			if timeoutms = int(time.Until(deadline) / time.Millisecond); timeoutms <= 0 {
+				return 0, nil
+			}
+		}
+	}
+}
+
+// makeWake creates the non-blocking pipe used to interrupt waitContext() and
+// registers it in epoll.
+//
+// It is created along the event rather than upon the first wait so that
+// concurrent calls to waitContext() don't race to create it.
+func (e *event) makeWake() error {
+	if err := syscall.Pipe2(e.wake[:], syscall.O_NONBLOCK|syscall.O_CLOEXEC); err != nil {
+		return err
+	}
+	ev := syscall.EpollEvent{Events: epollIN, Fd: int32(e.wake[0])}
+	if err := syscall.EpollCtl(e.epollFd, epollCTLAdd, e.wake[0], &ev); err != nil {
+		_ = syscall.Close(e.wake[0])
+		_ = syscall.Close(e.wake[1])
+		return err
+	}
+	return nil
 }
@@ -0,0 +1,102 @@
+// Copyright 2018 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package fs
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestEvent_WaitContext_cancel(t *testing.T) {
+	r, w, e := newPipeEvent(t)
+	defer r.Close()
+	defer w.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	if n, err := e.WaitContext(ctx); n != 0 || err != context.Canceled {
+		t.Fatal(n, err)
+	}
+	// Already canceled.
+	if n, err := e.WaitContext(ctx); n != 0 || err != context.Canceled {
+		t.Fatal(n, err)
+	}
+
+	// The wake pipe was drained so the next wait isn't woken up spuriously.
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if n, err := e.WaitContext(ctx); n != 0 || err != context.DeadlineExceeded {
+		t.Fatal(n, err)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Fatalf("woken up after %s", d)
+	}
+
+	// Data is still detected.
+	if _, err := w.Write([]byte{1}); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := e.WaitContext(context.Background()); n != 1 || err != nil {
+		t.Fatal(n, err)
+	}
+}
+
+func TestEvent_WaitContext_concurrent(t *testing.T) {
+	r, w, e := newPipeEvent(t)
+	defer r.Close()
+	defer w.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := make(chan error)
+	go func() {
+		_, err := e.WaitContext(ctx)
+		canceled <- err
+	}()
+	waited := make(chan int)
+	go func() {
+		n, err := e.WaitContext(context.Background())
+		if err != nil {
+			t.Error(err)
+		}
+		waited <- n
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	if err := <-canceled; err != context.Canceled {
+		t.Fatal(err)
+	}
+	// The other wait isn't affected by the cancellation.
+	select {
+	case n := <-waited:
+		t.Fatalf("woken up: %d", n)
+	case <-time.After(20 * time.Millisecond):
+	}
+	if _, err := w.Write([]byte{1}); err != nil {
+		t.Fatal(err)
+	}
+	if n := <-waited; n != 1 {
+		t.Fatal(n)
+	}
+}
+
+//
+
+func newPipeEvent(t *testing.T) (*os.File, *os.File, *Event) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &Event{}
+	if err := e.MakeReadEvent(r.Fd()); err != nil {
+		t.Fatal(err)
+	}
+	return r, w, e
+}
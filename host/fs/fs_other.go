@@ -6,7 +6,10 @@
 
 package fs
 
-import "errors"
+import (
+	"context"
+	"errors"
+)
 
 const isLinux = false
 
@@ -20,6 +23,14 @@ func (e *event) makeEvent(f uintptr) error {
 	return errors.New("fs: unreachable code")
 }
 
+func (e *event) makeReadEvent(f uintptr) error {
+	return errors.New("fs: unreachable code")
+}
+
 func (e *event) wait(timeoutms int) (int, error) {
 	return 0, errors.New("fs: unreachable code")
 }
+
+func (e *event) waitContext(ctx context.Context) (int, error) {
+	return 0, errors.New("fs: unreachable code")
+}
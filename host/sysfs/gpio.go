@@ -5,6 +5,7 @@
 package sysfs
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -210,6 +211,41 @@ func (p *Pin) WaitForEdge(timeout time.Duration) bool {
 	}
 }
 
+// ReadEdge does edge detection, returns once one is detected and implements
+// gpio.PinEdgeEvents.
+//
+// gpio sysfs doesn't timestamp the edges, so the event is timestamped when the
+// process is notified. When both edges are detected, the kind of edge is
+// deduced from the level read right after, which is wrong if the pin toggled
+// again in the meantime.
+func (p *Pin) ReadEdge(timeout time.Duration) (gpio.EdgeEvent, bool) {
+	if !p.WaitForEdge(timeout) {
+		return gpio.EdgeEvent{}, false
+	}
+	e := gpio.EdgeEvent{Time: monotonicTime(), Level: p.Read()}
+	switch p.edge {
+	case gpio.RisingEdge:
+		e.Edge = gpio.RisingEdge
+	case gpio.FallingEdge:
+		e.Edge = gpio.FallingEdge
+	default:
+		if e.Level == gpio.High {
+			e.Edge = gpio.RisingEdge
+		} else {
+			e.Edge = gpio.FallingEdge
+		}
+	}
+	return e, true
+}
+
+// WaitForEdgeContext does edge detection, returns once one is detected or ctx
+// is done and implements gpio.PinInContext.
+func (p *Pin) WaitForEdgeContext(ctx context.Context) bool {
+	// Run lockless, as the normal use is to call in a busy loop.
+	nr, err := p.event.WaitContext(ctx)
+	return err == nil && nr == 1
+}
+
 // Pull returns gpio.PullNoChange since gpio sysfs has no support for input
 // pull resistor.
 func (p *Pin) Pull() gpio.Pull {
@@ -455,4 +491,6 @@ func init() {
 var _ gpio.PinIn = &Pin{}
 var _ gpio.PinOut = &Pin{}
 var _ gpio.PinIO = &Pin{}
+var _ gpio.PinEdgeEvents = &Pin{}
+var _ gpio.PinInContext = &Pin{}
 var _ fmt.Stringer = &Pin{}
@@ -135,6 +135,9 @@ func TestPin_WaitForEdges(t *testing.T) {
 	if p.WaitForEdge(-1) {
 		t.Fatal("broken pin doesn't have edge triggered")
 	}
+	if _, ok := p.ReadEdge(-1); ok {
+		t.Fatal("broken pin doesn't have edge triggered")
+	}
 }
 
 func TestPin_Pull(t *testing.T) {
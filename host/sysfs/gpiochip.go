@@ -0,0 +1,828 @@
+// Copyright 2017 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package sysfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+	"unsafe"
+
+	"periph.io/x/periph"
+	"periph.io/x/periph/conn/gpio"
+	"periph.io/x/periph/conn/gpio/gpioreg"
+	"periph.io/x/periph/host/fs"
+)
+
+// GPIOChips is all the GPIO character devices found on the host, in order of
+// their device number.
+//
+// This global variable is initialized once at driver initialization and isn't
+// mutated afterward. Do not modify it.
+var GPIOChips []*GPIOChip
+
+// GPIOLines is all the GPIO lines exposed by the GPIO character devices,
+// indexed by their global pin number.
+//
+// The global pin number is the same as the one used by gpio sysfs when the
+// kernel exposes it, so the pins can be used interchangeably.
+//
+// This global variable is initialized once at driver initialization and isn't
+// mutated afterward. Do not modify it.
+var GPIOLines map[int]*GPIOLine
+
+// GPIOChip represents a GPIO controller as exposed by /dev/gpiochipN.
+type GPIOChip struct {
+	name  string // Something like gpiochip0
+	label string // Something like pinctrl-bcm2835
+	base  int    // Global number of the first line
+	lines []*GPIOLine
+
+	mu sync.Mutex // Serializes ioctl calls on f
+	f  ioctlCloser
+}
+
+func (c *GPIOChip) String() string {
+	return c.name
+}
+
+// Label returns the label the kernel driver assigned to the GPIO controller.
+func (c *GPIOChip) Label() string {
+	return c.label
+}
+
+// Lines returns all the lines exposed by this GPIO controller, in order of
+// offset.
+func (c *GPIOChip) Lines() []*GPIOLine {
+	return c.lines
+}
+
+// Drive specifies the output drive mode of a GPIOLine.
+type Drive uint8
+
+// Acceptable drive values.
+const (
+	PushPull   Drive = 0 // Actively drive both Low and High
+	OpenDrain  Drive = 1 // Only drive Low, let the line float on High
+	OpenSource Drive = 2 // Only drive High, let the line float on Low
+)
+
+const driveName = "PushPullOpenDrainOpenSource"
+
+var driveIndex = [...]uint8{0, 8, 17, 27}
+
+func (i Drive) String() string {
+	if i >= Drive(len(driveIndex)-1) {
+		return "Drive(" + strconv.Itoa(int(i)) + ")"
+	}
+	return driveName[driveIndex[i]:driveIndex[i+1]]
+}
+
+// GPIOLine represents one GPIO pin as found on a GPIO character device.
+//
+// The line is requested from the kernel the first time it is used and is
+// automatically released by the kernel when the process exits.
+type GPIOLine struct {
+	chip     *GPIOChip
+	offset   uint32
+	number   int
+	name     string // Something like GPIO17
+	lineName string // Name as assigned by the kernel driver, may be empty
+
+	mu        sync.Mutex
+	consumer  string    // Label the line is requested with
+	f         fileIO    // handle to the line request; never closed
+	direction direction // Cache of the last known direction
+	pull      gpio.Pull // Cache of the last pull set
+	edge      gpio.Edge // Cache of the last edge used
+	drive     Drive     // Drive used when set as output
+	debounce  time.Duration
+	hasEvent  bool     // Set once event is initialized
+	event     fs.Event // Initialized once
+}
+
+func (l *GPIOLine) String() string {
+	return l.name
+}
+
+// Name implements pin.Pin.
+func (l *GPIOLine) Name() string {
+	return l.name
+}
+
+// Number implements pin.Pin.
+func (l *GPIOLine) Number() int {
+	return l.number
+}
+
+// LineName returns the name the kernel driver assigned to the line, if any.
+func (l *GPIOLine) LineName() string {
+	return l.lineName
+}
+
+// Function implements pin.Pin.
+func (l *GPIOLine) Function() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		// The line is not requested by this process; ask the kernel what it
+		// knows about it.
+		info, err := l.chip.lineInfo(l.offset)
+		if err != nil {
+			return "ERR"
+		}
+		if info.flags&lineFlagOutput != 0 {
+			return "Out"
+		}
+		return "In"
+	}
+	switch l.direction {
+	case dIn:
+		return "In/" + l.read().String()
+	case dOut:
+		return "Out/" + l.read().String()
+	default:
+		return "ERR"
+	}
+}
+
+// Halt implements conn.Resource.
+//
+// It stops edge detection if enabled.
+func (l *GPIOLine) Halt() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.edge == gpio.NoEdge {
+		return nil
+	}
+	if err := l.setInput(l.pull, gpio.NoEdge); err != nil {
+		return l.wrap(err)
+	}
+	return nil
+}
+
+// SetConsumer sets the consumer label that is reported by the kernel while
+// the line is in use by this process.
+//
+// It defaults to the process name. It must be called before the line is
+// first used.
+func (l *GPIOLine) SetConsumer(label string) error {
+	if len(label) >= gpioMaxNameSize {
+		return l.wrap(fmt.Errorf("consumer label %q is too long", label))
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f != nil {
+		return l.wrap(errors.New("can't change the consumer label of a line already in use"))
+	}
+	l.consumer = label
+	return nil
+}
+
+// SetDrive sets the drive mode used when the line is set as output.
+//
+// If the line is currently an output, the change takes effect immediately.
+func (l *GPIOLine) SetDrive(d Drive) error {
+	if d > OpenSource {
+		return l.wrap(fmt.Errorf("invalid drive %s", d))
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.drive = d
+	if l.direction == dOut {
+		if err := l.setOutput(l.read()); err != nil {
+			return l.wrap(err)
+		}
+	}
+	return nil
+}
+
+// SetDebounce sets the debounce period applied by the kernel when the line
+// is set as input. Use 0 to disable debouncing.
+//
+// If the line is currently an input, the change takes effect immediately.
+func (l *GPIOLine) SetDebounce(d time.Duration) error {
+	if d < 0 || d/time.Microsecond >= 1<<32 {
+		return l.wrap(fmt.Errorf("invalid debounce period %s", d))
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.debounce = d
+	if l.direction == dIn {
+		if err := l.setInput(l.pull, l.edge); err != nil {
+			return l.wrap(err)
+		}
+	}
+	return nil
+}
+
+// In setups a pin as an input.
+//
+// Unlike gpio sysfs, pull resistors are supported if the kernel driver for
+// the GPIO controller supports it.
+func (l *GPIOLine) In(pull gpio.Pull, edge gpio.Edge) error {
+	if pull > gpio.PullNoChange {
+		return l.wrap(fmt.Errorf("invalid pull %s", pull))
+	}
+	if edge > gpio.BothEdges {
+		return l.wrap(fmt.Errorf("invalid edge %s", edge))
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if pull == gpio.PullNoChange {
+		pull = l.pull
+	}
+	if err := l.setInput(pull, edge); err != nil {
+		return l.wrap(err)
+	}
+	return nil
+}
+
+// Read implements gpio.PinIn.
+func (l *GPIOLine) Read() gpio.Level {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.read()
+}
+
+// WaitForEdge does edge detection, returns once one is detected and implements
+// gpio.PinIn.
+func (l *GPIOLine) WaitForEdge(timeout time.Duration) bool {
+	_, ok := l.ReadEdge(timeout)
+	return ok
+}
+
+// ReadEdge does edge detection, returns once one is detected and implements
+// gpio.PinEdgeEvents.
+//
+// The events are timestamped by the kernel, and queued up to a limit of 16
+// events.
+func (l *GPIOLine) ReadEdge(timeout time.Duration) (gpio.EdgeEvent, bool) {
+	// Run lockless, as the normal use is to call in a busy loop.
+	if !l.hasEvent {
+		return gpio.EdgeEvent{}, false
+	}
+	var ms int
+	if timeout == -1 {
+		ms = -1
+	} else {
+		ms = int(timeout / time.Millisecond)
+	}
+	start := time.Now()
+	for {
+		if nr, err := l.event.Wait(ms); err != nil {
+			return gpio.EdgeEvent{}, false
+		} else if nr == 1 {
+			return l.readEvent()
+		}
+		// A signal occurred.
+		if timeout != -1 {
+			ms = int((timeout - time.Since(start)) / time.Millisecond)
+		}
+		if ms <= 0 {
+			return gpio.EdgeEvent{}, false
+		}
+	}
+}
+
+// WaitForEdgeContext does edge detection, returns once one is detected or ctx
+// is done and implements gpio.PinInContext.
+func (l *GPIOLine) WaitForEdgeContext(ctx context.Context) bool {
+	// Run lockless, as the normal use is to call in a busy loop.
+	if !l.hasEvent {
+		return false
+	}
+	if nr, err := l.event.WaitContext(ctx); err != nil || nr != 1 {
+		return false
+	}
+	_, ok := l.readEvent()
+	return ok
+}
+
+// Pull implements gpio.PinIn.
+//
+// Returns PullNoChange if the bias is unknown.
+func (l *GPIOLine) Pull() gpio.Pull {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.pull
+}
+
+// Out sets a pin as output; implements gpio.PinOut.
+func (l *GPIOLine) Out(level gpio.Level) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.direction != dOut {
+		if err := l.setOutput(level); err != nil {
+			return l.wrap(err)
+		}
+		return nil
+	}
+	v := lineValues{mask: 1}
+	if level {
+		v.bits = 1
+	}
+	if err := l.f.Ioctl(ioctlLineSetValues, uintptr(unsafe.Pointer(&v))); err != nil {
+		return l.wrap(err)
+	}
+	return nil
+}
+
+//
+
+// read returns the current level.
+//
+// lock must be held.
+func (l *GPIOLine) read() gpio.Level {
+	if l.f == nil {
+		return gpio.Low
+	}
+	v := lineValues{mask: 1}
+	if err := l.f.Ioctl(ioctlLineGetValues, uintptr(unsafe.Pointer(&v))); err != nil {
+		// Error.
+		return gpio.Low
+	}
+	return v.bits&1 != 0
+}
+
+// setInput configures the line as input.
+//
+// lock must be held.
+func (l *GPIOLine) setInput(pull gpio.Pull, edge gpio.Edge) error {
+	var cfg lineConfig
+	cfg.flags = lineFlagInput | pullToFlags(pull)
+	switch edge {
+	case gpio.RisingEdge:
+		cfg.flags |= lineFlagEdgeRising
+	case gpio.FallingEdge:
+		cfg.flags |= lineFlagEdgeFalling
+	case gpio.BothEdges:
+		cfg.flags |= lineFlagEdgeRising | lineFlagEdgeFalling
+	}
+	if l.debounce != 0 {
+		cfg.addAttr(lineAttrDebounce, uint64(l.debounce/time.Microsecond))
+	}
+	if err := l.configure(&cfg); err != nil {
+		return err
+	}
+	l.direction = dIn
+	l.pull = pull
+	if edge != gpio.NoEdge && !l.hasEvent {
+		if err := l.event.MakeReadEvent(l.f.Fd()); err != nil {
+			return err
+		}
+		l.hasEvent = true
+	}
+	l.edge = edge
+	// Flush the edges that were queued before the reconfiguration. The kernel
+	// queue is bounded so this loop is bounded too.
+	for i := 0; i < lineEventBufferSize; i++ {
+		if !l.WaitForEdge(0) {
+			break
+		}
+	}
+	return nil
+}
+
+// setOutput configures the line as output with the initial level specified.
+//
+// lock must be held.
+func (l *GPIOLine) setOutput(level gpio.Level) error {
+	var cfg lineConfig
+	cfg.flags = lineFlagOutput
+	switch l.drive {
+	case OpenDrain:
+		cfg.flags |= lineFlagOpenDrain
+	case OpenSource:
+		cfg.flags |= lineFlagOpenSource
+	}
+	// Set the initial value in the same call to ensure glitch free operation.
+	var v uint64
+	if level {
+		v = 1
+	}
+	cfg.addAttr(lineAttrOutputValues, v)
+	if err := l.configure(&cfg); err != nil {
+		return err
+	}
+	l.direction = dOut
+	l.edge = gpio.NoEdge
+	return nil
+}
+
+// configure requests the line from the kernel if needed and applies the
+// configuration.
+//
+// lock must be held.
+func (l *GPIOLine) configure(cfg *lineConfig) error {
+	if l.f != nil {
+		return l.f.Ioctl(ioctlLineSetConfig, uintptr(unsafe.Pointer(cfg)))
+	}
+	req := lineRequest{numLines: 1, eventBufferSize: lineEventBufferSize}
+	req.offsets[0] = l.offset
+	copy(req.consumer[:gpioMaxNameSize-1], l.consumer)
+	req.config = *cfg
+	if err := l.chip.ioctl(ioctlGetLine, uintptr(unsafe.Pointer(&req))); err != nil {
+		if os.IsPermission(err) {
+			return fmt.Errorf("need more access, try as root or setup udev rules: %v", err)
+		}
+		return err
+	}
+	f, err := lineOpen(req.fd, l.name)
+	if err != nil {
+		return err
+	}
+	l.f = f
+	return nil
+}
+
+// readEvent consumes one event, otherwise the level triggered event stays
+// signaled.
+func (l *GPIOLine) readEvent() (gpio.EdgeEvent, bool) {
+	var e lineEvent
+	if _, err := l.f.Read((*[unsafe.Sizeof(e)]byte)(unsafe.Pointer(&e))[:]); err != nil {
+		return gpio.EdgeEvent{}, false
+	}
+	return e.toEdgeEvent(), true
+}
+
+func (l *GPIOLine) wrap(err error) error {
+	return fmt.Errorf("sysfs-gpiochip (%s): %v", l, err)
+}
+
+func pullToFlags(pull gpio.Pull) uint64 {
+	switch pull {
+	case gpio.Float:
+		return lineFlagBiasDisabled
+	case gpio.PullDown:
+		return lineFlagBiasPullDown
+	case gpio.PullUp:
+		return lineFlagBiasPullUp
+	default:
+		return 0
+	}
+}
+
+func flagsToPull(flags uint64) gpio.Pull {
+	switch {
+	case flags&lineFlagBiasDisabled != 0:
+		return gpio.Float
+	case flags&lineFlagBiasPullDown != 0:
+		return gpio.PullDown
+	case flags&lineFlagBiasPullUp != 0:
+		return gpio.PullUp
+	default:
+		return gpio.PullNoChange
+	}
+}
+
+//
+
+// lineOpen returns a file handle from the file descriptor returned by the
+// kernel for a line request.
+var lineOpen = lineOpenDefault
+
+func lineOpenDefault(fd int32, name string) (fileIO, error) {
+	if fd < 0 {
+		return nil, errors.New("invalid line file descriptor")
+	}
+	return &fs.File{File: os.NewFile(uintptr(fd), name)}, nil
+}
+
+func newGPIOChip(path string) (*GPIOChip, error) {
+	f, err := ioctlOpen(path, os.O_RDWR)
+	if err != nil {
+		if os.IsPermission(err) {
+			return nil, fmt.Errorf("need more access, try as root or setup udev rules: %v", err)
+		}
+		return nil, err
+	}
+	var info chipInfo
+	if err := f.Ioctl(ioctlGetChipInfo, uintptr(unsafe.Pointer(&info))); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	c := &GPIOChip{
+		name:  cString(info.name[:]),
+		label: cString(info.label[:]),
+		base:  -1,
+		lines: make([]*GPIOLine, info.lines),
+		f:     f,
+	}
+	if c.name == "" {
+		c.name = filepath.Base(path)
+	}
+	consumer := filepath.Base(os.Args[0])
+	if len(consumer) >= gpioMaxNameSize {
+		consumer = consumer[:gpioMaxNameSize-1]
+	}
+	for i := range c.lines {
+		l := &GPIOLine{chip: c, offset: uint32(i), consumer: consumer, pull: gpio.PullNoChange}
+		if li, err := c.lineInfo(l.offset); err == nil {
+			l.lineName = cString(li.name[:])
+			l.pull = flagsToPull(li.flags)
+		}
+		c.lines[i] = l
+	}
+	return c, nil
+}
+
+// setBase assigns the global pin numbers to the lines.
+func (c *GPIOChip) setBase(base int) {
+	c.base = base
+	for i, l := range c.lines {
+		l.number = base + i
+		l.name = fmt.Sprintf("GPIO%d", l.number)
+	}
+}
+
+func (c *GPIOChip) ioctl(op uint, data uintptr) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.f.Ioctl(op, data)
+}
+
+func (c *GPIOChip) lineInfo(offset uint32) (*lineInfo, error) {
+	info := &lineInfo{offset: offset}
+	if err := c.ioctl(ioctlGetLineInfo, uintptr(unsafe.Pointer(info))); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// sysfsGPIOChipBase returns the base number as assigned by gpio sysfs for the
+// GPIO controller, or -1 if gpio sysfs is not available.
+func sysfsGPIOChipBase(name string) int {
+	items, err := filepath.Glob("/sys/bus/gpio/devices/" + name + "/gpio/gpiochip*")
+	if err != nil || len(items) != 1 {
+		return -1
+	}
+	base, err := readInt(items[0] + "/base")
+	if err != nil {
+		return -1
+	}
+	return base
+}
+
+// cString returns the NUL terminated string in b.
+func cString(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
+
+// GPIO character device IOCTL control codes and structures, for the uAPI v2.
+//
+// Constants and structure definition can be found at
+// /usr/include/linux/gpio.h.
+const (
+	ioctlGetChipInfo   = 0x8044B401 // GPIO_GET_CHIPINFO_IOCTL
+	ioctlGetLineInfo   = 0xC100B405 // GPIO_V2_GET_LINEINFO_IOCTL
+	ioctlGetLine       = 0xC250B407 // GPIO_V2_GET_LINE_IOCTL
+	ioctlLineSetConfig = 0xC110B40D // GPIO_V2_LINE_SET_CONFIG_IOCTL
+	ioctlLineGetValues = 0xC010B40E // GPIO_V2_LINE_GET_VALUES_IOCTL
+	ioctlLineSetValues = 0xC010B40F // GPIO_V2_LINE_SET_VALUES_IOCTL
+)
+
+const (
+	gpioMaxNameSize     = 32
+	gpioLinesMax        = 64
+	gpioLineNumAttrsMax = 10
+	lineEventBufferSize = 16
+)
+
+// gpio_v2_line_flag
+const (
+	lineFlagUsed         = 1 << 0
+	lineFlagActiveLow    = 1 << 1
+	lineFlagInput        = 1 << 2
+	lineFlagOutput       = 1 << 3
+	lineFlagEdgeRising   = 1 << 4
+	lineFlagEdgeFalling  = 1 << 5
+	lineFlagOpenDrain    = 1 << 6
+	lineFlagOpenSource   = 1 << 7
+	lineFlagBiasPullUp   = 1 << 8
+	lineFlagBiasPullDown = 1 << 9
+	lineFlagBiasDisabled = 1 << 10
+)
+
+// gpio_v2_line_attr_id
+const (
+	lineAttrFlags        = 1
+	lineAttrOutputValues = 2
+	lineAttrDebounce     = 3
+)
+
+// gpio_v2_line_event_id
+const (
+	lineEventRisingEdge  = 1
+	lineEventFallingEdge = 2
+)
+
+// chipInfo is gpiochip_info.
+type chipInfo struct {
+	name  [gpioMaxNameSize]byte
+	label [gpioMaxNameSize]byte
+	lines uint32
+}
+
+// lineValues is gpio_v2_line_values.
+type lineValues struct {
+	bits uint64
+	mask uint64
+}
+
+// lineAttribute is gpio_v2_line_attribute.
+//
+// value is a union of flags, values and debounce_period_us.
+type lineAttribute struct {
+	id      uint32
+	padding uint32
+	value   uint64
+}
+
+// lineConfigAttribute is gpio_v2_line_config_attribute.
+type lineConfigAttribute struct {
+	attr lineAttribute
+	mask uint64
+}
+
+// lineConfig is gpio_v2_line_config.
+type lineConfig struct {
+	flags    uint64
+	numAttrs uint32
+	padding  [5]uint32
+	attrs    [gpioLineNumAttrsMax]lineConfigAttribute
+}
+
+// addAttr adds an attribute applying to the first line of the request.
+func (c *lineConfig) addAttr(id uint32, value uint64) {
+	c.attrs[c.numAttrs] = lineConfigAttribute{attr: lineAttribute{id: id, value: value}, mask: 1}
+	c.numAttrs++
+}
+
+// lineRequest is gpio_v2_line_request.
+type lineRequest struct {
+	offsets         [gpioLinesMax]uint32
+	consumer        [gpioMaxNameSize]byte
+	config          lineConfig
+	numLines        uint32
+	eventBufferSize uint32
+	padding         [5]uint32
+	fd              int32
+}
+
+// lineInfo is gpio_v2_line_info.
+type lineInfo struct {
+	name     [gpioMaxNameSize]byte
+	consumer [gpioMaxNameSize]byte
+	offset   uint32
+	numAttrs uint32
+	flags    uint64
+	attrs    [gpioLineNumAttrsMax]lineAttribute
+	padding  [4]uint32
+}
+
+// lineEvent is gpio_v2_line_event.
+type lineEvent struct {
+	timestamp uint64 // In ns, from CLOCK_MONOTONIC
+	id        uint32
+	offset    uint32
+	seqno     uint32
+	lineSeqno uint32
+	padding   [6]uint32
+}
+
+func (e *lineEvent) toEdgeEvent() gpio.EdgeEvent {
+	out := gpio.EdgeEvent{Time: time.Duration(e.timestamp)}
+	if e.id == lineEventRisingEdge {
+		out.Edge = gpio.RisingEdge
+		out.Level = gpio.High
+	} else {
+		out.Edge = gpio.FallingEdge
+		out.Level = gpio.Low
+	}
+	return out
+}
+
+//
+
+// driverGPIOChip implements periph.Driver.
+type driverGPIOChip struct {
+}
+
+func (d *driverGPIOChip) String() string {
+	return "sysfs-gpiochip"
+}
+
+func (d *driverGPIOChip) Prerequisites() []string {
+	return nil
+}
+
+func (d *driverGPIOChip) After() []string {
+	return []string{"sysfs-gpio"}
+}
+
+// Init initializes GPIO character device handling code.
+//
+// Uses the GPIO character device uAPI v2 as described at
+// https://www.kernel.org/doc/html/latest/userspace-api/gpio/chardev.html
+//
+// Unlike gpio sysfs, it supports pull resistors, open drain outputs and
+// debouncing, and the lines are released automatically when the process
+// exits.
+//
+// The pins registered by sysfs-gpio are superseded by the ones exposed by this
+// driver.
+func (d *driverGPIOChip) Init() (bool, error) {
+	prefix := "/dev/gpiochip"
+	items, err := filepath.Glob(prefix + "*")
+	if err != nil {
+		return true, err
+	}
+	// Make sure they are processed in numerical order.
+	var numbers []int
+	for _, item := range items {
+		if n, err := strconv.Atoi(item[len(prefix):]); err == nil {
+			numbers = append(numbers, n)
+		}
+	}
+	if len(numbers) == 0 {
+		return false, errors.New("no GPIO chip found")
+	}
+	sort.Ints(numbers)
+
+	// There are hosts that use non-continuous pin numbering so use a map instead
+	// of an array.
+	GPIOLines = map[int]*GPIOLine{}
+	next := 0
+	for _, n := range numbers {
+		c, err := newGPIOChip(prefix + strconv.Itoa(n))
+		if err != nil {
+			return true, err
+		}
+		// Use the same numbering as gpio sysfs when available, otherwise number
+		// the lines consecutively.
+		base := sysfsGPIOChipBase(c.name)
+		if base == -1 {
+			base = next
+		}
+		c.setBase(base)
+		if base+len(c.lines) > next {
+			next = base + len(c.lines)
+		}
+		GPIOChips = append(GPIOChips, c)
+		if err := d.registerLines(c); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+func (d *driverGPIOChip) registerLines(c *GPIOChip) error {
+	for _, l := range c.lines {
+		if _, ok := GPIOLines[l.number]; ok {
+			return fmt.Errorf("found two pins with number %d", l.number)
+		}
+		GPIOLines[l.number] = l
+		// Unregister the pin if already registered. This happens with sysfs-gpio.
+		// Do not error on it, since sysfs-gpio may have failed to load.
+		num := strconv.Itoa(l.number)
+		_ = gpioreg.Unregister(l.name)
+		_ = gpioreg.Unregister(num)
+		if err := gpioreg.Register(l, false); err != nil {
+			return err
+		}
+		// If there is a CPU memory mapped gpio pin with the same number, the
+		// driver has to unregister this pin and map its own after.
+		if err := gpioreg.RegisterAlias(num, l.name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func init() {
+	if isLinux {
+		periph.MustRegister(&driverGPIOChip{})
+	}
+}
+
+var _ gpio.PinIn = &GPIOLine{}
+var _ gpio.PinOut = &GPIOLine{}
+var _ gpio.PinIO = &GPIOLine{}
+var _ gpio.PinEdgeEvents = &GPIOLine{}
+var _ gpio.PinInContext = &GPIOLine{}
+var _ fmt.Stringer = &GPIOLine{}
+var _ fmt.Stringer = &GPIOChip{}
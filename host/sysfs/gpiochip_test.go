@@ -0,0 +1,363 @@
+// Copyright 2017 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package sysfs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"unsafe"
+
+	"periph.io/x/periph/conn/gpio"
+)
+
+func TestGPIOChip_ioctl_sizes(t *testing.T) {
+	data := []struct {
+		op   uint
+		size uintptr
+	}{
+		{ioctlGetChipInfo, unsafe.Sizeof(chipInfo{})},
+		{ioctlGetLineInfo, unsafe.Sizeof(lineInfo{})},
+		{ioctlGetLine, unsafe.Sizeof(lineRequest{})},
+		{ioctlLineSetConfig, unsafe.Sizeof(lineConfig{})},
+		{ioctlLineGetValues, unsafe.Sizeof(lineValues{})},
+		{ioctlLineSetValues, unsafe.Sizeof(lineValues{})},
+	}
+	for i, line := range data {
+		if s := uintptr(line.op>>16) & 0x3FFF; s != line.size {
+			t.Fatalf("#%d: op 0x%X encodes size %d, struct is %d", i, line.op, s, line.size)
+		}
+	}
+	if s := unsafe.Sizeof(lineEvent{}); s != 48 {
+		t.Fatal(s)
+	}
+}
+
+func TestNewGPIOChip(t *testing.T) {
+	defer reset()
+	ioctlOpen = func(path string, flag int) (ioctlCloser, error) {
+		if path != "/dev/gpiochip1" {
+			t.Fatal(path)
+		}
+		return &fakeGPIOChip{}, nil
+	}
+	c, err := newGPIOChip("/dev/gpiochip1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.setBase(10)
+	if s := c.String(); s != "gpiochip1" {
+		t.Fatal(s)
+	}
+	if s := c.Label(); s != "fake" {
+		t.Fatal(s)
+	}
+	if l := c.Lines(); len(l) != 3 {
+		t.Fatal(l)
+	}
+	l := c.Lines()[1]
+	if s := l.String(); s != "GPIO11" {
+		t.Fatal(s)
+	}
+	if s := l.Name(); s != "GPIO11" {
+		t.Fatal(s)
+	}
+	if n := l.Number(); n != 11 {
+		t.Fatal(n)
+	}
+	if s := l.LineName(); s != "LINE1" {
+		t.Fatal(s)
+	}
+	if p := l.Pull(); p != gpio.PullUp {
+		t.Fatal(p)
+	}
+	if s := l.Function(); s != "In" {
+		t.Fatal(s)
+	}
+	if s := c.Lines()[2].Function(); s != "Out" {
+		t.Fatal(s)
+	}
+}
+
+func TestNewGPIOChip_fail(t *testing.T) {
+	defer reset()
+	ioctlOpen = func(path string, flag int) (ioctlCloser, error) {
+		return &ioctlClose{}, errors.New("injected")
+	}
+	if _, err := newGPIOChip("/dev/gpiochip0"); err == nil {
+		t.Fatal("open failed")
+	}
+	ioctlOpen = func(path string, flag int) (ioctlCloser, error) {
+		return &fakeGPIOChip{fail: true}, nil
+	}
+	if _, err := newGPIOChip("/dev/gpiochip0"); err == nil {
+		t.Fatal("ioctl failed")
+	}
+}
+
+func TestGPIOLine_In(t *testing.T) {
+	defer reset()
+	l, c, f := newFakeGPIOLine(t)
+	if l.In(gpio.Pull(10), gpio.NoEdge) == nil {
+		t.Fatal("invalid pull")
+	}
+	if l.In(gpio.Float, gpio.Edge(10)) == nil {
+		t.Fatal("invalid edge")
+	}
+	if err := l.In(gpio.PullDown, gpio.NoEdge); err != nil {
+		t.Fatal(err)
+	}
+	if c.req.offsets[0] != 1 || c.req.numLines != 1 {
+		t.Fatal(c.req)
+	}
+	if s := cString(c.req.consumer[:]); s != "test" {
+		t.Fatal(s)
+	}
+	if flags := c.req.config.flags; flags != lineFlagInput|lineFlagBiasPullDown {
+		t.Fatalf("0x%x", flags)
+	}
+	if p := l.Pull(); p != gpio.PullDown {
+		t.Fatal(p)
+	}
+	if s := l.Function(); s != "In/Low" {
+		t.Fatal(s)
+	}
+	// Now that the line is requested, the configuration is updated in place.
+	if err := l.SetDebounce(5 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if f.cfg.numAttrs != 1 || f.cfg.attrs[0].attr.id != lineAttrDebounce || f.cfg.attrs[0].attr.value != 5000 {
+		t.Fatal(f.cfg)
+	}
+	if err := l.In(gpio.PullNoChange, gpio.NoEdge); err != nil {
+		t.Fatal(err)
+	}
+	if flags := f.cfg.flags; flags != lineFlagInput|lineFlagBiasPullDown {
+		t.Fatalf("0x%x", flags)
+	}
+	if err := l.In(gpio.Float, gpio.NoEdge); err != nil {
+		t.Fatal(err)
+	}
+	if flags := f.cfg.flags; flags != lineFlagInput|lineFlagBiasDisabled {
+		t.Fatalf("0x%x", flags)
+	}
+	f.values = 1
+	if v := l.Read(); v != gpio.High {
+		t.Fatal(v)
+	}
+	if l.WaitForEdge(0) {
+		t.Fatal("edge detection not enabled")
+	}
+	if _, ok := l.ReadEdge(0); ok {
+		t.Fatal("edge detection not enabled")
+	}
+	if l.WaitForEdgeContext(context.Background()) {
+		t.Fatal("edge detection not enabled")
+	}
+	if err := l.Halt(); err != nil {
+		t.Fatal(err)
+	}
+	if l.SetConsumer("other") == nil {
+		t.Fatal("line already in use")
+	}
+}
+
+func TestGPIOLine_Out(t *testing.T) {
+	defer reset()
+	l, c, f := newFakeGPIOLine(t)
+	if err := l.SetDrive(OpenDrain); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Out(gpio.High); err != nil {
+		t.Fatal(err)
+	}
+	if flags := c.req.config.flags; flags != lineFlagOutput|lineFlagOpenDrain {
+		t.Fatalf("0x%x", flags)
+	}
+	if a := c.req.config.attrs[0]; c.req.config.numAttrs != 1 || a.attr.id != lineAttrOutputValues || a.attr.value != 1 || a.mask != 1 {
+		t.Fatal(c.req.config)
+	}
+	if err := l.Out(gpio.Low); err != nil {
+		t.Fatal(err)
+	}
+	if f.values != 0 {
+		t.Fatal(f.values)
+	}
+	if err := l.Out(gpio.High); err != nil {
+		t.Fatal(err)
+	}
+	if s := l.Function(); s != "Out/High" {
+		t.Fatal(s)
+	}
+	if err := l.SetDrive(OpenSource); err != nil {
+		t.Fatal(err)
+	}
+	if flags := f.cfg.flags; flags != lineFlagOutput|lineFlagOpenSource {
+		t.Fatalf("0x%x", flags)
+	}
+	if l.SetDrive(Drive(10)) == nil {
+		t.Fatal("invalid drive")
+	}
+	f.fail = true
+	if l.Out(gpio.Low) == nil {
+		t.Fatal("ioctl failed")
+	}
+	if v := l.Read(); v != gpio.Low {
+		t.Fatal("broken line is always low")
+	}
+}
+
+func TestGPIOLine_fail(t *testing.T) {
+	defer reset()
+	l, c, _ := newFakeGPIOLine(t)
+	if l.SetConsumer("0123456789012345678901234567890123456789") == nil {
+		t.Fatal("label too long")
+	}
+	if l.SetDebounce(-1) == nil {
+		t.Fatal("invalid debounce")
+	}
+	if v := l.Read(); v != gpio.Low {
+		t.Fatal("unrequested line is always low")
+	}
+	c.fail = true
+	if l.Out(gpio.Low) == nil {
+		t.Fatal("line request failed")
+	}
+	if l.In(gpio.PullNoChange, gpio.NoEdge) == nil {
+		t.Fatal("line request failed")
+	}
+	if s := l.Function(); s != "ERR" {
+		t.Fatal(s)
+	}
+}
+
+func TestLineEvent_toEdgeEvent(t *testing.T) {
+	e := lineEvent{timestamp: 1234, id: lineEventRisingEdge}
+	if v := e.toEdgeEvent(); v.Edge != gpio.RisingEdge || v.Level != gpio.High || v.Time != 1234 {
+		t.Fatal(v)
+	}
+	e = lineEvent{timestamp: 5678, id: lineEventFallingEdge}
+	if v := e.toEdgeEvent(); v.Edge != gpio.FallingEdge || v.Level != gpio.Low || v.Time != 5678 {
+		t.Fatal(v)
+	}
+}
+
+func TestMonotonicTime(t *testing.T) {
+	a := monotonicTime()
+	b := monotonicTime()
+	if a <= 0 || b < a {
+		t.Fatal(a, b)
+	}
+}
+
+func TestDrive_String(t *testing.T) {
+	if s := OpenDrain.String(); s != "OpenDrain" {
+		t.Fatal(s)
+	}
+	if s := Drive(10).String(); s != "Drive(10)" {
+		t.Fatal(s)
+	}
+}
+
+func TestGPIOChipDriver(t *testing.T) {
+	d := driverGPIOChip{}
+	if len(d.Prerequisites()) != 0 {
+		t.Fatal("unexpected GPIO chip prerequisites")
+	}
+	if a := d.After(); len(a) != 1 || a[0] != "sysfs-gpio" {
+		t.Fatal(a)
+	}
+}
+
+//
+
+// newFakeGPIOLine returns a line on a fake chip where requests return a fake
+// line handle.
+func newFakeGPIOLine(t *testing.T) (*GPIOLine, *fakeGPIOChip, *fakeGPIOLine) {
+	c := &fakeGPIOChip{}
+	f := &fakeGPIOLine{}
+	lineOpen = func(fd int32, name string) (fileIO, error) {
+		if fd != 42 {
+			t.Fatal(fd)
+		}
+		return f, nil
+	}
+	chip := &GPIOChip{name: "gpiochip0", f: c}
+	l := &GPIOLine{chip: chip, offset: 1, number: 1, name: "GPIO1", consumer: "test", pull: gpio.PullNoChange}
+	chip.lines = []*GPIOLine{l}
+	return l, c, f
+}
+
+// ptr converts back an ioctl argument into a pointer.
+func ptr(data uintptr) unsafe.Pointer {
+	return *(*unsafe.Pointer)(unsafe.Pointer(&data))
+}
+
+// fakeGPIOChip implements ioctlCloser for a chip with 3 lines.
+type fakeGPIOChip struct {
+	fail bool
+	req  lineRequest
+}
+
+func (f *fakeGPIOChip) Close() error {
+	return nil
+}
+
+func (f *fakeGPIOChip) Ioctl(op uint, data uintptr) error {
+	if f.fail {
+		return errors.New("injected")
+	}
+	switch op {
+	case ioctlGetChipInfo:
+		i := (*chipInfo)(ptr(data))
+		copy(i.name[:], "gpiochip1")
+		copy(i.label[:], "fake")
+		i.lines = 3
+	case ioctlGetLineInfo:
+		i := (*lineInfo)(ptr(data))
+		copy(i.name[:], "LINE"+string('0'+byte(i.offset)))
+		switch i.offset {
+		case 1:
+			i.flags = lineFlagInput | lineFlagBiasPullUp
+		case 2:
+			i.flags = lineFlagOutput | lineFlagUsed
+		}
+	case ioctlGetLine:
+		r := (*lineRequest)(ptr(data))
+		f.req = *r
+		r.fd = 42
+	default:
+		return errors.New("unexpected ioctl")
+	}
+	return nil
+}
+
+// fakeGPIOLine implements fileIO for a line request.
+type fakeGPIOLine struct {
+	file
+	fail   bool
+	cfg    lineConfig
+	values uint64
+}
+
+func (f *fakeGPIOLine) Ioctl(op uint, data uintptr) error {
+	if f.fail {
+		return errors.New("injected")
+	}
+	switch op {
+	case ioctlLineSetConfig:
+		f.cfg = *(*lineConfig)(ptr(data))
+	case ioctlLineGetValues:
+		v := (*lineValues)(ptr(data))
+		v.bits = f.values & v.mask
+	case ioctlLineSetValues:
+		v := (*lineValues)(ptr(data))
+		f.values = (f.values &^ v.mask) | (v.bits & v.mask)
+	default:
+		return errors.New("unexpected ioctl")
+	}
+	return nil
+}
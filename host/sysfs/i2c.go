@@ -13,6 +13,8 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 	"unsafe"
 
 	"periph.io/x/periph"
@@ -20,6 +22,7 @@ import (
 	"periph.io/x/periph/conn/gpio/gpioreg"
 	"periph.io/x/periph/conn/i2c"
 	"periph.io/x/periph/conn/i2c/i2creg"
+	"periph.io/x/periph/conn/i2c/smbus"
 )
 
 // SetSpeedHook can be set by a driver to enable changing the I²C buses speed.
@@ -65,21 +68,30 @@ type I2C struct {
 	f         ioctlCloser
 	busNumber int
 
-	mu  sync.Mutex // In theory the kernel probably has an internal lock but not taking any chance.
-	fn  functionality
-	scl gpio.PinIO
-	sda gpio.PinIO
+	mu      sync.Mutex // In theory the kernel probably has an internal lock but not taking any chance.
+	fn      functionality
+	scl     gpio.PinIO
+	sda     gpio.PinIO
+	targets map[uint16]*I2CTargetMemory
 }
 
 // Close closes the handle to the I²C driver. It is not a requirement to close
 // before process termination.
+//
+// The targets registered with RegisterTargetMemory are closed.
 func (i *I2C) Close() error {
 	i.mu.Lock()
 	defer i.mu.Unlock()
-	if err := i.f.Close(); err != nil {
-		return fmt.Errorf("sysfs-i2c: %v", err)
+	var err error
+	for _, m := range i.targets {
+		if err2 := m.closeLocked(); err == nil {
+			err = err2
+		}
 	}
-	return nil
+	if err2 := i.f.Close(); err2 != nil {
+		return fmt.Errorf("sysfs-i2c: %v", err2)
+	}
+	return err
 }
 
 func (i *I2C) String() string {
@@ -120,11 +132,190 @@ func (i *I2C) Tx(addr uint16, w, r []byte) error {
 	i.mu.Lock()
 	defer i.mu.Unlock()
 	if err := i.f.Ioctl(ioctlRdwr, pp); err != nil {
-		return fmt.Errorf("sysfs-i2c: %v", err)
+		return wrapI2CErr(err)
+	}
+	return nil
+}
+
+// TxMsgs implements i2c.MsgBus.
+//
+// It uses the I2C_RDWR ioctl. The flags that require support from the
+// adapter are checked against its functionality.
+func (i *I2C) TxMsgs(msgs []i2c.Msg) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	if len(msgs) > i2cMaxMsgs {
+		return fmt.Errorf("sysfs-i2c: maximum %d messages per transaction, got %d", i2cMaxMsgs, len(msgs))
+	}
+	buf := make([]i2cMsg, len(msgs))
+	var need functionality
+	for j := range msgs {
+		m := &msgs[j]
+		b := &buf[j]
+		b.addr = m.Addr
+		if m.Flags&i2c.TenBit != 0 {
+			if m.Addr >= 0x400 {
+				return errors.New("sysfs-i2c: invalid address")
+			}
+			b.flags |= flagTEN
+			need |= func10BitAddr
+		} else if m.Addr >= 0x80 {
+			return errors.New("sysfs-i2c: invalid address")
+		}
+		if m.Flags&i2c.Read != 0 {
+			b.flags |= flagRD
+		}
+		if m.Flags&i2c.NoStart != 0 {
+			b.flags |= flagNOSTART
+			need |= funcNOSTART
+		}
+		if m.Flags&i2c.IgnoreNAK != 0 {
+			b.flags |= flagIgnoreNAK
+			need |= funcProtocolMangling
+		}
+		if m.Flags&i2c.RecvLen != 0 {
+			if m.Flags&i2c.Read == 0 || len(m.Buf) < 1+smbus.MaxBlockSize {
+				return fmt.Errorf("sysfs-i2c: RecvLen requires a read of at least %d bytes", 1+smbus.MaxBlockSize)
+			}
+			b.flags |= flagRecvLen
+			// The kernel expects the number of bytes to read before the count byte
+			// in the first byte.
+			m.Buf[0] = 1
+		}
+		if len(m.Buf) >= 1<<16 {
+			return fmt.Errorf("sysfs-i2c: message too long: %d bytes", len(m.Buf))
+		}
+		if b.length = uint16(len(m.Buf)); b.length != 0 {
+			b.buf = uintptr(unsafe.Pointer(&m.Buf[0]))
+		}
+	}
+	if i.fn&need != need {
+		return fmt.Errorf("sysfs-i2c: adapter doesn't support %s", need&^i.fn)
+	}
+	p := rdwrIoctlData{
+		msgs:  uintptr(unsafe.Pointer(&buf[0])),
+		nmsgs: uint32(len(buf)),
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if err := i.f.Ioctl(ioctlRdwr, uintptr(unsafe.Pointer(&p))); err != nil {
+		return wrapI2CErr(err)
+	}
+	// The kernel only copies the data back, not the length it adjusted, so the
+	// count byte is used instead.
+	for j := range msgs {
+		if msgs[j].Flags&i2c.RecvLen != 0 {
+			n := 1 + int(msgs[j].Buf[0])
+			if n > len(msgs[j].Buf) {
+				return fmt.Errorf("sysfs-i2c: device sent invalid length %d", msgs[j].Buf[0])
+			}
+			msgs[j].Buf = msgs[j].Buf[:n]
+		}
 	}
 	return nil
 }
 
+// SMBus implements smbus.Bus.
+//
+// It uses the I2C_SMBUS ioctl, which works even on adapters that lack generic
+// I²C support and can only do SMBus transactions.
+func (i *I2C) SMBus(addr uint16, t *smbus.Transfer) (int, error) {
+	if addr >= 0x80 {
+		return 0, errors.New("sysfs-i2c: invalid SMBus address")
+	}
+	p := smbusIoctlData{command: t.Cmd}
+	var fn functionality
+	// read is the direction of the transaction, reply is true when data is
+	// read back.
+	read := t.Read
+	reply := read && t.Protocol != smbus.Quick
+	switch t.Protocol {
+	case smbus.Quick:
+		p.size, fn = smbusQuick, funcSMBusQuick
+	case smbus.Byte:
+		p.size, fn = smbusByte, funcSMBusWriteByte
+		if read {
+			fn = funcSMBusReadByte
+		}
+	case smbus.ByteData:
+		p.size, fn = smbusByteData, funcSMBusWriteByteData
+		if read {
+			fn = funcSMBusReadByteData
+		}
+	case smbus.WordData:
+		p.size, fn = smbusWordData, funcSMBusWriteWordData
+		if read {
+			fn = funcSMBusReadWordData
+		}
+	case smbus.ProcCall:
+		p.size, fn, read, reply = smbusProcCall, funcSMBusProcCall, false, true
+	case smbus.BlockData:
+		p.size, fn = smbusBlockData, funcSMBusWriteBlockData
+		if read {
+			fn = funcSMBusReadBlockData
+		}
+	case smbus.BlockProcCall:
+		p.size, fn, read, reply = smbusBlockProcCall, funcSMBusBlockProcCall, false, true
+	default:
+		return 0, fmt.Errorf("sysfs-i2c: invalid SMBus protocol %s", t.Protocol)
+	}
+	if read {
+		p.readWrite = smbusRead
+	}
+	var data [smbus.MaxBlockSize + 2]byte
+	if !read {
+		switch p.size {
+		case smbusByteData, smbusWordData, smbusProcCall:
+			copy(data[:], t.W)
+		case smbusBlockData, smbusBlockProcCall:
+			if len(t.W) > smbus.MaxBlockSize {
+				return 0, errors.New("sysfs-i2c: SMBus block is too large")
+			}
+			data[0] = byte(len(t.W))
+			copy(data[1:], t.W)
+		}
+	}
+	p.data = uintptr(unsafe.Pointer(&data[0]))
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.fn&fn == 0 {
+		return 0, fmt.Errorf("sysfs-i2c: SMBus %s is not supported by the adapter; supported: %s", t.Protocol, i.fn)
+	}
+	var pec uintptr
+	if t.PEC {
+		if i.fn&funcSMBusPEC == 0 {
+			return 0, errors.New("sysfs-i2c: SMBus PEC is not supported by the adapter")
+		}
+		pec = 1
+	}
+	if err := i.f.Ioctl(ioctlSlave, uintptr(addr)); err != nil {
+		return 0, fmt.Errorf("sysfs-i2c: %v", err)
+	}
+	if err := i.f.Ioctl(ioctlPEC, pec); err != nil {
+		return 0, fmt.Errorf("sysfs-i2c: %v", err)
+	}
+	if err := i.f.Ioctl(ioctlSMBus, uintptr(unsafe.Pointer(&p))); err != nil {
+		return 0, wrapI2CErr(err)
+	}
+	if !reply {
+		return 0, nil
+	}
+	switch p.size {
+	case smbusByte, smbusByteData:
+		return copy(t.R, data[:1]), nil
+	case smbusWordData, smbusProcCall:
+		return copy(t.R, data[:2]), nil
+	default:
+		n := int(data[0])
+		if n > smbus.MaxBlockSize {
+			return 0, fmt.Errorf("sysfs-i2c: device returned a block of %d bytes", n)
+		}
+		return copy(t.R, data[1:1+n]), nil
+	}
+}
+
 // SetSpeed implements i2c.Bus.
 func (i *I2C) SetSpeed(hz int64) error {
 	if hz < 1 || hz >= 1<<32 {
@@ -138,6 +329,23 @@ func (i *I2C) SetSpeed(hz int64) error {
 	return errors.New("sysfs-i2c: not supported")
 }
 
+// SetTimeout implements i2c.BusTimeout.
+//
+// The timeout is rounded up to a multiple of 10ms, which is the unit used by
+// the kernel. Not all adapter drivers honor it.
+func (i *I2C) SetTimeout(d time.Duration) error {
+	if d <= 0 {
+		return fmt.Errorf("sysfs-i2c: invalid timeout %s", d)
+	}
+	const unit = 10 * time.Millisecond
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if err := i.f.Ioctl(ioctlTimeout, uintptr((d+unit-1)/unit)); err != nil {
+		return fmt.Errorf("sysfs-i2c: %v", err)
+	}
+	return nil
+}
+
 // SCL implements i2c.Pins.
 func (i *I2C) SCL() gpio.PinIO {
 	i.initPins()
@@ -198,11 +406,26 @@ func (i *I2C) initPins() {
 // /usr/include/linux/i2c-dev.h and /usr/include/linux/i2c.h.
 const (
 	ioctlRetries = 0x701 // TODO(maruel): Expose this
-	ioctlTimeout = 0x702 // TODO(maruel): Expose this; in units of 10ms
+	ioctlTimeout = 0x702 // In units of 10ms
 	ioctlSlave   = 0x703
 	ioctlTenBits = 0x704 // TODO(maruel): Expose this but the header says it's broken (!?)
 	ioctlFuncs   = 0x705
 	ioctlRdwr    = 0x707
+	ioctlPEC     = 0x708
+	ioctlSMBus   = 0x720
+)
+
+// SMBus transaction direction and size, as used by ioctlSMBus.
+const (
+	smbusWrite         = 0
+	smbusRead          = 1
+	smbusQuick         = 0
+	smbusByte          = 1
+	smbusByteData      = 2
+	smbusWordData      = 3
+	smbusProcCall      = 4
+	smbusBlockData     = 5
+	smbusBlockProcCall = 7
 )
 
 // flags
@@ -300,11 +523,21 @@ func (f functionality) String() string {
 	return strings.Join(out, "|")
 }
 
+// i2cMaxMsgs is the maximum number of messages in a single ioctlRdwr.
+const i2cMaxMsgs = 42
+
 type rdwrIoctlData struct {
 	msgs  uintptr // Pointer to i2cMsg
 	nmsgs uint32
 }
 
+type smbusIoctlData struct {
+	readWrite uint8
+	command   uint8
+	size      uint32
+	data      uintptr // Pointer to [34]byte
+}
+
 type i2cMsg struct {
 	addr   uint16 // Address to communicate with
 	flags  uint16 // 1 for read, see i2c.h for more details
@@ -312,6 +545,60 @@ type i2cMsg struct {
 	buf    uintptr
 }
 
+// i2cErrorKind is the kind of failure reported by the i2c-dev driver.
+type i2cErrorKind int
+
+const (
+	i2cOther i2cErrorKind = iota
+	i2cNACK
+	i2cArbitrationLost
+	i2cTimeout
+	i2cBusBusy
+)
+
+// i2cError is an error returned by a transaction.
+//
+// It implements the typed errors of package i2c.
+type i2cError struct {
+	msg  string
+	kind i2cErrorKind
+}
+
+func (e *i2cError) Error() string {
+	return e.msg
+}
+
+// NACK implements i2c.NACKError.
+func (e *i2cError) NACK() bool {
+	return e.kind == i2cNACK
+}
+
+// ArbitrationLost implements i2c.ArbitrationLostError.
+func (e *i2cError) ArbitrationLost() bool {
+	return e.kind == i2cArbitrationLost
+}
+
+// Timeout implements i2c.TimeoutError.
+func (e *i2cError) Timeout() bool {
+	return e.kind == i2cTimeout
+}
+
+// BusBusy implements i2c.BusBusyError.
+func (e *i2cError) BusBusy() bool {
+	return e.kind == i2cBusBusy
+}
+
+// wrapI2CErr converts an error returned by a transaction ioctl into an
+// i2cError, using the errno as documented at
+// https://www.kernel.org/doc/Documentation/i2c/fault-codes.
+func wrapI2CErr(err error) error {
+	e := &i2cError{msg: "sysfs-i2c: " + err.Error()}
+	if errno, ok := err.(syscall.Errno); ok {
+		e.kind = i2cErrnos[errno]
+	}
+	return e
+}
+
 var (
 	i2cMu    sync.Mutex
 	setSpeed func(hz int64) error
@@ -382,4 +669,11 @@ func init() {
 
 var _ i2c.Bus = &I2C{}
 var _ i2c.BusCloser = &I2C{}
+var _ i2c.MsgBus = &I2C{}
+var _ i2c.BusTimeout = &I2C{}
+var _ smbus.Bus = &I2C{}
+var _ i2c.NACKError = &i2cError{}
+var _ i2c.ArbitrationLostError = &i2cError{}
+var _ i2c.TimeoutError = &i2cError{}
+var _ i2c.BusBusyError = &i2cError{}
 var _ fmt.Stringer = &I2C{}
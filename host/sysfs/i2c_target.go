@@ -0,0 +1,187 @@
+// Copyright 2018 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package sysfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// RegisterTargetMemory instantiates the linux i2c-slave-eeprom backend at addr
+// and returns the memory it exposes to the master on the bus.
+//
+// It requires a kernel built with CONFIG_I2C_SLAVE_EEPROM and an adapter driver
+// supporting the slave mode. See
+// https://www.kernel.org/doc/Documentation/i2c/slave-interface.
+//
+// The backend cannot implement i2c.TargetBus: the kernel answers the
+// transactions on its own and userland only has access to the resulting
+// content of the memory, not to the individual transactions.
+//
+// Only 7 bits addresses are supported.
+func (i *I2C) RegisterTargetMemory(addr uint16) (*I2CTargetMemory, error) {
+	if addr >= 0x80 {
+		return nil, errors.New("sysfs-i2c: invalid target address")
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if _, ok := i.targets[addr]; ok {
+		return nil, fmt.Errorf("sysfs-i2c: target 0x%02x is already registered", addr)
+	}
+	s := i2cSlaveAddr(addr)
+	if err := i.writeSysfs("new_device", fmt.Sprintf("slave-24c02 0x%04x\n", s)); err != nil {
+		return nil, err
+	}
+	f, err := fileIOOpen(fmt.Sprintf("/sys/bus/i2c/devices/%d-%04x/slave-eeprom", i.busNumber, s), os.O_RDWR)
+	if err != nil {
+		_ = i.writeSysfs("delete_device", fmt.Sprintf("0x%04x\n", s))
+		return nil, fmt.Errorf("sysfs-i2c: %v", err)
+	}
+	if i.targets == nil {
+		i.targets = map[uint16]*I2CTargetMemory{}
+	}
+	m := &I2CTargetMemory{i: i, addr: addr, f: f}
+	i.targets[addr] = m
+	return m, nil
+}
+
+// I2CTargetMemory is the memory of a target emulated by the linux
+// i2c-slave-eeprom backend.
+//
+// The target behaves as a 24c02 EEPROM: the master writes an offset followed by
+// data, and reads the memory from the current offset. The memory is 256 bytes.
+//
+// ReadAt returns the content of the memory, including what the master wrote.
+// WriteAt sets what the master reads. Since only the content is visible, a
+// write of the value already in memory cannot be detected.
+type I2CTargetMemory struct {
+	// Immutable.
+	i    *I2C
+	addr uint16
+
+	mu sync.Mutex
+	f  fileIO
+}
+
+func (m *I2CTargetMemory) String() string {
+	return fmt.Sprintf("%s target 0x%02x", m.i, m.addr)
+}
+
+// ReadAt implements io.ReaderAt.
+func (m *I2CTargetMemory) ReadAt(b []byte, off int64) (int, error) {
+	l, err := i2cTargetRange(len(b), off)
+	if l == 0 {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.f == nil {
+		return 0, errors.New("sysfs-i2c: target is closed")
+	}
+	if _, err := m.f.Seek(off, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("sysfs-i2c: %v", err)
+	}
+	n, err2 := io.ReadFull(m.f, b[:l])
+	if err2 != nil {
+		return n, fmt.Errorf("sysfs-i2c: %v", err2)
+	}
+	return n, err
+}
+
+// WriteAt implements io.WriterAt.
+func (m *I2CTargetMemory) WriteAt(b []byte, off int64) (int, error) {
+	l, err := i2cTargetRange(len(b), off)
+	if l == 0 {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.f == nil {
+		return 0, errors.New("sysfs-i2c: target is closed")
+	}
+	if _, err := m.f.Seek(off, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("sysfs-i2c: %v", err)
+	}
+	n, err2 := m.f.Write(b[:l])
+	if err2 != nil {
+		return n, fmt.Errorf("sysfs-i2c: %v", err2)
+	}
+	return n, err
+}
+
+// Close stops responding at the address and removes the backend.
+func (m *I2CTargetMemory) Close() error {
+	m.i.mu.Lock()
+	defer m.i.mu.Unlock()
+	return m.closeLocked()
+}
+
+//
+
+// i2cTargetSize is the size of the memory of the slave-eeprom backend.
+const i2cTargetSize = 256
+
+// i2cSlaveAddr returns the address as expected by new_device to instantiate
+// a slave backend, which is I2C_OWN_SLAVE_ADDRESS in linux/i2c.h.
+func i2cSlaveAddr(addr uint16) uint16 {
+	return 0x1000 | addr
+}
+
+// i2cTargetRange returns the number of bytes of a n bytes access at off that
+// are within the memory, and the error to return for the remaining ones.
+func i2cTargetRange(n int, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("sysfs-i2c: negative offset")
+	}
+	if off >= i2cTargetSize {
+		return 0, io.EOF
+	}
+	if max := i2cTargetSize - int(off); n > max {
+		return max, io.EOF
+	}
+	return n, nil
+}
+
+// closeLocked removes the backend.
+//
+// i.mu must be held.
+func (m *I2CTargetMemory) closeLocked() error {
+	if m.i.targets[m.addr] != m {
+		return fmt.Errorf("sysfs-i2c: target 0x%02x is not registered", m.addr)
+	}
+	delete(m.i.targets, m.addr)
+	m.mu.Lock()
+	err := m.f.Close()
+	m.f = nil
+	m.mu.Unlock()
+	if err != nil {
+		err = fmt.Errorf("sysfs-i2c: %v", err)
+	}
+	if err2 := m.i.writeSysfs("delete_device", fmt.Sprintf("0x%04x\n", i2cSlaveAddr(m.addr))); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// writeSysfs writes s to the file name of the bus adapter directory.
+func (i *I2C) writeSysfs(name, s string) error {
+	f, err := fileIOOpen(fmt.Sprintf("/sys/bus/i2c/devices/i2c-%d/%s", i.busNumber, name), os.O_WRONLY)
+	if err != nil {
+		return fmt.Errorf("sysfs-i2c: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.Write([]byte(s)); err != nil {
+		return fmt.Errorf("sysfs-i2c: %v", err)
+	}
+	return nil
+}
+
+var _ io.ReaderAt = &I2CTargetMemory{}
+var _ io.WriterAt = &I2CTargetMemory{}
+var _ io.Closer = &I2CTargetMemory{}
+var _ fmt.Stringer = &I2CTargetMemory{}
@@ -0,0 +1,180 @@
+// Copyright 2018 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package sysfs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+)
+
+func TestI2C_RegisterTargetMemory(t *testing.T) {
+	defer reset()
+	mem := &fakeEEPROM{}
+	var cmds []string
+	fileIOOpen = func(path string, flag int) (fileIO, error) {
+		switch path {
+		case "/sys/bus/i2c/devices/i2c-24/new_device", "/sys/bus/i2c/devices/i2c-24/delete_device":
+			return &fakeSysfsCmd{cmds: &cmds}, nil
+		case "/sys/bus/i2c/devices/24-1042/slave-eeprom", "/sys/bus/i2c/devices/24-1043/slave-eeprom":
+			return mem, nil
+		default:
+			t.Fatalf("unknown %q", path)
+			return nil, errors.New("unknown file")
+		}
+	}
+	bus := I2C{f: &ioctlClose{}, busNumber: 24}
+	if _, err := bus.RegisterTargetMemory(0x80); err == nil {
+		t.Fatal("invalid address")
+	}
+	m, err := bus.RegisterTargetMemory(0x42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bus.RegisterTargetMemory(0x42); err == nil {
+		t.Fatal("already registered")
+	}
+	if s := m.String(); s != "I2C24 target 0x42" {
+		t.Fatal(s)
+	}
+
+	// The data read by the master.
+	if n, err := m.WriteAt([]byte{1, 2}, 3); n != 2 || err != nil {
+		t.Fatal(n, err)
+	}
+	if b := mem.get(); !bytes.Equal(b[:6], []byte{0, 0, 0, 1, 2, 0}) {
+		t.Fatal(b[:6])
+	}
+	if n, err := m.WriteAt([]byte{3, 4}, 255); n != 1 || err != io.EOF {
+		t.Fatal(n, err)
+	}
+	if b := mem.get(); b[255] != 3 {
+		t.Fatal(b[255])
+	}
+
+	// The data written by the master.
+	mem.set(4, []byte{5, 6})
+	b := make([]byte, 3)
+	if n, err := m.ReadAt(b, 3); n != 3 || err != nil || !bytes.Equal(b, []byte{1, 5, 6}) {
+		t.Fatal(n, err, b)
+	}
+	if n, err := m.ReadAt(b, 254); n != 2 || err != io.EOF || !bytes.Equal(b[:2], []byte{0, 3}) {
+		t.Fatal(n, err, b)
+	}
+	if n, err := m.ReadAt(b, 256); n != 0 || err != io.EOF {
+		t.Fatal(n, err)
+	}
+	if _, err := m.ReadAt(b, -1); err == nil {
+		t.Fatal("negative offset")
+	}
+
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if m.Close() == nil {
+		t.Fatal("already closed")
+	}
+	if _, err := m.ReadAt(b, 0); err == nil {
+		t.Fatal("closed")
+	}
+	if _, err := m.WriteAt(b, 0); err == nil {
+		t.Fatal("closed")
+	}
+
+	// Closing the bus closes the targets.
+	if _, err := bus.RegisterTargetMemory(0x43); err != nil {
+		t.Fatal(err)
+	}
+	if err := bus.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(bus.targets) != 0 {
+		t.Fatal(bus.targets)
+	}
+	expected := []string{"slave-24c02 0x1042\n", "0x1042\n", "slave-24c02 0x1043\n", "0x1043\n"}
+	if len(cmds) != len(expected) {
+		t.Fatal(cmds)
+	}
+	for i := range expected {
+		if cmds[i] != expected[i] {
+			t.Fatal(cmds)
+		}
+	}
+}
+
+func TestI2C_RegisterTargetMemory_fail(t *testing.T) {
+	defer reset()
+	fileIOOpen = func(path string, flag int) (fileIO, error) {
+		return nil, errors.New("no slave support")
+	}
+	bus := I2C{f: &ioctlClose{}, busNumber: 24}
+	if _, err := bus.RegisterTargetMemory(0x42); err == nil {
+		t.Fatal("no slave support")
+	}
+	if len(bus.targets) != 0 {
+		t.Fatal(bus.targets)
+	}
+}
+
+//
+
+// fakeSysfsCmd records the commands written to a sysfs file.
+type fakeSysfsCmd struct {
+	file
+	cmds *[]string
+}
+
+func (f *fakeSysfsCmd) Write(b []byte) (int, error) {
+	*f.cmds = append(*f.cmds, string(b))
+	return len(b), nil
+}
+
+// fakeEEPROM emulates the slave-eeprom file.
+type fakeEEPROM struct {
+	file
+	mu  sync.Mutex
+	mem [256]byte
+	off int
+}
+
+func (f *fakeEEPROM) Seek(offset int64, whence int) (int64, error) {
+	if whence != io.SeekStart {
+		return 0, errors.New("not implemented")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.off = int(offset)
+	return offset, nil
+}
+
+func (f *fakeEEPROM) Read(b []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := copy(b, f.mem[f.off:])
+	f.off += n
+	return n, nil
+}
+
+func (f *fakeEEPROM) Write(b []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := copy(f.mem[f.off:], b)
+	f.off += n
+	return n, nil
+}
+
+func (f *fakeEEPROM) get() [256]byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.mem
+}
+
+func (f *fakeEEPROM) set(off int, b []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	copy(f.mem[off:], b)
+}
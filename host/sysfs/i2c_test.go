@@ -5,9 +5,14 @@
 package sysfs
 
 import (
+	"bytes"
+	"errors"
 	"testing"
+	"time"
 
+	"periph.io/x/periph/conn/i2c"
 	"periph.io/x/periph/conn/i2c/i2creg"
+	"periph.io/x/periph/conn/i2c/smbus"
 )
 
 func TestNewI2C(t *testing.T) {
@@ -50,6 +55,175 @@ func TestI2C_faked(t *testing.T) {
 	}
 }
 
+func TestI2C_TxMsgs(t *testing.T) {
+	f := &fakeRdwr{}
+	bus := I2C{f: f, busNumber: 24, fn: funcI2C}
+	if err := bus.TxMsgs(nil); err != nil {
+		t.Fatal(err)
+	}
+	r := make([]byte, 2)
+	msgs := []i2c.Msg{
+		{Addr: 0x10, Buf: []byte{1}},
+		{Addr: 0x10, Flags: i2c.Read, Buf: r},
+		{Addr: 0x11, Flags: i2c.Read},
+	}
+	if err := bus.TxMsgs(msgs); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.msgs) != 3 || f.msgs[0].addr != 0x10 || f.msgs[0].flags != 0 || f.msgs[0].length != 1 || f.msgs[1].flags != flagRD || f.msgs[1].length != 2 || f.msgs[2].addr != 0x11 || f.msgs[2].buf != 0 {
+		t.Fatal(f.msgs)
+	}
+
+	// Flags requiring adapter support.
+	for _, flags := range []i2c.MsgFlags{i2c.TenBit, i2c.NoStart, i2c.IgnoreNAK} {
+		if err := bus.TxMsgs([]i2c.Msg{{Addr: 0x10, Flags: flags}}); err == nil {
+			t.Fatal(flags)
+		}
+	}
+	bus.fn |= func10BitAddr | funcNOSTART | funcProtocolMangling
+	if err := bus.TxMsgs([]i2c.Msg{{Addr: 0x3FF, Flags: i2c.TenBit | i2c.IgnoreNAK}, {Addr: 0x10, Flags: i2c.NoStart}}); err != nil {
+		t.Fatal(err)
+	}
+	if f.msgs[0].flags != flagTEN|flagIgnoreNAK || f.msgs[1].flags != flagNOSTART {
+		t.Fatal(f.msgs)
+	}
+
+	// RecvLen.
+	msgs = []i2c.Msg{{Addr: 0x10, Flags: i2c.Read | i2c.RecvLen, Buf: make([]byte, 33)}}
+	f.recvLen = 3
+	if err := bus.TxMsgs(msgs); err != nil {
+		t.Fatal(err)
+	}
+	if f.msgs[0].flags != flagRD|flagRecvLen || !bytes.Equal(msgs[0].Buf, []byte{3, 1, 2, 3}) {
+		t.Fatal(f.msgs, msgs)
+	}
+	f.recvLen = 33
+	if err := bus.TxMsgs([]i2c.Msg{{Addr: 0x10, Flags: i2c.Read | i2c.RecvLen, Buf: make([]byte, 33)}}); err == nil {
+		t.Fatal("invalid length")
+	}
+	if err := bus.TxMsgs([]i2c.Msg{{Addr: 0x10, Flags: i2c.Read | i2c.RecvLen, Buf: make([]byte, 32)}}); err == nil {
+		t.Fatal("buffer too short")
+	}
+
+	// Invalid.
+	if err := bus.TxMsgs([]i2c.Msg{{Addr: 0x80}}); err == nil {
+		t.Fatal("invalid address")
+	}
+	if err := bus.TxMsgs([]i2c.Msg{{Addr: 0x400, Flags: i2c.TenBit}}); err == nil {
+		t.Fatal("invalid address")
+	}
+	if err := bus.TxMsgs([]i2c.Msg{{Addr: 0x10, Buf: make([]byte, 1<<16)}}); err == nil {
+		t.Fatal("message too long")
+	}
+	if err := bus.TxMsgs(make([]i2c.Msg, 43)); err == nil {
+		t.Fatal("too many messages")
+	}
+	f.err = errors.New("injected")
+	if err := bus.TxMsgs([]i2c.Msg{{Addr: 0x10}}); err == nil {
+		t.Fatal("ioctl failure")
+	}
+}
+
+func TestI2C_SetTimeout(t *testing.T) {
+	f := &ioctlRecord{}
+	bus := I2C{f: f, busNumber: 24}
+	if bus.SetTimeout(0) == nil {
+		t.Fatal("invalid timeout")
+	}
+	if err := bus.SetTimeout(25 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if f.op != ioctlTimeout || f.data != 3 {
+		t.Fatal(f.op, f.data)
+	}
+	f.err = errors.New("injected")
+	if bus.SetTimeout(time.Second) == nil {
+		t.Fatal("ioctl failure")
+	}
+}
+
+func TestI2C_errors(t *testing.T) {
+	f := &fakeRdwr{}
+	bus := I2C{f: f, busNumber: 24, fn: funcI2C}
+	for errno, kind := range i2cErrnos {
+		f.err = errno
+		err := bus.Tx(0x10, []byte{1}, nil)
+		if err == nil {
+			t.Fatal(errno)
+		}
+		if e, ok := err.(i2c.NACKError); !ok || e.NACK() != (kind == i2cNACK) {
+			t.Fatal(errno, err)
+		}
+		if e, ok := err.(i2c.ArbitrationLostError); !ok || e.ArbitrationLost() != (kind == i2cArbitrationLost) {
+			t.Fatal(errno, err)
+		}
+		if e, ok := err.(i2c.TimeoutError); !ok || e.Timeout() != (kind == i2cTimeout) {
+			t.Fatal(errno, err)
+		}
+		if e, ok := err.(i2c.BusBusyError); !ok || e.BusBusy() != (kind == i2cBusBusy) {
+			t.Fatal(errno, err)
+		}
+	}
+	f.err = errors.New("injected")
+	err := bus.TxMsgs([]i2c.Msg{{Addr: 0x10}})
+	if s := err.Error(); s != "sysfs-i2c: injected" {
+		t.Fatal(s)
+	}
+	if e := err.(i2c.NACKError); e.NACK() {
+		t.Fatal("unexpected NACK")
+	}
+}
+
+func TestI2C_SMBus(t *testing.T) {
+	f := &fakeSMBus{}
+	bus := I2C{f: f, busNumber: 24, fn: funcSMBusQuick | funcSMBusReadWordData | funcSMBusWriteBlockData | funcSMBusBlockProcCall}
+	d := smbus.Dev{Bus: &bus, Addr: 0x10}
+	if err := d.QuickCommand(true); err != nil {
+		t.Fatal(err)
+	}
+	if f.addr != 0x10 || f.pec != 0 || f.p.size != smbusQuick || f.p.readWrite != smbusRead {
+		t.Fatal(f)
+	}
+	f.reply = []byte{0x34, 0x12}
+	if v, err := d.ReadWordData(2); err != nil || v != 0x1234 {
+		t.Fatal(v, err)
+	}
+	if f.p.size != smbusWordData || f.p.command != 2 {
+		t.Fatal(f.p)
+	}
+	if err := d.WriteBlock(3, []byte{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if f.p.size != smbusBlockData || f.p.readWrite != smbusWrite || !bytes.Equal(f.data[:3], []byte{2, 1, 2}) {
+		t.Fatal(f.p, f.data)
+	}
+	f.reply = []byte{1, 7}
+	if b, err := d.BlockProcessCall(4, []byte{9}); err != nil || !bytes.Equal(b, []byte{7}) {
+		t.Fatal(b, err)
+	}
+	f.reply = []byte{33}
+	if _, err := d.BlockProcessCall(4, []byte{9}); err == nil {
+		t.Fatal("invalid block size")
+	}
+	if _, err := d.ReadByteData(1); err == nil {
+		t.Fatal("unsupported protocol")
+	}
+	d.PEC = true
+	if err := d.QuickCommand(false); err == nil {
+		t.Fatal("unsupported PEC")
+	}
+	bus.fn |= funcSMBusPEC
+	if err := d.QuickCommand(false); err != nil {
+		t.Fatal(err)
+	}
+	if f.pec != 1 || f.p.readWrite != smbusWrite {
+		t.Fatal(f)
+	}
+	if _, err := bus.SMBus(0x80, &smbus.Transfer{}); err == nil {
+		t.Fatal("invalid address")
+	}
+}
+
 func TestI2C_functionality(t *testing.T) {
 	expected := "I2C|10BIT_ADDR|PROTOCOL_MANGLING|SMBUS_PEC|NOSTART|SMBUS_BLOCK_PROC_CALL|SMBUS_QUICK|SMBUS_READ_BYTE|SMBUS_WRITE_BYTE|SMBUS_READ_BYTE_DATA|SMBUS_WRITE_BYTE_DATA|SMBUS_READ_WORD_DATA|SMBUS_WRITE_WORD_DATA|SMBUS_PROC_CALL|SMBUS_READ_BLOCK_DATA|SMBUS_WRITE_BLOCK_DATA|SMBUS_READ_I2C_BLOCK|SMBUS_WRITE_I2C_BLOCK"
 	if s := functionality(0xFFFFFFFF).String(); s != expected {
@@ -100,3 +274,77 @@ func TestDriver_Init(t *testing.T) {
 		t.Fatal("second SetSpeedHook must fail")
 	}
 }
+
+//
+
+// fakeSMBus implements ioctlCloser for SMBus transactions.
+type fakeSMBus struct {
+	ioctlClose
+	addr  uintptr
+	pec   uintptr
+	p     smbusIoctlData
+	data  [34]byte
+	reply []byte
+}
+
+func (f *fakeSMBus) Ioctl(op uint, data uintptr) error {
+	switch op {
+	case ioctlSlave:
+		f.addr = data
+	case ioctlPEC:
+		f.pec = data
+	case ioctlSMBus:
+		f.p = *(*smbusIoctlData)(ptr(data))
+		b := (*[34]byte)(ptr(f.p.data))
+		f.data = *b
+		copy(b[:], f.reply)
+	default:
+		return errors.New("unexpected ioctl")
+	}
+	return nil
+}
+
+// ioctlRecord implements ioctlCloser and records the last ioctl.
+type ioctlRecord struct {
+	ioctlClose
+	op   uint
+	data uintptr
+	err  error
+}
+
+func (i *ioctlRecord) Ioctl(op uint, data uintptr) error {
+	i.op = op
+	i.data = data
+	return i.err
+}
+
+// fakeRdwr implements ioctlCloser for I2C_RDWR transactions.
+type fakeRdwr struct {
+	ioctlClose
+	err     error
+	msgs    []i2cMsg
+	recvLen uint16
+}
+
+func (f *fakeRdwr) Ioctl(op uint, data uintptr) error {
+	if f.err != nil {
+		return f.err
+	}
+	if op != ioctlRdwr {
+		return errors.New("unexpected ioctl")
+	}
+	p := (*rdwrIoctlData)(ptr(data))
+	msgs := (*[i2cMaxMsgs]i2cMsg)(ptr(p.msgs))[:p.nmsgs:p.nmsgs]
+	f.msgs = append([]i2cMsg{}, msgs...)
+	// Like i2cdev, only the data is copied back, not the adjusted length.
+	for j := range msgs {
+		if msgs[j].flags&flagRecvLen != 0 {
+			b := (*[1 << 16]byte)(ptr(msgs[j].buf))[:msgs[j].length:msgs[j].length]
+			b[0] = byte(f.recvLen)
+			for x := 1; x < len(b) && x <= int(f.recvLen); x++ {
+				b[x] = byte(x)
+			}
+		}
+	}
+	return nil
+}
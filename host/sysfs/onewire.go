@@ -0,0 +1,481 @@
+// Copyright 2018 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package sysfs
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"periph.io/x/periph"
+	"periph.io/x/periph/conn/onewire"
+	"periph.io/x/periph/conn/onewire/onewirereg"
+)
+
+// NewOneWire opens the 1-wire bus master managed by the kernel w1 subsystem,
+// exposed as /sys/bus/w1/devices/w1_bus_master<busNumber>.
+//
+// The bus master is typically created with dtoverlay=w1-gpio on a Raspberry
+// Pi.
+func NewOneWire(busNumber int) (*OneWire, error) {
+	if isLinux {
+		return newOneWire(busNumber)
+	}
+	return nil, errors.New("sysfs-onewire: is not supported on this platform")
+}
+
+// OneWire is a 1-wire bus master managed by the kernel w1 subsystem.
+//
+// Search returns the devices already discovered by the kernel. Tx is sent to
+// the kernel via the w1 netlink connector, which usually requires root.
+//
+// The kernel doesn't expose the strong pull-up via netlink, so it is never
+// enabled; parasitically powered devices are not supported.
+//
+// The w1 netlink interface has no way to switch the bus master to overdrive
+// speed, so OneWire doesn't implement onewire.BusOverdrive. Use
+// bitbang.OneWire or a DS248x for overdrive devices.
+type OneWire struct {
+	number int
+	root   string // "/sys/bus/w1/devices/w1_bus_masterN/"
+
+	mu sync.Mutex
+	c  w1Conn // Opened on first use.
+}
+
+// Close closes the handle to the w1 netlink connector.
+func (o *OneWire) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.c == nil {
+		return nil
+	}
+	err := o.c.Close()
+	o.c = nil
+	return err
+}
+
+func (o *OneWire) String() string {
+	return "w1_bus_master" + strconv.Itoa(o.number)
+}
+
+// Tx implements onewire.Bus.
+//
+// It resets the bus, writes w and then reads len(r) bytes. power is ignored.
+func (o *OneWire) Tx(w, r []byte, power onewire.Pullup) error {
+	cmds := []w1Cmd{{cmd: w1CmdReset}}
+	if len(w) != 0 {
+		cmds = append(cmds, w1Cmd{cmd: w1CmdWrite, data: w})
+	}
+	if len(r) != 0 {
+		cmds = append(cmds, w1Cmd{cmd: w1CmdRead, data: make([]byte, len(r))})
+	}
+	replies, err := o.run(cmds)
+	if err != nil {
+		return err
+	}
+	if len(r) != 0 {
+		for _, c := range replies {
+			if c.cmd == w1CmdRead {
+				if len(c.data) != len(r) {
+					return fmt.Errorf("sysfs-onewire: read %d bytes instead of %d", len(c.data), len(r))
+				}
+				copy(r, c.data)
+				return nil
+			}
+		}
+		return errors.New("sysfs-onewire: no data read")
+	}
+	return nil
+}
+
+// Search implements onewire.Bus.
+//
+// When alarmOnly is false, it returns the slaves already discovered by the
+// kernel. Otherwise an alarm search is sent to the kernel via netlink.
+func (o *OneWire) Search(alarmOnly bool) ([]onewire.Address, error) {
+	if !alarmOnly {
+		b, err := ioutil.ReadFile(o.root + "w1_master_slaves")
+		if err != nil {
+			return nil, fmt.Errorf("sysfs-onewire: %v", err)
+		}
+		return parseSlaves(string(b))
+	}
+	replies, err := o.run([]w1Cmd{{cmd: w1CmdAlarmSearch}})
+	if err != nil {
+		return nil, err
+	}
+	var out []onewire.Address
+	for _, c := range replies {
+		if c.cmd != w1CmdAlarmSearch {
+			continue
+		}
+		for i := 0; i+8 <= len(c.data); i += 8 {
+			out = append(out, onewire.Address(binary.LittleEndian.Uint64(c.data[i:])))
+		}
+	}
+	return out, nil
+}
+
+//
+
+var w1Root = "/sys/bus/w1/devices/"
+
+// w1Dial opens a connection to the w1 netlink connector.
+var w1Dial = w1DialDefault
+
+func newOneWire(busNumber int) (*OneWire, error) {
+	o := &OneWire{number: busNumber, root: fmt.Sprintf("%sw1_bus_master%d/", w1Root, busNumber)}
+	if _, err := os.Stat(o.root); err != nil {
+		return nil, fmt.Errorf("sysfs-onewire: %v", err)
+	}
+	return o, nil
+}
+
+// run sends the commands to the bus master and returns the replies carrying
+// data.
+func (o *OneWire) run(cmds []w1Cmd) ([]w1Cmd, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.c == nil {
+		c, err := w1Dial()
+		if err != nil {
+			return nil, fmt.Errorf("sysfs-onewire: %v", err)
+		}
+		o.c = c
+	}
+	replies, err := o.c.send(newW1Msg(uint32(o.number), cmds), len(cmds))
+	if err != nil {
+		return nil, fmt.Errorf("sysfs-onewire: %v", err)
+	}
+	var out []w1Cmd
+	for _, m := range replies {
+		if m.status != 0 {
+			if m.noCmd {
+				return nil, fmt.Errorf("sysfs-onewire: %s: %v", o, syscall.Errno(m.status))
+			}
+			return nil, &w1Error{cmd: m.cmd.cmd, errno: syscall.Errno(m.status)}
+		}
+		if len(m.cmd.data) != 0 {
+			out = append(out, m.cmd)
+		}
+	}
+	return out, nil
+}
+
+// parseSlaves parses the content of w1_master_slaves.
+//
+// Each line is the family code and the 48 bits serial number in hex, e.g.
+// "28-0316a1e5f2ff". The CRC is not included so it is calculated.
+func parseSlaves(s string) ([]onewire.Address, error) {
+	var out []onewire.Address
+	for _, l := range strings.Split(s, "\n") {
+		if l == "" || l == "not found." {
+			continue
+		}
+		if len(l) != 15 || l[2] != '-' {
+			return out, fmt.Errorf("sysfs-onewire: invalid slave %q", l)
+		}
+		family, err := strconv.ParseUint(l[:2], 16, 8)
+		if err != nil {
+			return out, fmt.Errorf("sysfs-onewire: invalid slave %q", l)
+		}
+		id, err := strconv.ParseUint(l[3:], 16, 48)
+		if err != nil {
+			return out, fmt.Errorf("sysfs-onewire: invalid slave %q", l)
+		}
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], family|id<<8)
+		b[7] = onewire.CalcCRC(b[:7])
+		out = append(out, onewire.Address(binary.LittleEndian.Uint64(b[:])))
+	}
+	return out, nil
+}
+
+// w1 netlink commands, from include/uapi/linux/w1_netlink.h.
+const (
+	w1MsgMasterCmd = 4
+
+	w1CmdRead        = 0
+	w1CmdWrite       = 1
+	w1CmdAlarmSearch = 3
+	w1CmdReset       = 5
+)
+
+// w1Cmd is a struct w1_netlink_cmd.
+type w1Cmd struct {
+	cmd  byte
+	data []byte
+}
+
+// w1Reply is a struct w1_netlink_msg sent by the kernel, with the command it
+// relates to, if any.
+type w1Reply struct {
+	status byte
+	cmd    w1Cmd
+	noCmd  bool // Status of the message itself.
+}
+
+// isStatus returns true if the reply is the status of a command, as opposed
+// to the data it returned.
+func (r *w1Reply) isStatus() bool {
+	return len(r.cmd.data) == 0
+}
+
+// w1Conn is a connection to the w1 netlink connector.
+type w1Conn interface {
+	io.Closer
+	// send sends the struct w1_netlink_msg and returns the replies up to the
+	// status of the last command.
+	send(msg []byte, ncmds int) ([]w1Reply, error)
+}
+
+// newW1Msg returns a struct w1_netlink_msg of type W1_MASTER_CMD.
+func newW1Msg(id uint32, cmds []w1Cmd) []byte {
+	l := 0
+	for _, c := range cmds {
+		l += 4 + len(c.data)
+	}
+	b := make([]byte, 12, 12+l)
+	b[0] = w1MsgMasterCmd
+	binary.LittleEndian.PutUint16(b[2:], uint16(l))
+	binary.LittleEndian.PutUint32(b[4:], id)
+	for _, c := range cmds {
+		b = append(b, c.cmd, 0, byte(len(c.data)), byte(len(c.data)>>8))
+		b = append(b, c.data...)
+	}
+	return b
+}
+
+// parseW1Msgs parses the struct w1_netlink_msg in the data of a struct
+// cn_msg.
+func parseW1Msgs(b []byte) ([]w1Reply, error) {
+	var out []w1Reply
+	for len(b) != 0 {
+		if len(b) < 12 {
+			return out, errors.New("short w1 message")
+		}
+		status := b[1]
+		l := int(binary.LittleEndian.Uint16(b[2:]))
+		if len(b) < 12+l {
+			return out, errors.New("short w1 message")
+		}
+		data := b[12 : 12+l]
+		b = b[12+l:]
+		if len(data) == 0 {
+			// Status of the message itself, e.g. the bus master doesn't exist.
+			out = append(out, w1Reply{status: status, noCmd: true})
+			continue
+		}
+		for len(data) != 0 {
+			if len(data) < 4 {
+				return out, errors.New("short w1 command")
+			}
+			cl := int(binary.LittleEndian.Uint16(data[2:]))
+			if len(data) < 4+cl {
+				return out, errors.New("short w1 command")
+			}
+			// The data is copied, since the receive buffer is reused for the next
+			// datagram.
+			d := append([]byte(nil), data[4:4+cl]...)
+			out = append(out, w1Reply{status: status, cmd: w1Cmd{cmd: data[0], data: d}})
+			data = data[4+cl:]
+		}
+	}
+	return out, nil
+}
+
+// recvW1Replies collects the replies to the request seq until the kernel
+// reported the status of every command or an error.
+//
+// recv receives one datagram; a reply may span multiple datagrams.
+func recvW1Replies(seq uint32, ncmds int, recv func(b []byte) (int, error)) ([]w1Reply, error) {
+	var out []w1Reply
+	buf := make([]byte, 65536)
+	statuses := 0
+	for statuses < ncmds {
+		n, err := recv(buf)
+		if err != nil {
+			return out, err
+		}
+		replies, err := parseCnMsgs(buf[:n], seq)
+		out = append(out, replies...)
+		if err != nil {
+			return out, err
+		}
+		for i := range replies {
+			if replies[i].isStatus() {
+				// The kernel stops processing on the first error.
+				if replies[i].status != 0 || replies[i].noCmd {
+					return out, nil
+				}
+				statuses++
+			}
+		}
+	}
+	return out, nil
+}
+
+// Netlink connector constants, from include/uapi/linux/connector.h.
+const (
+	cnW1Idx = 3
+	cnW1Val = 1
+
+	nlmsgDone = 3
+)
+
+// newCnMsg returns a netlink message containing a struct cn_msg for the w1
+// connector, requesting an acknowledgement.
+func newCnMsg(seq uint32, data []byte) []byte {
+	b := make([]byte, 16+20+len(data))
+	// struct nlmsghdr
+	binary.LittleEndian.PutUint32(b[0:], uint32(len(b)))
+	binary.LittleEndian.PutUint16(b[4:], nlmsgDone)
+	binary.LittleEndian.PutUint32(b[8:], seq)
+	// struct cn_msg
+	binary.LittleEndian.PutUint32(b[16:], cnW1Idx)
+	binary.LittleEndian.PutUint32(b[20:], cnW1Val)
+	binary.LittleEndian.PutUint32(b[24:], seq)
+	binary.LittleEndian.PutUint32(b[28:], seq+1)
+	binary.LittleEndian.PutUint16(b[32:], uint16(len(data)))
+	copy(b[36:], data)
+	return b
+}
+
+// parseCnMsgs parses the netlink messages received from the w1 connector and
+// returns the w1 replies to the request seq.
+func parseCnMsgs(b []byte, seq uint32) ([]w1Reply, error) {
+	var out []w1Reply
+	for len(b) >= 16 {
+		l := int(binary.LittleEndian.Uint32(b))
+		if l < 16 || l > len(b) {
+			return out, errors.New("invalid netlink message")
+		}
+		payload := b[16:l]
+		// Messages are aligned on 4 bytes.
+		if l = (l + 3) &^ 3; l > len(b) {
+			l = len(b)
+		}
+		b = b[l:]
+		// A netlink message may contain multiple struct cn_msg.
+		for len(payload) >= 20 {
+			idx := binary.LittleEndian.Uint32(payload)
+			s := binary.LittleEndian.Uint32(payload[8:])
+			dl := int(binary.LittleEndian.Uint16(payload[16:]))
+			if len(payload) < 20+dl {
+				return out, errors.New("invalid connector message")
+			}
+			data := payload[20 : 20+dl]
+			payload = payload[20+dl:]
+			if idx != cnW1Idx || s != seq {
+				continue
+			}
+			r, err := parseW1Msgs(data)
+			out = append(out, r...)
+			if err != nil {
+				return out, err
+			}
+		}
+	}
+	return out, nil
+}
+
+// w1Error is a command that failed in the kernel.
+type w1Error struct {
+	cmd   byte
+	errno syscall.Errno
+}
+
+func (e *w1Error) Error() string {
+	if e.NoDevices() {
+		return "sysfs-onewire: no device present"
+	}
+	return fmt.Sprintf("sysfs-onewire: command %d failed: %v", e.cmd, e.errno)
+}
+
+// NoDevices implements onewire.NoDevicesError.
+func (e *w1Error) NoDevices() bool {
+	return e.cmd == w1CmdReset
+}
+
+// BusError implements onewire.BusError.
+func (e *w1Error) BusError() bool {
+	return true
+}
+
+// driverOneWire implements periph.Driver.
+type driverOneWire struct {
+}
+
+func (d *driverOneWire) String() string {
+	return "sysfs-onewire"
+}
+
+func (d *driverOneWire) Prerequisites() []string {
+	return nil
+}
+
+func (d *driverOneWire) After() []string {
+	return nil
+}
+
+// Init registers the 1-wire bus masters of the kernel w1 subsystem.
+//
+// https://www.kernel.org/doc/Documentation/w1/w1.generic
+func (d *driverOneWire) Init() (bool, error) {
+	prefix := w1Root + "w1_bus_master"
+	items, err := filepath.Glob(prefix + "*")
+	if err != nil {
+		return true, err
+	}
+	// Make sure they are registered in numerical order.
+	var numbers []int
+	for _, item := range items {
+		if n, err := strconv.Atoi(item[len(prefix):]); err == nil {
+			numbers = append(numbers, n)
+		}
+	}
+	if len(numbers) == 0 {
+		return false, errors.New("no 1-wire bus found")
+	}
+	sort.Ints(numbers)
+	for _, n := range numbers {
+		name := "w1_bus_master" + strconv.Itoa(n)
+		if err := onewirereg.Register(name, nil, n, openerOneWire(n).Open); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+type openerOneWire int
+
+func (o openerOneWire) Open() (onewire.BusCloser, error) {
+	b, err := NewOneWire(int(o))
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func init() {
+	if isLinux {
+		periph.MustRegister(&driverOneWire{})
+	}
+}
+
+var _ onewire.Bus = &OneWire{}
+var _ onewire.BusCloser = &OneWire{}
+var _ onewire.NoDevicesError = &w1Error{}
+var _ onewire.BusError = &w1Error{}
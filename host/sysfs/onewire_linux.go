@@ -0,0 +1,60 @@
+// Copyright 2018 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package sysfs
+
+import (
+	"errors"
+	"syscall"
+	"time"
+)
+
+// w1Netlink is a netlink socket bound to the connector.
+type w1Netlink struct {
+	fd  int
+	seq uint32
+}
+
+func w1DialDefault() (w1Conn, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_DGRAM, netlinkConnector)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	// A search on a long bus takes a while but the kernel must eventually
+	// answer.
+	tv := syscall.NsecToTimeval(int64(10 * time.Second))
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	return &w1Netlink{fd: fd, seq: uint32(time.Now().Unix())}, nil
+}
+
+func (w *w1Netlink) Close() error {
+	return syscall.Close(w.fd)
+}
+
+// send sends the message and collects the replies until the kernel reported
+// the status of every command or an error.
+//
+// The kernel replies to the port of the sender since 3.19.
+func (w *w1Netlink) send(msg []byte, ncmds int) ([]w1Reply, error) {
+	w.seq++
+	if err := syscall.Sendto(w.fd, newCnMsg(w.seq, msg), 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return nil, err
+	}
+	return recvW1Replies(w.seq, ncmds, func(b []byte) (int, error) {
+		n, _, err := syscall.Recvfrom(w.fd, b, 0)
+		if err == syscall.EAGAIN {
+			return n, errors.New("timed out waiting for the kernel")
+		}
+		return n, err
+	})
+}
+
+const netlinkConnector = 11
@@ -0,0 +1,13 @@
+// Copyright 2018 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+// +build !linux
+
+package sysfs
+
+import "errors"
+
+func w1DialDefault() (w1Conn, error) {
+	return nil, errors.New("netlink is not supported on non-linux")
+}
@@ -0,0 +1,358 @@
+// Copyright 2018 The Periph Authors. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package sysfs
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"periph.io/x/periph/conn/onewire"
+	"periph.io/x/periph/conn/onewire/onewirereg"
+)
+
+func TestNewOneWire(t *testing.T) {
+	defer newW1Tree(t)()
+	if o, err := NewOneWire(2); o != nil || err == nil {
+		t.Fatal("bus 2 doesn't exist")
+	}
+	o, err := NewOneWire(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := o.String(); s != "w1_bus_master1" {
+		t.Fatal(s)
+	}
+	// Never opened.
+	if err := o.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOneWire_Search(t *testing.T) {
+	defer newW1Tree(t)()
+	o, err := NewOneWire(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := o.Search(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a) != 2 || a[0] != 0x740000070e41ac28 || a[1] != 0xfc0000003ddb5128 {
+		t.Fatalf("%#x", a)
+	}
+	for _, addr := range a {
+		var b [8]byte
+		putUint64(b[:], addr)
+		if !onewire.CheckCRC(b[:]) {
+			t.Fatalf("%#x", addr)
+		}
+	}
+	writeW1File(t, "w1_bus_master1/w1_master_slaves", "not found.\n")
+	if a, err = o.Search(false); len(a) != 0 || err != nil {
+		t.Fatal(a, err)
+	}
+	writeW1File(t, "w1_bus_master1/w1_master_slaves", "28-0000070e41ac\nbad\n")
+	if a, err = o.Search(false); len(a) != 1 || err == nil {
+		t.Fatal(a, err)
+	}
+}
+
+func TestOneWire_Search_alarm(t *testing.T) {
+	defer newW1Tree(t)()
+	f := &fakeW1{replies: []w1Reply{
+		{cmd: w1Cmd{cmd: w1CmdAlarmSearch, data: []byte{0x28, 0xac, 0x41, 0x0e, 0x07, 0x00, 0x00, 0x74}}},
+		{cmd: w1Cmd{cmd: w1CmdAlarmSearch}},
+	}}
+	o := newFakeOneWire(t, f)
+	a, err := o.Search(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a) != 1 || a[0] != 0x740000070e41ac28 {
+		t.Fatalf("%#x", a)
+	}
+	if !bytes.Equal(f.msgs[0], []byte{4, 0, 4, 0, 1, 0, 0, 0, 0, 0, 0, 0, 3, 0, 0, 0}) {
+		t.Fatalf("%v", f.msgs[0])
+	}
+}
+
+func TestOneWire_Tx(t *testing.T) {
+	defer newW1Tree(t)()
+	f := &fakeW1{replies: []w1Reply{
+		{cmd: w1Cmd{cmd: w1CmdReset}},
+		{cmd: w1Cmd{cmd: w1CmdWrite}},
+		{cmd: w1Cmd{cmd: w1CmdRead, data: []byte{1, 2}}},
+		{cmd: w1Cmd{cmd: w1CmdRead}},
+	}}
+	o := newFakeOneWire(t, f)
+	r := make([]byte, 2)
+	if err := o.Tx([]byte{0xcc, 0xbe}, r, onewire.StrongPullup); err != nil {
+		t.Fatal(err)
+	}
+	if r[0] != 1 || r[1] != 2 {
+		t.Fatal(r)
+	}
+	expected := []byte{
+		4, 0, 16, 0, 1, 0, 0, 0, 0, 0, 0, 0,
+		5, 0, 0, 0,
+		1, 0, 2, 0, 0xcc, 0xbe,
+		0, 0, 2, 0, 0, 0,
+	}
+	if !bytes.Equal(f.msgs[0], expected) || f.ncmds[0] != 3 {
+		t.Fatalf("%v", f.msgs[0])
+	}
+
+	// Write only.
+	f.replies = f.replies[:2]
+	if err := o.Tx([]byte{0xcc, 0x44}, nil, onewire.WeakPullup); err != nil {
+		t.Fatal(err)
+	}
+	if f.ncmds[1] != 2 {
+		t.Fatal(f.ncmds)
+	}
+
+	// Short read.
+	f.replies = []w1Reply{{cmd: w1Cmd{cmd: w1CmdRead, data: []byte{1}}}}
+	if err := o.Tx(nil, r, onewire.WeakPullup); err == nil {
+		t.Fatal("short read")
+	}
+	f.replies = nil
+	if err := o.Tx(nil, r, onewire.WeakPullup); err == nil {
+		t.Fatal("no data")
+	}
+
+	if err := o.Close(); err != nil || !f.closed {
+		t.Fatal(err)
+	}
+}
+
+func TestOneWire_Tx_errors(t *testing.T) {
+	defer newW1Tree(t)()
+	f := &fakeW1{replies: []w1Reply{{status: 255, cmd: w1Cmd{cmd: w1CmdReset}}}}
+	o := newFakeOneWire(t, f)
+	err := o.Tx([]byte{0xcc}, nil, onewire.WeakPullup)
+	if e, ok := err.(onewire.NoDevicesError); !ok || !e.NoDevices() {
+		t.Fatal(err)
+	}
+	if e, ok := err.(onewire.BusError); !ok || !e.BusError() {
+		t.Fatal(err)
+	}
+	if s := err.Error(); s != "sysfs-onewire: no device present" {
+		t.Fatal(s)
+	}
+
+	f.replies = []w1Reply{{status: 22, cmd: w1Cmd{cmd: w1CmdWrite}}}
+	err = o.Tx([]byte{0xcc}, nil, onewire.WeakPullup)
+	if e, ok := err.(onewire.NoDevicesError); !ok || e.NoDevices() {
+		t.Fatal(err)
+	}
+	if s := err.Error(); s != "sysfs-onewire: command 1 failed: invalid argument" {
+		t.Fatal(s)
+	}
+
+	f.replies = []w1Reply{{status: 19, noCmd: true}}
+	if err := o.Tx([]byte{0xcc}, nil, onewire.WeakPullup); err == nil || err.Error() != "sysfs-onewire: w1_bus_master1: no such device" {
+		t.Fatal(err)
+	}
+
+	f.err = errors.New("oops")
+	if err := o.Tx([]byte{0xcc}, nil, onewire.WeakPullup); err == nil || err.Error() != "sysfs-onewire: oops" {
+		t.Fatal(err)
+	}
+
+	w1Dial = func() (w1Conn, error) {
+		return nil, errors.New("denied")
+	}
+	o = &OneWire{number: 1}
+	if _, err := o.Search(true); err == nil || err.Error() != "sysfs-onewire: denied" {
+		t.Fatal(err)
+	}
+}
+
+func TestW1Msgs(t *testing.T) {
+	msg := newW1Msg(1, []w1Cmd{{cmd: w1CmdReset}})
+	b := newCnMsg(10, msg)
+	expected := []byte{
+		// nlmsghdr
+		52, 0, 0, 0, 3, 0, 0, 0, 10, 0, 0, 0, 0, 0, 0, 0,
+		// cn_msg
+		3, 0, 0, 0, 1, 0, 0, 0, 10, 0, 0, 0, 11, 0, 0, 0, 16, 0, 0, 0,
+		// w1_netlink_msg
+		4, 0, 4, 0, 1, 0, 0, 0, 0, 0, 0, 0,
+		// w1_netlink_cmd
+		5, 0, 0, 0,
+	}
+	if !bytes.Equal(b, expected) {
+		t.Fatalf("%v", b)
+	}
+
+	// The kernel echoes the message; use it as a reply with two messages in a
+	// single cn_msg, followed by a reply to another request.
+	reply := append(append([]byte{}, msg...), 4, 2, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0)
+	b = append(newCnMsg(10, reply), newCnMsg(9, msg)...)
+	r, err := parseCnMsgs(b, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r) != 2 || r[0].cmd.cmd != w1CmdReset || r[0].noCmd || !r[0].isStatus() || r[1].status != 2 || !r[1].noCmd {
+		t.Fatalf("%#v", r)
+	}
+
+	bad := [][]byte{
+		{1, 0, 0, 0},
+		{12, 0, 0, 0, 0, 0, 0, 0},
+	}
+	for i, b := range bad {
+		if _, err := parseW1Msgs(b); err == nil {
+			t.Fatalf("#%d: invalid message", i)
+		}
+	}
+	if _, err := parseW1Msgs([]byte{4, 0, 4, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0}); err == nil {
+		t.Fatal("short command")
+	}
+	if _, err := parseCnMsgs([]byte{100, 0, 0, 0, 3, 0, 0, 0, 10, 0, 0, 0, 0, 0, 0, 0}, 10); err == nil {
+		t.Fatal("invalid netlink message")
+	}
+	b = newCnMsg(10, msg)
+	b[32] = 100
+	if _, err := parseCnMsgs(b, 10); err == nil {
+		t.Fatal("invalid connector message")
+	}
+}
+
+func TestRecvW1Replies(t *testing.T) {
+	// The data read and the status of the command come in two datagrams,
+	// received in the same buffer. The second one starts with a reply to
+	// another request, overwriting the data of the first one.
+	datagrams := [][]byte{
+		newCnMsg(10, newW1Msg(1, []w1Cmd{{cmd: w1CmdRead, data: []byte{1, 2, 3}}})),
+		append(newCnMsg(9, newW1Msg(1, []w1Cmd{{cmd: w1CmdRead, data: []byte{4, 5, 6, 7}}})), newCnMsg(10, newW1Msg(1, []w1Cmd{{cmd: w1CmdRead}}))...),
+	}
+	r, err := recvW1Replies(10, 1, func(b []byte) (int, error) {
+		if len(datagrams) == 0 {
+			return 0, errors.New("no more datagram")
+		}
+		n := copy(b, datagrams[0])
+		datagrams = datagrams[1:]
+		return n, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r) != 2 || !bytes.Equal(r[0].cmd.data, []byte{1, 2, 3}) || !r[1].isStatus() {
+		t.Fatalf("%#v", r)
+	}
+
+	_, err = recvW1Replies(10, 1, func(b []byte) (int, error) {
+		return 0, errors.New("timed out")
+	})
+	if err == nil {
+		t.Fatal("recv failed")
+	}
+}
+
+func TestDriverOneWire(t *testing.T) {
+	defer newW1Tree(t)()
+	d := driverOneWire{}
+	if s := d.String(); s != "sysfs-onewire" {
+		t.Fatal(s)
+	}
+	if d.Prerequisites() != nil || d.After() != nil {
+		t.Fatal("unexpected dependencies")
+	}
+	if err := os.Mkdir(filepath.Join(w1Root, "w1_bus_master0"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	defer onewirereg.Unregister("w1_bus_master0")
+	defer onewirereg.Unregister("w1_bus_master1")
+	if ok, err := d.Init(); !ok || err != nil {
+		t.Fatal(ok, err)
+	}
+	all := onewirereg.All()
+	if len(all) != 2 || all[0].Name != "w1_bus_master0" || all[1].Number != 1 {
+		t.Fatal(all)
+	}
+	b, err := onewirereg.Open("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := b.(*OneWire).String(); s != "w1_bus_master1" {
+		t.Fatal(s)
+	}
+
+	w1Root = filepath.Join(w1Root, "empty") + "/"
+	if ok, err := d.Init(); ok || err == nil {
+		t.Fatal("no bus")
+	}
+}
+
+//
+
+// newW1Tree creates a fake w1 sysfs tree with one bus master and two slaves.
+func newW1Tree(t *testing.T) func() {
+	root, err := ioutil.TempDir("", "w1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w1Root = root + "/"
+	if err := os.Mkdir(filepath.Join(root, "w1_bus_master1"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	writeW1File(t, "w1_bus_master1/w1_master_slaves", "28-0000070e41ac\n28-0000003ddb51\n")
+	return func() {
+		os.RemoveAll(root)
+		w1Root = "/sys/bus/w1/devices/"
+		w1Dial = w1DialDefault
+	}
+}
+
+func writeW1File(t *testing.T, name, content string) {
+	if err := ioutil.WriteFile(filepath.Join(w1Root, name), []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newFakeOneWire(t *testing.T, f *fakeW1) *OneWire {
+	w1Dial = func() (w1Conn, error) {
+		return f, nil
+	}
+	o, err := NewOneWire(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+// fakeW1 implements w1Conn.
+type fakeW1 struct {
+	replies []w1Reply
+	err     error
+	msgs    [][]byte
+	ncmds   []int
+	closed  bool
+}
+
+func (f *fakeW1) Close() error {
+	f.closed = true
+	return nil
+}
+
+func (f *fakeW1) send(msg []byte, ncmds int) ([]w1Reply, error) {
+	f.msgs = append(f.msgs, msg)
+	f.ncmds = append(f.ncmds, ncmds)
+	return f.replies, f.err
+}
+
+// putUint64 is a copy of onewire.putUint64.
+func putUint64(b []byte, v onewire.Address) {
+	for i := range b {
+		b[i] = byte(v >> uint(8*i))
+	}
+}
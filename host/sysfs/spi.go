@@ -5,6 +5,7 @@
 package sysfs
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -15,6 +16,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 	"unsafe"
 
 	"periph.io/x/periph"
@@ -58,6 +60,7 @@ type SPI struct {
 
 	sync.Mutex
 	initialized bool
+	mode        spi.Mode
 	bitsPerWord uint8
 	halfDuplex  bool
 	noCS        bool
@@ -99,43 +102,63 @@ func (s *SPI) LimitSpeed(maxHz int64) error {
 //
 // It must be called before any I/O.
 func (s *SPI) Connect(maxHz int64, mode spi.Mode, bits int) (spi.Conn, error) {
-	if maxHz < 0 || maxHz >= 1<<32 {
-		return nil, fmt.Errorf("sysfs-spi: invalid speed %d", maxHz)
-	}
-	if mode&^(spi.Mode3|spi.HalfDuplex|spi.NoCS|spi.LSBFirst) != 0 {
-		return nil, fmt.Errorf("sysfs-spi: invalid mode %v", mode)
-	}
-	if bits < 1 || bits >= 256 {
-		return nil, fmt.Errorf("sysfs-spi: invalid bits %d", bits)
+	if err := checkSPIParams(maxHz, mode, bits); err != nil {
+		return nil, err
 	}
 	s.Lock()
 	defer s.Unlock()
 	if s.initialized {
 		return nil, errors.New("sysfs-spi: Connect() can only be called exactly once")
 	}
+	if err := s.configure(maxHz, mode, bits); err != nil {
+		return nil, err
+	}
 	s.initialized = true
-	s.maxHzDev = uint32(maxHz)
-	s.bitsPerWord = uint8(bits)
+	return &spiConn{s}, nil
+}
+
+// checkSPIParams verifies the arguments to Connect() and Configure().
+func checkSPIParams(maxHz int64, mode spi.Mode, bits int) error {
+	if maxHz < 0 || maxHz >= 1<<32 {
+		return fmt.Errorf("sysfs-spi: invalid speed %d", maxHz)
+	}
+	if mode&^(spi.Mode3|spi.HalfDuplex|spi.NoCS|spi.LSBFirst|spi.CSHigh|spi.Loopback|spi.TxDual|spi.TxQuad|spi.RxDual|spi.RxQuad) != 0 {
+		return fmt.Errorf("sysfs-spi: invalid mode %v", mode)
+	}
+	if bits < 1 || bits >= 256 {
+		return fmt.Errorf("sysfs-spi: invalid bits %d", bits)
+	}
+	return nil
+}
+
+// configure sets the communication parameters.
+//
+// s.Mutex must be held.
+func (s *SPI) configure(maxHz int64, mode spi.Mode, bits int) error {
 	// Only mode needs to be set via an IOCTL, others can be specified in the
 	// spiIOCTransfer packet, which saves a kernel call.
 	m := mode & spi.Mode3
-	if mode&spi.HalfDuplex != 0 {
-		m |= threeWire
-		s.halfDuplex = true
-	}
-	if mode&spi.NoCS != 0 {
-		m |= noCS
-		s.noCS = true
+	for _, f := range spiModeFlags {
+		if mode&f.m != 0 {
+			m |= f.k
+		}
 	}
-	if mode&spi.LSBFirst != 0 {
-		m |= lSBFirst
+	// Only the first 8 or 32 bits are used. This only works because the system
+	// is running in little endian. SPI_IOC_WR_MODE is used when possible as
+	// SPI_IOC_WR_MODE32 requires linux 3.15.
+	op := uint(spiIOCMode)
+	if m > 0xFF {
+		op = spiIOCMode32
 	}
-	// Only the first 8 bits are used. This only works because the system is
-	// running in little endian.
-	if err := s.setFlag(spiIOCMode, uint64(m)); err != nil {
-		return nil, fmt.Errorf("sysfs-spi: setting mode %v failed: %v", mode, err)
+	if err := s.setFlag(op, uint64(m)); err != nil {
+		return fmt.Errorf("sysfs-spi: setting mode %v failed: %v", mode, err)
 	}
-	return &spiConn{s}, nil
+	s.mode = mode
+	s.maxHzDev = uint32(maxHz)
+	s.bitsPerWord = uint8(bits)
+	s.halfDuplex = mode&spi.HalfDuplex != 0
+	s.noCS = mode&spi.NoCS != 0
+	return nil
 }
 
 func (s *SPI) duplex() conn.Duplex {
@@ -248,6 +271,12 @@ func (s *SPI) txPackets(p []spi.Packet) error {
 		if lR != 0 {
 			l = lR
 		}
+		if p[i].MaxHz < 0 || p[i].MaxHz >= 1<<32 {
+			return fmt.Errorf("sysfs-spi: invalid packet speed %d", p[i].MaxHz)
+		}
+		if p[i].Delay < 0 || p[i].Delay > 65535*time.Microsecond {
+			return fmt.Errorf("sysfs-spi: invalid packet delay %s", p[i].Delay)
+		}
 		if total += l; spiBufSize != 0 && total > spiBufSize {
 			return fmt.Errorf("sysfs-spi: maximum TxPackets length is %d, got at least %d bytes", spiBufSize, total)
 		}
@@ -269,12 +298,28 @@ func (s *SPI) txPackets(p []spi.Packet) error {
 	m := make([]spiIOCTransfer, len(p))
 	for i := range m {
 		m[i].speedHz = speed
+		if hz := uint32(p[i].MaxHz); hz != 0 {
+			if s.maxHzPort != 0 && hz > s.maxHzPort {
+				hz = s.maxHzPort
+			}
+			m[i].speedHz = hz
+		}
+		m[i].delayUsecs = uint16((p[i].Delay + time.Microsecond - 1) / time.Microsecond)
 		if m[i].bitsPerWord = p[i].BitsPerWord; m[i].bitsPerWord == 0 {
 			m[i].bitsPerWord = s.bitsPerWord
 		}
-		if !s.noCS && !p[i].KeepCS {
+		// cs_change releases CS after a packet, except after the last one where
+		// it keeps CS asserted.
+		if !s.noCS && p[i].KeepCS == (i == len(m)-1) {
 			m[i].csChange = 1
 		}
+		var err error
+		if m[i].txNBits, err = spiNBits(p[i].TxWidth, s.mode, spi.TxDual, spi.TxQuad); err != nil {
+			return err
+		}
+		if m[i].rxNBits, err = spiNBits(p[i].RxWidth, s.mode, spi.RxDual, spi.RxQuad); err != nil {
+			return err
+		}
 		lW := len(p[i].W)
 		lR := len(p[i].R)
 		if lW != 0 && lR != 0 && s.halfDuplex {
@@ -295,6 +340,19 @@ func (s *SPI) txPackets(p []spi.Packet) error {
 	return nil
 }
 
+// spiNBits returns the tx_nbits or rx_nbits value for a packet width.
+func spiNBits(width uint8, mode, dual, quad spi.Mode) (uint8, error) {
+	switch {
+	case width <= 1:
+		return width, nil
+	case width == 2 && mode&(dual|quad) != 0:
+		return width, nil
+	case width == 4 && mode&quad != 0:
+		return width, nil
+	}
+	return 0, fmt.Errorf("sysfs-spi: packet width %d is not enabled in mode %v", width, mode)
+}
+
 func (s *SPI) setFlag(op uint, arg uint64) error {
 	if err := s.f.Ioctl(op|0x40000000, uintptr(unsafe.Pointer(&arg))); err != nil {
 		return err
@@ -395,6 +453,9 @@ func (s *spiConn) Tx(w, r []byte) error {
 
 // TxPackets sends and receives packets as specified by the user.
 //
+// KeepCS:true on the last packet keeps CS asserted after the call returns,
+// until the next transaction.
+//
 // spidev enforces the maximum limit of transaction size. It can be as low as
 // 4096 bytes. See the platform documentation to learn how to increase the
 // limit.
@@ -402,6 +463,40 @@ func (s *spiConn) TxPackets(p []spi.Packet) error {
 	return s.s.txPackets(p)
 }
 
+// TxPacketsContext implements spi.ConnContext.
+//
+// The packets are sent in multiple batches, split after each packet with
+// KeepCS:false. ctx is checked before each batch.
+func (s *spiConn) TxPacketsContext(ctx context.Context, p []spi.Packet) error {
+	if len(p) == 0 {
+		return s.s.txPackets(p)
+	}
+	for len(p) != 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		i := 0
+		for i < len(p)-1 && p[i].KeepCS {
+			i++
+		}
+		if err := s.s.txPackets(p[:i+1]); err != nil {
+			return err
+		}
+		p = p[i+1:]
+	}
+	return nil
+}
+
+// Configure implements spi.Configurer.
+func (s *spiConn) Configure(maxHz int64, mode spi.Mode, bits int) error {
+	if err := checkSPIParams(maxHz, mode, bits); err != nil {
+		return err
+	}
+	s.s.Lock()
+	defer s.s.Unlock()
+	return s.s.configure(maxHz, mode, bits)
+}
+
 func (s *spiConn) Duplex() conn.Duplex {
 	return s.s.duplex()
 }
@@ -435,9 +530,28 @@ const (
 	loop      spi.Mode = 0x20 // loopback mode
 	noCS      spi.Mode = 0x40 // do not assert CS
 	ready     spi.Mode = 0x80 // slave pulls low to pause
-	// The driver optionally support dual and quad data lines.
+	txDual    spi.Mode = 0x100
+	txQuad    spi.Mode = 0x200
+	rxDual    spi.Mode = 0x400
+	rxQuad    spi.Mode = 0x800
 )
 
+// spiModeFlags maps the spi.Mode flags to the spidev ones.
+var spiModeFlags = []struct {
+	m spi.Mode
+	k spi.Mode
+}{
+	{spi.HalfDuplex, threeWire},
+	{spi.NoCS, noCS},
+	{spi.LSBFirst, lSBFirst},
+	{spi.CSHigh, cSHigh},
+	{spi.Loopback, loop},
+	{spi.TxDual, txDual},
+	{spi.TxQuad, txQuad},
+	{spi.RxDual, rxDual},
+	{spi.RxQuad, rxQuad},
+}
+
 // spidev driver IOCTL control codes.
 //
 // Constants and structure definition can be found at
@@ -587,6 +701,8 @@ var _ conn.Limits = &spiConn{}
 var _ io.Reader = &spiConn{}
 var _ io.Writer = &spiConn{}
 var _ spi.Conn = &spiConn{}
+var _ spi.ConnContext = &spiConn{}
+var _ spi.Configurer = &spiConn{}
 var _ spi.Pins = &SPI{}
 var _ spi.Pins = &spiConn{}
 var _ spi.Port = &SPI{}
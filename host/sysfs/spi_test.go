@@ -5,8 +5,10 @@
 package sysfs
 
 import (
+	"context"
 	"io"
 	"testing"
+	"time"
 
 	"periph.io/x/periph/conn"
 	"periph.io/x/periph/conn/gpio"
@@ -162,6 +164,130 @@ func TestSPI_Connect(t *testing.T) {
 	}
 }
 
+func TestSPI_Configure(t *testing.T) {
+	p := SPI{f: &ioctlClose{}, busNumber: 24}
+	c, err := p.Connect(1, spi.Mode0|spi.HalfDuplex, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := c.(spi.Configurer)
+	if err := cfg.Configure(-1, spi.Mode0, 8); err == nil {
+		t.Fatal("invalid speed")
+	}
+	if err := cfg.Configure(1000, spi.Mode3|spi.NoCS, 9); err != nil {
+		t.Fatal(err)
+	}
+	if d := c.Duplex(); d != conn.Full {
+		t.Fatal(d)
+	}
+	if p.maxHzDev != 1000 || p.bitsPerWord != 9 || !p.noCS {
+		t.Fatal(p.maxHzDev, p.bitsPerWord, p.noCS)
+	}
+}
+
+func TestSPI_Connect_mode32(t *testing.T) {
+	f := &spiRecord{}
+	p := SPI{f: f, busNumber: 24}
+	if _, err := p.Connect(1, spi.Mode1|spi.CSHigh|spi.Loopback, 8); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.ops) != 1 || f.ops[0] != spiIOCMode|0x40000000 || f.mode != uint64(spi.Mode1|cSHigh|loop) {
+		t.Fatalf("%#v 0x%x", f.ops, f.mode)
+	}
+	f.ops = nil
+	if err := (&spiConn{&p}).Configure(1, spi.Mode0|spi.TxQuad|spi.RxDual, 8); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.ops) != 1 || f.ops[0] != spiIOCMode32|0x40000000 || f.mode != uint64(txQuad|rxDual) {
+		t.Fatalf("%#v 0x%x", f.ops, f.mode)
+	}
+}
+
+func TestSPI_TxPackets_fields(t *testing.T) {
+	f := &spiRecord{}
+	p := SPI{f: f, busNumber: 24}
+	c, err := p.Connect(1000, spi.Mode0|spi.TxQuad|spi.RxDual, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.LimitSpeed(2000); err != nil {
+		t.Fatal(err)
+	}
+	pkt := []spi.Packet{
+		{W: []byte{0}, KeepCS: true, Delay: 1500 * time.Nanosecond},
+		{W: []byte{0}, TxWidth: 4, MaxHz: 1500},
+		{R: []byte{0}, RxWidth: 2, MaxHz: 3000, KeepCS: true},
+	}
+	if err := c.TxPackets(pkt); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.xfers) != 3 {
+		t.Fatal(f.xfers)
+	}
+	expected := []struct {
+		speedHz    uint32
+		delayUsecs uint16
+		csChange   uint8
+		txNBits    uint8
+		rxNBits    uint8
+	}{
+		{1000, 2, 0, 0, 0},
+		{1500, 0, 1, 4, 0},
+		{2000, 0, 1, 0, 2},
+	}
+	for i, e := range expected {
+		x := f.xfers[i]
+		if x.speedHz != e.speedHz || x.delayUsecs != e.delayUsecs || x.csChange != e.csChange || x.txNBits != e.txNBits || x.rxNBits != e.rxNBits {
+			t.Fatalf("#%d: %#v", i, x)
+		}
+	}
+
+	bad := [][]spi.Packet{
+		{{W: []byte{0}, TxWidth: 3}},
+		{{R: []byte{0}, RxWidth: 4}},
+		{{W: []byte{0}, MaxHz: -1}},
+		{{W: []byte{0}, Delay: time.Second}},
+	}
+	for i, pkt := range bad {
+		if err := c.TxPackets(pkt); err == nil {
+			t.Fatalf("#%d: invalid packet", i)
+		}
+	}
+}
+
+func TestSPI_TxPacketsContext(t *testing.T) {
+	f := &spiRecord{}
+	p := SPI{f: f, busNumber: 24}
+	c, err := p.Connect(1, spi.Mode3, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.ops = nil
+	pkt := []spi.Packet{
+		{W: []byte{0}, KeepCS: true},
+		{R: []byte{0}},
+		{W: []byte{0}},
+	}
+	cc := c.(spi.ConnContext)
+	if err := cc.TxPacketsContext(context.Background(), pkt); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.ops) != 2 || f.ops[0] != spiIOCTx(2) || f.ops[1] != spiIOCTx(1) {
+		t.Fatalf("%#v", f.ops)
+	}
+	if err := cc.TxPacketsContext(context.Background(), nil); err == nil {
+		t.Fatal("empty packets")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := cc.TxPacketsContext(ctx, pkt); err != context.Canceled {
+		t.Fatal(err)
+	}
+	if len(f.ops) != 2 {
+		t.Fatalf("%#v", f.ops)
+	}
+}
+
 func TestSPIIOCTX(t *testing.T) {
 	if v := spiIOCTx(1); v != 0x40206B00 {
 		t.Fatalf("Expected 0x40206B00, got 0x%08X", v)
@@ -182,3 +308,26 @@ func TestSPIDriver(t *testing.T) {
 func init() {
 	spiBufSize = 4096
 }
+
+//
+
+// spiRecord records the ioctl calls.
+type spiRecord struct {
+	ioctlClose
+	ops   []uint
+	mode  uint64           // Last mode set.
+	xfers []spiIOCTransfer // Transfers of the last transaction.
+}
+
+func (s *spiRecord) Ioctl(op uint, data uintptr) error {
+	s.ops = append(s.ops, op)
+	switch op {
+	case spiIOCMode | 0x40000000, spiIOCMode32 | 0x40000000:
+		s.mode = *(*uint64)(ptr(data))
+	default:
+		if n := int(op-spiIOCTx(0)) / 0x200000; n > 0 && spiIOCTx(n) == op {
+			s.xfers = append([]spiIOCTransfer{}, (*[16]spiIOCTransfer)(ptr(data))[:n:n]...)
+		}
+	}
+	return nil
+}
@@ -7,6 +7,8 @@ package sysfs
 import (
 	"os"
 	"syscall"
+	"time"
+	"unsafe"
 )
 
 const isLinux = true
@@ -15,3 +17,27 @@ func isErrBusy(err error) bool {
 	e, ok := err.(*os.PathError)
 	return ok && e.Err == syscall.EBUSY
 }
+
+// i2cErrnos maps the errno values returned by I²C adapters to the kind of
+// failure.
+var i2cErrnos = map[syscall.Errno]i2cErrorKind{
+	// No device responded to the address.
+	syscall.ENXIO: i2cNACK,
+	// The device didn't acknowledge a byte.
+	syscall.EREMOTEIO: i2cNACK,
+	syscall.EAGAIN:    i2cArbitrationLost,
+	syscall.ETIMEDOUT: i2cTimeout,
+	syscall.EBUSY:     i2cBusBusy,
+}
+
+// monotonicTime returns the current time of CLOCK_MONOTONIC, which is the
+// clock used by the kernel to timestamp GPIO edges.
+func monotonicTime() time.Duration {
+	var ts syscall.Timespec
+	if _, _, errno := syscall.Syscall(syscall.SYS_CLOCK_GETTIME, clockMonotonic, uintptr(unsafe.Pointer(&ts)), 0); errno != 0 {
+		return 0
+	}
+	return time.Duration(ts.Nano())
+}
+
+const clockMonotonic = 1
@@ -6,9 +6,25 @@
 
 package sysfs
 
+import (
+	"syscall"
+	"time"
+)
+
 const isLinux = false
 
 func isErrBusy(err error) bool {
 	// This function is not used on non-linux.
 	return false
 }
+
+// i2cErrnos is empty as there is no i2c-dev driver on non-linux.
+var i2cErrnos = map[syscall.Errno]i2cErrorKind{}
+
+// monotonicTime returns the time since the process started, as there is no
+// kernel clock to match on non-linux.
+func monotonicTime() time.Duration {
+	return time.Since(processStart)
+}
+
+var processStart = time.Now()
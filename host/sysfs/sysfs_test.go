@@ -19,6 +19,7 @@ func init() {
 func reset() {
 	fileIOOpen = fileIOOpenDefault
 	ioctlOpen = ioctlOpenDefault
+	lineOpen = lineOpenDefault
 	// Soon.
 	//fileIOOpen = fileIOOpenPanic
 	//ioctlOpen = ioctlOpenPanic
@@ -4,8 +4,8 @@
 
 // Package videocore interacts with the VideoCore GPU found on bcm283x.
 //
-// This package shouldn't be used directly, it is used by bcm283x's DMA
-// implementation.
+// This package shouldn't be used directly, it is used by bcm283x's DMA, SPI
+// and I²C implementations.
 //
 // Datasheet
 //
@@ -88,6 +88,41 @@ func Alloc(size int) (*Mem, error) {
 	return &Mem{View: b, handle: handle}, nil
 }
 
+// Clock is a clock managed by the VideoCore firmware.
+type Clock uint32
+
+// Clocks that can be queried with ClockRate.
+const (
+	ClockEMMC Clock = 1
+	ClockUART Clock = 2
+	ClockARM  Clock = 3
+	// ClockCore is the VPU core clock, which feeds the SPI, BSC and AUX
+	// peripherals.
+	ClockCore Clock = 4
+)
+
+// ClockRate returns the current rate of the clock c in Hz, as set by the
+// firmware.
+//
+// The rate of some clocks changes dynamically with the load unless the
+// frequency scaling is disabled in config.txt.
+func ClockRate(c Clock) (int64, error) {
+	if err := openMailbox(); err != nil {
+		return 0, wrapf("failed to open the mailbox to the GPU: %v", err)
+	}
+	b := genPacket(mbGetClockRate, 8, uint32(c))
+	if err := sendPacket(b); err != nil {
+		return 0, wrapf("failed request to get clock %d rate: %v", c, err)
+	}
+	if b[4] != mbReply|8 || b[5] != uint32(c) {
+		return 0, wrapf("got unexpected reply for clock %d: 0x%08x 0x%08x", c, b[4], b[5])
+	}
+	if b[6] == 0 {
+		return 0, wrapf("clock %d is not running", c)
+	}
+	return int64(b[6]), nil
+}
+
 //
 
 var (
@@ -108,6 +143,7 @@ const (
 	mbVCMemory        = 0x10006 // 0, 8
 	mbClocks          = 0x10007 // 0, variable
 	// These work:
+	mbGetClockRate   = 0x30002    // 4, 8
 	mbAllocateMemory = 0x3000C    // 12, 4
 	mbLockMemory     = 0x3000D    // 4, 4
 	mbUnlockMemory   = 0x3000E    // 4, 4
@@ -106,6 +106,36 @@ func TestSmokeTest(t *testing.T) {
 	}
 }
 
+func TestClockRate(t *testing.T) {
+	defer reset(t)
+	mailboxErr = errors.New("error")
+	if _, err := ClockRate(ClockCore); err == nil {
+		t.Fatal("mailboxErr is not nil")
+	}
+	mailboxErr = nil
+	mailbox = &clockRate{rate: 400000000}
+	if r, err := ClockRate(ClockCore); r != 400000000 || err != nil {
+		t.Fatal(r, err)
+	}
+	mailbox = &clockRate{}
+	if _, err := ClockRate(ClockCore); err == nil {
+		t.Fatal("clock not running")
+	}
+	mailbox = &clockRate{rate: 1, badID: true}
+	if _, err := ClockRate(ClockCore); err == nil {
+		t.Fatal("reply for another clock")
+	}
+	mailbox = &playback{}
+	if _, err := ClockRate(ClockCore); err == nil {
+		t.Fatal("mailbox failed")
+	}
+	// dummy replies with 4 bytes.
+	mailbox = &dummy{}
+	if _, err := ClockRate(ClockCore); err == nil {
+		t.Fatal("short reply")
+	}
+}
+
 func TestGenPacket(t *testing.T) {
 	defer reset(t)
 	actual := genPacket(10, 12, 1, 2, 3)
@@ -130,6 +160,25 @@ func (d *dummy) sendMessage(b []uint32) error {
 	return nil
 }
 
+// clockRate replies to mbGetClockRate.
+type clockRate struct {
+	rate  uint32
+	badID bool
+}
+
+func (c *clockRate) sendMessage(b []uint32) error {
+	if b[2] != mbGetClockRate || b[3] != 4 || b[4] != 8 {
+		return errors.New("unexpected request")
+	}
+	b[1] = mbReply
+	b[4] = mbReply | 8
+	if c.badID {
+		b[5]++
+	}
+	b[6] = c.rate
+	return nil
+}
+
 type playback struct {
 	reply []uint32
 	count int